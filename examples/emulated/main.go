@@ -2,7 +2,11 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
 	"fmt"
+	"os"
 	"time"
 
 	"github.com/charmbracelet/log"
@@ -13,13 +17,35 @@ import (
 	"github.com/rabarar/meshtool-go/public/radio"
 	"github.com/rabarar/meshtool-go/public/transport"
 	"golang.org/x/sync/errgroup"
+	"google.golang.org/protobuf/proto"
 )
 
 func main() {
 	// TODO: Flesh this example out and make it configurable
+	var server, username, password, caFile string
+	flag.StringVar(&server, "server", "tcp://mqtt.meshtastic.org:1883", "MQTT server, use ssl:// for TLS")
+	flag.StringVar(&username, "username", mqtt.DefaultUsername, "MQTT username")
+	flag.StringVar(&password, "password", mqtt.DefaultPassword, "MQTT password")
+	flag.StringVar(&caFile, "ca", "", "PEM encoded CA certificate to trust when connecting to the MQTT server over TLS")
+	flag.Parse()
+
 	ctx := context.Background()
 	log.SetLevel(log.DebugLevel)
 
+	var mqttOpts []mqtt.ClientOption
+	if caFile != "" {
+		caPEM, err := os.ReadFile(caFile)
+		if err != nil {
+			panic(err)
+		}
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(caPEM) {
+			panic(fmt.Sprintf("no certificates found in %s", caFile))
+		}
+		mqttOpts = append(mqttOpts, mqtt.WithTLSConfig(&tls.Config{RootCAs: roots}))
+	}
+	mqttClient := mqtt.NewClient(server, username, password, mqtt.DefaultTopicRoot, mqttOpts...)
+
 	nodeID, err := meshtool.RandomNodeID()
 	if err != nil {
 		panic(err)
@@ -28,7 +54,7 @@ func main() {
 		LongName:   "EXAMPLE",
 		ShortName:  "EMPL",
 		NodeID:     nodeID,
-		MQTTClient: &mqtt.DefaultClient,
+		MQTTClient: mqttClient,
 		Channels: &meshtastic.ChannelSet{
 			Settings: []*meshtastic.ChannelSettings{
 				{
@@ -41,10 +67,17 @@ func main() {
 
 		BroadcastPositionInterval: 5 * time.Minute,
 		// Hardcoded to the position of Buckingham Palace.
-		PositionLatitudeI:  515014760,
-		PositionLongitudeI: -1406340,
+		PositionLatitudeI:  meshtool.DegreesToI(51.501476),
+		PositionLongitudeI: meshtool.DegreesToI(-0.140634),
 		PositionAltitude:   2,
 
+		BroadcastTelemetryInterval: 15 * time.Minute,
+		DeviceMetrics: &meshtastic.DeviceMetrics{
+			// A battery level over 100 indicates the node is externally powered.
+			BatteryLevel: proto.Uint32(101),
+			Voltage:      proto.Float32(5),
+		},
+
 		TCPListenAddr: "127.0.0.1:4403",
 	})
 	if err != nil {
@@ -65,21 +98,8 @@ func main() {
 			return fmt.Errorf("creating connection: %w", err)
 		}
 
-		msg := &meshtastic.ToRadio{
-			PayloadVariant: &meshtastic.ToRadio_Packet{
-				Packet: &meshtastic.MeshPacket{
-					From: nodeID.Uint32(),
-					// This is hard coded to Noah's node ID
-					To: 2437877602,
-					PayloadVariant: &meshtastic.MeshPacket_Decoded{
-						Decoded: &meshtastic.Data{
-							Portnum: meshtastic.PortNum_TEXT_MESSAGE_APP,
-							Payload: []byte("from main!!"),
-						},
-					},
-				},
-			},
-		}
+		// This is hard coded to Noah's node ID
+		msg := transport.NewTextPacket(nodeID, 2437877602, "from main!!")
 		if err := conn.Write(msg); err != nil {
 			return fmt.Errorf("writing to radio: %w", err)
 		}
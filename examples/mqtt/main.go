@@ -1,17 +1,15 @@
 package main
 
 import (
-	"encoding/base64"
 	"encoding/hex"
 	"errors"
 	"flag"
-	"strings"
+	"fmt"
 
 	"github.com/charmbracelet/log"
-	"github.com/rabarar/meshtastic"
+	"github.com/rabarar/meshtool-go/public/meshtool"
 	"github.com/rabarar/meshtool-go/public/mqtt"
 	"github.com/rabarar/meshtool-go/public/radio"
-	"google.golang.org/protobuf/proto"
 )
 
 func main() {
@@ -33,89 +31,35 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
-	// key, err := generateKey("1PG7OiApB1nwvP+rz05pAQ==")
+	// key, err := radio.NormalizeAndParsePSK("1PG7OiApB1nwvP+rz05pAQ==")
 	// if err != nil {
 	// 	log.Fatal(err)
 	// }
-	client.Handle("LongFast", channelHandler("LongFast", radio.DefaultKey))
+	client.HandleE("LongFast", channelHandler("LongFast", radio.DefaultKey))
 	log.Info("Started")
 	select {}
 }
 
-func channelHandler(channel string, key []byte) mqtt.HandlerFunc {
-
-	return func(m mqtt.Message) {
-		var env meshtastic.ServiceEnvelope
-		err := proto.Unmarshal(m.Payload, &env)
-		if err != nil {
-			log.Fatal("failed unmarshalling to service envelope", "err", err, "payload", hex.EncodeToString(m.Payload))
-			return
+func channelHandler(channel string, key []byte) mqtt.HandlerFuncE {
+	keys := map[string][]byte{channel: key}
+	return func(m mqtt.Message) error {
+		// Other channels can share a topic, only those we hold the key for are decoded.
+		envChannel, data, _, err := radio.DecodeEnvelope(m.Payload, keys)
+		if errors.Is(err, radio.ErrNoKey) {
+			log.Debug("ignoring packet on channel without a key", "channel", envChannel)
+			return nil
 		}
-
-		/* TODO - not HasPacket()
-		if !env.HasPacket() {
-			log.Error("no packet in envelope", "payload", hex.EncodeToString(m.Payload))
-			return
-		}
-		*/
-		messagePtr, err := radio.TryDecode(env.Packet, key)
 		if err != nil {
-			log.Error("failed to decode packet", "err", err, "payload", hex.EncodeToString(m.Payload))
-			return
+			return fmt.Errorf("decoding packet %s: %w", hex.EncodeToString(m.Payload), err)
 		}
-		if out, err := processMessage(messagePtr); err != nil {
-			if messagePtr.Portnum != 0 {
-				log.Error("failed to process message", "err", err, "payload", hex.EncodeToString(m.Payload), "topic", m.Topic, "channel", channel, "portnum", messagePtr.Portnum.String())
+		out, err := meshtool.DecodePayload(data)
+		if err != nil {
+			if data.Portnum == 0 {
+				return nil
 			}
-			return
-		} else {
-			log.Info(out, "topic", m.Topic, "channel", channel, "portnum", messagePtr.Portnum.String())
+			return fmt.Errorf("processing %s message: %w", data.Portnum.String(), err)
 		}
+		log.Info(fmt.Sprint(out), "topic", m.Topic, "channel", channel, "portnum", data.Portnum.String())
+		return nil
 	}
 }
-
-var ErrUnknownMessageType = errors.New("unknown message type")
-
-func processMessage(message *meshtastic.Data) (string, error) {
-	var err error
-	if message.Portnum == meshtastic.PortNum_NODEINFO_APP {
-		var user = meshtastic.User{}
-		err = proto.Unmarshal(message.Payload, &user)
-		return user.String(), err
-	}
-	if message.Portnum == meshtastic.PortNum_POSITION_APP {
-		var pos = meshtastic.Position{}
-		err = proto.Unmarshal(message.Payload, &pos)
-		return pos.String(), err
-	}
-	if message.Portnum == meshtastic.PortNum_TELEMETRY_APP {
-		var t = meshtastic.Telemetry{}
-		err = proto.Unmarshal(message.Payload, &t)
-		return t.String(), err
-	}
-	if message.Portnum == meshtastic.PortNum_NEIGHBORINFO_APP {
-		var n = meshtastic.NeighborInfo{}
-		err = proto.Unmarshal(message.Payload, &n)
-		return n.String(), err
-	}
-	if message.Portnum == meshtastic.PortNum_STORE_FORWARD_APP {
-		var s = meshtastic.StoreAndForward{}
-		err = proto.Unmarshal(message.Payload, &s)
-		return s.String(), err
-	}
-
-	return "", ErrUnknownMessageType
-}
-
-func generateKey(key string) ([]byte, error) {
-	// Pad the key with '=' characters to ensure it's a valid base64 string
-	padding := (4 - len(key)%4) % 4
-	paddedKey := key + strings.Repeat("=", padding)
-
-	// Replace '-' with '+' and '_' with '/'
-	replacedKey := strings.ReplaceAll(paddedKey, "-", "+")
-	replacedKey = strings.ReplaceAll(replacedKey, "_", "/")
-
-	// Decode the base64-encoded key
-	return base64.StdEncoding.DecodeString(replacedKey)
-}
@@ -25,15 +25,27 @@ func main() {
 	if len(os.Args) > 1 {
 		port = os.Args[1]
 	} else {
-		port = serial.GetPorts()[0]
+		ports, err := serial.DetectMeshtastic()
+		if err != nil {
+			panic(err)
+		}
+		if len(ports) == 0 {
+			log.Fatal("no Meshtastic devices found, pass the port as an argument")
+		}
+		for _, p := range ports {
+			log.Info("found device", "port", p.Name, "chip", p.Chip, "product", p.Product, "serial", p.SerialNumber)
+		}
+		if len(ports) > 1 {
+			log.Warn("several devices found, pass the port as an argument to choose another", "using", ports[0].Name)
+		}
+		port = ports[0].Name
 	}
-	serialConn, err := serial.Connect(port)
+	streamConn, err := serial.ConnectTransport(port, serial.Options{})
 	if err != nil {
 		panic(err)
 	}
-	streamConn, err := transport.NewClientStreamConn(serialConn)
-	if err != nil {
-		panic(err)
+	streamConn.DebugOutput = func(line string) {
+		log.Debug("radio debug output", "line", line)
 	}
 	defer func() {
 		if err := streamConn.Close(); err != nil {
@@ -42,10 +54,8 @@ func main() {
 	}()
 
 	client := transport.NewClient(streamConn, false)
-	client.Handle(new(meshtastic.MeshPacket), func(msg proto.Message) {
-		pkt := msg.(*meshtastic.MeshPacket)
-		data := pkt.GetDecoded()
-		log.Info("Received message from radio", "msg", processMessage(data), "from", fmt.Sprintf("%x", pkt.From), "portnum", data.Portnum.String())
+	client.HandleDecoded(func(pkt *meshtastic.MeshPacket, msg proto.Message) {
+		log.Info("Received message from radio", "msg", msg, "from", fmt.Sprintf("%x", pkt.From), "type", proto.MessageName(msg))
 	})
 	ctxTimeout, cancelTimeout := context.WithTimeout(ctx, 10*time.Second)
 	defer cancelTimeout()
@@ -56,37 +66,3 @@ func main() {
 	log.Info("Waiting for interrupt signal")
 	<-ctx.Done()
 }
-
-func processMessage(message *meshtastic.Data) string {
-	if message.Portnum == meshtastic.PortNum_NODEINFO_APP {
-		var user = meshtastic.User{}
-		proto.Unmarshal(message.Payload, &user)
-		return user.String()
-	}
-	if message.Portnum == meshtastic.PortNum_POSITION_APP {
-		var pos = meshtastic.Position{}
-		proto.Unmarshal(message.Payload, &pos)
-		return pos.String()
-	}
-	if message.Portnum == meshtastic.PortNum_TELEMETRY_APP {
-		var t = meshtastic.Telemetry{}
-		proto.Unmarshal(message.Payload, &t)
-		return t.String()
-	}
-	if message.Portnum == meshtastic.PortNum_NEIGHBORINFO_APP {
-		var n = meshtastic.NeighborInfo{}
-		proto.Unmarshal(message.Payload, &n)
-		return n.String()
-	}
-	if message.Portnum == meshtastic.PortNum_TEXT_MESSAGE_APP {
-		return string(message.Payload)
-
-	}
-	if message.Portnum == meshtastic.PortNum_STORE_FORWARD_APP {
-		var s = meshtastic.StoreAndForward{}
-		proto.Unmarshal(message.Payload, &s)
-		return s.String()
-	}
-
-	return "unknown message type"
-}
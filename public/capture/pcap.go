@@ -0,0 +1,149 @@
+// Package capture records Meshtastic traffic to files for offline analysis.
+package capture
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/rabarar/meshtastic"
+	"google.golang.org/protobuf/proto"
+)
+
+// The file layout is the classic libpcap format with nanosecond timestamps, which Wireshark and tcpdump can read:
+//
+//	global header (24 bytes): magic 0xa1b23c4d, version 2.4, thiszone 0, sigfigs 0, snaplen, link type
+//	per record (16 bytes):    timestamp seconds, timestamp nanoseconds, captured length, original length
+//	record data:              a marshalled meshtastic.ServiceEnvelope
+//
+// All header fields are little endian. Records use LinkTypeUser0, so to dissect them in Wireshark map DLT User 0 to
+// the protobuf dissector with the message type meshtastic.ServiceEnvelope.
+const (
+	// pcapMagicNanos is the magic number of a pcap file with nanosecond resolution timestamps.
+	pcapMagicNanos = 0xa1b23c4d
+	pcapVersionMaj = 2
+	pcapVersionMin = 4
+	// LinkTypeUser0 is the pcap link type reserved for private use, used for each record.
+	LinkTypeUser0 = 147
+	// SnapLen is the maximum length of a record. ServiceEnvelopes are far smaller than this.
+	SnapLen = 65535
+
+	globalHeaderLen = 24
+	recordHeaderLen = 16
+)
+
+// ErrNotPCAP is returned by NewPCAPReader if the input is not a capture written by PCAPWriter.
+var ErrNotPCAP = errors.New("not a nanosecond pcap file")
+
+// PCAPWriter writes ServiceEnvelopes to a pcap file. It is safe for concurrent use.
+type PCAPWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewPCAPWriter writes the pcap global header to w and returns a PCAPWriter which appends records to it.
+func NewPCAPWriter(w io.Writer) (*PCAPWriter, error) {
+	header := make([]byte, globalHeaderLen)
+	binary.LittleEndian.PutUint32(header[0:], pcapMagicNanos)
+	binary.LittleEndian.PutUint16(header[4:], pcapVersionMaj)
+	binary.LittleEndian.PutUint16(header[6:], pcapVersionMin)
+	// thiszone and sigfigs are left as zero.
+	binary.LittleEndian.PutUint32(header[16:], SnapLen)
+	binary.LittleEndian.PutUint32(header[20:], LinkTypeUser0)
+	if _, err := w.Write(header); err != nil {
+		return nil, fmt.Errorf("writing pcap header: %w", err)
+	}
+	return &PCAPWriter{w: w}, nil
+}
+
+// WriteEnvelope records a ServiceEnvelope received at t. The envelope is recorded as is, so encrypted packets remain
+// encrypted.
+func (p *PCAPWriter) WriteEnvelope(t time.Time, se *meshtastic.ServiceEnvelope) error {
+	data, err := proto.Marshal(se)
+	if err != nil {
+		return fmt.Errorf("marshalling service envelope: %w", err)
+	}
+	if len(data) > SnapLen {
+		return fmt.Errorf("service envelope is %d bytes, larger than the snap length", len(data))
+	}
+	header := make([]byte, recordHeaderLen)
+	binary.LittleEndian.PutUint32(header[0:], uint32(t.Unix()))
+	binary.LittleEndian.PutUint32(header[4:], uint32(t.Nanosecond()))
+	binary.LittleEndian.PutUint32(header[8:], uint32(len(data)))
+	binary.LittleEndian.PutUint32(header[12:], uint32(len(data)))
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, err := p.w.Write(append(header, data...)); err != nil {
+		return fmt.Errorf("writing pcap record: %w", err)
+	}
+	return nil
+}
+
+// WritePacket records a MeshPacket received at t which did not arrive in a ServiceEnvelope, such as one received
+// from a radio. It is wrapped in an envelope with the given channel ID and gateway ID, either of which may be empty.
+func (p *PCAPWriter) WritePacket(t time.Time, channelID, gatewayID string, packet *meshtastic.MeshPacket) error {
+	return p.WriteEnvelope(t, &meshtastic.ServiceEnvelope{
+		Packet:    packet,
+		ChannelId: channelID,
+		GatewayId: gatewayID,
+	})
+}
+
+// PCAPReader reads ServiceEnvelopes from a pcap file written by PCAPWriter.
+type PCAPReader struct {
+	r io.Reader
+}
+
+// NewPCAPReader reads and validates the pcap global header from r.
+func NewPCAPReader(r io.Reader) (*PCAPReader, error) {
+	header := make([]byte, globalHeaderLen)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("reading pcap header: %w", err)
+	}
+	if binary.LittleEndian.Uint32(header[0:]) != pcapMagicNanos ||
+		binary.LittleEndian.Uint32(header[20:]) != LinkTypeUser0 {
+		return nil, ErrNotPCAP
+	}
+	return &PCAPReader{r: r}, nil
+}
+
+// ReadEnvelope returns the next recorded envelope along with the time it was recorded. io.EOF is returned once all
+// records have been read.
+func (p *PCAPReader) ReadEnvelope() (time.Time, *meshtastic.ServiceEnvelope, error) {
+	t, data, err := p.ReadRecord()
+	if err != nil {
+		return time.Time{}, nil, err
+	}
+	se := &meshtastic.ServiceEnvelope{}
+	if err := proto.Unmarshal(data, se); err != nil {
+		return time.Time{}, nil, fmt.Errorf("unmarshalling service envelope: %w", err)
+	}
+	return t, se, nil
+}
+
+// ReadRecord returns the next record, normally a marshalled ServiceEnvelope, as recorded along with the time it was
+// recorded. Unlike ReadEnvelope, records which fail to unmarshal can still be read. io.EOF is returned once all
+// records have been read.
+func (p *PCAPReader) ReadRecord() (time.Time, []byte, error) {
+	header := make([]byte, recordHeaderLen)
+	if _, err := io.ReadFull(p.r, header); err != nil {
+		if errors.Is(err, io.EOF) {
+			return time.Time{}, nil, io.EOF
+		}
+		return time.Time{}, nil, fmt.Errorf("reading pcap record header: %w", err)
+	}
+	t := time.Unix(int64(binary.LittleEndian.Uint32(header[0:])), int64(binary.LittleEndian.Uint32(header[4:])))
+	length := binary.LittleEndian.Uint32(header[8:])
+	if length > SnapLen {
+		return time.Time{}, nil, fmt.Errorf("pcap record is %d bytes, larger than the snap length", length)
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(p.r, data); err != nil {
+		return time.Time{}, nil, fmt.Errorf("reading pcap record: %w", err)
+	}
+	return t, data, nil
+}
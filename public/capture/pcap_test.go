@@ -0,0 +1,55 @@
+package capture
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/rabarar/meshtastic"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestPCAP_RoundTrip(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w, err := NewPCAPWriter(buf)
+	require.NoError(t, err)
+	// Check the header against the layout Wireshark expects.
+	require.Equal(t, []byte{0x4d, 0x3c, 0xb2, 0xa1, 0x02, 0x00, 0x04, 0x00}, buf.Bytes()[:8])
+
+	first := time.Unix(1700000000, 123456789)
+	envelope := &meshtastic.ServiceEnvelope{
+		ChannelId: "LongFast",
+		GatewayId: "!00001234",
+		Packet: &meshtastic.MeshPacket{
+			Id:             1,
+			From:           0x1234,
+			PayloadVariant: &meshtastic.MeshPacket_Encrypted{Encrypted: []byte{0x01, 0x02, 0x03}},
+		},
+	}
+	require.NoError(t, w.WriteEnvelope(first, envelope))
+	second := first.Add(1500 * time.Millisecond)
+	packet := &meshtastic.MeshPacket{Id: 2, From: 0x5678}
+	require.NoError(t, w.WritePacket(second, "", "", packet))
+
+	r, err := NewPCAPReader(buf)
+	require.NoError(t, err)
+	ts, se, err := r.ReadEnvelope()
+	require.NoError(t, err)
+	require.True(t, first.Equal(ts))
+	require.True(t, proto.Equal(envelope, se))
+
+	ts, se, err = r.ReadEnvelope()
+	require.NoError(t, err)
+	require.True(t, second.Equal(ts))
+	require.True(t, proto.Equal(packet, se.Packet))
+
+	_, _, err = r.ReadEnvelope()
+	require.ErrorIs(t, err, io.EOF)
+}
+
+func TestNewPCAPReader_NotPCAP(t *testing.T) {
+	_, err := NewPCAPReader(bytes.NewReader(make([]byte, globalHeaderLen)))
+	require.ErrorIs(t, err, ErrNotPCAP)
+}
@@ -0,0 +1,52 @@
+package capture
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// RecordReader is a source of recorded messages for Replayer. ReadRecord returns the next record, normally a
+// marshalled ServiceEnvelope, along with the time it was recorded, or io.EOF once all records have been read.
+type RecordReader interface {
+	ReadRecord() (time.Time, []byte, error)
+}
+
+var (
+	_ RecordReader = (*PCAPReader)(nil)
+	_ RecordReader = (*LengthPrefixedReader)(nil)
+)
+
+// LengthPrefixedReader reads records stored as a little endian uint32 length followed by that many bytes of payload,
+// the layout commonly used when dumping raw MQTT payloads. The format has no timestamps, so records are replayed
+// without any delay between them.
+type LengthPrefixedReader struct {
+	r io.Reader
+}
+
+// NewLengthPrefixedReader returns a LengthPrefixedReader which reads records from r.
+func NewLengthPrefixedReader(r io.Reader) *LengthPrefixedReader {
+	return &LengthPrefixedReader{r: r}
+}
+
+// ReadRecord returns the next record with a zero time. io.EOF is returned once all records have been read.
+func (l *LengthPrefixedReader) ReadRecord() (time.Time, []byte, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(l.r, header); err != nil {
+		if errors.Is(err, io.EOF) {
+			return time.Time{}, nil, io.EOF
+		}
+		return time.Time{}, nil, fmt.Errorf("reading record length: %w", err)
+	}
+	length := binary.LittleEndian.Uint32(header)
+	if length > SnapLen {
+		return time.Time{}, nil, fmt.Errorf("record is %d bytes, larger than the snap length", length)
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(l.r, data); err != nil {
+		return time.Time{}, nil, fmt.Errorf("reading record: %w", err)
+	}
+	return time.Time{}, data, nil
+}
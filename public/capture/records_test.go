@@ -0,0 +1,38 @@
+package capture
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func lengthPrefixed(records ...[]byte) []byte {
+	var buf []byte
+	for _, r := range records {
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(len(r)))
+		buf = append(buf, r...)
+	}
+	return buf
+}
+
+func TestLengthPrefixedReader(t *testing.T) {
+	r := NewLengthPrefixedReader(bytes.NewReader(lengthPrefixed([]byte{0x01, 0x02}, nil, []byte{0x03})))
+	for _, want := range [][]byte{{0x01, 0x02}, {}, {0x03}} {
+		ts, data, err := r.ReadRecord()
+		require.NoError(t, err)
+		require.True(t, ts.IsZero())
+		require.Equal(t, want, data)
+	}
+	_, _, err := r.ReadRecord()
+	require.ErrorIs(t, err, io.EOF)
+}
+
+func TestLengthPrefixedReader_Truncated(t *testing.T) {
+	buf := lengthPrefixed([]byte{0x01, 0x02, 0x03})
+	r := NewLengthPrefixedReader(bytes.NewReader(buf[:len(buf)-1]))
+	_, _, err := r.ReadRecord()
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
@@ -0,0 +1,91 @@
+package capture
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/rabarar/meshtastic"
+	"github.com/rabarar/meshtool-go/public/mqtt"
+	"google.golang.org/protobuf/proto"
+)
+
+// DefaultTopicRoot is the topic root replayed messages are published under if Replayer.TopicRoot is empty.
+const DefaultTopicRoot = "msh"
+
+// Replayer feeds the envelopes recorded in a capture to an mqtt.HandlerFunc as if they had arrived live from the
+// broker, to reproduce bugs deterministically and to drive regression tests with real traffic.
+type Replayer struct {
+	// TopicRoot is the root of the topics messages are given, which are otherwise <root>/2/e/<channel>/<gateway> from
+	// the recorded envelope. Defaults to DefaultTopicRoot.
+	TopicRoot string
+	// Speed scales the delays between messages, which otherwise match the gaps between their recorded timestamps. A
+	// Speed of 2 replays twice as fast as recorded, and math.Inf(1) replays without any delay. Defaults to 1.
+	Speed float64
+
+	// sleep waits for d or until ctx is done, and can be replaced in tests.
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+// Replay reads every record from r, such as a PCAPReader or LengthPrefixedReader, and calls handler with each in turn,
+// waiting between them to preserve the timing of the capture. Records which are not valid envelopes are still passed
+// to the handler, with a topic lacking the channel and gateway, so that decoding failures can be reproduced. It
+// returns the number of messages replayed, and stops early if ctx is done.
+func (p *Replayer) Replay(ctx context.Context, r RecordReader, handler mqtt.HandlerFunc) (int, error) {
+	root := p.TopicRoot
+	if root == "" {
+		root = DefaultTopicRoot
+	}
+	speed := p.Speed
+	if speed <= 0 {
+		speed = 1
+	}
+	sleep := p.sleep
+	if sleep == nil {
+		sleep = sleepContext
+	}
+
+	var previous time.Time
+	n := 0
+	for {
+		if err := ctx.Err(); err != nil {
+			return n, err
+		}
+		ts, data, err := r.ReadRecord()
+		if errors.Is(err, io.EOF) {
+			return n, nil
+		}
+		if err != nil {
+			return n, fmt.Errorf("reading record %d: %w", n+1, err)
+		}
+		if n > 0 {
+			if delay := time.Duration(float64(ts.Sub(previous)) / speed); delay > 0 {
+				if err := sleep(ctx, delay); err != nil {
+					return n, err
+				}
+			}
+		}
+		previous = ts
+
+		topic := root + mqtt.MQTTProtoTopic
+		se := &meshtastic.ServiceEnvelope{}
+		if err := proto.Unmarshal(data, se); err == nil {
+			topic += se.GetChannelId() + "/" + se.GetGatewayId()
+		}
+		handler(mqtt.Message{Topic: topic, Payload: data})
+		n++
+	}
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,122 @@
+package capture
+
+import (
+	"bytes"
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/rabarar/meshtastic"
+	"github.com/rabarar/meshtool-go/public/mqtt"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestReplayer_Replay(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	capture := func(t *testing.T) *PCAPReader {
+		buf := &bytes.Buffer{}
+		w, err := NewPCAPWriter(buf)
+		require.NoError(t, err)
+		for i, offset := range []time.Duration{0, time.Second, 3 * time.Second} {
+			require.NoError(t, w.WritePacket(start.Add(offset), "LongFast", "!00001234", &meshtastic.MeshPacket{Id: uint32(i + 1)}))
+		}
+		r, err := NewPCAPReader(buf)
+		require.NoError(t, err)
+		return r
+	}
+
+	tests := []struct {
+		name       string
+		replayer   Replayer
+		wantTopic  string
+		wantDelays []time.Duration
+	}{
+		{
+			name:       "real time",
+			wantTopic:  "msh/2/e/LongFast/!00001234",
+			wantDelays: []time.Duration{time.Second, 2 * time.Second},
+		},
+		{
+			name:       "sped up",
+			replayer:   Replayer{TopicRoot: "msh/US", Speed: 2},
+			wantTopic:  "msh/US/2/e/LongFast/!00001234",
+			wantDelays: []time.Duration{500 * time.Millisecond, time.Second},
+		},
+		{
+			name:      "no delay",
+			replayer:  Replayer{Speed: math.Inf(1)},
+			wantTopic: "msh/2/e/LongFast/!00001234",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var delays []time.Duration
+			tc.replayer.sleep = func(_ context.Context, d time.Duration) error {
+				delays = append(delays, d)
+				return nil
+			}
+			var ids []uint32
+			n, err := tc.replayer.Replay(context.Background(), capture(t), func(m mqtt.Message) {
+				require.Equal(t, tc.wantTopic, m.Topic)
+				se := &meshtastic.ServiceEnvelope{}
+				require.NoError(t, proto.Unmarshal(m.Payload, se))
+				ids = append(ids, se.Packet.Id)
+			})
+			require.NoError(t, err)
+			require.Equal(t, 3, n)
+			require.Equal(t, []uint32{1, 2, 3}, ids)
+			require.Equal(t, tc.wantDelays, delays)
+		})
+	}
+}
+
+func TestReplayer_Replay_Cancelled(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w, err := NewPCAPWriter(buf)
+	require.NoError(t, err)
+	require.NoError(t, w.WritePacket(time.Unix(0, 0), "LongFast", "!00001234", &meshtastic.MeshPacket{Id: 1}))
+	require.NoError(t, w.WritePacket(time.Unix(3600, 0), "LongFast", "!00001234", &meshtastic.MeshPacket{Id: 2}))
+	r, err := NewPCAPReader(buf)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	n, err := (&Replayer{}).Replay(ctx, r, func(mqtt.Message) {
+		// Cancel while waiting an hour for the second message.
+		cancel()
+	})
+	require.ErrorIs(t, err, context.Canceled)
+	require.Equal(t, 1, n)
+}
+
+func TestReplayer_Replay_LengthPrefixed(t *testing.T) {
+	var records [][]byte
+	for i := range 3 {
+		data, err := proto.Marshal(&meshtastic.ServiceEnvelope{
+			ChannelId: "LongFast",
+			GatewayId: "!00001234",
+			Packet:    &meshtastic.MeshPacket{Id: uint32(i + 1)},
+		})
+		require.NoError(t, err)
+		records = append(records, data)
+	}
+	records = append(records, []byte("not an envelope"))
+
+	replayer := Replayer{sleep: func(context.Context, time.Duration) error {
+		t.Fatal("length prefixed records have no timestamps to wait between")
+		return nil
+	}}
+	var topics []string
+	n, err := replayer.Replay(context.Background(), NewLengthPrefixedReader(bytes.NewReader(lengthPrefixed(records...))), func(m mqtt.Message) {
+		topics = append(topics, m.Topic)
+	})
+	require.NoError(t, err)
+	require.Equal(t, 4, n)
+	require.Equal(t, []string{
+		"msh/2/e/LongFast/!00001234",
+		"msh/2/e/LongFast/!00001234",
+		"msh/2/e/LongFast/!00001234",
+		"msh/2/e/",
+	}, topics)
+}
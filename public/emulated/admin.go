@@ -0,0 +1,301 @@
+package emulated
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/rabarar/meshtastic"
+	"github.com/rabarar/meshtool-go/public/meshtool"
+	"github.com/rabarar/meshtool-go/public/mqtt"
+	"github.com/rabarar/meshtool-go/public/radio"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// MaxChannels is the number of channel slots on the emulated radio, matching the firmware.
+const MaxChannels = 8
+
+// newDeviceChannels creates the radio's channel slots from a ChannelSet. The first channel in the set is primary, the
+// rest are secondary and any remaining slots are disabled.
+func newDeviceChannels(set *meshtastic.ChannelSet) []*meshtastic.Channel {
+	channels := make([]*meshtastic.Channel, MaxChannels)
+	for i := range channels {
+		ch := &meshtastic.Channel{Index: int32(i), Role: meshtastic.Channel_DISABLED}
+		if i < len(set.GetSettings()) {
+			ch.Settings = proto.Clone(set.GetSettings()[i]).(*meshtastic.ChannelSettings)
+			ch.Role = meshtastic.Channel_SECONDARY
+			if i == 0 {
+				ch.Role = meshtastic.Channel_PRIMARY
+			}
+		}
+		channels[i] = ch
+	}
+	return channels
+}
+
+// newConfigs creates the radio's initial config from the emulator's Config.
+func newConfigs(cfg Config) map[meshtastic.AdminMessage_ConfigType]*meshtastic.Config {
+	return map[meshtastic.AdminMessage_ConfigType]*meshtastic.Config{
+		meshtastic.AdminMessage_DEVICE_CONFIG: {
+			PayloadVariant: &meshtastic.Config_Device{
+				Device: &meshtastic.Config_DeviceConfig{
+					SerialEnabled:         true,
+					NodeInfoBroadcastSecs: uint32(cfg.BroadcastNodeInfoInterval.Seconds()),
+				},
+			},
+		},
+		meshtastic.AdminMessage_LORA_CONFIG: {
+			PayloadVariant: &meshtastic.Config_Lora{
+				Lora: &meshtastic.Config_LoRaConfig{
+					HopLimit: DefaultHopLimit,
+				},
+			},
+		},
+		meshtastic.AdminMessage_POSITION_CONFIG: {
+			PayloadVariant: &meshtastic.Config_Position{
+				Position: &meshtastic.Config_PositionConfig{
+					PositionBroadcastSecs: uint32(cfg.BroadcastPositionInterval.Seconds()),
+					PositionFlags:         DefaultPositionFlags,
+				},
+			},
+		},
+	}
+}
+
+// newModuleConfigs creates the radio's initial module config from the emulator's Config. The radio is always
+// connected to the mesh via MQTT.
+func newModuleConfigs(cfg Config) map[meshtastic.AdminMessage_ModuleConfigType]*meshtastic.ModuleConfig {
+	return map[meshtastic.AdminMessage_ModuleConfigType]*meshtastic.ModuleConfig{
+		meshtastic.AdminMessage_TELEMETRY_CONFIG: {
+			PayloadVariant: &meshtastic.ModuleConfig_Telemetry{
+				Telemetry: &meshtastic.ModuleConfig_TelemetryConfig{
+					DeviceUpdateInterval: uint32(cfg.BroadcastTelemetryInterval.Seconds()),
+				},
+			},
+		},
+		meshtastic.AdminMessage_STOREFORWARD_CONFIG: {
+			PayloadVariant: &meshtastic.ModuleConfig_StoreForward{
+				StoreForward: &meshtastic.ModuleConfig_StoreForwardConfig{
+					Enabled:  cfg.StoreForwardHistorySize > 0,
+					IsServer: cfg.StoreForwardHistorySize > 0,
+					Records:  uint32(cfg.StoreForwardHistorySize),
+				},
+			},
+		},
+		meshtastic.AdminMessage_MQTT_CONFIG: {
+			PayloadVariant: &meshtastic.ModuleConfig_Mqtt{
+				Mqtt: &meshtastic.ModuleConfig_MQTTConfig{
+					Enabled:           true,
+					EncryptionEnabled: true,
+				},
+			},
+		},
+	}
+}
+
+// getChannels returns the channels currently configured on the radio.
+func (r *Radio) getChannels() *radio.Channels {
+	r.configMu.RLock()
+	defer r.configMu.RUnlock()
+	return r.channels
+}
+
+// deviceChannels returns a copy of each of the radio's channel slots, including disabled ones.
+func (r *Radio) deviceChannels() []*meshtastic.Channel {
+	r.configMu.RLock()
+	defer r.configMu.RUnlock()
+	channels := make([]*meshtastic.Channel, len(r.channelSlots))
+	for i, ch := range r.channelSlots {
+		channels[i] = proto.Clone(ch).(*meshtastic.Channel)
+	}
+	return channels
+}
+
+// setChannel replaces a channel slot, as requested by an AdminMessage. The radio must be left with exactly one primary
+// channel.
+func (r *Radio) setChannel(ch *meshtastic.Channel) error {
+	if ch.GetIndex() < 0 || ch.GetIndex() >= MaxChannels {
+		return fmt.Errorf("channel index %d out of range", ch.GetIndex())
+	}
+	r.configMu.Lock()
+	slots := slices.Clone(r.channelSlots)
+	slots[ch.Index] = proto.Clone(ch).(*meshtastic.Channel)
+	channels, err := radio.NewChannelsFromDevice(slots, r.configs[meshtastic.AdminMessage_LORA_CONFIG].GetLora())
+	if err != nil {
+		r.configMu.Unlock()
+		return err
+	}
+	r.channelSlots = slots
+	r.channels = channels
+	r.configMu.Unlock()
+
+	r.unsubscribeRemovedChannels()
+	if ch.GetRole() != meshtastic.Channel_DISABLED {
+		r.subscribeChannel(ch.GetSettings().GetName())
+	}
+	return nil
+}
+
+// subscribeChannel subscribes to MQTT messages for a channel, unless the radio is already subscribed. Until Run
+// subscribes to the radio's initial channels, this is a no-op.
+func (r *Radio) subscribeChannel(name string) {
+	r.configMu.Lock()
+	defer r.configMu.Unlock()
+	if r.subscribedChannels == nil {
+		return
+	}
+	if _, ok := r.subscribedChannels[name]; ok {
+		return
+	}
+	r.subscribedChannels[name] = struct{}{}
+	r.logger.Debug("subscribing to mqtt for channel", "channel", name)
+	ctx := r.runCtx
+	r.mqtt.Handle(name, func(msg mqtt.Message) {
+		r.handleMQTTMessage(ctx, msg)
+	})
+}
+
+// unsubscribeRemovedChannels unsubscribes from MQTT messages for channels which the radio no longer has, if the MQTT
+// client supports it. Otherwise the subscriptions are kept, and messages for removed channels are ignored as for any
+// other channel the radio has no key for.
+func (r *Radio) unsubscribeRemovedChannels() {
+	client, ok := r.mqtt.(unsubscribableMQTTClient)
+	if !ok {
+		return
+	}
+	r.configMu.Lock()
+	defer r.configMu.Unlock()
+	for name := range r.subscribedChannels {
+		if _, ok := r.channels.ByName(name); ok {
+			continue
+		}
+		delete(r.subscribedChannels, name)
+		r.logger.Debug("unsubscribing from mqtt for channel", "channel", name)
+		client.Unsubscribe(name)
+	}
+}
+
+// hopLimit returns the hop limit from the radio's LoRa config, or DefaultHopLimit if it is not set.
+func (r *Radio) hopLimit() uint32 {
+	r.configMu.RLock()
+	defer r.configMu.RUnlock()
+	if hopLimit := r.configs[meshtastic.AdminMessage_LORA_CONFIG].GetLora().GetHopLimit(); hopLimit != 0 {
+		return hopLimit
+	}
+	return DefaultHopLimit
+}
+
+// positionFlags returns the position flags from the radio's position config.
+func (r *Radio) positionFlags() uint32 {
+	r.configMu.RLock()
+	defer r.configMu.RUnlock()
+	return r.configs[meshtastic.AdminMessage_POSITION_CONFIG].GetPosition().GetPositionFlags()
+}
+
+// positionPrecision returns the number of bits of precision positions are sent with on the channel with the given
+// index. As in the firmware, channels without module settings send positions with full precision.
+func (r *Radio) positionPrecision(channel int) uint32 {
+	r.configMu.RLock()
+	defer r.configMu.RUnlock()
+	if channel < 0 || channel >= len(r.channelSlots) {
+		return meshtool.FullPositionPrecision
+	}
+	settings := r.channelSlots[channel].GetSettings()
+	if settings.GetModuleSettings() == nil {
+		return meshtool.FullPositionPrecision
+	}
+	return settings.GetModuleSettings().GetPositionPrecision()
+}
+
+// getConfig returns the config of the given type. Types which have not been set are returned empty.
+func (r *Radio) getConfig(t meshtastic.AdminMessage_ConfigType) (*meshtastic.Config, error) {
+	r.configMu.RLock()
+	defer r.configMu.RUnlock()
+	if cfg, ok := r.configs[t]; ok {
+		return proto.Clone(cfg).(*meshtastic.Config), nil
+	}
+	cfg := &meshtastic.Config{}
+	if err := setEmptyVariant(cfg, int32(t)); err != nil {
+		return nil, fmt.Errorf("config type %s: %w", t, err)
+	}
+	return cfg, nil
+}
+
+// setConfig replaces the config of the type contained in cfg.
+func (r *Radio) setConfig(cfg *meshtastic.Config) error {
+	t, err := variantType(cfg)
+	if err != nil {
+		return err
+	}
+	r.configMu.Lock()
+	defer r.configMu.Unlock()
+	r.configs[meshtastic.AdminMessage_ConfigType(t)] = proto.Clone(cfg).(*meshtastic.Config)
+	return nil
+}
+
+// getModuleConfig returns the module config of the given type. Types which have not been set are returned empty.
+func (r *Radio) getModuleConfig(t meshtastic.AdminMessage_ModuleConfigType) (*meshtastic.ModuleConfig, error) {
+	r.configMu.RLock()
+	defer r.configMu.RUnlock()
+	if cfg, ok := r.moduleConfigs[t]; ok {
+		return proto.Clone(cfg).(*meshtastic.ModuleConfig), nil
+	}
+	cfg := &meshtastic.ModuleConfig{}
+	if err := setEmptyVariant(cfg, int32(t)); err != nil {
+		return nil, fmt.Errorf("module config type %s: %w", t, err)
+	}
+	return cfg, nil
+}
+
+// setModuleConfig replaces the module config of the type contained in cfg.
+func (r *Radio) setModuleConfig(cfg *meshtastic.ModuleConfig) error {
+	t, err := variantType(cfg)
+	if err != nil {
+		return err
+	}
+	r.configMu.Lock()
+	defer r.configMu.Unlock()
+	r.moduleConfigs[meshtastic.AdminMessage_ModuleConfigType(t)] = proto.Clone(cfg).(*meshtastic.ModuleConfig)
+	return nil
+}
+
+// allConfigs returns each config and module config held by the radio, ordered by type, for sending to clients.
+func (r *Radio) allConfigs() ([]*meshtastic.Config, []*meshtastic.ModuleConfig) {
+	r.configMu.RLock()
+	defer r.configMu.RUnlock()
+	var configs []*meshtastic.Config
+	for _, t := range slices.Sorted(maps.Keys(r.configs)) {
+		configs = append(configs, proto.Clone(r.configs[t]).(*meshtastic.Config))
+	}
+	var moduleConfigs []*meshtastic.ModuleConfig
+	for _, t := range slices.Sorted(maps.Keys(r.moduleConfigs)) {
+		moduleConfigs = append(moduleConfigs, proto.Clone(r.moduleConfigs[t]).(*meshtastic.ModuleConfig))
+	}
+	return configs, moduleConfigs
+}
+
+// The fields of the payload_variant oneofs of Config and ModuleConfig are numbered one higher than the corresponding
+// AdminMessage_ConfigType and AdminMessage_ModuleConfigType, which allows converting between them without listing
+// every variant.
+
+// setEmptyVariant sets the payload_variant of a Config or ModuleConfig to an empty message of the given type.
+func setEmptyVariant(cfg proto.Message, t int32) error {
+	m := cfg.ProtoReflect()
+	fd := m.Descriptor().Fields().ByNumber(protoreflect.FieldNumber(t + 1))
+	if fd == nil || fd.ContainingOneof() == nil {
+		return fmt.Errorf("unknown type %d", t)
+	}
+	m.Set(fd, m.NewField(fd))
+	return nil
+}
+
+// variantType returns the type of the payload_variant set in a Config or ModuleConfig.
+func variantType(cfg proto.Message) (int32, error) {
+	m := cfg.ProtoReflect()
+	fd := m.WhichOneof(m.Descriptor().Oneofs().ByName("payload_variant"))
+	if fd == nil {
+		return 0, fmt.Errorf("%s has no payload", m.Descriptor().Name())
+	}
+	return int32(fd.Number()) - 1, nil
+}
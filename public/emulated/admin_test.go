@@ -0,0 +1,174 @@
+package emulated
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rabarar/meshtastic"
+	"github.com/rabarar/meshtool-go/public/transport"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestConfigVariants(t *testing.T) {
+	// Every config type maps to the Config variant of the same name.
+	for value, name := range meshtastic.AdminMessage_ConfigType_name {
+		cfg := &meshtastic.Config{}
+		require.NoError(t, setEmptyVariant(cfg, value), name)
+		got, err := variantType(cfg)
+		require.NoError(t, err)
+		require.Equal(t, value, got)
+		m := cfg.ProtoReflect()
+		field := m.WhichOneof(m.Descriptor().Oneofs().ByName("payload_variant")).Name()
+		require.Equal(t, normalise(strings.TrimSuffix(name, "_CONFIG")), normalise(string(field)))
+	}
+	for value, name := range meshtastic.AdminMessage_ModuleConfigType_name {
+		cfg := &meshtastic.ModuleConfig{}
+		require.NoError(t, setEmptyVariant(cfg, value), name)
+		got, err := variantType(cfg)
+		require.NoError(t, err)
+		require.Equal(t, value, got)
+	}
+}
+
+// normalise lowercases s and removes underscores, so that enum values can be compared with field names.
+func normalise(s string) string {
+	return strings.ReplaceAll(strings.ToLower(s), "_", "")
+}
+
+// adminClient connects a transport.Client to the radio, returning a function which sends an AdminMessage to the
+// radio and waits for the response, if want is true.
+func adminClient(t *testing.T, r *Radio) func(admin *meshtastic.AdminMessage, want bool) *meshtastic.AdminMessage {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+	sc, err := transport.NewClientStreamConn(r.Conn(ctx))
+	require.NoError(t, err)
+	client := transport.NewClient(sc, false)
+	require.NoError(t, client.Connect(ctx))
+	packets, stop := client.SubscribePackets()
+	t.Cleanup(stop)
+
+	return func(admin *meshtastic.AdminMessage, want bool) *meshtastic.AdminMessage {
+		msg, err := transport.NewPayloadPacket(r.cfg.NodeID, r.cfg.NodeID, admin)
+		require.NoError(t, err)
+		id, err := client.SendPacket(msg.GetPacket())
+		require.NoError(t, err)
+		if !want {
+			return nil
+		}
+		for {
+			select {
+			case packet := <-packets:
+				if packet.GetDecoded().GetRequestId() != id {
+					continue
+				}
+				resp := &meshtastic.AdminMessage{}
+				require.NoError(t, proto.Unmarshal(packet.GetDecoded().GetPayload(), resp))
+				return resp
+			case <-ctx.Done():
+				t.Fatal("no admin response")
+			}
+		}
+	}
+}
+
+func TestRadio_AdminConfig(t *testing.T) {
+	r := newTestRadio(t, withSecondaryChannel("Other", nil))
+	send := adminClient(t, r)
+
+	resp := send(&meshtastic.AdminMessage{
+		PayloadVariant: &meshtastic.AdminMessage_GetConfigRequest{GetConfigRequest: meshtastic.AdminMessage_LORA_CONFIG},
+	}, true)
+	require.NotNil(t, resp.GetGetConfigResponse().GetLora())
+
+	lora := &meshtastic.Config{PayloadVariant: &meshtastic.Config_Lora{Lora: &meshtastic.Config_LoRaConfig{
+		Region:   meshtastic.Config_LoRaConfig_EU_868,
+		HopLimit: 5,
+	}}}
+	send(&meshtastic.AdminMessage{PayloadVariant: &meshtastic.AdminMessage_SetConfig{SetConfig: lora}}, false)
+	resp = send(&meshtastic.AdminMessage{
+		PayloadVariant: &meshtastic.AdminMessage_GetConfigRequest{GetConfigRequest: meshtastic.AdminMessage_LORA_CONFIG},
+	}, true)
+	require.True(t, proto.Equal(lora, resp.GetGetConfigResponse()))
+
+	resp = send(&meshtastic.AdminMessage{
+		PayloadVariant: &meshtastic.AdminMessage_GetModuleConfigRequest{GetModuleConfigRequest: meshtastic.AdminMessage_MQTT_CONFIG},
+	}, true)
+	require.True(t, resp.GetGetModuleConfigResponse().GetMqtt().GetEnabled())
+	telemetry := &meshtastic.ModuleConfig{PayloadVariant: &meshtastic.ModuleConfig_Telemetry{
+		Telemetry: &meshtastic.ModuleConfig_TelemetryConfig{DeviceUpdateInterval: 900},
+	}}
+	send(&meshtastic.AdminMessage{PayloadVariant: &meshtastic.AdminMessage_SetModuleConfig{SetModuleConfig: telemetry}}, false)
+	resp = send(&meshtastic.AdminMessage{
+		PayloadVariant: &meshtastic.AdminMessage_GetModuleConfigRequest{GetModuleConfigRequest: meshtastic.AdminMessage_TELEMETRY_CONFIG},
+	}, true)
+	require.True(t, proto.Equal(telemetry, resp.GetGetModuleConfigResponse()))
+}
+
+func TestRadio_AdminChannels(t *testing.T) {
+	r := newTestRadio(t, withSecondaryChannel("Other", nil))
+	send := adminClient(t, r)
+
+	// The request holds the index of the channel plus one.
+	resp := send(&meshtastic.AdminMessage{
+		PayloadVariant: &meshtastic.AdminMessage_GetChannelRequest{GetChannelRequest: 2},
+	}, true)
+	require.Equal(t, "Other", resp.GetGetChannelResponse().GetSettings().GetName())
+	require.Equal(t, meshtastic.Channel_SECONDARY, resp.GetGetChannelResponse().GetRole())
+	resp = send(&meshtastic.AdminMessage{
+		PayloadVariant: &meshtastic.AdminMessage_GetChannelRequest{GetChannelRequest: 3},
+	}, true)
+	require.Equal(t, meshtastic.Channel_DISABLED, resp.GetGetChannelResponse().GetRole())
+
+	send(&meshtastic.AdminMessage{PayloadVariant: &meshtastic.AdminMessage_SetChannel{SetChannel: &meshtastic.Channel{
+		Index:    2,
+		Settings: &meshtastic.ChannelSettings{Name: "Added", Psk: []byte{1}},
+		Role:     meshtastic.Channel_SECONDARY,
+	}}}, false)
+	// Disabling the primary channel is rejected.
+	send(&meshtastic.AdminMessage{PayloadVariant: &meshtastic.AdminMessage_SetChannel{SetChannel: &meshtastic.Channel{
+		Index: 0,
+		Role:  meshtastic.Channel_DISABLED,
+	}}}, false)
+	resp = send(&meshtastic.AdminMessage{
+		PayloadVariant: &meshtastic.AdminMessage_GetChannelRequest{GetChannelRequest: 3},
+	}, true)
+	require.Equal(t, "Added", resp.GetGetChannelResponse().GetSettings().GetName())
+
+	added, ok := r.getChannels().ByIndex(2)
+	require.True(t, ok)
+	require.Equal(t, "Added", added.Name)
+	require.Equal(t, "LongFast", r.getChannels().Primary().Name)
+}
+
+// unsubscribingBus wraps a Bus, recording the channels unsubscribed from.
+type unsubscribingBus struct {
+	*Bus
+	unsubscribed []string
+}
+
+func (b *unsubscribingBus) Unsubscribe(channel string) {
+	b.unsubscribed = append(b.unsubscribed, channel)
+}
+
+func TestRadio_setChannel_Unsubscribes(t *testing.T) {
+	r := newTestRadio(t, withSecondaryChannel("Other", nil))
+	client := &unsubscribingBus{Bus: r.cfg.Bus}
+	r.mqtt = client
+	runTestRadio(t, r)
+
+	// Renaming a channel unsubscribes from its old name and subscribes to the new one.
+	require.NoError(t, r.setChannel(&meshtastic.Channel{
+		Index:    1,
+		Settings: &meshtastic.ChannelSettings{Name: "Renamed"},
+		Role:     meshtastic.Channel_SECONDARY,
+	}))
+	require.Equal(t, []string{"Other"}, client.unsubscribed)
+	require.Contains(t, r.subscribedChannels, "Renamed")
+
+	require.NoError(t, r.setChannel(&meshtastic.Channel{Index: 1, Role: meshtastic.Channel_DISABLED}))
+	require.Equal(t, []string{"Other", "Renamed"}, client.unsubscribed)
+	require.Equal(t, map[string]struct{}{"LongFast": {}}, r.subscribedChannels)
+}
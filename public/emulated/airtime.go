@@ -0,0 +1,103 @@
+package emulated
+
+import (
+	"sync"
+	"time"
+
+	"github.com/rabarar/meshtastic"
+	"github.com/rabarar/meshtool-go/public/lora"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// channelUtilizationWindow is the period channel utilization is reported over, matching the firmware.
+	channelUtilizationWindow = time.Minute
+	// airUtilTxWindow is the period the radio's own transmit airtime is reported over, matching the firmware.
+	airUtilTxWindow = time.Hour
+	// packetHeaderLen is the length of the header sent over the air before a packet's payload.
+	packetHeaderLen = 16
+)
+
+type airtimeEntry struct {
+	at      time.Time
+	airtime time.Duration
+	tx      bool
+}
+
+// airtimeTracker records the time the packets sent and received by the radio would have spent on air, to report
+// channel utilization and transmit airtime in the same way as the firmware.
+type airtimeTracker struct {
+	now func() time.Time
+
+	mu sync.Mutex
+	// entries are held oldest first, and are forgotten once older than airUtilTxWindow.
+	entries []airtimeEntry
+}
+
+func newAirtimeTracker() *airtimeTracker {
+	return &airtimeTracker{now: time.Now}
+}
+
+// record adds a packet which was sent, if tx is true, or received.
+func (a *airtimeTracker) record(airtime time.Duration, tx bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := a.now()
+	a.prune(now)
+	a.entries = append(a.entries, airtimeEntry{at: now, airtime: airtime, tx: tx})
+}
+
+// utilization returns the percentage of the last channelUtilizationWindow in which the channel was busy with packets
+// sent or received, and the percentage of the last airUtilTxWindow spent transmitting.
+func (a *airtimeTracker) utilization() (channel, airUtilTx float32) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := a.now()
+	a.prune(now)
+	var busy, tx time.Duration
+	for _, entry := range a.entries {
+		if entry.tx {
+			tx += entry.airtime
+		}
+		if now.Sub(entry.at) < channelUtilizationWindow {
+			busy += entry.airtime
+		}
+	}
+	return percentOf(busy, channelUtilizationWindow), percentOf(tx, airUtilTxWindow)
+}
+
+// prune forgets entries which are too old to be reported. The caller must hold a.mu.
+func (a *airtimeTracker) prune(now time.Time) {
+	i := 0
+	for i < len(a.entries) && now.Sub(a.entries[i].at) >= airUtilTxWindow {
+		i++
+	}
+	a.entries = a.entries[i:]
+}
+
+func percentOf(d, window time.Duration) float32 {
+	return float32(min(100*float64(d)/float64(window), 100))
+}
+
+// packetAirLen returns the number of bytes a packet occupies over the air: its header and payload.
+func packetAirLen(packet *meshtastic.MeshPacket) int {
+	if encrypted := packet.GetEncrypted(); encrypted != nil {
+		return packetHeaderLen + len(encrypted)
+	}
+	return packetHeaderLen + proto.Size(packet.GetDecoded())
+}
+
+// recordAirtime records a packet sent or received by the radio, with the airtime it would take using the
+// modulation from the radio's LoRa config.
+func (r *Radio) recordAirtime(packet *meshtastic.MeshPacket, tx bool) {
+	n := packetAirLen(packet)
+	if tx {
+		r.stats.bytesSent.Add(uint64(n))
+	} else {
+		r.stats.bytesReceived.Add(uint64(n))
+	}
+	r.configMu.RLock()
+	modulation := lora.ModulationForConfig(r.configs[meshtastic.AdminMessage_LORA_CONFIG].GetLora())
+	r.configMu.RUnlock()
+	r.airtime.record(modulation.TimeOnAir(n), tx)
+}
@@ -0,0 +1,51 @@
+package emulated
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/rabarar/meshtastic"
+	"github.com/rabarar/meshtool-go/public/lora"
+	"github.com/rabarar/meshtool-go/public/meshtool"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAirtimeTracker(t *testing.T) {
+	now := time.Unix(1000, 0)
+	a := newAirtimeTracker()
+	a.now = func() time.Time {
+		return now
+	}
+
+	a.record(6*time.Second, true)
+	a.record(3*time.Second, false)
+	channel, tx := a.utilization()
+	require.InDelta(t, 15, channel, 1e-3)
+	require.InDelta(t, 100*6.0/3600, tx, 1e-3)
+
+	// Once a minute has passed, the packets no longer count towards channel utilization but are still included in
+	// the transmit airtime for the hour.
+	now = now.Add(time.Minute)
+	channel, tx = a.utilization()
+	require.Zero(t, channel)
+	require.InDelta(t, 100*6.0/3600, tx, 1e-3)
+
+	now = now.Add(time.Hour)
+	channel, tx = a.utilization()
+	require.Zero(t, channel)
+	require.Zero(t, tx)
+	require.Empty(t, a.entries)
+}
+
+func TestRadio_Stats_Airtime(t *testing.T) {
+	r := newTestRadio(t)
+	require.NoError(t, r.sendText(context.Background(), meshtool.BroadcastNodeID.Uint32(), 0, []byte("hello")))
+
+	stats := r.Stats()
+	require.NotZero(t, stats.BytesSent)
+	require.Zero(t, stats.BytesReceived)
+	airtime := lora.ModemPresets[meshtastic.Config_LoRaConfig_LONG_FAST].TimeOnAir(int(stats.BytesSent))
+	require.InDelta(t, 100*float64(airtime)/float64(time.Minute), stats.ChannelUtilization, 1e-3)
+	require.InDelta(t, 100*float64(airtime)/float64(time.Hour), stats.AirUtilTx, 1e-3)
+}
@@ -0,0 +1,99 @@
+package emulated
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/rabarar/meshtastic"
+	"github.com/rabarar/meshtool-go/public/mqtt"
+)
+
+// MQTTClient is the subset of mqtt.Client used by the emulated Radio to communicate with the mesh.
+// It is satisfied by *mqtt.Client and *Bus.
+type MQTTClient interface {
+	Connect() error
+	Handle(channel string, h mqtt.HandlerFunc)
+	Publish(m *mqtt.Message) error
+	PublishEnvelope(channel, gatewayID string, se *meshtastic.ServiceEnvelope) error
+	GetFullTopicForChannel(channel string) string
+}
+
+var (
+	_ MQTTClient = (*mqtt.Client)(nil)
+	_ MQTTClient = (*Bus)(nil)
+
+	_ unsubscribableMQTTClient = (*mqtt.Client)(nil)
+)
+
+// unsubscribableMQTTClient is implemented by MQTT clients which can stop handling a channel, such as *mqtt.Client. The
+// Bus does not, as its handlers are shared by every radio on it.
+type unsubscribableMQTTClient interface {
+	Unsubscribe(channel string)
+}
+
+// Bus is an in-memory message bus which emulates an MQTT broker and client. Several emulated radios sharing a Bus form
+// a local mesh without any network access, which is useful for tests.
+type Bus struct {
+	topicRoot string
+
+	mu              sync.RWMutex
+	channelHandlers map[string][]mqtt.HandlerFunc
+}
+
+// NewBus creates a new in-memory Bus using the given MQTT root topic.
+func NewBus(topicRoot string) *Bus {
+	return &Bus{
+		topicRoot:       topicRoot,
+		channelHandlers: map[string][]mqtt.HandlerFunc{},
+	}
+}
+
+// Connect is a no-op as the Bus is always connected.
+func (b *Bus) Connect() error {
+	return nil
+}
+
+// Handle registers a handler for messages published on the specified channel.
+func (b *Bus) Handle(channel string, h mqtt.HandlerFunc) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.channelHandlers[channel] = append(b.channelHandlers[channel], h)
+}
+
+// Publish delivers a message to all handlers registered for the channel in the message's topic. As with mqtt.Client,
+// handlers are called asynchronously.
+func (b *Bus) Publish(m *mqtt.Message) error {
+	channel, ok := b.channelFromTopic(m.Topic)
+	if !ok {
+		return nil
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, h := range b.channelHandlers[channel] {
+		go h(*m)
+	}
+	return nil
+}
+
+// PublishEnvelope publishes a ServiceEnvelope from the gateway gatewayID on channel. See mqtt.NewEnvelopeMessage.
+func (b *Bus) PublishEnvelope(channel, gatewayID string, se *meshtastic.ServiceEnvelope) error {
+	m, err := mqtt.NewEnvelopeMessage(b.GetFullTopicForChannel(channel), channel, gatewayID, se)
+	if err != nil {
+		return err
+	}
+	return b.Publish(m)
+}
+
+// GetFullTopicForChannel returns the topic messages for the channel are published under.
+func (b *Bus) GetFullTopicForChannel(channel string) string {
+	return b.topicRoot + mqtt.MQTTProtoTopic + channel
+}
+
+func (b *Bus) channelFromTopic(topic string) (string, bool) {
+	prefix := b.topicRoot + mqtt.MQTTProtoTopic
+	if !strings.HasPrefix(topic, prefix) {
+		return "", false
+	}
+	channel, _, _ := strings.Cut(strings.TrimPrefix(topic, prefix), "/")
+	return channel, true
+}
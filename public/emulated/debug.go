@@ -0,0 +1,75 @@
+package emulated
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// debugShutdownTimeout is how long in-flight debug requests are given to complete when the radio stops.
+const debugShutdownTimeout = 5 * time.Second
+
+// serveDebugHTTP serves the debug endpoints on Config.DebugHTTPAddr until ctx is cancelled.
+func (r *Radio) serveDebugHTTP(ctx context.Context) error {
+	srv := &http.Server{
+		Addr:    r.cfg.DebugHTTPAddr,
+		Handler: r.debugHandler(),
+	}
+	errCh := make(chan error, 1)
+	go func() {
+		r.logger.Info("serving debug endpoints", "addr", r.cfg.DebugHTTPAddr)
+		errCh <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errCh:
+		return fmt.Errorf("serving debug http: %w", err)
+	case <-ctx.Done():
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), debugShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("shutting down debug http: %w", err)
+	}
+	if err := <-errCh; !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("serving debug http: %w", err)
+	}
+	return nil
+}
+
+func (r *Radio) debugHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("ok\n"))
+	})
+	mux.HandleFunc("/stats", func(w http.ResponseWriter, req *http.Request) {
+		r.writeDebugJSON(w, r.Stats())
+	})
+	mux.HandleFunc("/nodes", func(w http.ResponseWriter, req *http.Request) {
+		// NodeInfo is a protobuf message, so use protojson to get the canonical JSON form of each node.
+		nodes := []json.RawMessage{}
+		for _, node := range r.Nodes() {
+			b, err := protojson.Marshal(node)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			nodes = append(nodes, b)
+		}
+		r.writeDebugJSON(w, nodes)
+	})
+	return mux
+}
+
+func (r *Radio) writeDebugJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		r.logger.Error("failed to write debug response", "err", err)
+	}
+}
@@ -0,0 +1,43 @@
+package emulated
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/rabarar/meshtastic"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRadio_debugHandler(t *testing.T) {
+	r := newTestRadio(t)
+	r.updateNodeDB(0xdeadbeef, &meshtastic.User{LongName: "Remote"})
+	srv := httptest.NewServer(r.debugHandler())
+	defer srv.Close()
+
+	res, err := http.Get(srv.URL + "/healthz")
+	require.NoError(t, err)
+	require.NoError(t, res.Body.Close())
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	res, err = http.Get(srv.URL + "/stats")
+	require.NoError(t, err)
+	stats := Stats{}
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&stats))
+	require.NoError(t, res.Body.Close())
+	// The nodeDB contains our own node as well as the remote one.
+	require.Equal(t, 2, stats.Nodes)
+
+	res, err = http.Get(srv.URL + "/nodes")
+	require.NoError(t, err)
+	var nodes []map[string]any
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&nodes))
+	require.NoError(t, res.Body.Close())
+	require.Len(t, nodes, 2)
+	var names []any
+	for _, node := range nodes {
+		names = append(names, node["user"].(map[string]any)["longName"])
+	}
+	require.Contains(t, names, "Remote")
+}
@@ -0,0 +1,83 @@
+package emulated
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// DefaultDuplicateWindow is the default period within which a packet heard again from MQTT is treated as a duplicate,
+// see Config.DuplicateWindow.
+const DefaultDuplicateWindow = 10 * time.Minute
+
+// packetHistorySize is the number of packets remembered for detecting duplicates. The oldest packets are forgotten
+// first once it is reached.
+const packetHistorySize = 1024
+
+// packetKey identifies a packet across rebroadcasts.
+type packetKey struct {
+	from uint32
+	id   uint32
+}
+
+type packetHistoryEntry struct {
+	key     packetKey
+	heardAt time.Time
+}
+
+// packetHistory is a least recently used record of the packets heard, used to drop the copies of a packet which are
+// rebroadcast by several gateways.
+type packetHistory struct {
+	window time.Duration
+	size   int
+	now    func() time.Time
+
+	mu      sync.Mutex
+	entries map[packetKey]*list.Element
+	// order holds the entries with the most recently heard at the front.
+	order *list.List
+}
+
+func newPacketHistory(window time.Duration, size int) *packetHistory {
+	return &packetHistory{
+		window:  window,
+		size:    size,
+		now:     time.Now,
+		entries: map[packetKey]*list.Element{},
+		order:   list.New(),
+	}
+}
+
+// seen records that the packet with the given ID was heard from the node, reporting whether it was already heard
+// within the window.
+func (h *packetHistory) seen(from, id uint32) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	now := h.now()
+	key := packetKey{from: from, id: id}
+	if el, ok := h.entries[key]; ok {
+		h.order.MoveToFront(el)
+		entry := el.Value.(*packetHistoryEntry)
+		if now.Sub(entry.heardAt) < h.window {
+			return true
+		}
+		entry.heardAt = now
+		return false
+	}
+	h.entries[key] = h.order.PushFront(&packetHistoryEntry{key: key, heardAt: now})
+	for h.order.Len() > h.size {
+		oldest := h.order.Back()
+		h.order.Remove(oldest)
+		delete(h.entries, oldest.Value.(*packetHistoryEntry).key)
+	}
+	return false
+}
+
+// contains reports whether the packet with the given ID was heard from the node within the window, without recording
+// it.
+func (h *packetHistory) contains(from, id uint32) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	el, ok := h.entries[packetKey{from: from, id: id}]
+	return ok && h.now().Sub(el.Value.(*packetHistoryEntry).heardAt) < h.window
+}
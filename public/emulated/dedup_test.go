@@ -0,0 +1,78 @@
+package emulated
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/rabarar/meshtastic"
+	"github.com/rabarar/meshtool-go/public/meshtool"
+	"github.com/rabarar/meshtool-go/public/mqtt"
+	"github.com/rabarar/meshtool-go/public/radio"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPacketHistory(t *testing.T) {
+	now := time.Unix(1000, 0)
+	h := newPacketHistory(time.Minute, 2)
+	h.now = func() time.Time {
+		return now
+	}
+
+	require.False(t, h.seen(1, 1))
+	require.True(t, h.seen(1, 1))
+	// The same ID from another node is a different packet.
+	require.False(t, h.seen(2, 1))
+
+	// Once the window has passed, the packet is treated as new.
+	now = now.Add(time.Minute)
+	require.False(t, h.seen(1, 1))
+	require.True(t, h.seen(1, 1))
+
+	// The least recently heard packet is forgotten once the history is full.
+	require.False(t, h.seen(3, 1))
+	require.True(t, h.seen(1, 1))
+	require.False(t, h.seen(2, 1))
+	require.False(t, h.seen(3, 1))
+}
+
+func TestRadio_DropsDuplicates(t *testing.T) {
+	tests := []struct {
+		name         string
+		window       time.Duration
+		wantReceived uint64
+	}{
+		{
+			name:         "default window",
+			wantReceived: 1,
+		},
+		{
+			name:         "disabled",
+			window:       -1,
+			wantReceived: 2,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r := newTestRadio(t, func(cfg *Config) {
+				cfg.DuplicateWindow = tc.window
+			})
+			payload := encryptedEnvelope(t, &meshtastic.MeshPacket{
+				Id:   42,
+				From: 0xdeadbeef,
+				To:   meshtool.BroadcastNodeID.Uint32(),
+				PayloadVariant: &meshtastic.MeshPacket_Decoded{Decoded: &meshtastic.Data{
+					Portnum: meshtastic.PortNum_TEXT_MESSAGE_APP,
+					Payload: []byte("hello"),
+				}},
+			}, "LongFast", radio.DefaultKey)
+
+			// The same packet rebroadcast by two gateways.
+			require.NoError(t, r.tryHandleMQTTMessage(context.Background(), mqtt.Message{Payload: payload}))
+			require.NoError(t, r.tryHandleMQTTMessage(context.Background(), mqtt.Message{Payload: payload}))
+			stats := r.Stats()
+			require.Equal(t, tc.wantReceived, stats.PacketsReceived)
+			require.Equal(t, 2-tc.wantReceived, stats.PacketsDuplicate)
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package emulated
+
+import (
+	"context"
+	"time"
+
+	"github.com/rabarar/meshtastic"
+	"github.com/rabarar/meshtool-go/public/meshtool"
+)
+
+// echoText replies to a text message from another node with the same text after Config.EchoDelay, unless the message
+// is itself a reply to one of our echoes. Without this, two radios in echo mode would echo each other forever. The
+// reply is abandoned if the context is cancelled or Run returns first.
+func (r *Radio) echoText(ctx context.Context, packet *meshtastic.MeshPacket, channel int, data *meshtastic.Data) {
+	if data.ReplyId != 0 && r.echoes.contains(r.cfg.NodeID.Uint32(), data.ReplyId) {
+		r.logger.Debug("not echoing reply to an echo", "from", meshtool.NodeID(packet.From).String())
+		return
+	}
+	r.echoMu.Lock()
+	defer r.echoMu.Unlock()
+	var timer *time.Timer
+	timer = time.AfterFunc(r.cfg.EchoDelay, func() {
+		r.echoMu.Lock()
+		_, pending := r.echoTimers[timer]
+		delete(r.echoTimers, timer)
+		r.echoMu.Unlock()
+		if !pending || ctx.Err() != nil {
+			return
+		}
+		if err := r.sendEcho(ctx, packet.From, packet.Id, channel, data.Payload); err != nil {
+			r.logger.Error("failed to send echo reply", "err", err)
+		}
+	})
+	r.echoTimers[timer] = struct{}{}
+}
+
+// stopEchoes abandons the echo replies waiting for Config.EchoDelay to pass.
+func (r *Radio) stopEchoes() {
+	r.echoMu.Lock()
+	defer r.echoMu.Unlock()
+	for timer := range r.echoTimers {
+		timer.Stop()
+		delete(r.echoTimers, timer)
+	}
+}
+
+// sendEcho sends text back to the node as a reply to the packet with the given ID, recording the echo so that replies
+// to it are not echoed in turn.
+func (r *Radio) sendEcho(ctx context.Context, to, replyID uint32, channel int, text []byte) error {
+	r.logger.Info("sending echo reply", "to", meshtool.NodeID(to).String(), "channel", channel)
+	id := r.nextPacketID()
+	r.echoes.seen(r.cfg.NodeID.Uint32(), id)
+	return r.sendPacket(ctx, &meshtastic.MeshPacket{
+		Id:      id,
+		From:    r.cfg.NodeID.Uint32(),
+		To:      to,
+		Channel: uint32(channel),
+		PayloadVariant: &meshtastic.MeshPacket_Decoded{
+			Decoded: &meshtastic.Data{
+				Portnum: meshtastic.PortNum_TEXT_MESSAGE_APP,
+				Payload: text,
+				ReplyId: replyID,
+			},
+		},
+	})
+}
@@ -0,0 +1,172 @@
+package emulated
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/rabarar/meshtastic"
+	"github.com/rabarar/meshtool-go/public/meshtool"
+	"github.com/rabarar/meshtool-go/public/mqtt"
+	"github.com/rabarar/meshtool-go/public/radio"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+// publishedTexts returns a channel receiving the text messages published to the LongFast channel of the bus.
+func publishedTexts(t *testing.T, bus *Bus) <-chan *meshtastic.MeshPacket {
+	t.Helper()
+	texts := make(chan *meshtastic.MeshPacket, 10)
+	bus.Handle("LongFast", func(m mqtt.Message) {
+		se := &meshtastic.ServiceEnvelope{}
+		if err := proto.Unmarshal(m.Payload, se); err != nil {
+			return
+		}
+		data, err := radio.TryDecode(se.Packet, radio.DefaultKey)
+		if err != nil || data.Portnum != meshtastic.PortNum_TEXT_MESSAGE_APP {
+			return
+		}
+		packet := proto.Clone(se.Packet).(*meshtastic.MeshPacket)
+		packet.PayloadVariant = &meshtastic.MeshPacket_Decoded{Decoded: data}
+		texts <- packet
+	})
+	return texts
+}
+
+func textEnvelope(t *testing.T, from, id, replyID uint32) []byte {
+	t.Helper()
+	return encryptedEnvelope(t, &meshtastic.MeshPacket{
+		Id:   id,
+		From: from,
+		To:   meshtool.BroadcastNodeID.Uint32(),
+		PayloadVariant: &meshtastic.MeshPacket_Decoded{Decoded: &meshtastic.Data{
+			Portnum: meshtastic.PortNum_TEXT_MESSAGE_APP,
+			Payload: []byte("hello"),
+			ReplyId: replyID,
+		}},
+	}, "LongFast", radio.DefaultKey)
+}
+
+func TestRadio_EchoMode(t *testing.T) {
+	const echoDelay = 50 * time.Millisecond
+	r := newTestRadio(t, func(cfg *Config) {
+		cfg.EchoMode = true
+		cfg.EchoDelay = echoDelay
+	})
+	texts := publishedTexts(t, r.cfg.Bus)
+
+	start := time.Now()
+	require.NoError(t, r.tryHandleMQTTMessage(context.Background(), mqtt.Message{
+		Payload: textEnvelope(t, 0xdeadbeef, 42, 0),
+	}))
+	var echo *meshtastic.MeshPacket
+	select {
+	case echo = <-texts:
+	case <-time.After(time.Second):
+		t.Fatal("no echo reply")
+	}
+	require.GreaterOrEqual(t, time.Since(start), echoDelay)
+	require.Equal(t, r.cfg.NodeID.Uint32(), echo.From)
+	require.Equal(t, uint32(0xdeadbeef), echo.To)
+	require.Equal(t, []byte("hello"), echo.GetDecoded().Payload)
+	require.Equal(t, uint32(42), echo.GetDecoded().ReplyId)
+}
+
+func TestRadio_EchoMode_NotEchoed(t *testing.T) {
+	tests := []struct {
+		name    string
+		from    uint32
+		replyTo func(r *Radio) uint32
+	}{
+		{
+			name: "own message",
+			from: 0x1234,
+		},
+		{
+			name: "reply to echo",
+			from: 0xdeadbeef,
+			replyTo: func(r *Radio) uint32 {
+				id := r.nextPacketID()
+				r.echoes.seen(r.cfg.NodeID.Uint32(), id)
+				return id
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestRadio(t, func(cfg *Config) {
+				cfg.EchoMode = true
+			})
+			texts := publishedTexts(t, r.cfg.Bus)
+			var replyID uint32
+			if tt.replyTo != nil {
+				replyID = tt.replyTo(r)
+			}
+			require.NoError(t, r.tryHandleMQTTMessage(context.Background(), mqtt.Message{
+				Payload: textEnvelope(t, tt.from, 42, replyID),
+			}))
+			select {
+			case <-texts:
+				t.Fatal("message was echoed")
+			case <-time.After(50 * time.Millisecond):
+			}
+		})
+	}
+}
+
+func TestRadio_EchoMode_TwoRadios(t *testing.T) {
+	ctx := context.Background()
+	bus := NewBus("msh")
+	texts := publishedTexts(t, bus)
+	a := newTestRadio(t, func(cfg *Config) {
+		cfg.Bus = bus
+		cfg.NodeID = 0xaaaa
+		cfg.EchoMode = true
+	})
+	b := newTestRadio(t, func(cfg *Config) {
+		cfg.Bus = bus
+		cfg.NodeID = 0xbbbb
+		cfg.EchoMode = true
+	})
+	runTestRadio(t, a)
+	runTestRadio(t, b)
+
+	require.NoError(t, a.sendText(ctx, 0xbbbb, 0, []byte("hello")))
+	// a's message is echoed by b, and b's echo by a, but b does not echo a reply to its own echo.
+	var senders []uint32
+	for {
+		select {
+		case packet := <-texts:
+			senders = append(senders, packet.From)
+			continue
+		case <-time.After(100 * time.Millisecond):
+		}
+		break
+	}
+	require.Equal(t, []uint32{0xaaaa, 0xbbbb, 0xaaaa}, senders)
+}
+
+func TestRadio_EchoMode_StopsWithRun(t *testing.T) {
+	r := newTestRadio(t, func(cfg *Config) {
+		cfg.EchoMode = true
+		cfg.EchoDelay = 50 * time.Millisecond
+	})
+	texts := publishedTexts(t, r.cfg.Bus)
+	ctx, cancel := context.WithCancel(context.Background())
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- r.Run(ctx)
+	}()
+	waitSubscribed(t, r)
+
+	require.NoError(t, r.tryHandleMQTTMessage(context.Background(), mqtt.Message{
+		Payload: textEnvelope(t, 0xdeadbeef, 42, 0),
+	}))
+	cancel()
+	require.NoError(t, <-runErr)
+	select {
+	case <-texts:
+		t.Fatal("echo reply sent after Run returned")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -2,8 +2,10 @@ package emulated
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"math/rand"
 	"net"
 	"sync"
 	"time"
@@ -22,12 +24,57 @@ import (
 const (
 	// MinAppVersion is the minimum app version supported by the emulated radio.
 	MinAppVersion = 30200
+	// DefaultFirmwareVersion is the firmware version reported by the emulated radio when none is configured.
+	DefaultFirmwareVersion = "2.2.19-fake"
+	// DefaultDeviceStateVersion is the device state version reported by the emulated radio when none is configured.
+	DefaultDeviceStateVersion = 22
+	// DefaultQueueSize is the size of the synthetic transmit queue reported by the emulated radio when none is
+	// configured. This matches the firmware's default transmit queue size.
+	DefaultQueueSize = 16
+	// DefaultHopLimit is the hop limit of packets sent by the emulated radio, unless the client sets one or changes the
+	// LoRa config. This matches the firmware's default.
+	DefaultHopLimit = 3
+	// DefaultPositionFlags are the position flags in the radio's position config unless the client changes them,
+	// including the altitude above mean sea level in positions sent.
+	DefaultPositionFlags = uint32(meshtastic.Config_PositionConfig_ALTITUDE | meshtastic.Config_PositionConfig_ALTITUDE_MSL)
 )
 
+// fromRadioBuffer is the number of FromRadio messages buffered for each connected client. Messages for a client which
+// falls further behind than this are dropped.
+const fromRadioBuffer = 64
+
+// KeyProvider supplies channel PSKs to the emulated radio at runtime, for example from a secrets manager.
+// It is consulted each time a PSK is needed, so keys may be rotated without restarting the radio.
+type KeyProvider interface {
+	// GetPSK returns the PSK for the named channel, or false if the provider has no key for the channel.
+	GetPSK(channelName string) ([]byte, bool)
+}
+
+// Capabilities are the capability flags the emulated radio reports in its DeviceMetadata.
+type Capabilities struct {
+	CanShutdown  bool
+	HasWifi      bool
+	HasBluetooth bool
+}
+
 // Config is the configuration for the emulated Radio.
 type Config struct {
 	// Dependencies
+	// MQTTClient is the client used to communicate with the mesh via an MQTT broker.
+	// Exactly one of MQTTClient or Bus must be provided.
 	MQTTClient *mqtt.Client
+	// Bus is an in-memory message bus used in place of MQTTClient, allowing emulated radios to form a local mesh
+	// without a broker.
+	Bus *Bus
+
+	// MQTTReconnectMaxAttempts is the number of consecutive failed attempts to connect to MQTT after which Run
+	// returns an error. The zero value retries forever.
+	MQTTReconnectMaxAttempts int
+	// MQTTReconnectInitialBackoff is the delay before retrying a failed connection to MQTT, which doubles after each
+	// failure up to MQTTReconnectMaxBackoff. Defaults to DefaultMQTTReconnectInitialBackoff and
+	// DefaultMQTTReconnectMaxBackoff respectively.
+	MQTTReconnectInitialBackoff time.Duration
+	MQTTReconnectMaxBackoff     time.Duration
 
 	// Node configuration
 	// NodeID is the ID of the node.
@@ -39,31 +86,99 @@ type Config struct {
 	// Channels is the set of channels the radio will listen and transmit on.
 	// The first channel in the set is considered the primary channel and is used for broadcasting NodeInfo and Position
 	Channels *meshtastic.ChannelSet
+	// ChannelURL is a channel URL as shared by the Meshtastic apps, see meshtool.ParseChannelURL. It may be provided in
+	// place of Channels.
+	ChannelURL string
+	// KeyProvider optionally supplies channel PSKs at runtime. Channels it has no key for fall back to the Psk set in
+	// Channels.
+	KeyProvider KeyProvider
 	// BroadcastNodeInfoInterval is the interval at which the radio will broadcast a NodeInfo on the Primary channel.
 	// The zero value disables broadcasting NodeInfo.
 	BroadcastNodeInfoInterval time.Duration
+	// SeedNodes are loaded into the nodeDB when the radio is created, so that attached clients see a populated mesh
+	// before any traffic is heard. Each node must have Num set.
+	SeedNodes []*meshtastic.NodeInfo
+	// StatePath is the path of a file the nodeDB and last packet ID are saved to, so that they survive restarts. The
+	// state is loaded when Run is called, and saved every StateSaveInterval and when Run returns. Persistence is
+	// disabled if empty.
+	StatePath string
+	// StateSaveInterval is the interval at which the state is saved to StatePath. Defaults to
+	// DefaultStateSaveInterval.
+	StateSaveInterval time.Duration
+
+	// BroadcastTelemetryInterval is the interval at which the radio will broadcast Telemetry containing DeviceMetrics
+	// on the Primary channel. The zero value disables broadcasting Telemetry.
+	BroadcastTelemetryInterval time.Duration
+	// DeviceMetrics are the battery level, voltage and utilization figures reported in broadcast Telemetry. The uptime
+	// is always set to how long the radio has been running. ChannelUtilization and AirUtilTx are calculated from the
+	// airtime of the packets sent and received unless they are set here, see Stats.
+	DeviceMetrics *meshtastic.DeviceMetrics
 
 	// BroadcastPositionInterval is the interval at which the radio will broadcast Position on the Primary channel.
 	// The zero value disables broadcasting NodeInfo.
 	BroadcastPositionInterval time.Duration
 	// PositionLatitudeI is the latitude of the position which will be regularly broadcasted.
-	// This is in degrees multiplied by 1e7.
+	// This is in degrees multiplied by 1e7, see SetPositionDegrees.
 	PositionLatitudeI int32
 	// PositionLongitudeI is the longitude of the position which will be regularly broadcasted.
-	// This is in degrees multiplied by 1e7.
+	// This is in degrees multiplied by 1e7, see SetPositionDegrees.
 	PositionLongitudeI int32
 	// PositionAltitude is the altitude of the position which will be regularly broadcasted.
 	// This is in meters above MSL.
 	PositionAltitude int32
 
+	// FirmwareVersion is the firmware version reported in the radio's DeviceMetadata. Defaults to DefaultFirmwareVersion.
+	FirmwareVersion string
+	// DeviceStateVersion is the device state version reported in the radio's DeviceMetadata.
+	// Defaults to DefaultDeviceStateVersion.
+	DeviceStateVersion uint32
+	// Capabilities are the capability flags reported in the radio's DeviceMetadata. If nil, all capabilities are
+	// reported as present.
+	Capabilities *Capabilities
+
 	// TCPListenAddr is the address the emulated radio will listen on for TCP connections and offer the Client API over.
 	TCPListenAddr string
+	// StreamChecksum enables the StreamConn checksum on client connections. Clients must also enable
+	// transport.StreamConn.Checksum, so this is only suitable for clients using this library.
+	StreamChecksum bool
+	// DebugHTTPAddr is the address to serve debug endpoints on, exposing the nodeDB at /nodes, Stats at /stats and a
+	// health check at /healthz. The server is disabled if empty.
+	DebugHTTPAddr string
+
+	// SimulatedLossRate is the probability, between 0 and 1, that a mesh packet sent to or heard from MQTT is
+	// dropped, as if lost over the radio link. Messages between the radio and its connected clients, such as its
+	// config, are never dropped. The zero value disables simulated packet loss.
+	SimulatedLossRate float64
+	// SimulatedLatency delays mesh packets sent to or heard from MQTT, as if slowed by the radio link. The zero value
+	// disables simulated latency.
+	SimulatedLatency time.Duration
+	// EchoMode causes the radio to reply to any text message it receives, sending the same text back to the
+	// originating node as a direct message. Replies to its own echoes are not echoed, so that two radios in echo mode
+	// do not echo each other forever.
+	EchoMode bool
+	// EchoDelay is how long the radio waits before sending an echo reply when EchoMode is enabled.
+	EchoDelay time.Duration
+
+	// DuplicateWindow is the period within which a packet received from MQTT with the same sender and ID as one already
+	// received is dropped, as busy channels carry the copies rebroadcast by several gateways. Defaults to
+	// DefaultDuplicateWindow. A negative value disables dropping duplicates.
+	DuplicateWindow time.Duration
+
+	// StoreForwardHistorySize is the number of text messages heard on the primary channel which the radio holds as a
+	// Store & Forward server, replaying them to clients which request the history. The zero value disables the
+	// Store & Forward server.
+	StoreForwardHistorySize int
+
+	// QueueSize is the size of the synthetic transmit queue reported to clients in QueueStatus messages.
+	// Defaults to DefaultQueueSize.
+	QueueSize uint32
+
+	// Rand is the source of randomness used for simulating packet loss. If nil, a time seeded source is used.
+	// Providing a seeded source allows for deterministic tests.
+	Rand *rand.Rand
 }
 
 func (c *Config) validate() error {
-	if c.MQTTClient == nil {
-		return fmt.Errorf("MQTTClient is required")
-	}
 	if c.NodeID == 0 {
 		return fmt.Errorf("NodeID is required")
 	}
@@ -73,61 +188,254 @@ func (c *Config) validate() error {
 	if c.ShortName == "" {
 		c.ShortName = c.NodeID.DefaultShortName()
 	}
+	if c.ChannelURL != "" {
+		if c.Channels != nil {
+			return fmt.Errorf("only one of Channels or ChannelURL should be provided")
+		}
+		channels, err := meshtool.ParseChannelURL(c.ChannelURL)
+		if err != nil {
+			//lint:ignore ST1005 we're referencing an actual field here.
+			return fmt.Errorf("ChannelURL: %w", err)
+		}
+		c.Channels = channels
+	}
 	if c.Channels == nil {
 		//lint:ignore ST1005 we're referencing an actual field here.
-		return fmt.Errorf("Channels is required")
+		return fmt.Errorf("Channels or ChannelURL is required")
 	}
-	if len(c.Channels.Settings) == 0 {
-		return fmt.Errorf("Channels.Settings should be non-empty")
+	if c.FirmwareVersion == "" {
+		c.FirmwareVersion = DefaultFirmwareVersion
+	}
+	if c.DeviceStateVersion == 0 {
+		c.DeviceStateVersion = DefaultDeviceStateVersion
+	}
+	if c.Capabilities == nil {
+		c.Capabilities = &Capabilities{
+			CanShutdown:  true,
+			HasWifi:      true,
+			HasBluetooth: true,
+		}
+	}
+	for i, node := range c.SeedNodes {
+		if node.GetNum() == 0 {
+			return fmt.Errorf("SeedNodes[%d] should have Num set", i)
+		}
+	}
+	if c.MQTTReconnectInitialBackoff == 0 {
+		c.MQTTReconnectInitialBackoff = DefaultMQTTReconnectInitialBackoff
+	}
+	if c.MQTTReconnectMaxBackoff == 0 {
+		c.MQTTReconnectMaxBackoff = DefaultMQTTReconnectMaxBackoff
+	}
+	if c.StateSaveInterval == 0 {
+		c.StateSaveInterval = DefaultStateSaveInterval
+	}
+	if c.QueueSize == 0 {
+		c.QueueSize = DefaultQueueSize
+	}
+	if c.DuplicateWindow == 0 {
+		c.DuplicateWindow = DefaultDuplicateWindow
+	}
+	if c.StoreForwardHistorySize < 0 {
+		return fmt.Errorf("StoreForwardHistorySize should not be negative")
+	}
+	if c.SimulatedLossRate < 0 || c.SimulatedLossRate > 1 {
+		return fmt.Errorf("SimulatedLossRate should be between 0 and 1")
+	}
+	if c.Rand == nil {
+		c.Rand = rand.New(rand.NewSource(time.Now().UnixNano()))
 	}
 	return nil
 }
 
+// SetPositionDegrees sets PositionLatitudeI and PositionLongitudeI from a latitude and longitude in degrees.
+func (c *Config) SetPositionDegrees(latitude, longitude float64) {
+	c.PositionLatitudeI = meshtool.DegreesToI(latitude)
+	c.PositionLongitudeI = meshtool.DegreesToI(longitude)
+}
+
+// user returns the User the radio identifies itself with.
+func (c *Config) user() *meshtastic.User {
+	// TODO: Lots of stuff missing here. However, this is enough for it to show in the UI of another node listening to
+	// the MQTT server.
+	return &meshtastic.User{
+		Id:        c.NodeID.String(),
+		LongName:  c.LongName,
+		ShortName: c.ShortName,
+		HwModel:   meshtastic.HardwareModel_PRIVATE_HW,
+	}
+}
+
+// position returns the configured position with full precision, including the fields selected by flags. See
+// meshtool.EncodePosition.
+func (c *Config) position(flags uint32) *meshtastic.Position {
+	p := meshtool.EncodePosition(
+		meshtool.IToDegrees(c.PositionLatitudeI),
+		meshtool.IToDegrees(c.PositionLongitudeI),
+		c.PositionAltitude,
+		flags,
+	)
+	p.Time = uint32(time.Now().Unix())
+	return p
+}
+
 // Radio emulates a meshtastic Node, communicating with a meshtastic network via MQTT.
 type Radio struct {
 	cfg    Config
-	mqtt   *mqtt.Client
+	mqtt   MQTTClient
 	logger *log.Logger
 
+	// configMu protects the radio's settings, which clients can change with AdminMessages.
+	configMu sync.RWMutex
+	// channelSlots holds each of the radio's MaxChannels channels, including disabled ones, and channels holds those
+	// which are enabled.
+	channelSlots       []*meshtastic.Channel
+	channels           *radio.Channels
+	configs            map[meshtastic.AdminMessage_ConfigType]*meshtastic.Config
+	moduleConfigs      map[meshtastic.AdminMessage_ModuleConfigType]*meshtastic.ModuleConfig
+	subscribedChannels map[string]struct{}
+	nodeDB             *meshtool.NodeDB
+	// runCtx is the context passed to Run, which MQTT messages are handled with once subscribed.
+	runCtx context.Context
+
 	// TODO: rwmutex?? seperate mutexes??
 	mu                   sync.Mutex
 	fromRadioSubscribers map[chan<- *meshtastic.FromRadio]struct{}
-	nodeDB               map[uint32]*meshtastic.NodeInfo
-	// packetID is incremented and included in each packet sent from the radio.
-	// TODO: Eventually, we should offer an easy way of persisting this so that we can resume from where we left off.
+	// packetID is incremented and included in each packet sent from the radio. It is persisted along with the nodeDB
+	// when Config.StatePath is set.
 	packetID uint32
+
+	// randMu protects cfg.Rand, which is not safe for concurrent use.
+	randMu sync.Mutex
+
+	stats radioStats
+	// packetHistory records the packets received from MQTT to drop duplicates. It is nil if Config.DuplicateWindow is
+	// negative.
+	packetHistory *packetHistory
+	// storeForward holds the text messages replayed to Store & Forward clients. It is nil if
+	// Config.StoreForwardHistorySize is zero.
+	storeForward *storeForwardHistory
+	// airtime records the airtime of the packets sent and received, for reporting utilization.
+	airtime *airtimeTracker
+	// echoes records the echo replies sent in EchoMode, so that replies to them are not echoed.
+	echoes *packetHistory
+	// echoMu protects echoTimers, the timers of the echo replies waiting for Config.EchoDelay to pass.
+	echoMu     sync.Mutex
+	echoTimers map[*time.Timer]struct{}
+
+	connMu    sync.Mutex
+	connState MQTTConnectionState
 }
 
 // NewRadio creates a new emulated radio.
 func NewRadio(cfg Config) (*Radio, error) {
+	var mqttClient MQTTClient
+	switch {
+	case cfg.MQTTClient == nil && cfg.Bus == nil:
+		return nil, fmt.Errorf("validating config: MQTTClient or Bus is required")
+	case cfg.MQTTClient != nil && cfg.Bus != nil:
+		return nil, fmt.Errorf("validating config: only one of MQTTClient or Bus should be provided")
+	case cfg.MQTTClient != nil:
+		mqttClient = cfg.MQTTClient
+	default:
+		mqttClient = cfg.Bus
+	}
+	return newRadio(cfg, mqttClient)
+}
+
+// newRadio creates a new emulated radio which communicates with the mesh using mqttClient.
+func newRadio(cfg Config, mqttClient MQTTClient) (*Radio, error) {
 	if err := cfg.validate(); err != nil {
 		return nil, fmt.Errorf("validating config: %w", err)
 	}
+	if len(cfg.Channels.GetSettings()) > MaxChannels {
+		return nil, fmt.Errorf("validating config: Channels: at most %d channels are supported", MaxChannels)
+	}
+	channels, err := radio.NewChannels(cfg.Channels)
+	if err != nil {
+		return nil, fmt.Errorf("validating config: Channels: %w", err)
+	}
+	nodeDB := meshtool.NewNodeDB()
+	for _, node := range cfg.SeedNodes {
+		nodeDB.Put(node)
+	}
+	// Our own entry is derived from the config, so it takes precedence over any seed node with the same ID.
+	nodeDB.Put(&meshtastic.NodeInfo{
+		Num:       cfg.NodeID.Uint32(),
+		User:      cfg.user(),
+		Position:  cfg.position(DefaultPositionFlags),
+		LastHeard: uint32(time.Now().Unix()),
+	})
+	var history *packetHistory
+	if cfg.DuplicateWindow > 0 {
+		history = newPacketHistory(cfg.DuplicateWindow, packetHistorySize)
+	}
+	var storeForward *storeForwardHistory
+	if cfg.StoreForwardHistorySize > 0 {
+		storeForward = newStoreForwardHistory(cfg.StoreForwardHistorySize)
+	}
 	return &Radio{
 		cfg:                  cfg,
+		channelSlots:         newDeviceChannels(cfg.Channels),
+		channels:             channels,
+		configs:              newConfigs(cfg),
+		moduleConfigs:        newModuleConfigs(cfg),
 		logger:               log.With("radio", cfg.NodeID.String()),
 		fromRadioSubscribers: map[chan<- *meshtastic.FromRadio]struct{}{},
-		mqtt:                 cfg.MQTTClient,
-		nodeDB:               map[uint32]*meshtastic.NodeInfo{},
+		mqtt:                 mqttClient,
+		nodeDB:               nodeDB,
+		packetHistory:        history,
+		storeForward:         storeForward,
+		airtime:              newAirtimeTracker(),
+		echoes:               newPacketHistory(DefaultDuplicateWindow, packetHistorySize),
+		echoTimers:           map[*time.Timer]struct{}{},
 	}, nil
 }
 
-// Run starts the radio. It blocks until the context is cancelled.
+// Run starts the radio. It blocks until the context is cancelled, or a background task such as the TCP listener
+// fails, in which case its error is returned.
 func (r *Radio) Run(ctx context.Context) error {
-	if err := r.mqtt.Connect(); err != nil {
-		return fmt.Errorf("connecting to mqtt: %w", err)
+	r.stats.recordStarted()
+	if r.cfg.StatePath != "" {
+		if err := r.loadState(); err != nil {
+			return fmt.Errorf("loading state: %w", err)
+		}
+	}
+	reconnectable, canReconnect := r.mqtt.(reconnectableMQTTClient)
+	if canReconnect {
+		// The radio drives reconnection itself so that it can report the state of the connection.
+		reconnectable.SetAutoReconnect(false)
+	}
+	if err := r.connectMQTT(ctx); err != nil {
+		return err
+	}
+	if disconnectable, ok := r.mqtt.(disconnectableMQTTClient); ok {
+		defer func() {
+			r.logger.Info("disconnecting from mqtt")
+			disconnectable.Disconnect(mqttDisconnectQuiesce)
+		}()
 	}
-	// TODO: Disconnect??
 
-	// Subscribe to all configured channels
-	for _, ch := range r.cfg.Channels.Settings {
-		r.logger.Debug("subscribing to mqtt for channel", "channel", ch.Name)
-		r.mqtt.Handle(ch.Name, r.handleMQTTMessage)
+	// Echo replies still waiting to be sent are abandoned once Run returns.
+	defer r.stopEchoes()
+
+	// Subscribe to all configured channels. Channels added later by clients are subscribed to as they are added.
+	r.configMu.Lock()
+	r.subscribedChannels = map[string]struct{}{}
+	r.runCtx = ctx
+	r.configMu.Unlock()
+	for _, ch := range r.getChannels().All() {
+		r.subscribeChannel(ch.Name)
 	}
 
 	// TODO: Rethink concurrency. Do we want a goroutine servicing ToRadio and one servicing FromRadio?
 
 	eg, egCtx := errgroup.WithContext(ctx)
+	if canReconnect {
+		eg.Go(func() error {
+			return r.maintainMQTTConnection(egCtx, reconnectable)
+		})
+	}
 	// Spin up goroutine to send NodeInfo every interval
 	if r.cfg.BroadcastNodeInfoInterval > 0 {
 		eg.Go(func() error {
@@ -145,6 +453,23 @@ func (r *Radio) Run(ctx context.Context) error {
 			}
 		})
 	}
+	// Spin up goroutine to send Telemetry every interval
+	if r.cfg.BroadcastTelemetryInterval > 0 {
+		eg.Go(func() error {
+			ticker := time.NewTicker(r.cfg.BroadcastTelemetryInterval)
+			defer ticker.Stop()
+			for {
+				if err := r.broadcastTelemetry(egCtx); err != nil {
+					r.logger.Error("failed to broadcast telemetry", "err", err)
+				}
+				select {
+				case <-egCtx.Done():
+					return nil
+				case <-ticker.C:
+				}
+			}
+		})
+	}
 	// Spin up goroutine to send Position every interval
 	if r.cfg.BroadcastPositionInterval > 0 {
 		eg.Go(func() error {
@@ -167,77 +492,161 @@ func (r *Radio) Run(ctx context.Context) error {
 			return r.listenTCP(egCtx)
 		})
 	}
+	if r.cfg.DebugHTTPAddr != "" {
+		eg.Go(func() error {
+			return r.serveDebugHTTP(egCtx)
+		})
+	}
+	if r.cfg.StatePath != "" {
+		eg.Go(func() error {
+			return r.persistState(egCtx)
+		})
+	}
+	// The radio keeps handling MQTT messages until ctx is cancelled, even if none of the tasks above are configured.
+	eg.Go(func() error {
+		<-egCtx.Done()
+		return nil
+	})
 
 	return eg.Wait()
 }
 
-func (r *Radio) handleMQTTMessage(msg mqtt.Message) {
+func (r *Radio) handleMQTTMessage(ctx context.Context, msg mqtt.Message) {
 	// TODO: Determine how "github.com/eclipse/paho.mqtt.golang" handles concurrency. Do we need to dispatch here to
 	// a goroutine which handles incoming messages to unblock this one?
-	if err := r.tryHandleMQTTMessage(msg); err != nil {
+	if err := r.tryHandleMQTTMessage(ctx, msg); err != nil {
 		r.logger.Error("failed to handle incoming mqtt message", "err", err)
 	}
 }
 
-func (r *Radio) updateNodeDB(nodeID uint32, updateFunc func(*meshtastic.NodeInfo)) {
-	r.mu.Lock()
-	defer r.mu.Unlock()
-	nodeInfo, ok := r.nodeDB[nodeID]
-	if !ok {
-		nodeInfo = &meshtastic.NodeInfo{
-			Num: nodeID,
-		}
+// updateNodeDB merges a payload received from a node into its nodeDB entry. See meshtool.MergeNodeInfo.
+func (r *Radio) updateNodeDB(nodeID uint32, update proto.Message) {
+	r.nodeDB.Merge(nodeID, update)
+}
+
+// recordSignal records the SNR and hop count of a packet received from another node in its nodeDB entry. RSSI is not
+// recorded, as NodeInfo has no field for it. Packets received from MQTT carry the SNR measured by the gateway which
+// uplinked them.
+func (r *Radio) recordSignal(packet *meshtastic.MeshPacket) {
+	if packet.From == r.cfg.NodeID.Uint32() {
+		return
 	}
-	updateFunc(nodeInfo)
-	nodeInfo.LastHeard = uint32(time.Now().Unix())
-	r.nodeDB[nodeID] = nodeInfo
+	r.nodeDB.Upsert(packet.From, func(node *meshtastic.NodeInfo) {
+		node.ViaMqtt = true
+		if packet.RxSnr != 0 {
+			node.Snr = packet.RxSnr
+		}
+		// Older firmware does not set HopStart, in which case the number of hops is unknown.
+		if packet.HopStart != 0 && packet.HopStart >= packet.HopLimit {
+			node.HopsAway = proto.Uint32(packet.HopStart - packet.HopLimit)
+		}
+	})
 }
 
-func (r *Radio) getNodeDB() []*meshtastic.NodeInfo {
-	r.mu.Lock()
-	defer r.mu.Unlock()
-	nodes := make([]*meshtastic.NodeInfo, 0, len(r.nodeDB))
-	for _, node := range r.nodeDB {
-		clonedNode := proto.Clone(node).(*meshtastic.NodeInfo)
-		nodes = append(nodes, clonedNode)
+func (r *Radio) getNode(nodeID uint32) (*meshtastic.NodeInfo, bool) {
+	return r.nodeDB.Get(nodeID)
+}
+
+// WatchNodeDB returns a channel which receives a copy of each NodeInfo as it is updated in the nodeDB, along with a
+// function to stop watching. Events are dropped rather than blocking the radio if the channel is not read promptly.
+func (r *Radio) WatchNodeDB() (<-chan *meshtastic.NodeInfo, func()) {
+	return r.nodeDB.Watch()
+}
+
+// WaitForNode blocks until the node with the given ID is present in the nodeDB, returning it. It returns false if
+// ctx is done first. If the node is already present, it returns immediately.
+func (r *Radio) WaitForNode(ctx context.Context, nodeID uint32) (*meshtastic.NodeInfo, bool) {
+	events, stop := r.WatchNodeDB()
+	defer stop()
+	for {
+		// Check the nodeDB itself rather than relying on the event, as events may be dropped. Since we start
+		// watching before the first check, any update we miss here is still signalled by a buffered event.
+		if nodeInfo, ok := r.getNode(nodeID); ok {
+			return nodeInfo, true
+		}
+		select {
+		case <-ctx.Done():
+			return nil, false
+		case <-events:
+		}
 	}
-	return nodes
 }
 
-func (r *Radio) tryHandleMQTTMessage(msg mqtt.Message) error {
+// Nodes returns a copy of each NodeInfo in the nodeDB, ordered by node number.
+func (r *Radio) Nodes() []*meshtastic.NodeInfo {
+	return r.nodeDB.List()
+}
+
+func (r *Radio) tryHandleMQTTMessage(ctx context.Context, msg mqtt.Message) error {
 	serviceEnvelope := &meshtastic.ServiceEnvelope{}
 	if err := proto.Unmarshal(msg.Payload, serviceEnvelope); err != nil {
 		return fmt.Errorf("unmarshalling: %w", err)
 	}
 	meshPacket := serviceEnvelope.Packet
+	if meshPacket == nil {
+		return fmt.Errorf("service envelope contains no packet")
+	}
+	// Packets without an ID can't be told apart, so they are never treated as duplicates.
+	if r.packetHistory != nil && meshPacket.Id != 0 && r.packetHistory.seen(meshPacket.From, meshPacket.Id) {
+		r.stats.packetsDuplicate.Add(1)
+		r.logger.Debug("dropping duplicate packet", "from", meshtool.NodeID(meshPacket.From).String(), "id", meshPacket.Id)
+		return nil
+	}
+	r.stats.packetsReceived.Add(1)
+
+	// Busy MQTT servers carry traffic for many channels we don't hold keys for. Ignore these quietly, as a real radio
+	// would never hear them.
+	psk, ok := r.channelPSK(serviceEnvelope.ChannelId)
+	if !ok {
+		r.logger.Debug("ignoring packet on channel without a key", "channel", serviceEnvelope.ChannelId)
+		return nil
+	}
+	// Our own packets are heard back from MQTT, but their airtime was recorded and the link impaired when they were
+	// sent.
+	if meshPacket.From != r.cfg.NodeID.Uint32() {
+		if !r.impairLink(ctx) {
+			r.logger.Debug("dropping incoming packet due to simulated packet loss")
+			return nil
+		}
+		r.recordAirtime(meshPacket, false)
+	}
+
+	// Tag a copy of the packet as having arrived via MQTT so that attached clients can label it as such.
+	relayedPacket := proto.Clone(meshPacket).(*meshtastic.MeshPacket)
+	relayedPacket.ViaMqtt = true
+	if relayedPacket.HopStart < relayedPacket.HopLimit {
+		// Older firmware does not set HopStart, treat the packet as not yet having been relayed.
+		relayedPacket.HopStart = relayedPacket.HopLimit
+	}
 
 	// TODO: Attempt decryption first before dispatching to subscribers
 	// TODO: This means we move this further below.
 	if err := r.dispatchMessageToFromRadio(&meshtastic.FromRadio{
 		PayloadVariant: &meshtastic.FromRadio_Packet{
-			Packet: meshPacket,
+			Packet: relayedPacket,
 		},
 	}); err != nil {
 		r.logger.Error("failed to dispatch message to FromRadio subscribers", "err", err)
 	}
 
-	// From now on, we only care about messages on the primary channel
-	primaryName := r.cfg.Channels.Settings[0].Name
-	primaryPSK := r.cfg.Channels.Settings[0].Psk
-	if serviceEnvelope.ChannelId != primaryName {
+	ch, ok := r.getChannels().ByName(serviceEnvelope.ChannelId)
+	if !ok {
+		// The key came from the KeyProvider, so the packet is relayed to clients but not handled by the radio itself.
 		return nil
 	}
 
-	r.logger.Debug("received service envelope for primary channel", "serviceEnvelope", serviceEnvelope)
-	// Check if we should try and decrypt the message
-	data, err := radio.TryDecode(meshPacket, primaryPSK)
+	r.logger.Debug("received service envelope", "channel", ch.Name, "serviceEnvelope", serviceEnvelope)
+	data, err := radio.TryDecode(meshPacket, psk)
 	if err != nil {
-		return fmt.Errorf("decoding: %w", err)
+		// We hold the key for this channel, so a failure suggests a misconfigured key or a corrupt packet.
+		r.logger.Warn("failed to decode packet", "channel", ch.Name, "from", meshPacket.From, "err", err)
+		return nil
 	}
 
-	r.logger.Debug("received data for primary channel", "data", data)
+	r.logger.Debug("received data", "channel", ch.Name, "data", data)
+	r.recordSignal(meshPacket)
 
-	// For messages on the primary channel, we want to handle these and potentially update the nodeDB.
+	// For messages on our channels, we want to handle these and potentially update the nodeDB.
 	switch data.Portnum {
 	case meshtastic.PortNum_NODEINFO_APP:
 		user := &meshtastic.User{}
@@ -245,11 +654,39 @@ func (r *Radio) tryHandleMQTTMessage(msg mqtt.Message) error {
 			return fmt.Errorf("unmarshalling user: %w", err)
 		}
 		r.logger.Info("received NodeInfo", "user", user)
-		r.updateNodeDB(meshPacket.From, func(nodeInfo *meshtastic.NodeInfo) {
-			nodeInfo.User = user
-		})
+		r.updateNodeDB(meshPacket.From, user)
+		// Nodes request our NodeInfo by sending theirs with want_response set, such as when "Request info" is used in
+		// the app.
+		if data.WantResponse && meshPacket.To == r.cfg.NodeID.Uint32() {
+			r.logger.Info("replying to NodeInfo request", "to", meshtool.NodeID(meshPacket.From).String())
+			if err := r.sendNodeInfo(ctx, meshPacket.From, ch.Index, meshPacket.Id); err != nil {
+				return fmt.Errorf("replying to NodeInfo request: %w", err)
+			}
+		}
 	case meshtastic.PortNum_TEXT_MESSAGE_APP:
 		r.logger.Info("received TextMessage", "message", string(data.Payload))
+		if r.storeForward != nil && ch.Index == 0 {
+			r.storeForward.add(meshPacket.From, meshPacket.To, data.Payload)
+		}
+		// Acknowledge messages sent directly to us so that the sender sees them as delivered. Broadcasts are not
+		// acknowledged.
+		if meshPacket.To == r.cfg.NodeID.Uint32() {
+			if err := r.sendRoutingAck(ctx, relayedPacket, ch.Index); err != nil {
+				return fmt.Errorf("acknowledging text message: %w", err)
+			}
+		}
+		// Avoid echoing our own messages, which we also receive from the MQTT subscription.
+		if r.cfg.EchoMode && meshPacket.From != r.cfg.NodeID.Uint32() {
+			r.echoText(ctx, meshPacket, ch.Index, data)
+		}
+	case meshtastic.PortNum_TRACEROUTE_APP:
+		if err := r.handleTraceroute(ctx, relayedPacket, ch, data); err != nil {
+			return fmt.Errorf("handling traceroute: %w", err)
+		}
+	case meshtastic.PortNum_STORE_FORWARD_APP:
+		if err := r.handleStoreForward(ctx, relayedPacket, ch, data); err != nil {
+			return fmt.Errorf("handling Store & Forward: %w", err)
+		}
 	case meshtastic.PortNum_ROUTING_APP:
 		routingPayload := &meshtastic.Routing{}
 		if err := proto.Unmarshal(data.Payload, routingPayload); err != nil {
@@ -262,22 +699,25 @@ func (r *Radio) tryHandleMQTTMessage(msg mqtt.Message) error {
 			return fmt.Errorf("unmarshalling positionPayload: %w", err)
 		}
 		r.logger.Info("received Position", "position", positionPayload)
-		r.updateNodeDB(meshPacket.From, func(nodeInfo *meshtastic.NodeInfo) {
-			nodeInfo.Position = positionPayload
-		})
+		r.updateNodeDB(meshPacket.From, positionPayload)
+		// Nodes request our position by sending theirs with want_response set, such as when the app refreshes a node
+		// on the map.
+		if data.WantResponse && meshPacket.To == r.cfg.NodeID.Uint32() {
+			r.logger.Info("replying to Position request", "to", meshtool.NodeID(meshPacket.From).String())
+			if err := r.sendPosition(ctx, meshPacket.From, ch.Index, false, meshPacket.Id); err != nil {
+				return fmt.Errorf("replying to Position request: %w", err)
+			}
+		}
 	case meshtastic.PortNum_TELEMETRY_APP:
 		telemetryPayload := &meshtastic.Telemetry{}
 		if err := proto.Unmarshal(data.Payload, telemetryPayload); err != nil {
 			return fmt.Errorf("unmarshalling telemetryPayload: %w", err)
 		}
-		deviceMetrics := telemetryPayload.GetDeviceMetrics()
-		if deviceMetrics == nil {
+		if telemetryPayload.GetDeviceMetrics() == nil {
 			break
 		}
 		r.logger.Info("received Telemetry deviceMetrics", "telemetry", telemetryPayload)
-		r.updateNodeDB(meshPacket.From, func(nodeInfo *meshtastic.NodeInfo) {
-			nodeInfo.DeviceMetrics = deviceMetrics
-		})
+		r.updateNodeDB(meshPacket.From, telemetryPayload)
 	default:
 		r.logger.Debug("received unhandled app payload", "data", data)
 	}
@@ -285,6 +725,19 @@ func (r *Radio) tryHandleMQTTMessage(msg mqtt.Message) error {
 	return nil
 }
 
+// channelPSK returns the PSK for the named channel, consulting the KeyProvider before the configured Channels.
+func (r *Radio) channelPSK(name string) ([]byte, bool) {
+	if r.cfg.KeyProvider != nil {
+		if psk, ok := r.cfg.KeyProvider.GetPSK(name); ok {
+			return psk, true
+		}
+	}
+	if ch, ok := r.getChannels().ByName(name); ok {
+		return ch.PSK, true
+	}
+	return nil, false
+}
+
 func (r *Radio) nextPacketID() uint32 {
 	r.mu.Lock()
 	defer r.mu.Unlock()
@@ -293,50 +746,90 @@ func (r *Radio) nextPacketID() uint32 {
 }
 
 func (r *Radio) sendPacket(ctx context.Context, packet *meshtastic.MeshPacket) error {
-	// TODO: Optimistically attempt to encrypt the packet here if we recognise the channel, encryption is enabled and
-	// the payload is not currently encrypted.
+	// sendPacket is responsible for setting the packet ID, unless a client has already assigned one.
+	if packet.Id == 0 {
+		packet.Id = r.nextPacketID()
+	}
+	// Report the (synthetic) state of the transmit queue to clients, as a real radio would after queueing a packet.
+	// Packets are sent immediately, so the queue always has all slots free.
+	if err := r.dispatchMessageToFromRadio(&meshtastic.FromRadio{
+		PayloadVariant: &meshtastic.FromRadio_QueueStatus{
+			QueueStatus: &meshtastic.QueueStatus{
+				Free:         r.cfg.QueueSize,
+				Maxlen:       r.cfg.QueueSize,
+				MeshPacketId: packet.Id,
+			},
+		},
+	}); err != nil {
+		r.logger.Error("failed to dispatch QueueStatus to FromRadio subscribers", "err", err)
+	}
 
-	// sendPacket is responsible for setting the packet ID.
-	r.packetID = r.nextPacketID()
+	if !r.impairLink(ctx) {
+		r.logger.Debug("dropping outgoing packet due to simulated packet loss")
+		return nil
+	}
 
-	se := &meshtastic.ServiceEnvelope{
-		// TODO: Fetch channel to use based on packet.Channel rather than hardcoding to primary channel.
-		ChannelId: r.cfg.Channels.Settings[0].Name,
-		GatewayId: r.cfg.NodeID.String(),
-		Packet:    packet,
+	// As the firmware does, packets are sent with the configured hop limit unless one is set, and HopStart records
+	// the hop limit the packet started with so that receivers can tell how many hops away we are.
+	if packet.HopLimit == 0 {
+		packet.HopLimit = r.hopLimit()
 	}
-	bytes, err := proto.Marshal(se)
-	if err != nil {
-		return fmt.Errorf("marshalling service envelope: %w", err)
+	if packet.HopStart < packet.HopLimit {
+		packet.HopStart = packet.HopLimit
+	}
+
+	// Packets from clients and the radio itself set Channel to the index of the channel to send on.
+	ch, ok := r.getChannels().ByIndex(int(packet.Channel))
+	if !ok {
+		return fmt.Errorf("no channel with index %d", packet.Channel)
 	}
-	// TODO: optional encryption
-	return r.mqtt.Publish(&mqtt.Message{
-		Topic:   r.mqtt.GetFullTopicForChannel(r.cfg.Channels.Settings[0].Name) + "/" + r.cfg.NodeID.String(),
-		Payload: bytes,
+	channelName := ch.Name
+	// Encrypt the payload if the channel has a key, as the firmware does before uplinking to MQTT. Channels without a
+	// key are sent in the clear.
+	if psk, ok := r.channelPSK(channelName); ok && len(psk) > 0 {
+		self := &meshtool.Node{ID: r.cfg.NodeID.Uint32()}
+		encrypted, err := self.EncryptPacket(packet, channelName, psk)
+		if err != nil {
+			return fmt.Errorf("encrypting packet: %w", err)
+		}
+		packet = encrypted
+	}
+
+	gatewayID := r.cfg.NodeID.String()
+	err := r.mqtt.PublishEnvelope(channelName, gatewayID, &meshtastic.ServiceEnvelope{
+		ChannelId: channelName,
+		GatewayId: gatewayID,
+		Packet:    packet,
 	})
+	if err != nil {
+		return err
+	}
+	r.stats.packetsSent.Add(1)
+	r.recordAirtime(packet, true)
+	return nil
 }
 
 func (r *Radio) broadcastNodeInfo(ctx context.Context) error {
 	r.logger.Info("broadcasting NodeInfo")
-	// TODO: Lots of stuff missing here. However, this is enough for it to show in the UI of another node listening to
-	// the MQTT server.
-	user := &meshtastic.User{
-		Id:        r.cfg.NodeID.String(),
-		LongName:  r.cfg.LongName,
-		ShortName: r.cfg.ShortName,
-		HwModel:   meshtastic.HardwareModel_PRIVATE_HW,
-	}
-	userBytes, err := proto.Marshal(user)
+	return r.sendNodeInfo(ctx, meshtool.BroadcastNodeID.Uint32(), 0, 0)
+}
+
+// sendNodeInfo sends the radio's User to a node on the channel with the given index. requestID is the ID of the
+// packet being replied to, or zero if the NodeInfo was not requested.
+func (r *Radio) sendNodeInfo(ctx context.Context, to uint32, channel int, requestID uint32) error {
+	userBytes, err := proto.Marshal(r.cfg.user())
 	if err != nil {
 		return fmt.Errorf("marshalling user: %w", err)
 	}
 	return r.sendPacket(ctx, &meshtastic.MeshPacket{
-		From: r.cfg.NodeID.Uint32(),
-		To:   meshtool.BroadcastNodeID.Uint32(),
+		From:    r.cfg.NodeID.Uint32(),
+		To:      to,
+		Channel: uint32(channel),
 		PayloadVariant: &meshtastic.MeshPacket_Decoded{
 			Decoded: &meshtastic.Data{
-				Portnum: meshtastic.PortNum_NODEINFO_APP,
-				Payload: userBytes,
+				Portnum:   meshtastic.PortNum_NODEINFO_APP,
+				Payload:   userBytes,
+				RequestId: requestID,
 			},
 		},
 	})
@@ -344,24 +837,126 @@ func (r *Radio) broadcastNodeInfo(ctx context.Context) error {
 
 func (r *Radio) broadcastPosition(ctx context.Context) error {
 	r.logger.Info("broadcasting Position")
+	return r.sendPosition(ctx, meshtool.BroadcastNodeID.Uint32(), 0, false, 0)
+}
+
+// RequestPosition asks the node with the given number for its position, on the channel with the given index. As the
+// firmware does, the request carries our own position. The reply is recorded in the nodeDB when it is received, see
+// WaitForNode.
+func (r *Radio) RequestPosition(ctx context.Context, to uint32, channel int) error {
+	r.logger.Info("requesting Position", "to", meshtool.NodeID(to).String(), "channel", channel)
+	return r.sendPosition(ctx, to, channel, true, 0)
+}
 
-	position := &meshtastic.Position{
-		LatitudeI:  &r.cfg.PositionLatitudeI,
-		LongitudeI: &r.cfg.PositionLongitudeI,
-		Altitude:   &r.cfg.PositionAltitude,
-		Time:       uint32(time.Now().Unix()),
+// sendPosition sends the radio's position to a node on the channel with the given index. wantResponse asks the node
+// to reply with its own position, and requestID is the ID of the packet being replied to, or zero if the position was
+// not requested.
+func (r *Radio) sendPosition(ctx context.Context, to uint32, channel int, wantResponse bool, requestID uint32) error {
+	position := r.cfg.position(r.positionFlags())
+	r.updateNodeDB(r.cfg.NodeID.Uint32(), position)
+	// As the firmware does, the location is only sent with the precision configured for the channel.
+	precision := r.positionPrecision(channel)
+	if precision == 0 {
+		r.logger.Debug("not sending Position as the channel's position precision is 0", "channel", channel)
+		return nil
 	}
-	positionBytes, err := proto.Marshal(position)
+	positionBytes, err := proto.Marshal(meshtool.ReducePositionPrecision(position, precision))
 	if err != nil {
 		return fmt.Errorf("marshalling position: %w", err)
 	}
+	return r.sendPacket(ctx, &meshtastic.MeshPacket{
+		From:    r.cfg.NodeID.Uint32(),
+		To:      to,
+		Channel: uint32(channel),
+		PayloadVariant: &meshtastic.MeshPacket_Decoded{
+			Decoded: &meshtastic.Data{
+				Portnum:      meshtastic.PortNum_POSITION_APP,
+				Payload:      positionBytes,
+				WantResponse: wantResponse,
+				RequestId:    requestID,
+			},
+		},
+	})
+}
+
+func (r *Radio) broadcastTelemetry(ctx context.Context) error {
+	r.logger.Info("broadcasting Telemetry")
+
+	metrics := &meshtastic.DeviceMetrics{}
+	if r.cfg.DeviceMetrics != nil {
+		metrics = proto.Clone(r.cfg.DeviceMetrics).(*meshtastic.DeviceMetrics)
+	}
+	if startedAt := r.stats.startedAt.Load(); startedAt != 0 {
+		uptime := uint32(time.Since(time.Unix(0, startedAt)).Seconds())
+		metrics.UptimeSeconds = &uptime
+	}
+	channelUtilization, airUtilTx := r.airtime.utilization()
+	if metrics.ChannelUtilization == nil {
+		metrics.ChannelUtilization = &channelUtilization
+	}
+	if metrics.AirUtilTx == nil {
+		metrics.AirUtilTx = &airUtilTx
+	}
+	telemetry := &meshtastic.Telemetry{
+		Time: uint32(time.Now().Unix()),
+		Variant: &meshtastic.Telemetry_DeviceMetrics{
+			DeviceMetrics: metrics,
+		},
+	}
+	telemetryBytes, err := proto.Marshal(telemetry)
+	if err != nil {
+		return fmt.Errorf("marshalling telemetry: %w", err)
+	}
 	return r.sendPacket(ctx, &meshtastic.MeshPacket{
 		From: r.cfg.NodeID.Uint32(),
 		To:   meshtool.BroadcastNodeID.Uint32(),
 		PayloadVariant: &meshtastic.MeshPacket_Decoded{
 			Decoded: &meshtastic.Data{
-				Portnum: meshtastic.PortNum_POSITION_APP,
-				Payload: positionBytes,
+				Portnum: meshtastic.PortNum_TELEMETRY_APP,
+				Payload: telemetryBytes,
+			},
+		},
+	})
+}
+
+// sendRoutingAck acknowledges receipt of a packet to its sender, on the channel with the given index.
+func (r *Radio) sendRoutingAck(ctx context.Context, packet *meshtastic.MeshPacket, channel int) error {
+	routing := &meshtastic.Routing{
+		Variant: &meshtastic.Routing_ErrorReason{
+			ErrorReason: meshtastic.Routing_NONE,
+		},
+	}
+	routingBytes, err := proto.Marshal(routing)
+	if err != nil {
+		return fmt.Errorf("marshalling routing: %w", err)
+	}
+	r.logger.Debug("sending routing ACK", "to", meshtool.NodeID(packet.From).String(), "id", packet.Id)
+	return r.sendPacket(ctx, &meshtastic.MeshPacket{
+		From:     r.cfg.NodeID.Uint32(),
+		To:       packet.From,
+		Channel:  uint32(channel),
+		HopLimit: packet.HopStart,
+		HopStart: packet.HopStart,
+		PayloadVariant: &meshtastic.MeshPacket_Decoded{
+			Decoded: &meshtastic.Data{
+				Portnum:   meshtastic.PortNum_ROUTING_APP,
+				Payload:   routingBytes,
+				RequestId: packet.Id,
+			},
+		},
+	})
+}
+
+func (r *Radio) sendText(ctx context.Context, to uint32, channel int, text []byte) error {
+	r.logger.Info("sending TextMessage", "to", meshtool.NodeID(to).String(), "channel", channel)
+	return r.sendPacket(ctx, &meshtastic.MeshPacket{
+		From:    r.cfg.NodeID.Uint32(),
+		To:      to,
+		Channel: uint32(channel),
+		PayloadVariant: &meshtastic.MeshPacket_Decoded{
+			Decoded: &meshtastic.Data{
+				Portnum: meshtastic.PortNum_TEXT_MESSAGE_APP,
+				Payload: text,
 			},
 		},
 	})
@@ -373,12 +968,51 @@ func (r *Radio) dispatchMessageToFromRadio(msg *meshtastic.FromRadio) error {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	for ch := range r.fromRadioSubscribers {
-		// TODO: Make this way safer/resilient
-		ch <- msg
+		select {
+		case ch <- msg:
+		default:
+			// The client isn't keeping up, drop the message rather than stalling the radio and every other client.
+			r.logger.Warn("dropping FromRadio message for slow client", "msg", msg)
+		}
 	}
 	return nil
 }
 
+// impairLink applies the simulated packet loss and latency from the Config to a mesh packet sent or heard over the
+// radio link. It returns false if the packet should be dropped, otherwise it blocks for the simulated latency, or
+// until ctx is done, before returning true. The client API stream is local to the radio, so it is never impaired.
+func (r *Radio) impairLink(ctx context.Context) bool {
+	if r.cfg.SimulatedLossRate > 0 {
+		r.randMu.Lock()
+		drop := r.cfg.Rand.Float64() < r.cfg.SimulatedLossRate
+		r.randMu.Unlock()
+		if drop {
+			r.stats.packetsDropped.Add(1)
+			return false
+		}
+	}
+	if r.cfg.SimulatedLatency > 0 {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(r.cfg.SimulatedLatency):
+		}
+	}
+	return true
+}
+
+func (r *Radio) deviceMetadata() *meshtastic.DeviceMetadata {
+	return &meshtastic.DeviceMetadata{
+		FirmwareVersion:    r.cfg.FirmwareVersion,
+		DeviceStateVersion: r.cfg.DeviceStateVersion,
+		CanShutdown:        r.cfg.Capabilities.CanShutdown,
+		HasWifi:            r.cfg.Capabilities.HasWifi,
+		HasBluetooth:       r.cfg.Capabilities.HasBluetooth,
+		// PositionFlags?
+		HwModel: meshtastic.HardwareModel_PRIVATE_HW,
+	}
+}
+
 func (r *Radio) handleToRadioWantConfigID(conn *transport.StreamConn, req *meshtastic.ToRadio_WantConfigId) error {
 	// Send MyInfo
 	err := conn.Write(&meshtastic.FromRadio{
@@ -398,40 +1032,15 @@ func (r *Radio) handleToRadioWantConfigID(conn *transport.StreamConn, req *mesht
 	// Send Metadata
 	err = conn.Write(&meshtastic.FromRadio{
 		PayloadVariant: &meshtastic.FromRadio_Metadata{
-			Metadata: &meshtastic.DeviceMetadata{
-				// TODO: Establish firmwareVersion/deviceStateVersion to fake here
-				FirmwareVersion:    "2.2.19-fake",
-				DeviceStateVersion: 22,
-				CanShutdown:        true,
-				HasWifi:            true,
-				HasBluetooth:       true,
-				// PositionFlags?
-				HwModel: meshtastic.HardwareModel_PRIVATE_HW,
-			},
+			Metadata: r.deviceMetadata(),
 		},
 	})
 	if err != nil {
 		return fmt.Errorf("writing to streamConn: %w", err)
 	}
 
-	// Send all NodeDB entries - plus myself.
-	// TODO: Our own node info entry should be in the DB to avoid the special case here.
-	err = conn.Write(&meshtastic.FromRadio{
-		PayloadVariant: &meshtastic.FromRadio_NodeInfo{
-			NodeInfo: &meshtastic.NodeInfo{
-				Num: r.cfg.NodeID.Uint32(),
-				User: &meshtastic.User{
-					Id:        r.cfg.NodeID.String(),
-					LongName:  r.cfg.LongName,
-					ShortName: r.cfg.ShortName,
-				},
-			},
-		},
-	})
-	if err != nil {
-		return fmt.Errorf("writing to streamConn: %w", err)
-	}
-	for _, nodeInfo := range r.getNodeDB() {
+	// Send all NodeDB entries, which includes our own.
+	for _, nodeInfo := range r.Nodes() {
 		err = conn.Write(&meshtastic.FromRadio{
 			PayloadVariant: &meshtastic.FromRadio_NodeInfo{
 				NodeInfo: nodeInfo,
@@ -442,37 +1051,37 @@ func (r *Radio) handleToRadioWantConfigID(conn *transport.StreamConn, req *mesht
 		}
 	}
 
-	// TODO: Send all channels
-	err = conn.Write(&meshtastic.FromRadio{
-		PayloadVariant: &meshtastic.FromRadio_Channel{
-			Channel: &meshtastic.Channel{
-				Index: 0,
-				Settings: &meshtastic.ChannelSettings{
-					Psk: nil,
-				},
-				Role: meshtastic.Channel_PRIMARY,
+	for _, ch := range r.deviceChannels() {
+		err = conn.Write(&meshtastic.FromRadio{
+			PayloadVariant: &meshtastic.FromRadio_Channel{
+				Channel: ch,
 			},
-		},
-	})
-	if err != nil {
-		return fmt.Errorf("writing to streamConn: %w", err)
+		})
+		if err != nil {
+			return fmt.Errorf("writing to streamConn: %w", err)
+		}
 	}
 
-	// Send Config: Device
-	err = conn.Write(&meshtastic.FromRadio{
-		PayloadVariant: &meshtastic.FromRadio_Config{
-			Config: &meshtastic.Config{
-				PayloadVariant: &meshtastic.Config_Device{
-					Device: &meshtastic.Config_DeviceConfig{
-						SerialEnabled:         true,
-						NodeInfoBroadcastSecs: uint32(r.cfg.BroadcastNodeInfoInterval.Seconds()),
-					},
-				},
+	configs, moduleConfigs := r.allConfigs()
+	for _, cfg := range configs {
+		err = conn.Write(&meshtastic.FromRadio{
+			PayloadVariant: &meshtastic.FromRadio_Config{
+				Config: cfg,
 			},
-		},
-	})
-	if err != nil {
-		return fmt.Errorf("writing to streamConn: %w", err)
+		})
+		if err != nil {
+			return fmt.Errorf("writing to streamConn: %w", err)
+		}
+	}
+	for _, cfg := range moduleConfigs {
+		err = conn.Write(&meshtastic.FromRadio{
+			PayloadVariant: &meshtastic.FromRadio_ModuleConfig{
+				ModuleConfig: cfg,
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("writing to streamConn: %w", err)
+		}
 	}
 
 	// Send ConfigComplete to indicate we're done
@@ -488,8 +1097,123 @@ func (r *Radio) handleToRadioWantConfigID(conn *transport.StreamConn, req *mesht
 	return nil
 }
 
+func (r *Radio) handleToRadioPacket(ctx context.Context, conn *transport.StreamConn, packet *meshtastic.MeshPacket) error {
+	decoded := packet.GetDecoded()
+	if decoded == nil {
+		return nil
+	}
+	switch decoded.Portnum {
+	case meshtastic.PortNum_ADMIN_APP:
+		admin := &meshtastic.AdminMessage{}
+		if err := proto.Unmarshal(decoded.Payload, admin); err != nil {
+			return fmt.Errorf("unmarshalling admin: %w", err)
+		}
+		return r.handleAdminMessage(conn, packet, admin)
+	default:
+		// Transmit anything else onto the mesh on behalf of the client.
+		if packet.From == 0 {
+			packet.From = r.cfg.NodeID.Uint32()
+		}
+		return r.sendPacket(ctx, packet)
+	}
+}
+
+func (r *Radio) handleAdminMessage(conn *transport.StreamConn, packet *meshtastic.MeshPacket, admin *meshtastic.AdminMessage) error {
+	switch adminPayload := admin.PayloadVariant.(type) {
+	case *meshtastic.AdminMessage_GetChannelRequest:
+		r.logger.Info("received GetChannelRequest", "adminPayload", adminPayload, "packet", packet)
+		// The request holds the index of the channel plus one.
+		index := int(adminPayload.GetChannelRequest) - 1
+		channels := r.deviceChannels()
+		if index < 0 || index >= len(channels) {
+			r.logger.Warn("ignoring GetChannelRequest for channel out of range", "index", index)
+			return nil
+		}
+		return r.writeAdminResponse(conn, packet, &meshtastic.AdminMessage{
+			PayloadVariant: &meshtastic.AdminMessage_GetChannelResponse{
+				GetChannelResponse: channels[index],
+			},
+		})
+	case *meshtastic.AdminMessage_GetConfigRequest:
+		r.logger.Info("received GetConfigRequest", "type", adminPayload.GetConfigRequest, "packet", packet)
+		cfg, err := r.getConfig(adminPayload.GetConfigRequest)
+		if err != nil {
+			r.logger.Warn("ignoring GetConfigRequest", "err", err)
+			return nil
+		}
+		return r.writeAdminResponse(conn, packet, &meshtastic.AdminMessage{
+			PayloadVariant: &meshtastic.AdminMessage_GetConfigResponse{
+				GetConfigResponse: cfg,
+			},
+		})
+	case *meshtastic.AdminMessage_GetModuleConfigRequest:
+		r.logger.Info("received GetModuleConfigRequest", "type", adminPayload.GetModuleConfigRequest, "packet", packet)
+		cfg, err := r.getModuleConfig(adminPayload.GetModuleConfigRequest)
+		if err != nil {
+			r.logger.Warn("ignoring GetModuleConfigRequest", "err", err)
+			return nil
+		}
+		return r.writeAdminResponse(conn, packet, &meshtastic.AdminMessage{
+			PayloadVariant: &meshtastic.AdminMessage_GetModuleConfigResponse{
+				GetModuleConfigResponse: cfg,
+			},
+		})
+	case *meshtastic.AdminMessage_SetConfig:
+		r.logger.Info("received SetConfig", "config", adminPayload.SetConfig, "packet", packet)
+		if err := r.setConfig(adminPayload.SetConfig); err != nil {
+			r.logger.Warn("ignoring SetConfig", "err", err)
+		}
+	case *meshtastic.AdminMessage_SetModuleConfig:
+		r.logger.Info("received SetModuleConfig", "config", adminPayload.SetModuleConfig, "packet", packet)
+		if err := r.setModuleConfig(adminPayload.SetModuleConfig); err != nil {
+			r.logger.Warn("ignoring SetModuleConfig", "err", err)
+		}
+	case *meshtastic.AdminMessage_SetChannel:
+		r.logger.Info("received SetChannel", "channel", adminPayload.SetChannel, "packet", packet)
+		if err := r.setChannel(adminPayload.SetChannel); err != nil {
+			r.logger.Warn("ignoring SetChannel", "err", err)
+		}
+	case *meshtastic.AdminMessage_GetDeviceMetadataRequest:
+		r.logger.Info("received GetDeviceMetadataRequest", "packet", packet)
+		return r.writeAdminResponse(conn, packet, &meshtastic.AdminMessage{
+			PayloadVariant: &meshtastic.AdminMessage_GetDeviceMetadataResponse{
+				GetDeviceMetadataResponse: r.deviceMetadata(),
+			},
+		})
+	}
+	return nil
+}
+
+// writeAdminResponse sends an admin message to the client in response to the request contained in packet.
+func (r *Radio) writeAdminResponse(conn *transport.StreamConn, packet *meshtastic.MeshPacket, resp *meshtastic.AdminMessage) error {
+	respBytes, err := proto.Marshal(resp)
+	if err != nil {
+		return fmt.Errorf("marshalling admin response: %w", err)
+	}
+	if err := conn.Write(&meshtastic.FromRadio{
+		PayloadVariant: &meshtastic.FromRadio_Packet{
+			Packet: &meshtastic.MeshPacket{
+				Id:   r.nextPacketID(),
+				From: r.cfg.NodeID.Uint32(),
+				To:   r.cfg.NodeID.Uint32(),
+				PayloadVariant: &meshtastic.MeshPacket_Decoded{
+					Decoded: &meshtastic.Data{
+						Portnum:   meshtastic.PortNum_ADMIN_APP,
+						Payload:   respBytes,
+						RequestId: packet.Id,
+					},
+				},
+			},
+		},
+	}); err != nil {
+		return fmt.Errorf("writing to streamConn: %w", err)
+	}
+	return nil
+}
+
 func (r *Radio) handleConn(ctx context.Context, underlying io.ReadWriteCloser) error {
 	streamConn := transport.NewRadioStreamConn(underlying)
+	streamConn.Checksum = r.cfg.StreamChecksum
 	defer func() {
 		if err := streamConn.Close(); err != nil {
 			r.logger.Error("failed to close streamConn", "err", err)
@@ -500,13 +1224,12 @@ func (r *Radio) handleConn(ctx context.Context, underlying io.ReadWriteCloser) e
 	// Handling messages coming from client
 	eg.Go(func() error {
 		for {
-			select {
-			case <-egCtx.Done():
-				return nil
-			default:
-			}
 			msg := &meshtastic.ToRadio{}
-			if err := streamConn.Read(msg); err != nil {
+			// Connections which support deadlines, such as TCP, stop reading as soon as egCtx is done.
+			if err := streamConn.ReadContext(egCtx, msg); err != nil {
+				if egCtx.Err() != nil {
+					return nil
+				}
 				return fmt.Errorf("reading from streamConn: %w", err)
 			}
 			r.logger.Info("received ToRadio from streamConn", "msg", msg)
@@ -520,61 +1243,15 @@ func (r *Radio) handleConn(ctx context.Context, underlying io.ReadWriteCloser) e
 					return fmt.Errorf("handling WantConfigId: %w", err)
 				}
 			case *meshtastic.ToRadio_Packet:
-				if decoded := payload.Packet.GetDecoded(); decoded != nil {
-					if decoded.Portnum == meshtastic.PortNum_ADMIN_APP {
-						admin := &meshtastic.AdminMessage{}
-						if err := proto.Unmarshal(decoded.Payload, admin); err != nil {
-							return fmt.Errorf("unmarshalling admin: %w", err)
-						}
-
-						switch adminPayload := admin.PayloadVariant.(type) {
-						// TODO: Properly handle channel listing, this hack is just so the Python CLI thinks
-						// it's connected
-						case *meshtastic.AdminMessage_GetChannelRequest:
-							r.logger.Info("received GetChannelRequest", "adminPayload", adminPayload, "packet", payload)
-							resp := &meshtastic.AdminMessage{
-								PayloadVariant: &meshtastic.AdminMessage_GetChannelResponse{
-									GetChannelResponse: &meshtastic.Channel{
-										Index: 0,
-										Settings: &meshtastic.ChannelSettings{
-											Psk: nil,
-										},
-										Role: meshtastic.Channel_DISABLED,
-									},
-								},
-							}
-							respBytes, err := proto.Marshal(resp)
-							if err != nil {
-								return fmt.Errorf("marshalling GetChannelResponse: %w", err)
-							}
-							// Send GetChannelResponse
-							if err := streamConn.Write(&meshtastic.FromRadio{
-								PayloadVariant: &meshtastic.FromRadio_Packet{
-									Packet: &meshtastic.MeshPacket{
-										Id:   r.nextPacketID(),
-										From: r.cfg.NodeID.Uint32(),
-										To:   r.cfg.NodeID.Uint32(),
-										PayloadVariant: &meshtastic.MeshPacket_Decoded{
-											Decoded: &meshtastic.Data{
-												Portnum:   meshtastic.PortNum_ADMIN_APP,
-												Payload:   respBytes,
-												RequestId: payload.Packet.Id,
-											},
-										},
-									},
-								},
-							}); err != nil {
-								return fmt.Errorf("writing to streamConn: %w", err)
-							}
-						}
-					}
+				if err := r.handleToRadioPacket(egCtx, streamConn, payload.Packet); err != nil {
+					return fmt.Errorf("handling Packet: %w", err)
 				}
 			}
 		}
 	})
 	// Handle sending messages to client
 	eg.Go(func() error {
-		ch := make(chan *meshtastic.FromRadio)
+		ch := make(chan *meshtastic.FromRadio, fromRadioBuffer)
 		r.mu.Lock()
 		r.fromRadioSubscribers[ch] = struct{}{}
 		r.mu.Unlock()
@@ -605,10 +1282,23 @@ func (r *Radio) listenTCP(ctx context.Context) error {
 		return fmt.Errorf("listening: %w", err)
 	}
 	r.logger.Info("listening for tcp connections", "addr", r.cfg.TCPListenAddr)
+	// Closing the listener unblocks Accept so that Run can return once ctx is cancelled.
+	stop := context.AfterFunc(ctx, func() {
+		if err := l.Close(); err != nil {
+			r.logger.Debug("closing tcp listener", "err", err)
+		}
+	})
+	defer stop()
 
 	for {
 		c, err := l.Accept()
 		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return fmt.Errorf("accepting connections: %w", err)
+			}
 			r.logger.Error("failed to accept connection", "err", err)
 			continue
 		}
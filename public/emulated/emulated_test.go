@@ -0,0 +1,955 @@
+package emulated
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rabarar/meshtastic"
+	"github.com/rabarar/meshtool-go/public/meshtool"
+	"github.com/rabarar/meshtool-go/public/mqtt"
+	"github.com/rabarar/meshtool-go/public/radio"
+	"github.com/rabarar/meshtool-go/public/transport"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+func newTestRadio(t *testing.T, opts ...func(*Config)) *Radio {
+	t.Helper()
+	cfg := Config{
+		Bus:    NewBus("msh"),
+		NodeID: meshtool.NodeID(0x1234),
+		Channels: &meshtastic.ChannelSet{
+			Settings: []*meshtastic.ChannelSettings{
+				{
+					Name: "LongFast",
+					Psk:  radio.DefaultKey,
+				},
+			},
+		},
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	r, err := NewRadio(cfg)
+	require.NoError(t, err)
+	return r
+}
+
+// runTestRadio runs r in the background until the test finishes, returning once it has subscribed to its channels.
+func runTestRadio(t *testing.T, r *Radio) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- r.Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("running radio: %v", err)
+		}
+	})
+	waitSubscribed(t, r)
+}
+
+// waitSubscribed waits until Run has subscribed r to each of its channels.
+func waitSubscribed(t *testing.T, r *Radio) {
+	t.Helper()
+	require.Eventually(t, func() bool {
+		r.configMu.RLock()
+		defer r.configMu.RUnlock()
+		if r.subscribedChannels == nil {
+			return false
+		}
+		for _, ch := range r.channels.All() {
+			if _, ok := r.subscribedChannels[ch.Name]; !ok {
+				return false
+			}
+		}
+		return true
+	}, time.Second, time.Millisecond)
+}
+
+// withSecondaryChannel adds a secondary channel to the test radio.
+func withSecondaryChannel(name string, psk []byte) func(*Config) {
+	return func(cfg *Config) {
+		cfg.Channels.Settings = append(cfg.Channels.Settings, &meshtastic.ChannelSettings{Name: name, Psk: psk})
+	}
+}
+
+// encryptedEnvelope returns a marshalled ServiceEnvelope containing packet encrypted with key, as it would be
+// published to MQTT by another node.
+func encryptedEnvelope(t *testing.T, packet *meshtastic.MeshPacket, channel string, key []byte) []byte {
+	t.Helper()
+	node := &meshtool.Node{ID: packet.From}
+	encrypted, err := node.EncryptPacket(packet, channel, key)
+	require.NoError(t, err)
+	payload, err := proto.Marshal(&meshtastic.ServiceEnvelope{
+		ChannelId: channel,
+		GatewayId: meshtool.NodeID(packet.From).String(),
+		Packet:    encrypted,
+	})
+	require.NoError(t, err)
+	return payload
+}
+
+func TestRadio_tryHandleMQTTMessage_TagsViaMQTT(t *testing.T) {
+	r := newTestRadio(t, withSecondaryChannel("Other", radio.DefaultKey))
+
+	ch := make(chan *meshtastic.FromRadio, 1)
+	r.fromRadioSubscribers[ch] = struct{}{}
+
+	payload, err := proto.Marshal(&meshtastic.ServiceEnvelope{
+		// The payload cannot be decrypted, so only the relay path is exercised.
+		ChannelId: "Other",
+		GatewayId: "!deadbeef",
+		Packet: &meshtastic.MeshPacket{
+			Id:             1,
+			From:           0xdeadbeef,
+			To:             meshtool.BroadcastNodeID.Uint32(),
+			HopLimit:       3,
+			PayloadVariant: &meshtastic.MeshPacket_Encrypted{Encrypted: []byte{0x01, 0x02}},
+		},
+	})
+	require.NoError(t, err)
+	require.NoError(t, r.tryHandleMQTTMessage(context.Background(), mqtt.Message{Payload: payload}))
+
+	msg := <-ch
+	packet := msg.GetPacket()
+	require.NotNil(t, packet)
+	require.True(t, packet.ViaMqtt)
+	require.Equal(t, uint32(3), packet.HopLimit)
+	require.Equal(t, uint32(3), packet.HopStart)
+}
+
+func TestRadio_tryHandleMQTTMessage_IgnoresUnknownChannel(t *testing.T) {
+	r := newTestRadio(t)
+
+	ch := make(chan *meshtastic.FromRadio, 1)
+	r.fromRadioSubscribers[ch] = struct{}{}
+
+	payload, err := proto.Marshal(&meshtastic.ServiceEnvelope{
+		ChannelId: "Unknown",
+		GatewayId: "!deadbeef",
+		Packet: &meshtastic.MeshPacket{
+			Id:             1,
+			From:           0xdeadbeef,
+			PayloadVariant: &meshtastic.MeshPacket_Encrypted{Encrypted: []byte{0x01, 0x02}},
+		},
+	})
+	require.NoError(t, err)
+	require.NoError(t, r.tryHandleMQTTMessage(context.Background(), mqtt.Message{Payload: payload}))
+	require.Empty(t, ch)
+}
+
+func TestRadio_SimulatedLoss(t *testing.T) {
+	tests := []struct {
+		name     string
+		lossRate float64
+		check    func(t *testing.T, delivered int)
+	}{
+		{name: "no loss", lossRate: 0, check: func(t *testing.T, delivered int) { require.Equal(t, 100, delivered) }},
+		{name: "total loss", lossRate: 1, check: func(t *testing.T, delivered int) { require.Equal(t, 0, delivered) }},
+		{name: "partial loss", lossRate: 0.5, check: func(t *testing.T, delivered int) {
+			require.Greater(t, delivered, 0)
+			require.Less(t, delivered, 100)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestRadio(t, func(cfg *Config) {
+				cfg.SimulatedLossRate = tt.lossRate
+				cfg.Rand = rand.New(rand.NewSource(1))
+			})
+			ch := make(chan *meshtastic.FromRadio, 200)
+			r.fromRadioSubscribers[ch] = struct{}{}
+			for i := 0; i < 100; i++ {
+				payload := encryptedEnvelope(t, &meshtastic.MeshPacket{
+					Id:   uint32(i + 1),
+					From: 0xdeadbeef,
+					To:   meshtool.BroadcastNodeID.Uint32(),
+					PayloadVariant: &meshtastic.MeshPacket_Decoded{Decoded: &meshtastic.Data{
+						Portnum: meshtastic.PortNum_ROUTING_APP,
+					}},
+				}, "LongFast", radio.DefaultKey)
+				require.NoError(t, r.tryHandleMQTTMessage(context.Background(), mqtt.Message{Payload: payload}))
+			}
+			tt.check(t, len(ch))
+
+			// Messages from the radio to its clients which are not mesh packets never cross the radio link.
+			for len(ch) > 0 {
+				<-ch
+			}
+			for i := 0; i < 100; i++ {
+				require.NoError(t, r.dispatchMessageToFromRadio(&meshtastic.FromRadio{Id: uint32(i)}))
+			}
+			require.Len(t, ch, 100)
+		})
+	}
+}
+
+func TestRadio_DeviceMetadata(t *testing.T) {
+	tests := []struct {
+		name string
+		opt  func(cfg *Config)
+		want *meshtastic.DeviceMetadata
+	}{
+		{
+			name: "defaults",
+			opt:  func(cfg *Config) {},
+			want: &meshtastic.DeviceMetadata{
+				FirmwareVersion:    DefaultFirmwareVersion,
+				DeviceStateVersion: DefaultDeviceStateVersion,
+				CanShutdown:        true,
+				HasWifi:            true,
+				HasBluetooth:       true,
+				HwModel:            meshtastic.HardwareModel_PRIVATE_HW,
+			},
+		},
+		{
+			name: "configured",
+			opt: func(cfg *Config) {
+				cfg.FirmwareVersion = "2.5.0"
+				cfg.DeviceStateVersion = 23
+				cfg.Capabilities = &Capabilities{HasBluetooth: true}
+			},
+			want: &meshtastic.DeviceMetadata{
+				FirmwareVersion:    "2.5.0",
+				DeviceStateVersion: 23,
+				HasBluetooth:       true,
+				HwModel:            meshtastic.HardwareModel_PRIVATE_HW,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestRadio(t, tt.opt)
+			radioConn, clientConn := net.Pipe()
+			defer clientConn.Close()
+			go func() {
+				defer radioConn.Close()
+				_ = r.handleAdminMessage(transport.NewRadioStreamConn(radioConn), &meshtastic.MeshPacket{Id: 7}, &meshtastic.AdminMessage{
+					PayloadVariant: &meshtastic.AdminMessage_GetDeviceMetadataRequest{GetDeviceMetadataRequest: true},
+				})
+			}()
+
+			msg := &meshtastic.FromRadio{}
+			require.NoError(t, transport.NewRadioStreamConn(clientConn).Read(msg))
+			decoded := msg.GetPacket().GetDecoded()
+			require.Equal(t, meshtastic.PortNum_ADMIN_APP, decoded.GetPortnum())
+			require.Equal(t, uint32(7), decoded.GetRequestId())
+			resp := &meshtastic.AdminMessage{}
+			require.NoError(t, proto.Unmarshal(decoded.GetPayload(), resp))
+			require.True(t, proto.Equal(tt.want, resp.GetGetDeviceMetadataResponse()), "got %v", resp)
+			// The metadata sent with the radio's config matches that returned by GetDeviceMetadataRequest.
+			require.True(t, proto.Equal(tt.want, r.deviceMetadata()))
+		})
+	}
+}
+
+func TestRadio_SimulatedLoss_ClientConnects(t *testing.T) {
+	r := newTestRadio(t, func(cfg *Config) {
+		cfg.SimulatedLossRate = 1
+		cfg.SimulatedLatency = time.Hour
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	sc, err := transport.NewClientStreamConn(r.Conn(ctx))
+	require.NoError(t, err)
+	client := transport.NewClient(sc, false)
+	// The config handshake is local to the radio, so it completes however impaired the mesh is.
+	require.NoError(t, client.Connect(ctx))
+	require.NoError(t, client.Disconnect())
+}
+
+func TestRadio_SimulatedLatency_Cancelled(t *testing.T) {
+	r := newTestRadio(t, func(cfg *Config) {
+		cfg.SimulatedLatency = time.Hour
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- r.sendText(ctx, meshtool.BroadcastNodeID.Uint32(), 0, []byte("hello"))
+	}()
+	cancel()
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("simulated latency not cancelled with the context")
+	}
+	require.Equal(t, uint64(0), r.Stats().PacketsSent)
+}
+
+func TestRadio_dispatchMessageToFromRadio_SlowSubscriber(t *testing.T) {
+	r := newTestRadio(t)
+	// The slow subscriber is never read from, so it fills up immediately.
+	slow := make(chan *meshtastic.FromRadio)
+	fast := make(chan *meshtastic.FromRadio, 10)
+	r.fromRadioSubscribers[slow] = struct{}{}
+	r.fromRadioSubscribers[fast] = struct{}{}
+
+	done := make(chan error)
+	go func() {
+		for i := 0; i < 10; i++ {
+			if err := r.dispatchMessageToFromRadio(&meshtastic.FromRadio{Id: uint32(i)}); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("dispatch blocked on slow subscriber")
+	}
+	require.Len(t, fast, 10)
+	for i := 0; i < 10; i++ {
+		require.Equal(t, uint32(i), (<-fast).Id)
+	}
+}
+
+func TestRadio_Bus(t *testing.T) {
+	ctx := context.Background()
+	bus := NewBus("msh")
+	a := newTestRadio(t, func(cfg *Config) {
+		cfg.Bus = bus
+		cfg.NodeID = 0xaaaa
+	})
+	b := newTestRadio(t, func(cfg *Config) {
+		cfg.Bus = bus
+		cfg.NodeID = 0xbbbb
+	})
+	runTestRadio(t, a)
+	runTestRadio(t, b)
+
+	require.NoError(t, a.broadcastNodeInfo(ctx))
+	waitCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	node, ok := b.WaitForNode(waitCtx, 0xaaaa)
+	require.True(t, ok)
+	require.Equal(t, a.cfg.LongName, node.GetUser().GetLongName())
+}
+
+func TestRadio_broadcastTelemetry(t *testing.T) {
+	ctx := context.Background()
+	bus := NewBus("msh")
+	a := newTestRadio(t, func(cfg *Config) {
+		cfg.Bus = bus
+		cfg.NodeID = 0xaaaa
+		cfg.DeviceMetrics = &meshtastic.DeviceMetrics{
+			BatteryLevel:       proto.Uint32(87),
+			Voltage:            proto.Float32(3.9),
+			ChannelUtilization: proto.Float32(12.5),
+		}
+	})
+	b := newTestRadio(t, func(cfg *Config) {
+		cfg.Bus = bus
+		cfg.NodeID = 0xbbbb
+	})
+	runTestRadio(t, a)
+	runTestRadio(t, b)
+
+	require.NoError(t, a.broadcastTelemetry(ctx))
+	waitCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	node, ok := b.WaitForNode(waitCtx, 0xaaaa)
+	require.True(t, ok)
+	metrics := node.GetDeviceMetrics()
+	require.Equal(t, uint32(87), metrics.GetBatteryLevel())
+	require.Equal(t, float32(3.9), metrics.GetVoltage())
+	require.Equal(t, float32(12.5), metrics.GetChannelUtilization())
+	// AirUtilTx is not configured, so it is calculated from the packets the radio has sent.
+	require.NotNil(t, metrics.AirUtilTx)
+	require.NotNil(t, metrics.UptimeSeconds)
+}
+
+func TestRadio_NodeInfoRequest(t *testing.T) {
+	r := newTestRadio(t)
+	published := make(chan mqtt.Message, 1)
+	r.cfg.Bus.Handle("LongFast", func(m mqtt.Message) {
+		published <- m
+	})
+
+	userBytes, err := proto.Marshal(&meshtastic.User{Id: "!deadbeef", LongName: "Remote"})
+	require.NoError(t, err)
+	payload := encryptedEnvelope(t, &meshtastic.MeshPacket{
+		Id:   42,
+		From: 0xdeadbeef,
+		To:   r.cfg.NodeID.Uint32(),
+		PayloadVariant: &meshtastic.MeshPacket_Decoded{Decoded: &meshtastic.Data{
+			Portnum:      meshtastic.PortNum_NODEINFO_APP,
+			Payload:      userBytes,
+			WantResponse: true,
+		}},
+	}, "LongFast", radio.DefaultKey)
+	require.NoError(t, r.tryHandleMQTTMessage(context.Background(), mqtt.Message{Payload: payload}))
+
+	se := &meshtastic.ServiceEnvelope{}
+	select {
+	case m := <-published:
+		require.NoError(t, proto.Unmarshal(m.Payload, se))
+	case <-time.After(time.Second):
+		t.Fatal("no NodeInfo reply")
+	}
+	require.Equal(t, uint32(0xdeadbeef), se.Packet.To)
+	data, err := radio.TryDecode(se.Packet, radio.DefaultKey)
+	require.NoError(t, err)
+	require.Equal(t, meshtastic.PortNum_NODEINFO_APP, data.Portnum)
+	require.Equal(t, uint32(42), data.RequestId)
+	user := &meshtastic.User{}
+	require.NoError(t, proto.Unmarshal(data.Payload, user))
+	require.Equal(t, r.cfg.LongName, user.LongName)
+}
+
+func TestRadio_PositionRequest(t *testing.T) {
+	ctx := context.Background()
+	bus := NewBus("msh")
+	a := newTestRadio(t, func(cfg *Config) {
+		cfg.Bus = bus
+		cfg.NodeID = 0xaaaa
+		cfg.SetPositionDegrees(51.5, -0.1)
+	})
+	b := newTestRadio(t, func(cfg *Config) {
+		cfg.Bus = bus
+		cfg.NodeID = 0xbbbb
+		cfg.SetPositionDegrees(48.8, 2.3)
+	})
+	published := make(chan *meshtastic.Data, 2)
+	bus.Handle("LongFast", func(m mqtt.Message) {
+		se := &meshtastic.ServiceEnvelope{}
+		if err := proto.Unmarshal(m.Payload, se); err != nil {
+			return
+		}
+		if data, err := radio.TryDecode(se.Packet, radio.DefaultKey); err == nil {
+			select {
+			case published <- data:
+			default:
+			}
+		}
+	})
+	runTestRadio(t, a)
+	runTestRadio(t, b)
+
+	require.NoError(t, a.RequestPosition(ctx, 0xbbbb, 0))
+	var request, reply *meshtastic.Data
+	for _, data := range []**meshtastic.Data{&request, &reply} {
+		select {
+		case *data = <-published:
+		case <-time.After(time.Second):
+			t.Fatal("no Position published")
+		}
+	}
+	require.Equal(t, meshtastic.PortNum_POSITION_APP, request.Portnum)
+	require.True(t, request.WantResponse)
+	require.Equal(t, meshtastic.PortNum_POSITION_APP, reply.Portnum)
+	require.False(t, reply.WantResponse)
+	require.NotZero(t, reply.RequestId)
+
+	waitCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	node, ok := a.WaitForNode(waitCtx, 0xbbbb)
+	require.True(t, ok)
+	require.Equal(t, b.cfg.position(DefaultPositionFlags).GetLatitudeI(), node.GetPosition().GetLatitudeI())
+	require.Equal(t, b.cfg.position(DefaultPositionFlags).GetLongitudeI(), node.GetPosition().GetLongitudeI())
+}
+
+func TestRadio_sendPosition_Precision(t *testing.T) {
+	tests := []struct {
+		name           string
+		moduleSettings *meshtastic.ModuleSettings
+		wantSent       bool
+		wantPrecision  uint32
+	}{
+		{name: "no module settings", wantSent: true, wantPrecision: meshtool.FullPositionPrecision},
+		{name: "reduced", moduleSettings: &meshtastic.ModuleSettings{PositionPrecision: 13}, wantSent: true, wantPrecision: 13},
+		{name: "not shared", moduleSettings: &meshtastic.ModuleSettings{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestRadio(t, func(cfg *Config) {
+				cfg.Channels.Settings[0].ModuleSettings = tt.moduleSettings
+				cfg.SetPositionDegrees(51.501476, -0.140634)
+			})
+			published := make(chan mqtt.Message, 1)
+			r.cfg.Bus.Handle("LongFast", func(m mqtt.Message) {
+				published <- m
+			})
+			require.NoError(t, r.broadcastPosition(context.Background()))
+
+			if !tt.wantSent {
+				select {
+				case <-published:
+					t.Fatal("Position sent on a channel with a position precision of 0")
+				case <-time.After(50 * time.Millisecond):
+				}
+				return
+			}
+			se := &meshtastic.ServiceEnvelope{}
+			select {
+			case m := <-published:
+				require.NoError(t, proto.Unmarshal(m.Payload, se))
+			case <-time.After(time.Second):
+				t.Fatal("no Position sent")
+			}
+			data, err := radio.TryDecode(se.Packet, radio.DefaultKey)
+			require.NoError(t, err)
+			position := &meshtastic.Position{}
+			require.NoError(t, proto.Unmarshal(data.Payload, position))
+			want := meshtool.ReducePositionPrecision(r.cfg.position(DefaultPositionFlags), tt.wantPrecision)
+			require.Equal(t, want.GetLatitudeI(), position.GetLatitudeI())
+			require.Equal(t, want.GetLongitudeI(), position.GetLongitudeI())
+			require.Equal(t, tt.wantPrecision, position.PrecisionBits)
+			require.Equal(t, r.cfg.PositionAltitude, position.GetAltitude())
+		})
+	}
+}
+
+func TestRadio_RoutingAck(t *testing.T) {
+	tests := []struct {
+		name    string
+		to      uint32
+		wantAck bool
+	}{
+		{
+			name:    "unicast",
+			to:      0x1234,
+			wantAck: true,
+		},
+		{
+			name: "broadcast",
+			to:   meshtool.BroadcastNodeID.Uint32(),
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r := newTestRadio(t)
+			published := make(chan mqtt.Message, 1)
+			r.cfg.Bus.Handle("LongFast", func(m mqtt.Message) {
+				published <- m
+			})
+
+			payload := encryptedEnvelope(t, &meshtastic.MeshPacket{
+				Id:   42,
+				From: 0xdeadbeef,
+				To:   tc.to,
+				PayloadVariant: &meshtastic.MeshPacket_Decoded{Decoded: &meshtastic.Data{
+					Portnum: meshtastic.PortNum_TEXT_MESSAGE_APP,
+					Payload: []byte("hello"),
+				}},
+			}, "LongFast", radio.DefaultKey)
+			require.NoError(t, r.tryHandleMQTTMessage(context.Background(), mqtt.Message{Payload: payload}))
+
+			if !tc.wantAck {
+				select {
+				case <-published:
+					t.Fatal("broadcast was acknowledged")
+				case <-time.After(50 * time.Millisecond):
+				}
+				return
+			}
+			se := &meshtastic.ServiceEnvelope{}
+			select {
+			case m := <-published:
+				require.NoError(t, proto.Unmarshal(m.Payload, se))
+			case <-time.After(time.Second):
+				t.Fatal("no routing ACK")
+			}
+			require.Equal(t, uint32(0xdeadbeef), se.Packet.To)
+			data, err := radio.TryDecode(se.Packet, radio.DefaultKey)
+			require.NoError(t, err)
+			require.Equal(t, meshtastic.PortNum_ROUTING_APP, data.Portnum)
+			require.Equal(t, uint32(42), data.RequestId)
+			routing := &meshtastic.Routing{}
+			require.NoError(t, proto.Unmarshal(data.Payload, routing))
+			require.Equal(t, meshtastic.Routing_NONE, routing.GetErrorReason())
+		})
+	}
+}
+
+func TestRadio_WaitForNode(t *testing.T) {
+	r := newTestRadio(t)
+
+	// Returns false once the context expires.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, ok := r.WaitForNode(ctx, 0xdead)
+	require.False(t, ok)
+
+	// Returns immediately if the node is already present.
+	r.updateNodeDB(0xdead, &meshtastic.User{})
+	node, ok := r.WaitForNode(context.Background(), 0xdead)
+	require.True(t, ok)
+	require.Equal(t, uint32(0xdead), node.Num)
+}
+
+// mapKeyProvider is a KeyProvider holding keys in a map, which may be changed to rotate them.
+type mapKeyProvider struct {
+	mu   sync.Mutex
+	keys map[string][]byte
+}
+
+func (p *mapKeyProvider) GetPSK(channelName string) ([]byte, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	key, ok := p.keys[channelName]
+	return key, ok
+}
+
+func (p *mapKeyProvider) set(channelName string, key []byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.keys[channelName] = key
+}
+
+func TestRadio_KeyProvider_Send(t *testing.T) {
+	provider := &mapKeyProvider{keys: map[string][]byte{}}
+	r := newTestRadio(t, func(cfg *Config) {
+		cfg.KeyProvider = provider
+	})
+	published := make(chan mqtt.Message, 1)
+	r.cfg.Bus.Handle("LongFast", func(m mqtt.Message) {
+		published <- m
+	})
+	sendWithKey := func(t *testing.T, key []byte) {
+		t.Helper()
+		require.NoError(t, r.sendText(context.Background(), meshtool.BroadcastNodeID.Uint32(), 0, []byte("hello")))
+		se := &meshtastic.ServiceEnvelope{}
+		require.NoError(t, proto.Unmarshal((<-published).Payload, se))
+		require.Equal(t, uint32(radio.ChannelNumber("LongFast", key)), se.Packet.Channel)
+		data, err := radio.TryDecode(se.Packet, key)
+		require.NoError(t, err)
+		require.Equal(t, "hello", string(data.Payload))
+	}
+
+	// Channels the provider has no key for use the configured PSK.
+	sendWithKey(t, radio.DefaultKey)
+	// Keys are looked up for each packet, so rotating them takes effect immediately.
+	provider.set("LongFast", []byte("0123456789abcdef"))
+	sendWithKey(t, []byte("0123456789abcdef"))
+	provider.set("LongFast", []byte("fedcba9876543210"))
+	sendWithKey(t, []byte("fedcba9876543210"))
+}
+
+func TestRadio_KeyProvider_Receive(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	provider := &mapKeyProvider{keys: map[string][]byte{"LongFast": key, "Dynamic": key}}
+	r := newTestRadio(t, func(cfg *Config) {
+		cfg.KeyProvider = provider
+	})
+	ch := make(chan *meshtastic.FromRadio, 2)
+	r.fromRadioSubscribers[ch] = struct{}{}
+	user, err := proto.Marshal(&meshtastic.User{Id: "!deadbeef", LongName: "Remote"})
+	require.NoError(t, err)
+	envelope := func(id uint32, channel string) []byte {
+		plaintext, err := proto.Marshal(&meshtastic.Data{Portnum: meshtastic.PortNum_NODEINFO_APP, Payload: user})
+		require.NoError(t, err)
+		encrypted, err := radio.XOR(plaintext, key, id, 0xdeadbeef)
+		require.NoError(t, err)
+		payload, err := proto.Marshal(&meshtastic.ServiceEnvelope{
+			ChannelId: channel,
+			GatewayId: "!deadbeef",
+			Packet: &meshtastic.MeshPacket{
+				Id:             id,
+				From:           0xdeadbeef,
+				To:             meshtool.BroadcastNodeID.Uint32(),
+				PayloadVariant: &meshtastic.MeshPacket_Encrypted{Encrypted: encrypted},
+			},
+		})
+		require.NoError(t, err)
+		return payload
+	}
+
+	// A channel known only to the provider is relayed to clients, but not handled by the radio.
+	require.NoError(t, r.tryHandleMQTTMessage(context.Background(), mqtt.Message{Payload: envelope(1, "Dynamic")}))
+	require.Len(t, ch, 1)
+	_, ok := r.nodeDB.Get(0xdeadbeef)
+	require.False(t, ok)
+
+	// The provider's key takes precedence over the configured PSK for configured channels.
+	require.NoError(t, r.tryHandleMQTTMessage(context.Background(), mqtt.Message{Payload: envelope(2, "LongFast")}))
+	require.Len(t, ch, 2)
+	node, ok := r.nodeDB.Get(0xdeadbeef)
+	require.True(t, ok)
+	require.Equal(t, "Remote", node.GetUser().GetLongName())
+}
+
+func TestRadio_sendPacket_QueueStatus(t *testing.T) {
+	r := newTestRadio(t, func(cfg *Config) {
+		cfg.QueueSize = 8
+	})
+	ch := make(chan *meshtastic.FromRadio, 1)
+	r.fromRadioSubscribers[ch] = struct{}{}
+
+	require.NoError(t, r.sendText(context.Background(), meshtool.BroadcastNodeID.Uint32(), 0, []byte("hello")))
+
+	queueStatus := (<-ch).GetQueueStatus()
+	require.NotNil(t, queueStatus)
+	require.Equal(t, uint32(8), queueStatus.Free)
+	require.Equal(t, uint32(8), queueStatus.Maxlen)
+	require.NotZero(t, queueStatus.MeshPacketId)
+}
+
+func TestRadio_sendPacket_Encrypts(t *testing.T) {
+	r := newTestRadio(t)
+	published := make(chan mqtt.Message, 1)
+	r.cfg.Bus.Handle("LongFast", func(m mqtt.Message) {
+		published <- m
+	})
+
+	require.NoError(t, r.sendText(context.Background(), meshtool.BroadcastNodeID.Uint32(), 0, []byte("hello")))
+
+	se := &meshtastic.ServiceEnvelope{}
+	require.NoError(t, proto.Unmarshal((<-published).Payload, se))
+	require.NotNil(t, se.Packet.GetEncrypted())
+	require.Equal(t, uint32(8), se.Packet.Channel)
+	data, err := radio.TryDecode(se.Packet, radio.DefaultKey)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(data.Payload))
+}
+
+func TestRadio_MultipleChannels(t *testing.T) {
+	otherKey := bytes.Repeat([]byte{0x42}, 32)
+	r := newTestRadio(t, withSecondaryChannel("Other", otherKey))
+	published := make(chan mqtt.Message, 1)
+	r.cfg.Bus.Handle("Other", func(m mqtt.Message) {
+		published <- m
+	})
+
+	// Packets sent on the secondary channel are published on its topic and encrypted with its key.
+	require.NoError(t, r.sendText(context.Background(), meshtool.BroadcastNodeID.Uint32(), 1, []byte("hello")))
+	se := &meshtastic.ServiceEnvelope{}
+	require.NoError(t, proto.Unmarshal((<-published).Payload, se))
+	require.Equal(t, "Other", se.ChannelId)
+	require.Equal(t, uint32(radio.ChannelNumber("Other", otherKey)), se.Packet.Channel)
+	data, err := radio.TryDecode(se.Packet, otherKey)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(data.Payload))
+
+	// Packets received on the secondary channel are decrypted with its key and update the nodeDB.
+	userBytes, err := proto.Marshal(&meshtastic.User{Id: "!deadbeef", LongName: "Remote"})
+	require.NoError(t, err)
+	payload := encryptedEnvelope(t, &meshtastic.MeshPacket{
+		Id:   1,
+		From: 0xdeadbeef,
+		To:   meshtool.BroadcastNodeID.Uint32(),
+		PayloadVariant: &meshtastic.MeshPacket_Decoded{Decoded: &meshtastic.Data{
+			Portnum: meshtastic.PortNum_NODEINFO_APP,
+			Payload: userBytes,
+		}},
+	}, "Other", otherKey)
+	require.NoError(t, r.tryHandleMQTTMessage(context.Background(), mqtt.Message{Payload: payload}))
+	node, ok := r.getNode(0xdeadbeef)
+	require.True(t, ok)
+	require.Equal(t, "Remote", node.GetUser().GetLongName())
+
+	require.Error(t, r.sendText(context.Background(), meshtool.BroadcastNodeID.Uint32(), 2, []byte("hello")))
+}
+
+func TestRadio_SeedNodes(t *testing.T) {
+	seed := &meshtastic.NodeInfo{
+		Num:  0xdeadbeef,
+		User: &meshtastic.User{LongName: "Seeded"},
+	}
+	r := newTestRadio(t, func(cfg *Config) {
+		cfg.SeedNodes = []*meshtastic.NodeInfo{seed}
+	})
+	node, ok := r.getNode(0xdeadbeef)
+	require.True(t, ok)
+	require.True(t, proto.Equal(seed, node))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	sc, err := transport.NewClientStreamConn(r.Conn(ctx))
+	require.NoError(t, err)
+	client := transport.NewClient(sc, false)
+	require.NoError(t, client.Connect(ctx))
+	var names []string
+	for _, n := range client.State.Nodes() {
+		names = append(names, n.GetUser().GetLongName())
+	}
+	require.Contains(t, names, "Seeded")
+}
+
+func TestRadio_OwnNode(t *testing.T) {
+	r := newTestRadio(t, func(cfg *Config) {
+		cfg.PositionLatitudeI = 515014760
+		cfg.PositionLongitudeI = -1406340
+	})
+	self, ok := r.getNode(r.cfg.NodeID.Uint32())
+	require.True(t, ok)
+	require.Equal(t, r.cfg.LongName, self.GetUser().GetLongName())
+	require.Equal(t, int32(515014760), self.GetPosition().GetLatitudeI())
+
+	// Broadcasting a position updates our own entry.
+	r.cfg.PositionLatitudeI = 515000000
+	require.NoError(t, r.broadcastPosition(context.Background()))
+	self, ok = r.getNode(r.cfg.NodeID.Uint32())
+	require.True(t, ok)
+	require.Equal(t, int32(515000000), self.GetPosition().GetLatitudeI())
+
+	// Clients receive our own node exactly once.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	sc, err := transport.NewClientStreamConn(r.Conn(ctx))
+	require.NoError(t, err)
+	client := transport.NewClient(sc, false)
+	require.NoError(t, client.Connect(ctx))
+	nodes := client.State.Nodes()
+	require.Len(t, nodes, 1)
+	require.Equal(t, r.cfg.NodeID.Uint32(), nodes[0].GetNum())
+	require.Equal(t, r.cfg.LongName, nodes[0].GetUser().GetLongName())
+}
+
+// dropFirstMessageConn drops the first stream protocol message written to it, emulating a radio which misses it.
+type dropFirstMessageConn struct {
+	net.Conn
+	dropping, dropped bool
+}
+
+func (c *dropFirstMessageConn) Write(p []byte) (int, error) {
+	// StreamConn writes each message as a header followed by its body.
+	if !c.dropped {
+		if c.dropping {
+			c.dropped = true
+			return len(p), nil
+		}
+		if len(p) == 4 && p[0] == transport.Start1 {
+			c.dropping = true
+			return len(p), nil
+		}
+	}
+	return c.Conn.Write(p)
+}
+
+func TestRadio_WantConfigRetry(t *testing.T) {
+	r := newTestRadio(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	sc, err := transport.NewClientStreamConn(&dropFirstMessageConn{Conn: r.Conn(ctx)})
+	require.NoError(t, err)
+	client := transport.NewClient(sc, false, transport.WithWantConfigInterval(50*time.Millisecond))
+	// The first request for config is dropped, so Connect only succeeds if it is resent.
+	require.NoError(t, client.Connect(ctx))
+	require.Equal(t, r.cfg.NodeID.Uint32(), client.State.NodeInfo().GetMyNodeNum())
+}
+
+func TestConfig_SetPositionDegrees(t *testing.T) {
+	cfg := Config{}
+	cfg.SetPositionDegrees(51.501476, -0.140634)
+	require.Equal(t, int32(515014760), cfg.PositionLatitudeI)
+	require.Equal(t, int32(-1406340), cfg.PositionLongitudeI)
+}
+
+func TestConfig_SeedNodesRequireNum(t *testing.T) {
+	_, err := NewRadio(Config{
+		Bus:       NewBus("msh"),
+		NodeID:    meshtool.NodeID(0x1234),
+		Channels:  &meshtastic.ChannelSet{Settings: []*meshtastic.ChannelSettings{{Name: "LongFast"}}},
+		SeedNodes: []*meshtastic.NodeInfo{{}},
+	})
+	require.Error(t, err)
+}
+
+func TestConfig_ChannelURL(t *testing.T) {
+	channelURL, err := meshtool.ChannelSetToURL(&meshtastic.ChannelSet{
+		Settings: []*meshtastic.ChannelSettings{
+			{Name: "LongFast", Psk: []byte{0x01}},
+			{Name: "Private", Psk: bytes.Repeat([]byte{0x42}, 16)},
+		},
+	})
+	require.NoError(t, err)
+	r, err := NewRadio(Config{Bus: NewBus("msh"), NodeID: meshtool.NodeID(0x1234), ChannelURL: channelURL})
+	require.NoError(t, err)
+	ch, ok := r.getChannels().ByName("Private")
+	require.True(t, ok)
+	require.Equal(t, bytes.Repeat([]byte{0x42}, 16), ch.PSK)
+	require.Equal(t, radio.DefaultKey, r.getChannels().PrimaryPSK())
+
+	_, err = NewRadio(Config{
+		Bus:        NewBus("msh"),
+		NodeID:     meshtool.NodeID(0x1234),
+		ChannelURL: channelURL,
+		Channels:   &meshtastic.ChannelSet{Settings: []*meshtastic.ChannelSettings{{Name: "LongFast"}}},
+	})
+	require.Error(t, err)
+}
+
+func TestRadio_RecordsSignal(t *testing.T) {
+	r := newTestRadio(t)
+	payload := encryptedEnvelope(t, &meshtastic.MeshPacket{
+		Id:       42,
+		From:     0xdeadbeef,
+		To:       meshtool.BroadcastNodeID.Uint32(),
+		RxSnr:    5.5,
+		RxRssi:   -90,
+		HopStart: 3,
+		HopLimit: 1,
+		PayloadVariant: &meshtastic.MeshPacket_Decoded{Decoded: &meshtastic.Data{
+			Portnum: meshtastic.PortNum_TEXT_MESSAGE_APP,
+			Payload: []byte("hello"),
+		}},
+	}, "LongFast", radio.DefaultKey)
+	require.NoError(t, r.tryHandleMQTTMessage(context.Background(), mqtt.Message{Payload: payload}))
+
+	node, ok := r.getNode(0xdeadbeef)
+	require.True(t, ok)
+	require.Equal(t, float32(5.5), node.Snr)
+	require.Equal(t, uint32(2), node.GetHopsAway())
+	require.True(t, node.ViaMqtt)
+	require.NotZero(t, node.LastHeard)
+}
+
+func TestRadio_sendPacket_HopLimit(t *testing.T) {
+	tests := []struct {
+		name         string
+		hopLimit     uint32
+		wantHopLimit uint32
+	}{
+		{
+			name:         "default",
+			wantHopLimit: DefaultHopLimit,
+		},
+		{
+			name:         "set by client",
+			hopLimit:     5,
+			wantHopLimit: 5,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r := newTestRadio(t)
+			published := make(chan mqtt.Message, 1)
+			r.cfg.Bus.Handle("LongFast", func(m mqtt.Message) {
+				published <- m
+			})
+			require.NoError(t, r.sendPacket(context.Background(), &meshtastic.MeshPacket{
+				From:     r.cfg.NodeID.Uint32(),
+				To:       meshtool.BroadcastNodeID.Uint32(),
+				HopLimit: tc.hopLimit,
+				PayloadVariant: &meshtastic.MeshPacket_Decoded{Decoded: &meshtastic.Data{
+					Portnum: meshtastic.PortNum_TEXT_MESSAGE_APP,
+					Payload: []byte("hello"),
+				}},
+			}))
+
+			se := &meshtastic.ServiceEnvelope{}
+			select {
+			case m := <-published:
+				require.NoError(t, proto.Unmarshal(m.Payload, se))
+			case <-time.After(time.Second):
+				t.Fatal("packet not published")
+			}
+			require.Equal(t, tc.wantHopLimit, se.Packet.HopLimit)
+			require.Equal(t, tc.wantHopLimit, se.Packet.HopStart)
+		})
+	}
+}
@@ -0,0 +1,172 @@
+package emulated
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/rabarar/meshtastic"
+	"github.com/rabarar/meshtool-go/public/meshtool"
+	"github.com/rabarar/meshtool-go/public/mqtt"
+	"golang.org/x/sync/errgroup"
+)
+
+// NodeGroup runs several emulated radios over a single shared MQTT client, simulating a small mesh. The shared client
+// is connected once and subscribed to each channel once, with incoming messages fanned out to every radio in the group
+// which has subscribed to the channel. Each radio keeps its own nodeDB and packet ID counter.
+//
+// As several radios share the connection, the shared client is left to reconnect by itself rather than each radio
+// driving reconnection, and is disconnected by Run once every radio has stopped rather than by each radio.
+type NodeGroup struct {
+	mqtt MQTTClient
+
+	connectMu sync.Mutex
+	connected bool
+
+	mu         sync.RWMutex
+	radios     []*Radio
+	handlers   map[string]map[meshtool.NodeID]mqtt.HandlerFunc
+	subscribed map[string]struct{}
+}
+
+// NewNodeGroup creates a new NodeGroup sharing the given MQTT client, which may be an *mqtt.Client or a *Bus.
+func NewNodeGroup(client MQTTClient) *NodeGroup {
+	return &NodeGroup{
+		mqtt:       client,
+		handlers:   map[string]map[meshtool.NodeID]mqtt.HandlerFunc{},
+		subscribed: map[string]struct{}{},
+	}
+}
+
+// AddRadio creates a new emulated radio in the group. cfg.MQTTClient and cfg.Bus must not be set, as the radio uses
+// the group's shared client. Each radio must have a unique NodeID.
+func (g *NodeGroup) AddRadio(cfg Config) (*Radio, error) {
+	if cfg.MQTTClient != nil || cfg.Bus != nil {
+		return nil, fmt.Errorf("validating config: MQTTClient and Bus should not be provided to a NodeGroup radio")
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, r := range g.radios {
+		if r.cfg.NodeID == cfg.NodeID {
+			return nil, fmt.Errorf("validating config: NodeID %s is already in the group", cfg.NodeID)
+		}
+	}
+	r, err := newRadio(cfg, &groupMember{group: g, nodeID: cfg.NodeID})
+	if err != nil {
+		return nil, err
+	}
+	g.radios = append(g.radios, r)
+	return r, nil
+}
+
+// Radios returns the radios in the group, in the order they were added.
+func (g *NodeGroup) Radios() []*Radio {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return append([]*Radio(nil), g.radios...)
+}
+
+// Run runs every radio in the group. It blocks until the context is cancelled or a radio returns an error, in which
+// case the remaining radios are stopped. Once they have all stopped, the shared client is disconnected if it supports
+// it, as an *mqtt.Client does.
+func (g *NodeGroup) Run(ctx context.Context) error {
+	defer g.disconnect()
+	eg, egCtx := errgroup.WithContext(ctx)
+	for _, r := range g.Radios() {
+		eg.Go(func() error {
+			if err := r.Run(egCtx); err != nil {
+				return fmt.Errorf("running radio %s: %w", r.cfg.NodeID, err)
+			}
+			return nil
+		})
+	}
+	return eg.Wait()
+}
+
+// connect connects the shared client unless it is already connected.
+func (g *NodeGroup) connect() error {
+	g.connectMu.Lock()
+	defer g.connectMu.Unlock()
+	if g.connected {
+		return nil
+	}
+	if err := g.mqtt.Connect(); err != nil {
+		return err
+	}
+	g.connected = true
+	return nil
+}
+
+// disconnect disconnects the shared client if it was connected by a radio in the group.
+func (g *NodeGroup) disconnect() {
+	g.connectMu.Lock()
+	defer g.connectMu.Unlock()
+	if !g.connected {
+		return
+	}
+	if client, ok := g.mqtt.(disconnectableMQTTClient); ok {
+		client.Disconnect(mqttDisconnectQuiesce)
+	}
+	g.connected = false
+}
+
+// handle registers the handler of a radio in the group for a channel, subscribing the shared client to the channel the
+// first time any radio in the group handles it.
+func (g *NodeGroup) handle(channel string, nodeID meshtool.NodeID, h mqtt.HandlerFunc) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.handlers[channel] == nil {
+		g.handlers[channel] = map[meshtool.NodeID]mqtt.HandlerFunc{}
+	}
+	g.handlers[channel][nodeID] = h
+	if _, ok := g.subscribed[channel]; ok {
+		return
+	}
+	g.subscribed[channel] = struct{}{}
+	g.mqtt.Handle(channel, func(msg mqtt.Message) {
+		g.dispatch(channel, msg)
+	})
+}
+
+// dispatch delivers a message received on a channel to each radio in the group handling the channel.
+func (g *NodeGroup) dispatch(channel string, msg mqtt.Message) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	for _, h := range g.handlers[channel] {
+		go h(msg)
+	}
+}
+
+// groupMember is the MQTTClient used by a radio in a NodeGroup.
+type groupMember struct {
+	group  *NodeGroup
+	nodeID meshtool.NodeID
+}
+
+var (
+	_ MQTTClient               = (*groupMember)(nil)
+	_ disconnectableMQTTClient = (*groupMember)(nil)
+)
+
+func (m *groupMember) Connect() error {
+	return m.group.connect()
+}
+
+func (m *groupMember) Handle(channel string, h mqtt.HandlerFunc) {
+	m.group.handle(channel, m.nodeID, h)
+}
+
+// Disconnect does nothing, as the shared client is disconnected by NodeGroup.Run once every radio has stopped.
+func (m *groupMember) Disconnect(quiesce uint) {}
+
+func (m *groupMember) Publish(msg *mqtt.Message) error {
+	return m.group.mqtt.Publish(msg)
+}
+
+func (m *groupMember) PublishEnvelope(channel, gatewayID string, se *meshtastic.ServiceEnvelope) error {
+	return m.group.mqtt.PublishEnvelope(channel, gatewayID, se)
+}
+
+func (m *groupMember) GetFullTopicForChannel(channel string) string {
+	return m.group.mqtt.GetFullTopicForChannel(channel)
+}
@@ -0,0 +1,127 @@
+package emulated
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rabarar/meshtastic"
+	"github.com/rabarar/meshtool-go/public/meshtool"
+	"github.com/rabarar/meshtool-go/public/mqtt"
+	"github.com/rabarar/meshtool-go/public/radio"
+	"github.com/stretchr/testify/require"
+)
+
+// countingClient is an MQTTClient which counts connections, subscriptions and disconnections made through it.
+type countingClient struct {
+	*Bus
+	connects, handles, disconnects atomic.Int32
+}
+
+func (c *countingClient) Connect() error {
+	c.connects.Add(1)
+	return c.Bus.Connect()
+}
+
+func (c *countingClient) Handle(channel string, h mqtt.HandlerFunc) {
+	c.handles.Add(1)
+	c.Bus.Handle(channel, h)
+}
+
+func (c *countingClient) Disconnect(quiesce uint) {
+	c.disconnects.Add(1)
+}
+
+func TestNodeGroup(t *testing.T) {
+	client := &countingClient{Bus: NewBus("msh")}
+	group := NewNodeGroup(client)
+	newConfig := func(nodeID meshtool.NodeID) Config {
+		return Config{
+			NodeID: nodeID,
+			Channels: &meshtastic.ChannelSet{
+				Settings: []*meshtastic.ChannelSettings{{Name: "LongFast", Psk: radio.DefaultKey}},
+			},
+		}
+	}
+	a, err := group.AddRadio(newConfig(0xaaaa))
+	require.NoError(t, err)
+	b, err := group.AddRadio(newConfig(0xbbbb))
+	require.NoError(t, err)
+	_, err = group.AddRadio(newConfig(0xaaaa))
+	require.Error(t, err)
+	withBus := newConfig(0xcccc)
+	withBus.Bus = NewBus("msh")
+	_, err = group.AddRadio(withBus)
+	require.Error(t, err)
+	require.Equal(t, []*Radio{a, b}, group.Radios())
+
+	ctx := context.Background()
+	runCtx, stop := context.WithCancel(ctx)
+	done := make(chan error, 1)
+	go func() {
+		done <- group.Run(runCtx)
+	}()
+	t.Cleanup(func() {
+		stop()
+		require.NoError(t, <-done)
+	})
+	waitSubscribed(t, a)
+	waitSubscribed(t, b)
+	// The shared client is connected and subscribed once, however many radios are in the group.
+	require.Equal(t, int32(1), client.connects.Load())
+	require.Equal(t, int32(1), client.handles.Load())
+
+	require.NoError(t, a.broadcastNodeInfo(ctx))
+	require.NoError(t, b.broadcastNodeInfo(ctx))
+	waitCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	node, ok := b.WaitForNode(waitCtx, 0xaaaa)
+	require.True(t, ok)
+	require.Equal(t, a.cfg.LongName, node.GetUser().GetLongName())
+	node, ok = a.WaitForNode(waitCtx, 0xbbbb)
+	require.True(t, ok)
+	require.Equal(t, b.cfg.LongName, node.GetUser().GetLongName())
+
+	// Each radio has sent one packet from its own packet ID counter.
+	require.Equal(t, uint32(2), a.nextPacketID())
+	require.Equal(t, uint32(2), b.nextPacketID())
+}
+
+func TestNodeGroup_Disconnect(t *testing.T) {
+	client := &countingClient{Bus: NewBus("msh")}
+	group := NewNodeGroup(client)
+	var radios []*Radio
+	for _, nodeID := range []meshtool.NodeID{0xaaaa, 0xbbbb} {
+		r, err := group.AddRadio(Config{
+			NodeID: nodeID,
+			Channels: &meshtastic.ChannelSet{
+				Settings: []*meshtastic.ChannelSettings{{Name: "LongFast", Psk: radio.DefaultKey}},
+			},
+		})
+		require.NoError(t, err)
+		radios = append(radios, r)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- group.Run(ctx)
+	}()
+	for _, r := range radios {
+		waitSubscribed(t, r)
+	}
+	// Radios leave the shared client connected while the group is running.
+	require.Equal(t, int32(0), client.disconnects.Load())
+
+	cancel()
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return")
+	}
+	// The shared client is disconnected once, after every radio has stopped.
+	require.Equal(t, int32(1), client.disconnects.Load())
+}
@@ -0,0 +1,122 @@
+package emulated
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/rabarar/meshtastic"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// DefaultStateSaveInterval is the default interval at which the radio's state is saved to Config.StatePath.
+const DefaultStateSaveInterval = time.Minute
+
+// persistedState is the state of the radio saved to Config.StatePath, so that it survives restarts.
+type persistedState struct {
+	// PacketID is the ID of the last packet sent by the radio.
+	PacketID uint32 `json:"packet_id"`
+	// Nodes holds the nodeDB, with each NodeInfo encoded with protojson.
+	Nodes []json.RawMessage `json:"nodes"`
+}
+
+// loadState restores the nodeDB and packet ID from Config.StatePath. A missing file is not an error, as it is created
+// the first time the state is saved. Nodes loaded from the file replace any SeedNodes with the same Num, except for
+// the radio's own entry which is always derived from the config.
+func (r *Radio) loadState() error {
+	b, err := os.ReadFile(r.cfg.StatePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("reading state: %w", err)
+	}
+	state := persistedState{}
+	if err := json.Unmarshal(b, &state); err != nil {
+		return fmt.Errorf("unmarshalling state: %w", err)
+	}
+	nodes := make([]*meshtastic.NodeInfo, 0, len(state.Nodes))
+	for i, raw := range state.Nodes {
+		node := &meshtastic.NodeInfo{}
+		if err := protojson.Unmarshal(raw, node); err != nil {
+			return fmt.Errorf("unmarshalling node %d: %w", i, err)
+		}
+		nodes = append(nodes, node)
+	}
+
+	for _, node := range nodes {
+		if node.Num == r.cfg.NodeID.Uint32() {
+			continue
+		}
+		r.nodeDB.Put(node)
+	}
+	r.mu.Lock()
+	r.packetID = state.PacketID
+	r.mu.Unlock()
+	r.logger.Info("loaded state", "path", r.cfg.StatePath, "nodes", len(nodes))
+	return nil
+}
+
+// saveState writes the nodeDB and packet ID to Config.StatePath. The file is replaced atomically so that a crash
+// while saving does not lose the previous state.
+func (r *Radio) saveState() error {
+	nodes := r.nodeDB.List()
+	r.mu.Lock()
+	state := persistedState{
+		PacketID: r.packetID,
+		Nodes:    make([]json.RawMessage, 0, len(nodes)),
+	}
+	r.mu.Unlock()
+	for _, node := range nodes {
+		raw, err := protojson.Marshal(node)
+		if err != nil {
+			return fmt.Errorf("marshalling node %d: %w", node.Num, err)
+		}
+		state.Nodes = append(state.Nodes, raw)
+	}
+
+	b, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("marshalling state: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(r.cfg.StatePath), filepath.Base(r.cfg.StatePath)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("creating temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return fmt.Errorf("writing state: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("closing temporary file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), r.cfg.StatePath); err != nil {
+		return fmt.Errorf("replacing state: %w", err)
+	}
+	return nil
+}
+
+// persistState saves the radio's state every Config.StateSaveInterval, and once more when ctx is cancelled.
+func (r *Radio) persistState(ctx context.Context) error {
+	ticker := time.NewTicker(r.cfg.StateSaveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			if err := r.saveState(); err != nil {
+				return fmt.Errorf("saving state on shutdown: %w", err)
+			}
+			return nil
+		case <-ticker.C:
+			if err := r.saveState(); err != nil {
+				r.logger.Error("failed to save state", "err", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package emulated
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rabarar/meshtastic"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRadio_StatePath(t *testing.T) {
+	statePath := filepath.Join(t.TempDir(), "state.json")
+	withStatePath := func(cfg *Config) {
+		cfg.StatePath = statePath
+	}
+
+	// The state is saved when Run returns.
+	r := newTestRadio(t, withStatePath)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- r.Run(ctx)
+	}()
+	r.updateNodeDB(0xdeadbeef, &meshtastic.User{LongName: "Remote"})
+	lastID := r.nextPacketID()
+	cancel()
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return")
+	}
+
+	// A new radio resumes from the saved state when Run is called.
+	restarted := newTestRadio(t, withStatePath)
+	require.NoError(t, restarted.loadState())
+	node, ok := restarted.getNode(0xdeadbeef)
+	require.True(t, ok)
+	require.Equal(t, "Remote", node.GetUser().GetLongName())
+	require.Equal(t, lastID+1, restarted.nextPacketID())
+}
+
+func TestRadio_loadState_Missing(t *testing.T) {
+	r := newTestRadio(t, func(cfg *Config) {
+		cfg.StatePath = filepath.Join(t.TempDir(), "missing.json")
+	})
+	require.NoError(t, r.loadState())
+	// Only our own entry is present.
+	nodes := r.Nodes()
+	require.Len(t, nodes, 1)
+	require.Equal(t, r.cfg.NodeID.Uint32(), nodes[0].Num)
+}
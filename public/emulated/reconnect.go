@@ -0,0 +1,123 @@
+package emulated
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/rabarar/meshtool-go/public/mqtt"
+)
+
+const (
+	// DefaultMQTTReconnectInitialBackoff is the delay before the first retry when connecting to MQTT fails.
+	DefaultMQTTReconnectInitialBackoff = time.Second
+	// DefaultMQTTReconnectMaxBackoff is the longest delay between attempts to connect to MQTT.
+	DefaultMQTTReconnectMaxBackoff = time.Minute
+	// mqttDisconnectQuiesce is the time in milliseconds allowed for in-flight MQTT work to complete when the radio
+	// disconnects on shutdown.
+	mqttDisconnectQuiesce = 250
+)
+
+// disconnectableMQTTClient is implemented by MQTT clients which hold a connection to a broker that should be closed
+// when the radio stops, such as *mqtt.Client.
+type disconnectableMQTTClient interface {
+	Disconnect(quiesce uint)
+}
+
+var _ disconnectableMQTTClient = (*mqtt.Client)(nil)
+
+// reconnectableMQTTClient is implemented by MQTT clients which report losing their connection and allow the radio to
+// drive reconnection, such as *mqtt.Client.
+type reconnectableMQTTClient interface {
+	ConnectionLost() <-chan error
+	SetAutoReconnect(enabled bool)
+}
+
+// MQTTConnectionState describes the state of the radio's connection to MQTT.
+type MQTTConnectionState struct {
+	Connected bool
+	// Retries is the number of failed attempts to connect since the connection was last established.
+	Retries int
+	// LastError is the error from the most recent failed connection attempt or connection loss.
+	LastError error
+}
+
+// MQTTConnectionState returns the current state of the radio's connection to MQTT.
+func (r *Radio) MQTTConnectionState() MQTTConnectionState {
+	r.connMu.Lock()
+	defer r.connMu.Unlock()
+	return r.connState
+}
+
+func (r *Radio) setMQTTConnectionState(update func(*MQTTConnectionState)) {
+	r.connMu.Lock()
+	defer r.connMu.Unlock()
+	update(&r.connState)
+}
+
+// connectMQTT connects to MQTT, retrying with exponential backoff and jitter until it succeeds, ctx is cancelled or
+// Config.MQTTReconnectMaxAttempts is reached.
+func (r *Radio) connectMQTT(ctx context.Context) error {
+	backoff := r.cfg.MQTTReconnectInitialBackoff
+	for attempt := 1; ; attempt++ {
+		err := r.mqtt.Connect()
+		if err == nil {
+			r.setMQTTConnectionState(func(s *MQTTConnectionState) {
+				s.Connected = true
+				s.Retries = 0
+			})
+			return nil
+		}
+		r.setMQTTConnectionState(func(s *MQTTConnectionState) {
+			s.Connected = false
+			s.Retries = attempt
+			s.LastError = err
+		})
+		if r.cfg.MQTTReconnectMaxAttempts > 0 && attempt >= r.cfg.MQTTReconnectMaxAttempts {
+			return fmt.Errorf("connecting to mqtt after %d attempts: %w", attempt, err)
+		}
+
+		delay := r.jitter(backoff)
+		r.logger.Warn("failed to connect to mqtt, retrying", "err", err, "attempt", attempt, "delay", delay)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		backoff = min(backoff*2, r.cfg.MQTTReconnectMaxBackoff)
+	}
+}
+
+// jitter returns a random duration between half of d and d, so that many radios don't retry in lockstep.
+func (r *Radio) jitter(d time.Duration) time.Duration {
+	if d <= 1 {
+		return d
+	}
+	r.randMu.Lock()
+	defer r.randMu.Unlock()
+	return d/2 + time.Duration(r.cfg.Rand.Int63n(int64(d/2)))
+}
+
+// maintainMQTTConnection reconnects to MQTT each time the connection is lost, until ctx is cancelled or reconnecting
+// fails.
+func (r *Radio) maintainMQTTConnection(ctx context.Context, client reconnectableMQTTClient) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-client.ConnectionLost():
+			r.logger.Warn("lost connection to mqtt, reconnecting", "err", err)
+			r.setMQTTConnectionState(func(s *MQTTConnectionState) {
+				s.Connected = false
+				s.LastError = err
+			})
+		}
+		if err := r.connectMQTT(ctx); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		r.logger.Info("reconnected to mqtt")
+	}
+}
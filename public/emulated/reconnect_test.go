@@ -0,0 +1,157 @@
+package emulated
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// flakyMQTTClient wraps a Bus, failing a number of Connect calls and allowing a connection loss to be simulated. It
+// records how many times it has been disconnected.
+type flakyMQTTClient struct {
+	*Bus
+	lost chan error
+
+	mu            sync.Mutex
+	failures      int
+	connects      int
+	disconnects   int
+	autoReconnect bool
+}
+
+func (f *flakyMQTTClient) Connect() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.connects++
+	if f.failures > 0 {
+		f.failures--
+		return errors.New("broker unavailable")
+	}
+	return nil
+}
+
+func (f *flakyMQTTClient) ConnectionLost() <-chan error {
+	return f.lost
+}
+
+func (f *flakyMQTTClient) SetAutoReconnect(enabled bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.autoReconnect = enabled
+}
+
+func (f *flakyMQTTClient) Disconnect(uint) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.disconnects++
+}
+
+func (f *flakyMQTTClient) fail(n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failures = n
+}
+
+func newFlakyTestRadio(t *testing.T, opts ...func(*Config)) (*Radio, *flakyMQTTClient) {
+	t.Helper()
+	r := newTestRadio(t, append([]func(*Config){func(cfg *Config) {
+		cfg.MQTTReconnectInitialBackoff = time.Millisecond
+		cfg.MQTTReconnectMaxBackoff = 4 * time.Millisecond
+	}}, opts...)...)
+	client := &flakyMQTTClient{Bus: r.cfg.Bus, lost: make(chan error, 1), autoReconnect: true}
+	r.mqtt = client
+	return r, client
+}
+
+func TestRadio_connectMQTT_Retries(t *testing.T) {
+	r, client := newFlakyTestRadio(t)
+	client.fail(3)
+
+	require.NoError(t, r.connectMQTT(context.Background()))
+	require.Equal(t, 4, client.connects)
+	state := r.MQTTConnectionState()
+	require.True(t, state.Connected)
+	require.Zero(t, state.Retries)
+}
+
+func TestRadio_connectMQTT_MaxAttempts(t *testing.T) {
+	r, client := newFlakyTestRadio(t, func(cfg *Config) {
+		cfg.MQTTReconnectMaxAttempts = 2
+	})
+	client.fail(5)
+
+	require.Error(t, r.connectMQTT(context.Background()))
+	state := r.MQTTConnectionState()
+	require.False(t, state.Connected)
+	require.Equal(t, 2, state.Retries)
+	require.Error(t, state.LastError)
+}
+
+func TestRadio_Run_Reconnects(t *testing.T) {
+	r, client := newFlakyTestRadio(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- r.Run(ctx)
+	}()
+
+	require.Eventually(t, func() bool {
+		return r.MQTTConnectionState().Connected
+	}, time.Second, time.Millisecond)
+	client.mu.Lock()
+	require.False(t, client.autoReconnect)
+	client.mu.Unlock()
+
+	client.fail(2)
+	client.lost <- errors.New("connection reset")
+	require.Eventually(t, func() bool {
+		client.mu.Lock()
+		defer client.mu.Unlock()
+		return client.connects == 4
+	}, time.Second, time.Millisecond)
+	require.Eventually(t, func() bool {
+		return r.MQTTConnectionState().Connected
+	}, time.Second, time.Millisecond)
+
+	client.mu.Lock()
+	require.Zero(t, client.disconnects)
+	client.mu.Unlock()
+
+	// The broker connection is closed once the radio stops.
+	cancel()
+	require.NoError(t, <-done)
+	client.mu.Lock()
+	require.Equal(t, 1, client.disconnects)
+	client.mu.Unlock()
+}
+
+func TestRadio_Run_ListeningDisconnects(t *testing.T) {
+	r, client := newFlakyTestRadio(t, func(cfg *Config) {
+		cfg.TCPListenAddr = "127.0.0.1:0"
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- r.Run(ctx)
+	}()
+	require.Eventually(t, func() bool {
+		return r.MQTTConnectionState().Connected
+	}, time.Second, time.Millisecond)
+
+	// The TCP listener is closed on cancellation, so Run returns and disconnects from MQTT.
+	cancel()
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("Run did not return after being cancelled")
+	}
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	require.Equal(t, 1, client.disconnects)
+}
@@ -0,0 +1,64 @@
+package emulated
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Stats contains counters describing the activity of a Radio since it started running.
+type Stats struct {
+	// PacketsReceived is the number of packets received from MQTT, including those sent by the radio itself.
+	PacketsReceived uint64 `json:"packetsReceived"`
+	// PacketsSent is the number of packets published to MQTT.
+	PacketsSent uint64 `json:"packetsSent"`
+	// PacketsDropped is the number of packets dropped by SimulatedLossRate.
+	PacketsDropped uint64 `json:"packetsDropped"`
+	// PacketsDuplicate is the number of packets received from MQTT which were dropped as duplicates, see
+	// Config.DuplicateWindow. They are not counted in PacketsReceived.
+	PacketsDuplicate uint64 `json:"packetsDuplicate"`
+	// BytesSent and BytesReceived are the number of bytes in the packets counted by PacketsSent and PacketsReceived,
+	// as they would be sent over the air. Packets from the radio itself are not included in BytesReceived.
+	BytesSent     uint64 `json:"bytesSent"`
+	BytesReceived uint64 `json:"bytesReceived"`
+	// ChannelUtilization is the percentage of the last minute in which packets were being sent or received, and
+	// AirUtilTx is the percentage of the last hour spent sending packets. As there is no real radio, these are
+	// calculated from the airtime packets would take using the modulation in the LoRa config.
+	ChannelUtilization float32 `json:"channelUtilization"`
+	AirUtilTx          float32 `json:"airUtilTx"`
+	// Nodes is the number of nodes in the nodeDB.
+	Nodes int `json:"nodes"`
+	// StartedAt is when Run was called, or the zero time if the radio is not running.
+	StartedAt time.Time `json:"startedAt"`
+}
+
+type radioStats struct {
+	packetsReceived  atomic.Uint64
+	packetsSent      atomic.Uint64
+	packetsDropped   atomic.Uint64
+	packetsDuplicate atomic.Uint64
+	bytesSent        atomic.Uint64
+	bytesReceived    atomic.Uint64
+	startedAt        atomic.Int64
+}
+
+func (s *radioStats) recordStarted() {
+	s.startedAt.Store(time.Now().UnixNano())
+}
+
+// Stats returns a snapshot of the radio's counters.
+func (r *Radio) Stats() Stats {
+	stats := Stats{
+		PacketsReceived:  r.stats.packetsReceived.Load(),
+		PacketsSent:      r.stats.packetsSent.Load(),
+		PacketsDropped:   r.stats.packetsDropped.Load(),
+		PacketsDuplicate: r.stats.packetsDuplicate.Load(),
+		BytesSent:        r.stats.bytesSent.Load(),
+		BytesReceived:    r.stats.bytesReceived.Load(),
+	}
+	stats.ChannelUtilization, stats.AirUtilTx = r.airtime.utilization()
+	if startedAt := r.stats.startedAt.Load(); startedAt != 0 {
+		stats.StartedAt = time.Unix(0, startedAt)
+	}
+	stats.Nodes = r.nodeDB.Len()
+	return stats
+}
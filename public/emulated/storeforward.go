@@ -0,0 +1,143 @@
+package emulated
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rabarar/meshtastic"
+	"github.com/rabarar/meshtool-go/public/meshtool"
+	"github.com/rabarar/meshtool-go/public/radio"
+	"google.golang.org/protobuf/proto"
+)
+
+// storedMessage is a text message held by the Store & Forward server for replaying to clients.
+type storedMessage struct {
+	from    uint32
+	to      uint32
+	text    []byte
+	heardAt time.Time
+}
+
+// storeForwardHistory holds the most recent text messages heard on the primary channel, oldest first.
+type storeForwardHistory struct {
+	size int
+	now  func() time.Time
+
+	mu       sync.Mutex
+	messages []storedMessage
+}
+
+func newStoreForwardHistory(size int) *storeForwardHistory {
+	return &storeForwardHistory{size: size, now: time.Now}
+}
+
+// add records a text message, forgetting the oldest message once the history is full.
+func (h *storeForwardHistory) add(from, to uint32, text []byte) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.messages = append(h.messages, storedMessage{from: from, to: to, text: text, heardAt: h.now()})
+	if len(h.messages) > h.size {
+		h.messages = h.messages[len(h.messages)-h.size:]
+	}
+}
+
+// replay returns the messages which should be replayed to the requesting node: broadcasts, and direct messages sent
+// to it. A non-zero window limits these to the messages heard within it.
+func (h *storeForwardHistory) replay(requester uint32, window time.Duration) []storedMessage {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	now := h.now()
+	var messages []storedMessage
+	for _, msg := range h.messages {
+		if window != 0 && now.Sub(msg.heardAt) > window {
+			continue
+		}
+		if msg.to != meshtool.BroadcastNodeID.Uint32() && msg.to != requester {
+			continue
+		}
+		messages = append(messages, msg)
+	}
+	return messages
+}
+
+// handleStoreForward responds to Store & Forward requests addressed to the radio or broadcast, as the firmware's
+// StoreForwardModule does when running as a server. A history request is answered with a ROUTER_HISTORY message
+// giving the number of messages to expect, followed by each message in the order it was heard. Clients which don't
+// know the address of a server broadcast their request, as transport.Client.RequestStoreForwardHistory does.
+func (r *Radio) handleStoreForward(ctx context.Context, packet *meshtastic.MeshPacket, ch radio.Channel, data *meshtastic.Data) error {
+	if r.storeForward == nil {
+		return nil
+	}
+	if packet.To != r.cfg.NodeID.Uint32() && packet.To != meshtool.BroadcastNodeID.Uint32() {
+		return nil
+	}
+	request := &meshtastic.StoreAndForward{}
+	if err := proto.Unmarshal(data.Payload, request); err != nil {
+		return fmt.Errorf("unmarshalling storeAndForward: %w", err)
+	}
+	if request.Rr != meshtastic.StoreAndForward_CLIENT_HISTORY {
+		r.logger.Debug("received unhandled Store & Forward request", "request", request)
+		return nil
+	}
+	// The window is given in minutes.
+	window := request.GetHistory().GetWindow()
+	messages := r.storeForward.replay(packet.From, time.Duration(window)*time.Minute)
+	r.logger.Info("replaying Store & Forward history",
+		"to", meshtool.NodeID(packet.From).String(), "messages", len(messages))
+
+	err := r.sendStoreForward(ctx, packet.From, ch.Index, &meshtastic.StoreAndForward{
+		Rr: meshtastic.StoreAndForward_ROUTER_HISTORY,
+		Variant: &meshtastic.StoreAndForward_History_{
+			History: &meshtastic.StoreAndForward_History{
+				HistoryMessages: uint32(len(messages)),
+				Window:          window,
+			},
+		},
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("sending history: %w", err)
+	}
+	for _, msg := range messages {
+		rr := meshtastic.StoreAndForward_ROUTER_TEXT_DIRECT
+		if msg.to == meshtool.BroadcastNodeID.Uint32() {
+			rr = meshtastic.StoreAndForward_ROUTER_TEXT_BROADCAST
+		}
+		err := r.sendStoreForward(ctx, packet.From, ch.Index, &meshtastic.StoreAndForward{
+			Rr:      rr,
+			Variant: &meshtastic.StoreAndForward_Text{Text: msg.text},
+		}, &msg)
+		if err != nil {
+			return fmt.Errorf("replaying message: %w", err)
+		}
+	}
+	return nil
+}
+
+// sendStoreForward sends a StoreAndForward message to a node on the channel with the given index. If it replays
+// a stored message, the original sender and recipient are recorded in the Data's Source and Dest, and the time it was
+// heard in the packet's RxTime.
+func (r *Radio) sendStoreForward(ctx context.Context, to uint32, channel int, sf *meshtastic.StoreAndForward, msg *storedMessage) error {
+	payload, err := proto.Marshal(sf)
+	if err != nil {
+		return fmt.Errorf("marshalling storeAndForward: %w", err)
+	}
+	packet := &meshtastic.MeshPacket{
+		From:    r.cfg.NodeID.Uint32(),
+		To:      to,
+		Channel: uint32(channel),
+		PayloadVariant: &meshtastic.MeshPacket_Decoded{
+			Decoded: &meshtastic.Data{
+				Portnum: meshtastic.PortNum_STORE_FORWARD_APP,
+				Payload: payload,
+			},
+		},
+	}
+	if msg != nil {
+		packet.RxTime = uint32(msg.heardAt.Unix())
+		packet.GetDecoded().Source = msg.from
+		packet.GetDecoded().Dest = msg.to
+	}
+	return r.sendPacket(ctx, packet)
+}
@@ -0,0 +1,182 @@
+package emulated
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/rabarar/meshtastic"
+	"github.com/rabarar/meshtool-go/public/meshtool"
+	"github.com/rabarar/meshtool-go/public/mqtt"
+	"github.com/rabarar/meshtool-go/public/radio"
+	"github.com/rabarar/meshtool-go/public/transport"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestStoreForwardHistory(t *testing.T) {
+	now := time.Unix(1000, 0)
+	h := newStoreForwardHistory(3)
+	h.now = func() time.Time {
+		return now
+	}
+	broadcast := meshtool.BroadcastNodeID.Uint32()
+
+	h.add(1, broadcast, []byte("first"))
+	now = now.Add(time.Hour)
+	h.add(1, broadcast, []byte("second"))
+	h.add(2, 0xaaaa, []byte("direct to aaaa"))
+	h.add(2, 0xbbbb, []byte("direct to bbbb"))
+
+	texts := func(messages []storedMessage) []string {
+		var texts []string
+		for _, msg := range messages {
+			texts = append(texts, string(msg.text))
+		}
+		return texts
+	}
+	// The first message has been forgotten, and direct messages are only replayed to their recipient.
+	require.Equal(t, []string{"second", "direct to aaaa"}, texts(h.replay(0xaaaa, 0)))
+	require.Equal(t, []string{"second", "direct to bbbb"}, texts(h.replay(0xbbbb, 0)))
+
+	now = now.Add(time.Hour)
+	h.add(1, broadcast, []byte("third"))
+	require.Equal(t, []string{"third"}, texts(h.replay(0xaaaa, time.Minute)))
+}
+
+func TestRadio_StoreForward(t *testing.T) {
+	r := newTestRadio(t, func(cfg *Config) {
+		cfg.StoreForwardHistorySize = 10
+	})
+	published := make(chan *meshtastic.MeshPacket, 10)
+	r.cfg.Bus.Handle("LongFast", func(m mqtt.Message) {
+		se := &meshtastic.ServiceEnvelope{}
+		if err := proto.Unmarshal(m.Payload, se); err != nil || se.Packet.From != r.cfg.NodeID.Uint32() {
+			return
+		}
+		select {
+		case published <- se.Packet:
+		default:
+		}
+	})
+
+	for i, text := range []string{"hello", "world"} {
+		payload := encryptedEnvelope(t, &meshtastic.MeshPacket{
+			Id:   uint32(100 + i),
+			From: 0xdeadbeef,
+			To:   meshtool.BroadcastNodeID.Uint32(),
+			PayloadVariant: &meshtastic.MeshPacket_Decoded{Decoded: &meshtastic.Data{
+				Portnum: meshtastic.PortNum_TEXT_MESSAGE_APP,
+				Payload: []byte(text),
+			}},
+		}, "LongFast", radio.DefaultKey)
+		require.NoError(t, r.tryHandleMQTTMessage(context.Background(), mqtt.Message{Payload: payload}))
+	}
+
+	request, err := proto.Marshal(&meshtastic.StoreAndForward{Rr: meshtastic.StoreAndForward_CLIENT_HISTORY})
+	require.NoError(t, err)
+	payload := encryptedEnvelope(t, &meshtastic.MeshPacket{
+		Id:   200,
+		From: 0xcafe,
+		To:   r.cfg.NodeID.Uint32(),
+		PayloadVariant: &meshtastic.MeshPacket_Decoded{Decoded: &meshtastic.Data{
+			Portnum: meshtastic.PortNum_STORE_FORWARD_APP,
+			Payload: request,
+		}},
+	}, "LongFast", radio.DefaultKey)
+	require.NoError(t, r.tryHandleMQTTMessage(context.Background(), mqtt.Message{Payload: payload}))
+
+	// The Bus delivers messages asynchronously, so the replies are put back in the order they were sent by ID.
+	replyIDs := map[*meshtastic.StoreAndForward]uint32{}
+	var replies []*meshtastic.StoreAndForward
+	for len(replies) < 3 {
+		select {
+		case packet := <-published:
+			require.Equal(t, uint32(0xcafe), packet.To)
+			data, err := radio.TryDecode(packet, radio.DefaultKey)
+			require.NoError(t, err)
+			require.Equal(t, meshtastic.PortNum_STORE_FORWARD_APP, data.Portnum)
+			sf := &meshtastic.StoreAndForward{}
+			require.NoError(t, proto.Unmarshal(data.Payload, sf))
+			if sf.Rr != meshtastic.StoreAndForward_ROUTER_HISTORY {
+				require.Equal(t, uint32(0xdeadbeef), data.Source)
+			}
+			replyIDs[sf] = packet.Id
+			replies = append(replies, sf)
+		case <-time.After(time.Second):
+			t.Fatalf("received %d Store & Forward replies, want 3", len(replies))
+		}
+	}
+	slices.SortFunc(replies, func(a, b *meshtastic.StoreAndForward) int {
+		return cmp.Compare(replyIDs[a], replyIDs[b])
+	})
+	require.Equal(t, meshtastic.StoreAndForward_ROUTER_HISTORY, replies[0].Rr)
+	require.Equal(t, uint32(2), replies[0].GetHistory().GetHistoryMessages())
+	for i, text := range []string{"hello", "world"} {
+		require.Equal(t, meshtastic.StoreAndForward_ROUTER_TEXT_BROADCAST, replies[i+1].Rr)
+		require.Equal(t, text, string(replies[i+1].GetText()))
+	}
+}
+
+func TestRadio_StoreForward_Disabled(t *testing.T) {
+	r := newTestRadio(t)
+	require.Nil(t, r.storeForward)
+	cfg, err := r.getModuleConfig(meshtastic.AdminMessage_STOREFORWARD_CONFIG)
+	require.NoError(t, err)
+	require.False(t, cfg.GetStoreForward().GetEnabled())
+}
+
+func TestRadio_StoreForward_Client(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	bus := NewBus("msh")
+	server := newTestRadio(t, func(cfg *Config) {
+		cfg.Bus = bus
+		cfg.NodeID = 0xaaaa
+		cfg.StoreForwardHistorySize = 10
+	})
+	r := newTestRadio(t, func(cfg *Config) {
+		cfg.Bus = bus
+		cfg.NodeID = 0xbbbb
+	})
+	runTestRadio(t, server)
+	runTestRadio(t, r)
+
+	// The server hears messages which the radio the client is attached to does not.
+	for i, text := range []string{"hello", "world"} {
+		payload := encryptedEnvelope(t, &meshtastic.MeshPacket{
+			Id:   uint32(100 + i),
+			From: 0xdeadbeef,
+			To:   meshtool.BroadcastNodeID.Uint32(),
+			PayloadVariant: &meshtastic.MeshPacket_Decoded{Decoded: &meshtastic.Data{
+				Portnum: meshtastic.PortNum_TEXT_MESSAGE_APP,
+				Payload: []byte(text),
+			}},
+		}, "LongFast", radio.DefaultKey)
+		require.NoError(t, server.tryHandleMQTTMessage(ctx, mqtt.Message{Payload: payload}))
+	}
+
+	sc, err := transport.NewClientStreamConn(r.Conn(ctx))
+	require.NoError(t, err)
+	keys := radio.NewThing(nil)
+	client := transport.NewClient(sc, false, transport.WithKeyring(keys))
+	require.NoError(t, client.Connect(ctx))
+	defer client.Disconnect()
+
+	history, err := client.RequestStoreForwardHistory(ctx, time.Hour)
+	require.NoError(t, err)
+	var texts []string
+	for _, packet := range history {
+		_, data, err := keys.Decode(packet)
+		require.NoError(t, err)
+		sf := &meshtastic.StoreAndForward{}
+		require.NoError(t, proto.Unmarshal(data.Payload, sf))
+		require.Equal(t, uint32(0xdeadbeef), data.Source)
+		texts = append(texts, string(sf.GetText()))
+	}
+	// The Bus delivers messages asynchronously, so they may arrive out of order.
+	slices.Sort(texts)
+	require.Equal(t, []string{"hello", "world"}, texts)
+}
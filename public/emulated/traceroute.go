@@ -0,0 +1,71 @@
+package emulated
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	"github.com/rabarar/meshtastic"
+	"github.com/rabarar/meshtool-go/public/meshtool"
+	"github.com/rabarar/meshtool-go/public/radio"
+	"google.golang.org/protobuf/proto"
+)
+
+// unknownSNR is recorded in a RouteDiscovery for hops with no known SNR, matching the firmware.
+const unknownSNR = math.MinInt8
+
+// handleTraceroute replies to a traceroute request addressed to the radio, as the firmware's TraceRouteModule does.
+// The RouteDiscovery in the request holds the nodes it was relayed by, and the reply echoes this back with the SNR of
+// the final hop to the radio appended.
+func (r *Radio) handleTraceroute(ctx context.Context, packet *meshtastic.MeshPacket, ch radio.Channel, data *meshtastic.Data) error {
+	if packet.To != r.cfg.NodeID.Uint32() || !data.WantResponse {
+		return nil
+	}
+	route := &meshtastic.RouteDiscovery{}
+	if err := proto.Unmarshal(data.Payload, route); err != nil {
+		return fmt.Errorf("unmarshalling routeDiscovery: %w", err)
+	}
+	r.logger.Info("received traceroute request", "from", meshtool.NodeID(packet.From).String(), "route", route)
+	appendTowards(route, packet)
+
+	payload, err := proto.Marshal(route)
+	if err != nil {
+		return fmt.Errorf("marshalling routeDiscovery: %w", err)
+	}
+	return r.sendPacket(ctx, &meshtastic.MeshPacket{
+		From:     r.cfg.NodeID.Uint32(),
+		To:       packet.From,
+		Channel:  uint32(ch.Index),
+		HopLimit: packet.HopStart,
+		HopStart: packet.HopStart,
+		PayloadVariant: &meshtastic.MeshPacket_Decoded{
+			Decoded: &meshtastic.Data{
+				Portnum:   meshtastic.PortNum_TRACEROUTE_APP,
+				Payload:   payload,
+				RequestId: packet.Id,
+			},
+		},
+	})
+}
+
+// appendTowards records the hops a traceroute request took to reach the radio in its RouteDiscovery. Relaying nodes
+// which did not add themselves to the route, such as those running older firmware, are recorded as
+// meshtool.BroadcastNodeID with an unknown SNR. The SNR of the final hop is unknown when the packet was received via
+// MQTT.
+func appendTowards(route *meshtastic.RouteDiscovery, packet *meshtastic.MeshPacket) {
+	if packet.HopStart >= packet.HopLimit {
+		hops := int(packet.HopStart - packet.HopLimit)
+		for len(route.Route) < hops {
+			route.Route = append(route.Route, meshtool.BroadcastNodeID.Uint32())
+		}
+	}
+	for len(route.SnrTowards) < len(route.Route) {
+		route.SnrTowards = append(route.SnrTowards, unknownSNR)
+	}
+	snr := int32(unknownSNR)
+	if !packet.ViaMqtt && packet.RxSnr != 0 {
+		// SNR is sent in units of 0.25dB.
+		snr = int32(packet.RxSnr * 4)
+	}
+	route.SnrTowards = append(route.SnrTowards, snr)
+}
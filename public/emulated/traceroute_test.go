@@ -0,0 +1,101 @@
+package emulated
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/rabarar/meshtastic"
+	"github.com/rabarar/meshtool-go/public/meshtool"
+	"github.com/rabarar/meshtool-go/public/radio"
+	"github.com/rabarar/meshtool-go/public/transport"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+func Test_appendTowards(t *testing.T) {
+	tests := []struct {
+		name   string
+		route  *meshtastic.RouteDiscovery
+		packet *meshtastic.MeshPacket
+		want   *meshtastic.RouteDiscovery
+	}{
+		{
+			name:   "direct via MQTT",
+			route:  &meshtastic.RouteDiscovery{},
+			packet: &meshtastic.MeshPacket{HopStart: 3, HopLimit: 3, ViaMqtt: true, RxSnr: 5},
+			want:   &meshtastic.RouteDiscovery{SnrTowards: []int32{unknownSNR}},
+		},
+		{
+			name:   "direct over LoRa",
+			route:  &meshtastic.RouteDiscovery{},
+			packet: &meshtastic.MeshPacket{HopStart: 3, HopLimit: 3, RxSnr: 6.5},
+			want:   &meshtastic.RouteDiscovery{SnrTowards: []int32{26}},
+		},
+		{
+			name:   "relayed",
+			route:  &meshtastic.RouteDiscovery{Route: []uint32{0x1111}, SnrTowards: []int32{12}},
+			packet: &meshtastic.MeshPacket{HopStart: 3, HopLimit: 1, ViaMqtt: true},
+			want: &meshtastic.RouteDiscovery{
+				Route:      []uint32{0x1111, meshtool.BroadcastNodeID.Uint32()},
+				SnrTowards: []int32{12, unknownSNR, unknownSNR},
+			},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			appendTowards(tc.route, tc.packet)
+			require.True(t, proto.Equal(tc.want, tc.route), tc.route.String())
+		})
+	}
+}
+
+func TestRadio_Traceroute(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	bus := NewBus("msh")
+	a := newTestRadio(t, func(cfg *Config) {
+		cfg.Bus = bus
+		cfg.NodeID = 0xaaaa
+	})
+	b := newTestRadio(t, func(cfg *Config) {
+		cfg.Bus = bus
+		cfg.NodeID = 0xbbbb
+	})
+	runTestRadio(t, a)
+	runTestRadio(t, b)
+
+	sc, err := transport.NewClientStreamConn(a.Conn(ctx))
+	require.NoError(t, err)
+	client := transport.NewClient(sc, false)
+	require.NoError(t, client.Connect(ctx))
+	packets, stop := client.SubscribePackets()
+	defer stop()
+
+	request, err := transport.NewPayloadPacket(a.cfg.NodeID, b.cfg.NodeID, &meshtastic.RouteDiscovery{})
+	require.NoError(t, err)
+	request.GetPacket().GetDecoded().WantResponse = true
+	id, err := client.SendPacket(request.GetPacket())
+	require.NoError(t, err)
+
+	for {
+		select {
+		case packet := <-packets:
+			if packet.From != b.cfg.NodeID.Uint32() {
+				continue
+			}
+			require.Equal(t, a.cfg.NodeID.Uint32(), packet.To)
+			data, err := radio.TryDecode(packet, radio.DefaultKey)
+			require.NoError(t, err)
+			require.Equal(t, meshtastic.PortNum_TRACEROUTE_APP, data.Portnum)
+			require.Equal(t, id, data.RequestId)
+			route := &meshtastic.RouteDiscovery{}
+			require.NoError(t, proto.Unmarshal(data.Payload, route))
+			require.Empty(t, route.Route)
+			require.Equal(t, []int32{unknownSNR}, route.SnrTowards)
+			return
+		case <-ctx.Done():
+			t.Fatal("no traceroute reply")
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package lora
+
+import (
+	"math"
+	"time"
+
+	"github.com/rabarar/meshtastic"
+)
+
+// Modulation holds the LoRa parameters which determine how long a packet takes to transmit.
+type Modulation struct {
+	// SpreadingFactor is between 7 and 12.
+	SpreadingFactor int
+	// Bandwidth is in Hz.
+	Bandwidth float64
+	// CodingRate is the denominator of the coding rate, between 5 for 4/5 and 8 for 4/8.
+	CodingRate int
+	// PreambleLength is the number of preamble symbols.
+	PreambleLength int
+}
+
+// meshtasticPreambleLength is the preamble length used by the firmware for all modem presets.
+const meshtasticPreambleLength = 16
+
+// ModemPresets are the modulations used by the firmware for each modem preset.
+var ModemPresets = map[meshtastic.Config_LoRaConfig_ModemPreset]Modulation{
+	meshtastic.Config_LoRaConfig_SHORT_TURBO:    {SpreadingFactor: 7, Bandwidth: 500e3, CodingRate: 5, PreambleLength: meshtasticPreambleLength},
+	meshtastic.Config_LoRaConfig_SHORT_FAST:     {SpreadingFactor: 7, Bandwidth: 250e3, CodingRate: 5, PreambleLength: meshtasticPreambleLength},
+	meshtastic.Config_LoRaConfig_SHORT_SLOW:     {SpreadingFactor: 8, Bandwidth: 250e3, CodingRate: 5, PreambleLength: meshtasticPreambleLength},
+	meshtastic.Config_LoRaConfig_MEDIUM_FAST:    {SpreadingFactor: 9, Bandwidth: 250e3, CodingRate: 5, PreambleLength: meshtasticPreambleLength},
+	meshtastic.Config_LoRaConfig_MEDIUM_SLOW:    {SpreadingFactor: 10, Bandwidth: 250e3, CodingRate: 5, PreambleLength: meshtasticPreambleLength},
+	meshtastic.Config_LoRaConfig_LONG_FAST:      {SpreadingFactor: 11, Bandwidth: 250e3, CodingRate: 5, PreambleLength: meshtasticPreambleLength},
+	meshtastic.Config_LoRaConfig_LONG_MODERATE:  {SpreadingFactor: 11, Bandwidth: 125e3, CodingRate: 8, PreambleLength: meshtasticPreambleLength},
+	meshtastic.Config_LoRaConfig_LONG_SLOW:      {SpreadingFactor: 12, Bandwidth: 125e3, CodingRate: 8, PreambleLength: meshtasticPreambleLength},
+	meshtastic.Config_LoRaConfig_VERY_LONG_SLOW: {SpreadingFactor: 12, Bandwidth: 62.5e3, CodingRate: 8, PreambleLength: meshtasticPreambleLength},
+}
+
+// ModulationForConfig returns the modulation a radio with the given LoRa config transmits with. The modem preset is
+// used unless UsePreset is false and a custom spreading factor, bandwidth and coding rate are all set. Unknown presets
+// fall back to LONG_FAST, the firmware default.
+func ModulationForConfig(cfg *meshtastic.Config_LoRaConfig) Modulation {
+	if !cfg.GetUsePreset() && cfg.GetSpreadFactor() != 0 && cfg.GetBandwidth() != 0 && cfg.GetCodingRate() != 0 {
+		return Modulation{
+			SpreadingFactor: int(cfg.GetSpreadFactor()),
+			// The bandwidth is configured in kHz.
+			Bandwidth:      float64(cfg.GetBandwidth()) * 1e3,
+			CodingRate:     int(cfg.GetCodingRate()),
+			PreambleLength: meshtasticPreambleLength,
+		}
+	}
+	if m, ok := ModemPresets[cfg.GetModemPreset()]; ok {
+		return m
+	}
+	return ModemPresets[meshtastic.Config_LoRaConfig_LONG_FAST]
+}
+
+// SymbolTime returns the duration of a single symbol.
+func (m Modulation) SymbolTime() time.Duration {
+	return time.Duration(math.Pow(2, float64(m.SpreadingFactor)) / m.Bandwidth * float64(time.Second))
+}
+
+// TimeOnAir returns how long a packet with a payload of payloadLen bytes takes to transmit, using the formula from the
+// Semtech SX127x datasheet with an explicit header and CRC, as the firmware uses. Low data rate optimisation is
+// enabled when the symbol time exceeds 16ms, as required by the datasheet.
+func (m Modulation) TimeOnAir(payloadLen int) time.Duration {
+	symbolTime := math.Pow(2, float64(m.SpreadingFactor)) / m.Bandwidth
+	lowDataRate := 0.0
+	if symbolTime > 0.016 {
+		lowDataRate = 1
+	}
+	sf := float64(m.SpreadingFactor)
+	const crc = 16
+	payloadSymbols := math.Ceil((8*float64(payloadLen)-4*sf+28+crc)/(4*(sf-2*lowDataRate))) * float64(m.CodingRate)
+	symbols := float64(m.PreambleLength) + 4.25 + 8 + math.Max(payloadSymbols, 0)
+	return time.Duration(symbols * symbolTime * float64(time.Second))
+}
@@ -1,6 +1,11 @@
 package lora
 
-import "testing"
+import (
+	"testing"
+	"time"
+
+	"github.com/rabarar/meshtastic"
+)
 
 // TestGetSignalQuality tests the GetSignalQuality function with different RSSI and SNR values.
 func TestGetSignalQuality(t *testing.T) {
@@ -41,3 +46,55 @@ func TestGetDiagnosticNotes(t *testing.T) {
 		}
 	}
 }
+
+// TestTimeOnAir tests TimeOnAir against values worked through by hand from the Semtech formula for a 50 byte payload.
+func TestTimeOnAir(t *testing.T) {
+	tests := []struct {
+		preset   meshtastic.Config_LoRaConfig_ModemPreset
+		expected time.Duration
+	}{
+		{meshtastic.Config_LoRaConfig_SHORT_FAST, 52864 * time.Microsecond},
+		{meshtastic.Config_LoRaConfig_LONG_FAST, 641024 * time.Microsecond},
+		// Low data rate optimisation is enabled for the slowest presets.
+		{meshtastic.Config_LoRaConfig_LONG_SLOW, 3547136 * time.Microsecond},
+	}
+
+	for _, test := range tests {
+		actual := ModemPresets[test.preset].TimeOnAir(50)
+		if diff := actual - test.expected; diff < -time.Microsecond || diff > time.Microsecond {
+			t.Errorf("TimeOnAir(50) for %v = %v; want %v", test.preset, actual, test.expected)
+		}
+	}
+}
+
+// TestModulationForConfig tests that presets and custom modulations are chosen from the LoRa config.
+func TestModulationForConfig(t *testing.T) {
+	tests := []struct {
+		name     string
+		cfg      *meshtastic.Config_LoRaConfig
+		expected Modulation
+	}{
+		{"default", nil, ModemPresets[meshtastic.Config_LoRaConfig_LONG_FAST]},
+		{
+			"preset",
+			&meshtastic.Config_LoRaConfig{UsePreset: true, ModemPreset: meshtastic.Config_LoRaConfig_MEDIUM_FAST},
+			ModemPresets[meshtastic.Config_LoRaConfig_MEDIUM_FAST],
+		},
+		{
+			"custom",
+			&meshtastic.Config_LoRaConfig{SpreadFactor: 10, Bandwidth: 125, CodingRate: 6},
+			Modulation{SpreadingFactor: 10, Bandwidth: 125e3, CodingRate: 6, PreambleLength: 16},
+		},
+		{
+			"custom ignored when using preset",
+			&meshtastic.Config_LoRaConfig{UsePreset: true, SpreadFactor: 10, Bandwidth: 125, CodingRate: 6},
+			ModemPresets[meshtastic.Config_LoRaConfig_LONG_FAST],
+		},
+	}
+
+	for _, test := range tests {
+		if actual := ModulationForConfig(test.cfg); actual != test.expected {
+			t.Errorf("ModulationForConfig(%s) = %+v; want %+v", test.name, actual, test.expected)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package meshtool
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/rabarar/meshtastic"
+	"google.golang.org/protobuf/proto"
+)
+
+// ChannelURLPrefix is the start of the URLs the Meshtastic apps use to share channels. It is followed by a ChannelSet
+// marshalled and encoded as URL-safe base64 without padding.
+const ChannelURLPrefix = "https://meshtastic.org/e/#"
+
+// ParseChannelURL parses a channel URL, as shared by the Meshtastic apps, into the ChannelSet it encodes. The
+// ChannelSet is taken from the URL's fragment, so a query such as "?add=true" is ignored. Both the URL-safe and
+// standard base64 alphabets are accepted, with or without padding.
+func ParseChannelURL(channelURL string) (*meshtastic.ChannelSet, error) {
+	u, err := url.Parse(strings.TrimSpace(channelURL))
+	if err != nil {
+		return nil, fmt.Errorf("parsing channel URL: %w", err)
+	}
+	if u.Fragment == "" {
+		return nil, errors.New("channel URL has no channels after the #")
+	}
+	// Map the standard alphabet onto the URL-safe one and drop any padding, so that either form decodes.
+	encoded := strings.NewReplacer("+", "-", "/", "_").Replace(strings.TrimRight(u.Fragment, "="))
+	b, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("decoding channel URL: %w", err)
+	}
+	set := &meshtastic.ChannelSet{}
+	if err := proto.Unmarshal(b, set); err != nil {
+		return nil, fmt.Errorf("unmarshalling channel set: %w", err)
+	}
+	if len(set.GetSettings()) == 0 {
+		return nil, errors.New("channel URL contains no channels")
+	}
+	return set, nil
+}
+
+// ChannelSetToURL encodes a ChannelSet as a channel URL, which can be scanned or opened by the Meshtastic apps. It
+// round-trips with ParseChannelURL.
+func ChannelSetToURL(set *meshtastic.ChannelSet) (string, error) {
+	b, err := proto.Marshal(set)
+	if err != nil {
+		return "", fmt.Errorf("marshalling channel set: %w", err)
+	}
+	return ChannelURLPrefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
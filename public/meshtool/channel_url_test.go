@@ -0,0 +1,65 @@
+package meshtool
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/rabarar/meshtastic"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestParseChannelURL(t *testing.T) {
+	// The URL shared by the apps for the default LongFast channel.
+	defaultSet := &meshtastic.ChannelSet{
+		Settings: []*meshtastic.ChannelSettings{{Psk: []byte{0x01}}},
+		LoraConfig: &meshtastic.Config_LoRaConfig{
+			UsePreset: true,
+			HopLimit:  3,
+			TxEnabled: true,
+		},
+	}
+
+	tests := []struct {
+		name    string
+		url     string
+		want    *meshtastic.ChannelSet
+		wantErr bool
+	}{
+		{name: "default", url: "https://meshtastic.org/e/#CgMSAQESBggBQANIAQ", want: defaultSet},
+		{name: "add query", url: "https://meshtastic.org/e/?add=true#CgMSAQESBggBQANIAQ", want: defaultSet},
+		{name: "padded", url: "https://meshtastic.org/e/#CgMSAQESBggBQANIAQ==", want: defaultSet},
+		{name: "surrounding whitespace", url: " https://meshtastic.org/e/#CgMSAQESBggBQANIAQ\n", want: defaultSet},
+		{name: "no fragment", url: "https://meshtastic.org/e/", wantErr: true},
+		{name: "invalid base64", url: "https://meshtastic.org/e/#!!!", wantErr: true},
+		{name: "no channels", url: "https://meshtastic.org/e/#EgYIAUADSAE", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseChannelURL(tt.url)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.True(t, proto.Equal(tt.want, got), "got %v", got)
+		})
+	}
+}
+
+func TestChannelSetToURL(t *testing.T) {
+	set := &meshtastic.ChannelSet{
+		Settings: []*meshtastic.ChannelSettings{
+			{Name: "LongFast", Psk: []byte{0x01}},
+			// Keys commonly contain bytes which encode as the characters that differ between base64 alphabets.
+			{Name: "Private", Psk: bytes.Repeat([]byte{0xfb, 0xff, 0xbf, 0xfe}, 4)},
+		},
+	}
+	u, err := ChannelSetToURL(set)
+	require.NoError(t, err)
+	require.Regexp(t, `^https://meshtastic\.org/e/#[A-Za-z0-9_-]+$`, u)
+
+	got, err := ParseChannelURL(u)
+	require.NoError(t, err)
+	require.True(t, proto.Equal(set, got))
+}
@@ -0,0 +1,103 @@
+package meshtool
+
+import (
+	"time"
+
+	"github.com/rabarar/meshtastic"
+	"github.com/rabarar/meshtool-go/public/radio"
+	"google.golang.org/protobuf/proto"
+)
+
+// DecodedPacket is a transport independent view of a received MeshPacket with its payload decoded to the concrete
+// message type for its portnum. See radio.DecodeData for the types used.
+type DecodedPacket struct {
+	ID   uint32
+	From uint32
+	To   uint32
+	// Channel is the name of the channel the packet was received on, if known.
+	Channel string
+	Portnum meshtastic.PortNum
+	// RxTime is the time the packet was received, or the zero time if the receiver did not record it.
+	RxTime  time.Time
+	SNR     float32
+	Payload proto.Message
+	// Packet is the packet the DecodedPacket was built from.
+	Packet *meshtastic.MeshPacket
+}
+
+// NewDecodedPacket decodes a MeshPacket, decrypting it with the keys held in keys if required. keys may be nil if the
+// packet is known to have already been decrypted.
+func NewDecodedPacket(packet *meshtastic.MeshPacket, keys *radio.Something) (*DecodedPacket, error) {
+	var channel string
+	data := packet.GetDecoded()
+	if data == nil {
+		if keys == nil {
+			return nil, radio.ErrDecrypt
+		}
+		var err error
+		channel, data, err = keys.Decode(packet)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return newDecodedPacket(packet, channel, data)
+}
+
+// NewDecodedPacketFromEnvelope decodes the MeshPacket within a ServiceEnvelope received over MQTT. The key for the
+// envelope's channel is preferred when decrypting if its channel hash matches the packet, falling back to trying every
+// key in keys.
+func NewDecodedPacketFromEnvelope(se *meshtastic.ServiceEnvelope, keys *radio.Something) (*DecodedPacket, error) {
+	packet := se.GetPacket()
+	if packet == nil {
+		return nil, radio.ErrUnkownPayloadType
+	}
+	if keys != nil && packet.GetDecoded() == nil {
+		// A wrong key can produce garbage which still unmarshals as Data, so the channel hash is checked first.
+		key, ok := keys.Key(se.GetChannelId())
+		if ok && uint32(radio.ChannelNumber(se.GetChannelId(), key)) == packet.GetChannel() {
+			if data, err := radio.TryDecode(packet, key); err == nil {
+				return newDecodedPacket(packet, se.GetChannelId(), data)
+			}
+		}
+	}
+	decoded, err := NewDecodedPacket(packet, keys)
+	if err != nil {
+		return nil, err
+	}
+	decoded.Channel = se.GetChannelId()
+	return decoded, nil
+}
+
+// ErrUnknownMessageType is returned by DecodePayload for portnums whose payload type is not known. It is the same error
+// as radio.ErrUnkownPayloadType.
+var ErrUnknownMessageType = radio.ErrUnkownPayloadType
+
+// DecodePayload unmarshals the payload of a Data protobuf into the concrete message type for its portnum, e.g. a
+// *meshtastic.User for NODEINFO_APP or a *meshtastic.Telemetry for TELEMETRY_APP. Text payloads are returned as a
+// *wrapperspb.StringValue. See radio.DecodeData for the full list of types. ErrUnknownMessageType is returned for
+// portnums which are not understood.
+func DecodePayload(data *meshtastic.Data) (proto.Message, error) {
+	return radio.DecodeData(data)
+}
+
+func newDecodedPacket(packet *meshtastic.MeshPacket, channel string, data *meshtastic.Data) (*DecodedPacket, error) {
+	payload, err := DecodePayload(data)
+	if err != nil {
+		return nil, err
+	}
+	var rxTime time.Time
+	if packet.RxTime != 0 {
+		rxTime = time.Unix(int64(packet.RxTime), 0)
+	}
+	return &DecodedPacket{
+		ID:      packet.Id,
+		From:    packet.From,
+		To:      packet.To,
+		Channel: channel,
+		Portnum: data.Portnum,
+		RxTime:  rxTime,
+		SNR:     packet.RxSnr,
+		Payload: payload,
+		Packet:  packet,
+	}, nil
+}
@@ -0,0 +1,219 @@
+package meshtool
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rabarar/meshtastic"
+	"github.com/rabarar/meshtool-go/public/radio"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// encryptPacket returns a copy of packet with its payload encrypted with key, as sent on the named channel.
+func encryptPacket(t *testing.T, packet *meshtastic.MeshPacket, channel string, key []byte) *meshtastic.MeshPacket {
+	t.Helper()
+	plaintext, err := proto.Marshal(packet.GetDecoded())
+	require.NoError(t, err)
+	encrypted, err := radio.XOR(plaintext, key, packet.Id, packet.From)
+	require.NoError(t, err)
+	packet = proto.Clone(packet).(*meshtastic.MeshPacket)
+	packet.Channel = uint32(radio.ChannelNumber(channel, key))
+	packet.PayloadVariant = &meshtastic.MeshPacket_Encrypted{Encrypted: encrypted}
+	return packet
+}
+
+func TestNewDecodedPacket(t *testing.T) {
+	privateKey := []byte("0123456789abcdef")
+	keys := radio.NewThing(map[string][]byte{"Private": privateKey})
+	data := &meshtastic.Data{Portnum: meshtastic.PortNum_TEXT_MESSAGE_APP, Payload: []byte("hello")}
+	packet := &meshtastic.MeshPacket{
+		Id:             42,
+		From:           0xdeadbeef,
+		To:             0x1234,
+		RxTime:         1700000000,
+		RxSnr:          6.5,
+		PayloadVariant: &meshtastic.MeshPacket_Decoded{Decoded: data},
+	}
+	encrypt := func(channel string, key []byte) *meshtastic.MeshPacket {
+		return encryptPacket(t, packet, channel, key)
+	}
+
+	tests := []struct {
+		name        string
+		packet      *meshtastic.MeshPacket
+		keys        *radio.Something
+		wantChannel string
+		wantErr     error
+	}{
+		{name: "decoded", packet: packet},
+		{name: "encrypted", packet: encrypt("LongFast", radio.DefaultKey), keys: keys, wantChannel: "LongFast"},
+		{name: "private channel", packet: encrypt("Private", privateKey), keys: keys, wantChannel: "Private"},
+		{name: "no keyring", packet: encrypt("LongFast", radio.DefaultKey), wantErr: radio.ErrDecrypt},
+		{
+			name:    "unknown key",
+			packet:  encrypt("Other", []byte("fedcba9876543210")),
+			keys:    keys,
+			wantErr: radio.ErrDecrypt,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewDecodedPacket(tt.packet, tt.keys)
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, uint32(42), got.ID)
+			require.Equal(t, uint32(0xdeadbeef), got.From)
+			require.Equal(t, uint32(0x1234), got.To)
+			require.Equal(t, tt.wantChannel, got.Channel)
+			require.Equal(t, meshtastic.PortNum_TEXT_MESSAGE_APP, got.Portnum)
+			require.Equal(t, time.Unix(1700000000, 0), got.RxTime)
+			require.Equal(t, float32(6.5), got.SNR)
+			require.True(t, proto.Equal(wrapperspb.String("hello"), got.Payload))
+			require.Same(t, tt.packet, got.Packet)
+		})
+	}
+}
+
+func TestNewDecodedPacket_NoRxTime(t *testing.T) {
+	got, err := NewDecodedPacket(&meshtastic.MeshPacket{
+		PayloadVariant: &meshtastic.MeshPacket_Decoded{Decoded: &meshtastic.Data{
+			Portnum: meshtastic.PortNum_TEXT_MESSAGE_APP,
+		}},
+	}, nil)
+	require.NoError(t, err)
+	require.True(t, got.RxTime.IsZero())
+}
+
+func TestNewDecodedPacketFromEnvelope(t *testing.T) {
+	privateKey := []byte("0123456789abcdef")
+	keys := radio.NewThing(map[string][]byte{"Private": privateKey})
+	packet := &meshtastic.MeshPacket{
+		Id:   42,
+		From: 0xdeadbeef,
+		PayloadVariant: &meshtastic.MeshPacket_Decoded{Decoded: &meshtastic.Data{
+			Portnum: meshtastic.PortNum_TEXT_MESSAGE_APP,
+			Payload: []byte("hello"),
+		}},
+	}
+	encrypt := func(channel string, key []byte) *meshtastic.MeshPacket {
+		return encryptPacket(t, packet, channel, key)
+	}
+
+	tests := []struct {
+		name     string
+		envelope *meshtastic.ServiceEnvelope
+		wantErr  bool
+	}{
+		{
+			name:     "decoded",
+			envelope: &meshtastic.ServiceEnvelope{ChannelId: "LongFast", Packet: packet},
+		},
+		{
+			name:     "channel key",
+			envelope: &meshtastic.ServiceEnvelope{ChannelId: "Private", Packet: encrypt("Private", privateKey)},
+		},
+		{
+			// The key registered for the envelope's channel does not match, so every key is tried.
+			name:     "other key",
+			envelope: &meshtastic.ServiceEnvelope{ChannelId: "Private", Packet: encrypt("LongFast", radio.DefaultKey)},
+		},
+		{
+			name:     "unknown key",
+			envelope: &meshtastic.ServiceEnvelope{ChannelId: "Other", Packet: encrypt("Other", []byte("fedcba9876543210"))},
+			wantErr:  true,
+		},
+		{
+			name:     "no packet",
+			envelope: &meshtastic.ServiceEnvelope{ChannelId: "LongFast"},
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewDecodedPacketFromEnvelope(tt.envelope, keys)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.envelope.ChannelId, got.Channel)
+			require.Equal(t, uint32(42), got.ID)
+			require.True(t, proto.Equal(wrapperspb.String("hello"), got.Payload))
+		})
+	}
+}
+
+func TestDecodePayload(t *testing.T) {
+	marshal := func(m proto.Message) []byte {
+		b, err := proto.Marshal(m)
+		require.NoError(t, err)
+		return b
+	}
+	tests := []struct {
+		name    string
+		data    *meshtastic.Data
+		want    proto.Message
+		wantErr bool
+		errIs   error
+	}{
+		{
+			name: "text",
+			data: &meshtastic.Data{Portnum: meshtastic.PortNum_TEXT_MESSAGE_APP, Payload: []byte("hello")},
+			want: wrapperspb.String("hello"),
+		},
+		{
+			name: "user",
+			data: &meshtastic.Data{
+				Portnum: meshtastic.PortNum_NODEINFO_APP,
+				Payload: marshal(&meshtastic.User{Id: "!deadbeef", LongName: "Remote"}),
+			},
+			want: &meshtastic.User{Id: "!deadbeef", LongName: "Remote"},
+		},
+		{
+			name: "neighbor info",
+			data: &meshtastic.Data{
+				Portnum: meshtastic.PortNum_NEIGHBORINFO_APP,
+				Payload: marshal(&meshtastic.NeighborInfo{NodeId: 0xdeadbeef}),
+			},
+			want: &meshtastic.NeighborInfo{NodeId: 0xdeadbeef},
+		},
+		{
+			name: "routing",
+			data: &meshtastic.Data{
+				Portnum: meshtastic.PortNum_ROUTING_APP,
+				Payload: marshal(&meshtastic.Routing{Variant: &meshtastic.Routing_ErrorReason{ErrorReason: meshtastic.Routing_NO_ROUTE}}),
+			},
+			want: &meshtastic.Routing{Variant: &meshtastic.Routing_ErrorReason{ErrorReason: meshtastic.Routing_NO_ROUTE}},
+		},
+		{
+			name:    "unknown portnum",
+			data:    &meshtastic.Data{Portnum: meshtastic.PortNum_PRIVATE_APP},
+			wantErr: true,
+			errIs:   ErrUnknownMessageType,
+		},
+		{
+			name:    "malformed",
+			data:    &meshtastic.Data{Portnum: meshtastic.PortNum_NODEINFO_APP, Payload: []byte{0xff}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DecodePayload(tt.data)
+			if tt.wantErr {
+				require.Error(t, err)
+				if tt.errIs != nil {
+					require.ErrorIs(t, err, tt.errIs)
+				}
+				return
+			}
+			require.NoError(t, err)
+			require.True(t, proto.Equal(tt.want, got), "got %v", got)
+		})
+	}
+}
@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"math"
 	"math/big"
+	"strconv"
+	"strings"
 )
 
 // NodeID holds the node identifier. This is a uint32 value which uniquely identifies a node within a mesh.
@@ -31,6 +33,35 @@ func (n NodeID) String() string {
 	return fmt.Sprintf("!%08x", uint32(n))
 }
 
+// ParseNodeID parses a NodeID from the hex form returned by String, e.g. !deadbeef, or from a uint32. Values are parsed
+// as hex when prefixed with 0x, and otherwise as decimal, even with a leading zero. Bare hex such as deadbeef is
+// rejected, as a value like 12345678 would be ambiguous.
+func ParseNodeID(s string) (NodeID, error) {
+	if hex, ok := strings.CutPrefix(s, "!"); ok {
+		return parseHexNodeID(s, hex)
+	}
+	if hex, ok := strings.CutPrefix(strings.ToLower(s), "0x"); ok {
+		return parseHexNodeID(s, hex)
+	}
+	n, err := strconv.ParseUint(s, 10, 32)
+	if err != nil {
+		if _, hexErr := strconv.ParseUint(s, 16, 32); hexErr == nil {
+			return 0, fmt.Errorf("parsing node ID %q: hex node IDs must be prefixed with ! or 0x: %w", s, err)
+		}
+		return 0, fmt.Errorf("parsing node ID %q: %w", s, err)
+	}
+	return NodeID(n), nil
+}
+
+// parseHexNodeID parses hex, the hex digits of the node ID s.
+func parseHexNodeID(s, hex string) (NodeID, error) {
+	n, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return 0, fmt.Errorf("parsing node ID %q: %w", s, err)
+	}
+	return NodeID(n), nil
+}
+
 // Bytes converts the NodeID to a byte slice
 func (n NodeID) Bytes() []byte {
 	bytes := make([]byte, 4) // uint32 is 4 bytes
@@ -33,6 +33,58 @@ func TestNodeID_String(t *testing.T) {
 	}
 }
 
+func TestParseNodeID(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    NodeID
+		wantErr bool
+	}{
+		{input: "!deadbeef", want: testNodeID},
+		{input: "!DEADBEEF", want: testNodeID},
+		{input: "!00001234", want: 0x1234},
+		{input: "3735928559", want: testNodeID},
+		{input: "0xdeadbeef", want: testNodeID},
+		{input: "12345678", want: 12345678},
+		{input: "1234", want: 1234},
+		{input: "0123", want: 123},
+		{input: "0XDEADBEEF", want: testNodeID},
+		{input: "!ffffffff", want: BroadcastNodeID},
+		{input: "", wantErr: true},
+		// Bare hex is ambiguous with decimal, so must be prefixed.
+		{input: "deadbeef", wantErr: true},
+		{input: "1234567a", wantErr: true},
+		{input: "!", wantErr: true},
+		{input: "!1deadbeef", wantErr: true},
+		{input: "4294967296", wantErr: true},
+		{input: "-1", wantErr: true},
+		{input: "node", wantErr: true},
+		{input: "1_000", wantErr: true},
+		{input: "0x", wantErr: true},
+		{input: "0x_1", wantErr: true},
+		{input: "0o17", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseNodeID(tt.input)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseNodeID(%q): expected error, got %v", tt.input, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseNodeID(%q): expected no error, got %v", tt.input, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseNodeID(%q): expected %v, got %v", tt.input, tt.want, got)
+		}
+		// The hex form round trips through String.
+		if roundTrip, err := ParseNodeID(got.String()); err != nil || roundTrip != got {
+			t.Errorf("ParseNodeID(%q): expected %v, got %v (%v)", got.String(), got, roundTrip, err)
+		}
+	}
+}
+
 func TestNodeID_DefaultShortName(t *testing.T) {
 	nodeID := NodeID(testNodeID)
 	want := "beef"
@@ -0,0 +1,142 @@
+package meshtool
+
+import (
+	"cmp"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/rabarar/meshtastic"
+	"google.golang.org/protobuf/proto"
+)
+
+// nodeDBWatchBuffer is the number of updates buffered for each NodeDB watcher before updates are dropped.
+const nodeDBWatchBuffer = 16
+
+// NodeDB is a database of the nodes in a mesh, keyed by node number, which is safe for concurrent use. It tracks when
+// each node was last heard from. Nodes are copied on the way in and out, so callers never share a NodeInfo with the
+// database.
+type NodeDB struct {
+	mu       sync.RWMutex
+	nodes    map[uint32]*meshtastic.NodeInfo
+	watchers map[chan *meshtastic.NodeInfo]struct{}
+}
+
+// NewNodeDB creates an empty NodeDB.
+func NewNodeDB() *NodeDB {
+	return &NodeDB{
+		nodes:    map[uint32]*meshtastic.NodeInfo{},
+		watchers: map[chan *meshtastic.NodeInfo]struct{}{},
+	}
+}
+
+// Upsert calls update with the entry for num, creating it if it does not exist, and then marks the node as heard now.
+// update is called with the database locked, so it must not retain the NodeInfo or call back into the NodeDB. A copy of
+// the updated entry is returned.
+func (db *NodeDB) Upsert(num uint32, update func(node *meshtastic.NodeInfo)) *meshtastic.NodeInfo {
+	return db.store(num, func(existing *meshtastic.NodeInfo) *meshtastic.NodeInfo {
+		node := existing
+		if node == nil {
+			node = &meshtastic.NodeInfo{}
+		}
+		node.Num = num
+		update(node)
+		node.LastHeard = uint32(time.Now().Unix())
+		return node
+	})
+}
+
+// Merge merges a payload received from the node num into its entry, creating it if it does not exist. See
+// MergeNodeInfo. A copy of the updated entry is returned.
+func (db *NodeDB) Merge(num uint32, update proto.Message) *meshtastic.NodeInfo {
+	return db.store(num, func(existing *meshtastic.NodeInfo) *meshtastic.NodeInfo {
+		return MergeNodeInfo(existing, update)
+	})
+}
+
+// Put replaces the entry for node.Num with a copy of node, leaving LastHeard as given. It is intended for populating
+// the database from a snapshot, e.g. a radio's nodeDB or a saved state.
+func (db *NodeDB) Put(node *meshtastic.NodeInfo) {
+	node = proto.Clone(node).(*meshtastic.NodeInfo)
+	db.store(node.Num, func(*meshtastic.NodeInfo) *meshtastic.NodeInfo {
+		return node
+	})
+}
+
+// store replaces the entry for num with the result of update and notifies watchers, returning a copy of the entry.
+func (db *NodeDB) store(num uint32, update func(existing *meshtastic.NodeInfo) *meshtastic.NodeInfo) *meshtastic.NodeInfo {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	node := update(db.nodes[num])
+	node.Num = num
+	db.nodes[num] = node
+
+	for ch := range db.watchers {
+		select {
+		case ch <- proto.Clone(node).(*meshtastic.NodeInfo):
+		default:
+			// Watcher isn't keeping up, drop the event rather than blocking the database.
+		}
+	}
+	return proto.Clone(node).(*meshtastic.NodeInfo)
+}
+
+// Get returns a copy of the entry for num, and false if there is none.
+func (db *NodeDB) Get(num uint32) (*meshtastic.NodeInfo, bool) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	node, ok := db.nodes[num]
+	if !ok {
+		return nil, false
+	}
+	return proto.Clone(node).(*meshtastic.NodeInfo), true
+}
+
+// List returns a copy of each entry, ordered by node number.
+func (db *NodeDB) List() []*meshtastic.NodeInfo {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	nodes := make([]*meshtastic.NodeInfo, 0, len(db.nodes))
+	for _, node := range db.nodes {
+		nodes = append(nodes, proto.Clone(node).(*meshtastic.NodeInfo))
+	}
+	slices.SortFunc(nodes, func(a, b *meshtastic.NodeInfo) int {
+		return cmp.Compare(a.Num, b.Num)
+	})
+	return nodes
+}
+
+// Len returns the number of entries.
+func (db *NodeDB) Len() int {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return len(db.nodes)
+}
+
+// LastHeard returns when the node num was last heard from, and false if there is no entry for it.
+func (db *NodeDB) LastHeard(num uint32) (time.Time, bool) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	node, ok := db.nodes[num]
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(node.LastHeard), 0), true
+}
+
+// Watch returns a channel which receives a copy of each entry as it is updated, along with a function to stop
+// watching. Updates are dropped rather than blocking the database if the channel is not read promptly.
+func (db *NodeDB) Watch() (<-chan *meshtastic.NodeInfo, func()) {
+	ch := make(chan *meshtastic.NodeInfo, nodeDBWatchBuffer)
+	db.mu.Lock()
+	db.watchers[ch] = struct{}{}
+	db.mu.Unlock()
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			db.mu.Lock()
+			delete(db.watchers, ch)
+			db.mu.Unlock()
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package meshtool
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/rabarar/meshtastic"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestNodeDB(t *testing.T) {
+	db := NewNodeDB()
+	_, ok := db.Get(1)
+	require.False(t, ok)
+	_, ok = db.LastHeard(1)
+	require.False(t, ok)
+
+	events, stop := db.Watch()
+	defer stop()
+
+	node := db.Upsert(1, func(node *meshtastic.NodeInfo) {
+		node.User = &meshtastic.User{LongName: "Remote"}
+	})
+	require.Equal(t, uint32(1), node.Num)
+	require.NotZero(t, node.LastHeard)
+	require.Equal(t, "Remote", (<-events).GetUser().GetLongName())
+
+	// The returned NodeInfo is a copy.
+	node.User.LongName = "Changed"
+	got, ok := db.Get(1)
+	require.True(t, ok)
+	require.Equal(t, "Remote", got.GetUser().GetLongName())
+	lastHeard, ok := db.LastHeard(1)
+	require.True(t, ok)
+	require.Equal(t, int64(got.LastHeard), lastHeard.Unix())
+
+	db.Merge(1, &meshtastic.User{LongName: "Merged"})
+	got, _ = db.Get(1)
+	require.Equal(t, "Merged", got.GetUser().GetLongName())
+
+	// Put keeps LastHeard as given.
+	db.Put(&meshtastic.NodeInfo{Num: 0, LastHeard: 42})
+	lastHeard, _ = db.LastHeard(0)
+	require.Equal(t, int64(42), lastHeard.Unix())
+
+	nodes := db.List()
+	require.Len(t, nodes, 2)
+	require.Equal(t, 2, db.Len())
+	require.Equal(t, uint32(0), nodes[0].Num)
+	require.Equal(t, uint32(1), nodes[1].Num)
+}
+
+func TestNodeDB_ConcurrentUpsert(t *testing.T) {
+	db := NewNodeDB()
+	const workers, upserts = 8, 100
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range upserts {
+				db.Upsert(1, func(node *meshtastic.NodeInfo) {
+					node.HopsAway = proto.Uint32(node.GetHopsAway() + 1)
+				})
+			}
+		}()
+	}
+	wg.Wait()
+	node, ok := db.Get(1)
+	require.True(t, ok)
+	require.Equal(t, uint32(workers*upserts), node.GetHopsAway())
+}
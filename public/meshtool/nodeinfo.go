@@ -0,0 +1,56 @@
+package meshtool
+
+import (
+	"time"
+
+	"github.com/rabarar/meshtastic"
+	"google.golang.org/protobuf/proto"
+)
+
+// MergeNodeInfo returns a copy of existing updated with a payload received from the node, and with LastHeard set to
+// now. existing may be nil for a node which has not been heard from before, in which case the caller should set Num.
+//
+// User, Position and Telemetry payloads, as returned by radio.DecodeData, update the corresponding fields. Only
+// device metrics are kept from Telemetry. A NodeInfo update replaces each field it sets. Other payloads only update
+// LastHeard.
+func MergeNodeInfo(existing *meshtastic.NodeInfo, update proto.Message) *meshtastic.NodeInfo {
+	var merged *meshtastic.NodeInfo
+	if existing != nil {
+		merged = proto.Clone(existing).(*meshtastic.NodeInfo)
+	} else {
+		merged = &meshtastic.NodeInfo{}
+	}
+	merged.LastHeard = uint32(time.Now().Unix())
+
+	switch u := update.(type) {
+	case *meshtastic.User:
+		merged.User = proto.Clone(u).(*meshtastic.User)
+	case *meshtastic.Position:
+		merged.Position = proto.Clone(u).(*meshtastic.Position)
+	case *meshtastic.Telemetry:
+		if metrics := u.GetDeviceMetrics(); metrics != nil {
+			merged.DeviceMetrics = proto.Clone(metrics).(*meshtastic.DeviceMetrics)
+		}
+	case *meshtastic.NodeInfo:
+		if u.Num != 0 {
+			merged.Num = u.Num
+		}
+		if u.User != nil {
+			merged.User = proto.Clone(u.User).(*meshtastic.User)
+		}
+		if u.Position != nil {
+			merged.Position = proto.Clone(u.Position).(*meshtastic.Position)
+		}
+		if u.DeviceMetrics != nil {
+			merged.DeviceMetrics = proto.Clone(u.DeviceMetrics).(*meshtastic.DeviceMetrics)
+		}
+		if u.Snr != 0 {
+			merged.Snr = u.Snr
+		}
+		// A NodeInfo from the radio's own nodeDB carries the time the radio last heard the node.
+		if u.LastHeard != 0 {
+			merged.LastHeard = u.LastHeard
+		}
+	}
+	return merged
+}
@@ -0,0 +1,38 @@
+package meshtool
+
+import (
+	"testing"
+
+	"github.com/rabarar/meshtastic"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestMergeNodeInfo(t *testing.T) {
+	node := MergeNodeInfo(nil, &meshtastic.User{LongName: "Remote"})
+	require.Equal(t, "Remote", node.GetUser().GetLongName())
+	require.NotZero(t, node.LastHeard)
+
+	existing := node
+	node = MergeNodeInfo(existing, &meshtastic.Position{LatitudeI: proto.Int32(1)})
+	require.Equal(t, "Remote", node.GetUser().GetLongName())
+	require.Equal(t, int32(1), node.GetPosition().GetLatitudeI())
+	// The existing NodeInfo is not modified.
+	require.Nil(t, existing.Position)
+
+	node = MergeNodeInfo(node, &meshtastic.Telemetry{
+		Variant: &meshtastic.Telemetry_DeviceMetrics{DeviceMetrics: &meshtastic.DeviceMetrics{BatteryLevel: proto.Uint32(50)}},
+	})
+	require.Equal(t, uint32(50), node.GetDeviceMetrics().GetBatteryLevel())
+
+	// Telemetry without device metrics leaves the existing metrics alone.
+	node = MergeNodeInfo(node, &meshtastic.Telemetry{
+		Variant: &meshtastic.Telemetry_EnvironmentMetrics{EnvironmentMetrics: &meshtastic.EnvironmentMetrics{}},
+	})
+	require.Equal(t, uint32(50), node.GetDeviceMetrics().GetBatteryLevel())
+
+	node = MergeNodeInfo(node, &meshtastic.NodeInfo{Num: 0x1234, LastHeard: 1, Snr: 5})
+	require.Equal(t, uint32(0x1234), node.Num)
+	require.Equal(t, uint32(1), node.LastHeard)
+	require.Equal(t, "Remote", node.GetUser().GetLongName())
+}
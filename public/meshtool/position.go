@@ -0,0 +1,93 @@
+package meshtool
+
+import (
+	"math"
+
+	"github.com/rabarar/meshtastic"
+	"google.golang.org/protobuf/proto"
+)
+
+// positionScale is the factor degrees are multiplied by in the integer latitude and longitude fields of a Position.
+const positionScale = 1e7
+
+// DegreesToI converts degrees to the integer form used by the LatitudeI and LongitudeI fields of a Position, which is
+// degrees multiplied by 1e7, rounded to the nearest integer.
+func DegreesToI(deg float64) int32 {
+	return int32(math.Round(deg * positionScale))
+}
+
+// IToDegrees converts the integer form used by the LatitudeI and LongitudeI fields of a Position to degrees.
+func IToDegrees(i int32) float64 {
+	return float64(i) / positionScale
+}
+
+// FullPositionPrecision is the number of precision bits of a Position which has not had its precision reduced.
+const FullPositionPrecision = 32
+
+// EncodePosition creates a Position from a latitude and longitude in degrees and an altitude in meters, as the
+// firmware's PositionModule does. flags is a bitmask of meshtastic.Config_PositionConfig_PositionFlags, of which
+// ALTITUDE includes the altitude, as the altitude above mean sea level if ALTITUDE_MSL is also set, otherwise as the
+// height above the ellipsoid. The remaining flags describe GPS data which is not given here, so are ignored.
+//
+// The position has full precision, see ReducePositionPrecision.
+func EncodePosition(lat, lon float64, alt int32, flags uint32) *meshtastic.Position {
+	p := &meshtastic.Position{
+		LatitudeI:     proto.Int32(DegreesToI(lat)),
+		LongitudeI:    proto.Int32(DegreesToI(lon)),
+		PrecisionBits: FullPositionPrecision,
+	}
+	if flags&uint32(meshtastic.Config_PositionConfig_ALTITUDE) != 0 {
+		if flags&uint32(meshtastic.Config_PositionConfig_ALTITUDE_MSL) != 0 {
+			p.Altitude = proto.Int32(alt)
+		} else {
+			p.AltitudeHae = proto.Int32(alt)
+		}
+	}
+	return p
+}
+
+// DecodePosition returns the latitude and longitude in degrees and the altitude in meters of a Position, reporting
+// whether it has a location. The altitude above mean sea level is preferred, falling back to the height above the
+// ellipsoid, and is zero if the position has neither. A position with reduced precision decodes to the centre of the
+// area it could be in.
+func DecodePosition(p *meshtastic.Position) (lat, lon float64, alt int32, ok bool) {
+	if p.LatitudeI == nil || p.LongitudeI == nil {
+		return 0, 0, 0, false
+	}
+	switch {
+	case p.Altitude != nil:
+		alt = p.GetAltitude()
+	case p.AltitudeHae != nil:
+		alt = p.GetAltitudeHae()
+	}
+	return IToDegrees(p.GetLatitudeI()), IToDegrees(p.GetLongitudeI()), alt, true
+}
+
+// ReducePositionPrecision returns a copy of p with its location truncated to the given number of bits, as the firmware
+// does for channels configured with a position precision (ModuleSettings.PositionPrecision). The location is moved to
+// the centre of the area it was truncated to, rather than a corner. A precision of 0 means the location is not shared,
+// so it is removed, while FullPositionPrecision or more leaves it unchanged.
+func ReducePositionPrecision(p *meshtastic.Position, bits uint32) *meshtastic.Position {
+	out := proto.Clone(p).(*meshtastic.Position)
+	switch {
+	case bits == 0:
+		out.LatitudeI, out.LongitudeI = nil, nil
+	case bits >= FullPositionPrecision:
+		bits = FullPositionPrecision
+	default:
+		if out.LatitudeI != nil {
+			out.LatitudeI = proto.Int32(truncatePositionI(out.GetLatitudeI(), bits))
+		}
+		if out.LongitudeI != nil {
+			out.LongitudeI = proto.Int32(truncatePositionI(out.GetLongitudeI(), bits))
+		}
+	}
+	out.PrecisionBits = bits
+	return out
+}
+
+// truncatePositionI keeps the top bits of a LatitudeI or LongitudeI value, then adds half of the truncated range.
+func truncatePositionI(i int32, bits uint32) int32 {
+	mask := uint32(math.MaxUint32) << (FullPositionPrecision - bits)
+	return int32(uint32(i)&mask) + int32(1)<<(FullPositionPrecision-1-bits)
+}
@@ -0,0 +1,121 @@
+package meshtool
+
+import (
+	"testing"
+
+	"github.com/rabarar/meshtastic"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestDegreesToI(t *testing.T) {
+	tests := []struct {
+		name string
+		deg  float64
+		want int32
+	}{
+		{name: "Buckingham Palace latitude", deg: 51.501476, want: 515014760},
+		{name: "Buckingham Palace longitude", deg: -0.140634, want: -1406340},
+		{name: "rounds to nearest", deg: 0.00000006, want: 1},
+		{name: "north pole", deg: 90, want: 900000000},
+		{name: "antimeridian", deg: -180, want: -1800000000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := DegreesToI(tt.deg)
+			require.Equal(t, tt.want, got)
+			require.InDelta(t, tt.deg, IToDegrees(got), 1e-7)
+		})
+	}
+}
+
+func TestEncodePosition(t *testing.T) {
+	altitude := uint32(meshtastic.Config_PositionConfig_ALTITUDE)
+	msl := uint32(meshtastic.Config_PositionConfig_ALTITUDE_MSL)
+	tests := []struct {
+		name  string
+		flags uint32
+		want  *meshtastic.Position
+	}{
+		{
+			name: "no altitude",
+			want: &meshtastic.Position{
+				LatitudeI:     proto.Int32(515014760),
+				LongitudeI:    proto.Int32(-1406340),
+				PrecisionBits: FullPositionPrecision,
+			},
+		},
+		{
+			name:  "altitude above mean sea level",
+			flags: altitude | msl,
+			want: &meshtastic.Position{
+				LatitudeI:     proto.Int32(515014760),
+				LongitudeI:    proto.Int32(-1406340),
+				Altitude:      proto.Int32(12),
+				PrecisionBits: FullPositionPrecision,
+			},
+		},
+		{
+			name:  "height above ellipsoid",
+			flags: altitude,
+			want: &meshtastic.Position{
+				LatitudeI:     proto.Int32(515014760),
+				LongitudeI:    proto.Int32(-1406340),
+				AltitudeHae:   proto.Int32(12),
+				PrecisionBits: FullPositionPrecision,
+			},
+		},
+		{
+			name:  "mean sea level flag alone",
+			flags: msl,
+			want: &meshtastic.Position{
+				LatitudeI:     proto.Int32(515014760),
+				LongitudeI:    proto.Int32(-1406340),
+				PrecisionBits: FullPositionPrecision,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := EncodePosition(51.501476, -0.140634, 12, tt.flags)
+			require.True(t, proto.Equal(tt.want, got), "got %v", got)
+
+			lat, lon, alt, ok := DecodePosition(got)
+			require.True(t, ok)
+			require.InDelta(t, 51.501476, lat, 1e-7)
+			require.InDelta(t, -0.140634, lon, 1e-7)
+			if tt.want.Altitude != nil || tt.want.AltitudeHae != nil {
+				require.Equal(t, int32(12), alt)
+			}
+		})
+	}
+
+	_, _, _, ok := DecodePosition(&meshtastic.Position{})
+	require.False(t, ok)
+}
+
+func TestReducePositionPrecision(t *testing.T) {
+	position := EncodePosition(51.501476, -0.140634, 0, 0)
+	tests := []struct {
+		name    string
+		bits    uint32
+		wantLat *int32
+		wantLon *int32
+	}{
+		{name: "full", bits: 32, wantLat: proto.Int32(515014760), wantLon: proto.Int32(-1406340)},
+		{name: "above full", bits: 40, wantLat: proto.Int32(515014760), wantLon: proto.Int32(-1406340)},
+		// The top 13 bits leave areas 2^19 units across, with the location moved to the centre of its area.
+		{name: "13 bits", bits: 13, wantLat: proto.Int32(515112960), wantLon: proto.Int32(-1310720)},
+		{name: "not shared", bits: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ReducePositionPrecision(position, tt.bits)
+			require.Equal(t, tt.wantLat, got.LatitudeI)
+			require.Equal(t, tt.wantLon, got.LongitudeI)
+			require.Equal(t, min(tt.bits, FullPositionPrecision), got.PrecisionBits)
+		})
+	}
+	// The original position is left untouched.
+	require.Equal(t, int32(515014760), position.GetLatitudeI())
+}
@@ -1,7 +1,11 @@
 package meshtool
 
 import (
+	"fmt"
+
 	"github.com/rabarar/meshtastic"
+	"github.com/rabarar/meshtool-go/public/radio"
+	"google.golang.org/protobuf/proto"
 )
 
 type Node struct {
@@ -11,17 +15,30 @@ type Node struct {
 	HardwareModel meshtastic.HardwareModel
 }
 
-// EncryptPacket - Not actually in use yet 😅
-func (n *Node) EncryptPacket(pkt *meshtastic.MeshPacket, channelName string, key []byte) *meshtastic.MeshPacket {
-	payload := pkt.GetPayloadVariant()
-	_ = payload
-	switch p := payload.(type) {
-	case *meshtastic.MeshPacket_Decoded:
-		_ = p
-		encrypted := meshtastic.MeshPacket_Encrypted{
-			Encrypted: nil,
-		}
-		_ = encrypted
+// EncryptPacket returns a copy of pkt with its decoded payload encrypted using the key of the named channel, and the
+// packet's Channel set to the channel hash. The key is the channel PSK as configured, which is passed through
+// radio.ExpandKey as by the firmware, so a short form such as the 1-byte default PSK may be given. If pkt.From is
+// unset, the packet is sent from this node. Packets which are already encrypted are returned unchanged.
+func (n *Node) EncryptPacket(pkt *meshtastic.MeshPacket, channelName string, key []byte) (*meshtastic.MeshPacket, error) {
+	out := proto.Clone(pkt).(*meshtastic.MeshPacket)
+	decoded, ok := pkt.GetPayloadVariant().(*meshtastic.MeshPacket_Decoded)
+	if !ok {
+		return out, nil
+	}
+	if out.From == 0 {
+		out.From = n.ID
+	}
+	plaintext, err := proto.Marshal(decoded.Decoded)
+	if err != nil {
+		return nil, fmt.Errorf("marshalling data: %w", err)
+	}
+	encrypted, err := radio.Encrypt(plaintext, radio.ExpandKey(key), out.Id, out.From)
+	if err != nil {
+		return nil, fmt.Errorf("encrypting: %w", err)
+	}
+	out.Channel = uint32(radio.ChannelNumber(channelName, key))
+	out.PayloadVariant = &meshtastic.MeshPacket_Encrypted{
+		Encrypted: encrypted,
 	}
-	return nil
+	return out, nil
 }
@@ -0,0 +1,73 @@
+package meshtool
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/rabarar/meshtastic"
+	"github.com/rabarar/meshtool-go/public/radio"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestNode_EncryptPacket(t *testing.T) {
+	node := &Node{ID: 0x1234}
+	data := &meshtastic.Data{
+		Portnum: meshtastic.PortNum_TEXT_MESSAGE_APP,
+		Payload: []byte("hello"),
+	}
+	pkt := &meshtastic.MeshPacket{
+		Id:             42,
+		To:             BroadcastNodeID.Uint32(),
+		PayloadVariant: &meshtastic.MeshPacket_Decoded{Decoded: data},
+	}
+
+	encrypted, err := node.EncryptPacket(pkt, "LongFast", radio.DefaultKey)
+	require.NoError(t, err)
+	require.Equal(t, uint32(0x1234), encrypted.From)
+	require.Equal(t, uint32(8), encrypted.Channel)
+	require.NotNil(t, encrypted.GetEncrypted())
+	// The original packet is left untouched.
+	require.NotNil(t, pkt.GetDecoded())
+
+	decrypted, err := radio.TryDecode(encrypted, radio.DefaultKey)
+	require.NoError(t, err)
+	require.True(t, proto.Equal(data, decrypted))
+}
+
+func TestNode_EncryptPacket_PSKs(t *testing.T) {
+	psk24 := bytes.Repeat([]byte{0x5a}, 24)
+	tests := []struct {
+		name        string
+		psk         []byte
+		wantChannel uint32
+	}{
+		// "AQ==", the 1-byte shorthand for the default key, hashes and encrypts as the full key does.
+		{name: "1-byte default PSK", psk: []byte{0x01}, wantChannel: 8},
+		{name: "full default key", psk: radio.DefaultKey, wantChannel: 8},
+		// A 24-byte PSK is zero padded to an AES-256 key, which is also hashed.
+		{name: "24-byte PSK", psk: psk24, wantChannel: uint32(radio.ChannelNumber("LongFast", psk24))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node := &Node{ID: 0x1234}
+			data := &meshtastic.Data{Portnum: meshtastic.PortNum_TEXT_MESSAGE_APP, Payload: []byte("hello")}
+			pkt := &meshtastic.MeshPacket{Id: 42, PayloadVariant: &meshtastic.MeshPacket_Decoded{Decoded: data}}
+
+			encrypted, err := node.EncryptPacket(pkt, "LongFast", tt.psk)
+			require.NoError(t, err)
+			require.Equal(t, tt.wantChannel, encrypted.Channel)
+
+			decrypted, err := radio.TryDecode(encrypted, tt.psk)
+			require.NoError(t, err)
+			require.True(t, proto.Equal(data, decrypted))
+
+			// The decoder filters packets by the channel hash, so it must match for the packet to be decoded.
+			thing := radio.NewThing(map[string][]byte{"LongFast": tt.psk})
+			channel, decoded, err := thing.Decode(encrypted)
+			require.NoError(t, err)
+			require.Equal(t, "LongFast", channel)
+			require.True(t, proto.Equal(data, decoded))
+		})
+	}
+}
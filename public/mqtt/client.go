@@ -1,17 +1,27 @@
 package mqtt
 
 import (
+	"crypto/tls"
 	"errors"
+	"fmt"
+	"maps"
+	"runtime/debug"
+	"slices"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/charmbracelet/log"
 	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/rabarar/meshtastic"
+	"google.golang.org/protobuf/proto"
 )
 
 const MQTTProtoTopic = "/2/e/"
 
+// unsubscribeTimeout is the time in milliseconds Unsubscribe waits for the broker to acknowledge.
+const unsubscribeTimeout = 10000
+
 type Client struct {
 	server    string
 	username  string
@@ -21,10 +31,41 @@ type Client struct {
 	client    mqtt.Client
 	sync.RWMutex
 	channelHandlers map[string][]HandlerFunc
+	channelQoS      map[string]byte
+	jsonHandlers    map[string][]JSONHandlerFunc
+	allHandlers     []func(channel string, message Message)
+	// subscriptions are the topics subscribed to on the broker.
+	subscriptions map[string]struct{}
+
+	// manualReconnect disables the paho client's automatic reconnection, leaving it to the caller.
+	manualReconnect bool
+	lostMu          sync.Mutex
+	lost            chan error
+
+	// tlsConfig is used when connecting to ssl:// (or equivalent) URLs, when nil the system roots are trusted.
+	tlsConfig *tls.Config
+	// lastWill is published by the broker if the client disconnects unexpectedly.
+	lastWill *Message
+}
+
+// ClientOption configures optional behaviour of a Client.
+type ClientOption func(*Client)
+
+// WithTLSConfig sets the TLS configuration used to connect to brokers with an ssl://, tls:// or mqtts:// URL, for
+// example to trust a custom CA or present a client certificate. For testing against a broker with a self-signed
+// certificate, InsecureSkipVerify may be set.
+func WithTLSConfig(cfg *tls.Config) ClientOption {
+	return func(c *Client) {
+		c.tlsConfig = cfg
+	}
 }
 
 type HandlerFunc func(message Message)
 
+// HandlerFuncE is a HandlerFunc which reports failing to handle a message, such as a malformed payload, by returning
+// an error. See HandleE.
+type HandlerFuncE func(message Message) error
+
 var DefaultClient = Client{
 	server:    "tcp://mqtt.meshtastic.org:1883",
 	username:  "meshdev",
@@ -34,14 +75,34 @@ var DefaultClient = Client{
 	channelHandlers: make(map[string][]HandlerFunc),
 }
 
-func NewClient(url, username, password, rootTopic string) *Client {
-	return &Client{
+// WithLastWill sets a Last Will and Testament, which the broker publishes to topic if the client disconnects without
+// first disconnecting cleanly, e.g. to mark a gateway as offline. The message is retained, so that systems which
+// subscribe later also see that the client has gone, and should be replaced by an "online" message published with
+// Retained set once connected.
+func WithLastWill(topic string, payload []byte) ClientOption {
+	return func(c *Client) {
+		c.lastWill = &Message{
+			Topic:    topic,
+			Payload:  payload,
+			Retained: true,
+		}
+	}
+}
+
+// NewClient creates a Client for the broker at url, e.g. tcp://mqtt.meshtastic.org:1883 or
+// ssl://broker.example.com:8883.
+func NewClient(url, username, password, rootTopic string, opts ...ClientOption) *Client {
+	c := &Client{
 		server:          url,
 		username:        username,
 		password:        password,
 		topicRoot:       rootTopic,
 		channelHandlers: make(map[string][]HandlerFunc),
 	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
 }
 
 func (c *Client) TopicRoot() string {
@@ -54,6 +115,70 @@ func (c *Client) Connect() error {
 
 	mqtt.DEBUG = log.StandardLog(log.StandardLogOptions{ForceLevel: log.DebugLevel})
 	mqtt.ERROR = log.StandardLog(log.StandardLogOptions{ForceLevel: log.ErrorLevel})
+	c.client = mqtt.NewClient(c.clientOptions())
+	if token := c.client.Connect(); token.Wait() && token.Error() != nil {
+		return token.Error()
+	}
+	if c.manualReconnect {
+		// Each Connect uses a new client ID so no session is resumed, restore the subscriptions of registered handlers.
+		c.Lock()
+		defer c.Unlock()
+		for channel := range c.channelHandlers {
+			c.subscribe(c.GetFullTopicForChannel(channel)+"/+", c.channelQoS[channel], c.handleBrokerMessage)
+		}
+		for channel := range c.jsonHandlers {
+			c.subscribe(c.GetFullJSONTopicForChannel(channel)+"/+", 0, c.handleBrokerJSONMessage)
+		}
+		if len(c.allHandlers) > 0 {
+			c.subscribe(c.allChannelsTopic(), 0, c.handleAllBrokerMessage)
+		}
+	}
+	return nil
+}
+
+// Disconnect unsubscribes from the topics of all registered handlers and disconnects from the broker, waiting up to
+// quiesce milliseconds for in-flight work to complete. The disconnection is clean, so any Last Will is not published.
+// Handlers remain registered, and a later Connect with automatic reconnection disabled subscribes them again. It does
+// nothing if the client has not connected.
+func (c *Client) Disconnect(quiesce uint) {
+	if c.client == nil {
+		return
+	}
+	c.Lock()
+	topics := slices.Collect(maps.Keys(c.subscriptions))
+	// The broker forgets the subscriptions along with the connection.
+	c.subscriptions = nil
+	c.Unlock()
+	c.unsubscribe(quiesce, topics...)
+	c.client.Disconnect(quiesce)
+	log.Info("disconnected from", "server", c.server)
+}
+
+// subscribe subscribes to topic on the broker and records the subscription. The caller must hold the lock.
+func (c *Client) subscribe(topic string, qos byte, callback mqtt.MessageHandler) {
+	if c.subscriptions == nil {
+		c.subscriptions = make(map[string]struct{})
+	}
+	c.subscriptions[topic] = struct{}{}
+	c.client.Subscribe(topic, qos, callback)
+}
+
+// unsubscribe unsubscribes from topics on the broker, waiting up to timeout milliseconds for the broker to
+// acknowledge. Failures are logged, as the subscriptions are forgotten by the client regardless.
+func (c *Client) unsubscribe(timeout uint, topics ...string) {
+	if len(topics) == 0 {
+		return
+	}
+	tok := c.client.Unsubscribe(topics...)
+	if !tok.WaitTimeout(time.Duration(timeout) * time.Millisecond) {
+		log.Warn("timeout on mqtt unsubscribe", "topics", topics)
+	} else if err := tok.Error(); err != nil {
+		log.Warn("failed to unsubscribe from mqtt", "topics", topics, "err", err)
+	}
+}
+
+// clientOptions returns the options the paho client is created with on each Connect.
+func (c *Client) clientOptions() *mqtt.ClientOptions {
 	opts := mqtt.NewClientOptions().
 		AddBroker(c.server).
 		SetUsername(c.username).
@@ -61,14 +186,22 @@ func (c *Client) Connect() error {
 		SetPassword(c.password).
 		SetClientID(c.clientID).
 		SetCleanSession(false)
+	if c.tlsConfig != nil {
+		opts.SetTLSConfig(c.tlsConfig)
+	}
 	opts.SetKeepAlive(30 * time.Second)
 	opts.SetResumeSubs(true)
 	//opts.SetDefaultPublishHandler(f)
 	opts.SetPingTimeout(5 * time.Second)
-	opts.SetAutoReconnect(true)
+	opts.SetAutoReconnect(!c.manualReconnect)
 	opts.SetMaxReconnectInterval(1 * time.Minute)
-	opts.SetConnectionLostHandler(func(c mqtt.Client, err error) {
+	opts.SetConnectionLostHandler(func(_ mqtt.Client, err error) {
 		log.Error("mqtt connection lost", "err", err)
+		select {
+		case c.connectionLost() <- err:
+		default:
+			// A loss is already pending, the caller only needs to know that the connection has gone.
+		}
 	})
 	opts.SetReconnectingHandler(func(c mqtt.Client, options *mqtt.ClientOptions) {
 		log.Info("mqtt reconnecting")
@@ -76,23 +209,57 @@ func (c *Client) Connect() error {
 	opts.SetOnConnectHandler(func(client mqtt.Client) {
 		log.Info("connected to", "server", c.server)
 	})
-	c.client = mqtt.NewClient(opts)
-	if token := c.client.Connect(); token.Wait() && token.Error() != nil {
-		return token.Error()
+	if c.lastWill != nil {
+		opts.SetBinaryWill(c.lastWill.Topic, c.lastWill.Payload, c.lastWill.QoS, c.lastWill.Retained)
 	}
-	return nil
+	return opts
+}
+
+// SetAutoReconnect controls whether the client automatically reconnects to the broker when the connection is lost,
+// which is the default. When disabled, the caller should watch ConnectionLost and call Connect again, which restores
+// the subscriptions of all registered handlers. It must be called before Connect.
+func (c *Client) SetAutoReconnect(enabled bool) {
+	c.manualReconnect = !enabled
+}
+
+// ConnectionLost returns a channel which receives an error when the connection to the broker is lost.
+func (c *Client) ConnectionLost() <-chan error {
+	return c.connectionLost()
 }
 
+func (c *Client) connectionLost() chan error {
+	c.lostMu.Lock()
+	defer c.lostMu.Unlock()
+	if c.lost == nil {
+		c.lost = make(chan error, 1)
+	}
+	return c.lost
+}
+
+// MQTT quality of service levels, see Message.QoS and HandleQoS.
+const (
+	QoSAtMostOnce  byte = 0
+	QoSAtLeastOnce byte = 1
+	QoSExactlyOnce byte = 2
+)
+
 // Message contains MQTT Message
 type Message struct {
-	Topic    string
-	Payload  []byte
+	Topic   string
+	Payload []byte
+	// Retained asks the broker to keep the message and deliver it to future subscribers of the topic.
 	Retained bool
+	// QoS is the quality of service the message is published with. The zero value is QoSAtMostOnce, which is used by
+	// the firmware for most traffic.
+	QoS byte
 }
 
 // Publish a message to the broker
 func (c *Client) Publish(m *Message) error {
-	tok := c.client.Publish(m.Topic, 0, m.Retained, m.Payload)
+	if m.QoS > QoSExactlyOnce {
+		return fmt.Errorf("invalid QoS %d", m.QoS)
+	}
+	tok := c.client.Publish(m.Topic, m.QoS, m.Retained, m.Payload)
 	if !tok.WaitTimeout(10 * time.Second) {
 		tok.Wait()
 		return errors.New("timeout on mqtt publish")
@@ -103,19 +270,128 @@ func (c *Client) Publish(m *Message) error {
 	return nil
 }
 
+// NewEnvelopeMessage returns the Message publishing a ServiceEnvelope from the gateway gatewayID on channel, where
+// channelTopic is the topic of the channel as returned by GetFullTopicForChannel. The envelope's ChannelId and GatewayId
+// must be set and match channel and gatewayID.
+func NewEnvelopeMessage(channelTopic, channel, gatewayID string, se *meshtastic.ServiceEnvelope) (*Message, error) {
+	switch {
+	case se.GetChannelId() == "":
+		return nil, errors.New("service envelope has no ChannelId")
+	case se.GetGatewayId() == "":
+		return nil, errors.New("service envelope has no GatewayId")
+	case se.GetChannelId() != channel:
+		return nil, fmt.Errorf("service envelope ChannelId %q does not match channel %q", se.GetChannelId(), channel)
+	case se.GetGatewayId() != gatewayID:
+		return nil, fmt.Errorf("service envelope GatewayId %q does not match gateway %q", se.GetGatewayId(), gatewayID)
+	}
+	payload, err := proto.Marshal(se)
+	if err != nil {
+		return nil, fmt.Errorf("marshalling service envelope: %w", err)
+	}
+	return &Message{
+		Topic:   channelTopic + "/" + gatewayID,
+		Payload: payload,
+	}, nil
+}
+
+// PublishEnvelope publishes a ServiceEnvelope from the gateway gatewayID on channel, as a radio uplinking a packet
+// would. See NewEnvelopeMessage.
+func (c *Client) PublishEnvelope(channel, gatewayID string, se *meshtastic.ServiceEnvelope) error {
+	m, err := NewEnvelopeMessage(c.GetFullTopicForChannel(channel), channel, gatewayID, se)
+	if err != nil {
+		return err
+	}
+	return c.Publish(m)
+}
+
 // Handle registers a handler for messages on the specified channel
 func (c *Client) Handle(channel string, h HandlerFunc) {
+	c.HandleQoS(channel, QoSAtMostOnce, h)
+}
+
+// HandleE registers a handler for messages on the specified channel which may return an error. Errors are logged with
+// the message's topic, so that one malformed message does not stop a long-running client.
+func (c *Client) HandleE(channel string, h HandlerFuncE) {
+	c.Handle(channel, func(m Message) {
+		if err := h(m); err != nil {
+			log.Error("failed to handle mqtt message", "topic", m.Topic, "err", err)
+		}
+	})
+}
+
+// HandleQoS registers a handler for messages on the specified channel, subscribing with the given quality of service.
+// As there is a single subscription per channel, the highest QoS requested for the channel is used.
+func (c *Client) HandleQoS(channel string, qos byte, h HandlerFunc) {
 	c.Lock()
 	defer c.Unlock()
 	topic := c.GetFullTopicForChannel(channel)
 	c.channelHandlers[channel] = append(c.channelHandlers[channel], h)
-	c.client.Subscribe(topic+"/+", 0, c.handleBrokerMessage)
+	if c.channelQoS == nil {
+		c.channelQoS = make(map[string]byte)
+	}
+	c.channelQoS[channel] = max(c.channelQoS[channel], qos)
+	c.subscribe(topic+"/+", c.channelQoS[channel], c.handleBrokerMessage)
+}
+
+// HandleAll registers a handler for messages on every channel under the root topic, using a single wildcard
+// subscription. The handler receives the channel name parsed from the topic of each message. Messages on channels which
+// also have a handler registered with Handle are delivered to both.
+func (c *Client) HandleAll(h func(channel string, message Message)) {
+	c.Lock()
+	defer c.Unlock()
+	c.allHandlers = append(c.allHandlers, h)
+	c.subscribe(c.allChannelsTopic(), 0, c.handleAllBrokerMessage)
+}
+
+// Unsubscribe stops handling messages on the specified channel, removing the handlers registered for it with Handle,
+// HandleQoS or HandleJSON and unsubscribing from its topics on the broker. Handlers registered with HandleAll are
+// unaffected.
+func (c *Client) Unsubscribe(channel string) {
+	c.Lock()
+	delete(c.channelHandlers, channel)
+	delete(c.channelQoS, channel)
+	delete(c.jsonHandlers, channel)
+	var topics []string
+	for _, topic := range []string{
+		c.GetFullTopicForChannel(channel) + "/+",
+		c.GetFullJSONTopicForChannel(channel) + "/+",
+	} {
+		if _, ok := c.subscriptions[topic]; ok {
+			delete(c.subscriptions, topic)
+			topics = append(topics, topic)
+		}
+	}
+	c.Unlock()
+	c.unsubscribe(unsubscribeTimeout, topics...)
+}
+
+// allChannelsTopic is the wildcard topic matching messages from every gateway on every channel.
+func (c *Client) allChannelsTopic() string {
+	return c.topicRoot + MQTTProtoTopic + "+/+"
 }
 
 func (c *Client) GetFullTopicForChannel(channel string) string {
 	return c.topicRoot + MQTTProtoTopic + channel
 }
 
+// ParseTopic parses the channel name and gateway ID out of a topic of the form <root>/2/e/<channel>/<gateway>, or the
+// equivalent JSON topic <root>/2/json/<channel>/<gateway>. It returns false if the topic does not have this structure
+// under the client's root topic.
+func (c *Client) ParseTopic(topic string) (channel, gatewayID string, ok bool) {
+	rest, found := strings.CutPrefix(topic, c.topicRoot+MQTTProtoTopic)
+	if !found {
+		rest, found = strings.CutPrefix(topic, c.topicRoot+MQTTJSONTopic)
+	}
+	if !found {
+		return "", "", false
+	}
+	channel, gatewayID, found = strings.Cut(rest, "/")
+	if !found || channel == "" || gatewayID == "" || strings.Contains(gatewayID, "/") {
+		return "", "", false
+	}
+	return channel, gatewayID, true
+}
+
 func (c *Client) GetChannelFromTopic(topic string) string {
 	protoIndex := strings.Index(topic, MQTTProtoTopic)
 	trimmed := topic[protoIndex+len(MQTTProtoTopic):]
@@ -139,6 +415,39 @@ func (c *Client) handleBrokerMessage(client mqtt.Client, message mqtt.Message) {
 		log.Error("no handlers found", "channel", channel, "topic", msg.Topic)
 	}
 	for _, ch := range chans {
-		go ch(msg)
+		go runHandler(msg.Topic, func() {
+			ch(msg)
+		})
 	}
 }
+
+func (c *Client) handleAllBrokerMessage(_ mqtt.Client, message mqtt.Message) {
+	msg := Message{
+		Topic:    message.Topic(),
+		Payload:  message.Payload(),
+		Retained: message.Retained(),
+	}
+	channel, _, ok := c.ParseTopic(msg.Topic)
+	if !ok {
+		log.Warn("ignoring message with unexpected topic", "topic", msg.Topic)
+		return
+	}
+	c.RLock()
+	defer c.RUnlock()
+	for _, h := range c.allHandlers {
+		go runHandler(msg.Topic, func() {
+			h(channel, msg)
+		})
+	}
+}
+
+// runHandler calls a handler for a message received on topic, recovering from any panic so that a bug in one handler
+// does not take down the client along with every other handler.
+func runHandler(topic string, h func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Error("mqtt handler panicked", "topic", topic, "panic", r, "stack", string(debug.Stack()))
+		}
+	}()
+	h()
+}
@@ -0,0 +1,379 @@
+package mqtt
+
+import (
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/rabarar/meshtastic"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+// startTLSListener starts a listener which completes a TLS handshake with each connection before closing it, returning
+// its address, the client TLS config trusting its certificate and a channel receiving the result of each handshake.
+func startTLSListener(t *testing.T) (string, *tls.Config, <-chan error) {
+	t.Helper()
+	// httptest generates a certificate for 127.0.0.1 along with a client config trusting it.
+	srv := httptest.NewUnstartedServer(http.NotFoundHandler())
+	srv.StartTLS()
+	serverConfig := srv.TLS.Clone()
+	clientConfig := srv.Client().Transport.(*http.Transport).TLSClientConfig.Clone()
+	srv.Close()
+
+	l, err := tls.Listen("tcp", "127.0.0.1:0", serverConfig)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		l.Close()
+	})
+	handshakes := make(chan error, 16)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			handshakes <- conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+	return l.Addr().String(), clientConfig, handshakes
+}
+
+func TestClient_Connect_TLS(t *testing.T) {
+	tests := []struct {
+		name          string
+		scheme        string
+		trustCA       bool
+		wantHandshake bool
+	}{
+		{
+			name:          "ssl with custom CA",
+			scheme:        "ssl",
+			trustCA:       true,
+			wantHandshake: true,
+		},
+		{
+			name:          "mqtts with custom CA",
+			scheme:        "mqtts",
+			trustCA:       true,
+			wantHandshake: true,
+		},
+		{
+			name:   "untrusted certificate",
+			scheme: "ssl",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr, clientConfig, handshakes := startTLSListener(t)
+			var opts []ClientOption
+			if tt.trustCA {
+				opts = append(opts, WithTLSConfig(clientConfig))
+			}
+			c := NewClient(tt.scheme+"://"+addr, "user", "pass", DefaultTopicRoot, opts...)
+			c.SetAutoReconnect(false)
+
+			// The listener isn't an MQTT broker, so connecting always fails once the TLS handshake is complete.
+			require.Error(t, c.Connect())
+			select {
+			case err := <-handshakes:
+				if tt.wantHandshake {
+					require.NoError(t, err)
+				} else {
+					require.Error(t, err)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("no connection to listener")
+			}
+		})
+	}
+}
+
+func TestClient_handleAllBrokerMessage(t *testing.T) {
+	c := NewClient("tcp://localhost:1883", "", "", "msh/EU_868")
+	require.Equal(t, "msh/EU_868/2/e/+/+", c.allChannelsTopic())
+	type received struct {
+		channel string
+		msg     Message
+	}
+	messages := make(chan received, 2)
+	c.allHandlers = []func(string, Message){
+		func(channel string, m Message) {
+			messages <- received{channel, m}
+		},
+	}
+
+	for _, channel := range []string{"LongFast", "MediumSlow"} {
+		c.handleAllBrokerMessage(nil, fakeMessage{
+			topic:   "msh/EU_868/2/e/" + channel + "/!abcd1234",
+			payload: []byte(channel),
+		})
+		select {
+		case r := <-messages:
+			require.Equal(t, channel, r.channel)
+			require.Equal(t, []byte(channel), r.msg.Payload)
+		case <-time.After(time.Second):
+			t.Fatal("handler not called")
+		}
+	}
+}
+
+// doneToken is a paho token which has already completed.
+type doneToken struct{}
+
+func (doneToken) Wait() bool                       { return true }
+func (doneToken) WaitTimeout(_ time.Duration) bool { return true }
+func (doneToken) Done() <-chan struct{} {
+	done := make(chan struct{})
+	close(done)
+	return done
+}
+func (doneToken) Error() error { return nil }
+
+// recordingClient is a paho client which records the QoS of each publish and subscription, and whether it has been
+// disconnected.
+type recordingClient struct {
+	mqtt.Client
+	published    []byte
+	subscribed   map[string]byte
+	disconnected bool
+}
+
+func (c *recordingClient) Publish(_ string, qos byte, _ bool, _ interface{}) mqtt.Token {
+	c.published = append(c.published, qos)
+	return doneToken{}
+}
+
+func (c *recordingClient) Subscribe(topic string, qos byte, _ mqtt.MessageHandler) mqtt.Token {
+	c.subscribed[topic] = qos
+	return doneToken{}
+}
+
+func (c *recordingClient) Unsubscribe(topics ...string) mqtt.Token {
+	for _, topic := range topics {
+		delete(c.subscribed, topic)
+	}
+	return doneToken{}
+}
+
+func (c *recordingClient) Disconnect(uint) {
+	c.disconnected = true
+}
+
+func TestClient_QoS(t *testing.T) {
+	paho := &recordingClient{subscribed: map[string]byte{}}
+	c := NewClient("tcp://localhost:1883", "", "", "msh")
+	c.client = paho
+
+	require.NoError(t, c.Publish(&Message{Topic: "msh/2/e/LongFast/!abcd1234"}))
+	require.NoError(t, c.Publish(&Message{Topic: "msh/2/map/", QoS: QoSAtLeastOnce, Retained: true}))
+	require.Error(t, c.Publish(&Message{Topic: "msh/2/map/", QoS: 3}))
+	require.Equal(t, []byte{QoSAtMostOnce, QoSAtLeastOnce}, paho.published)
+
+	// The subscription for a channel uses the highest QoS requested for it.
+	c.Handle("LongFast", func(Message) {})
+	require.Equal(t, QoSAtMostOnce, paho.subscribed["msh/2/e/LongFast/+"])
+	c.HandleQoS("LongFast", QoSAtLeastOnce, func(Message) {})
+	require.Equal(t, QoSAtLeastOnce, paho.subscribed["msh/2/e/LongFast/+"])
+	c.Handle("LongFast", func(Message) {})
+	require.Equal(t, QoSAtLeastOnce, paho.subscribed["msh/2/e/LongFast/+"])
+}
+
+func TestClient_ParseTopic(t *testing.T) {
+	c := NewClient("tcp://localhost:1883", "", "", "msh/EU_868")
+	tests := []struct {
+		name        string
+		topic       string
+		wantChannel string
+		wantGateway string
+		wantOK      bool
+	}{
+		{
+			name:        "protobuf",
+			topic:       c.GetFullTopicForChannel("LongFast") + "/!abcd1234",
+			wantChannel: "LongFast",
+			wantGateway: "!abcd1234",
+			wantOK:      true,
+		},
+		{
+			name:        "json",
+			topic:       c.GetFullJSONTopicForChannel("MediumSlow") + "/!abcd1234",
+			wantChannel: "MediumSlow",
+			wantGateway: "!abcd1234",
+			wantOK:      true,
+		},
+		{
+			name:  "missing gateway",
+			topic: "msh/EU_868/2/e/LongFast",
+		},
+		{
+			name:  "empty gateway",
+			topic: "msh/EU_868/2/e/LongFast/",
+		},
+		{
+			name:  "extra segments",
+			topic: "msh/EU_868/2/e/LongFast/!abcd1234/extra",
+		},
+		{
+			name:  "other root",
+			topic: "msh/US/2/e/LongFast/!abcd1234",
+		},
+		{
+			name:  "map report",
+			topic: "msh/EU_868/2/map/",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			channel, gateway, ok := c.ParseTopic(tt.topic)
+			require.Equal(t, tt.wantOK, ok)
+			require.Equal(t, tt.wantChannel, channel)
+			require.Equal(t, tt.wantGateway, gateway)
+		})
+	}
+}
+
+func TestWithLastWill(t *testing.T) {
+	opts := NewClient("tcp://localhost:1883", "", "", "msh").clientOptions()
+	require.False(t, opts.WillEnabled)
+
+	c := NewClient("tcp://localhost:1883", "", "", "msh", WithLastWill("msh/2/stat/!abcd1234", []byte("offline")))
+	opts = c.clientOptions()
+	require.True(t, opts.WillEnabled)
+	require.Equal(t, "msh/2/stat/!abcd1234", opts.WillTopic)
+	require.Equal(t, []byte("offline"), opts.WillPayload)
+	require.True(t, opts.WillRetained)
+}
+
+func TestNewEnvelopeMessage(t *testing.T) {
+	c := NewClient("tcp://localhost:1883", "", "", "msh/EU_868")
+	packet := &meshtastic.MeshPacket{Id: 42, From: 0xabcd1234}
+	tests := []struct {
+		name      string
+		channel   string
+		gatewayID string
+		se        *meshtastic.ServiceEnvelope
+		wantErr   bool
+	}{
+		{
+			name:      "valid",
+			channel:   "LongFast",
+			gatewayID: "!abcd1234",
+			se:        &meshtastic.ServiceEnvelope{ChannelId: "LongFast", GatewayId: "!abcd1234", Packet: packet},
+		},
+		{
+			name:      "missing ChannelId",
+			channel:   "LongFast",
+			gatewayID: "!abcd1234",
+			se:        &meshtastic.ServiceEnvelope{GatewayId: "!abcd1234", Packet: packet},
+			wantErr:   true,
+		},
+		{
+			name:      "missing GatewayId",
+			channel:   "LongFast",
+			gatewayID: "!abcd1234",
+			se:        &meshtastic.ServiceEnvelope{ChannelId: "LongFast", Packet: packet},
+			wantErr:   true,
+		},
+		{
+			name:      "mismatched channel",
+			channel:   "MediumSlow",
+			gatewayID: "!abcd1234",
+			se:        &meshtastic.ServiceEnvelope{ChannelId: "LongFast", GatewayId: "!abcd1234", Packet: packet},
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := NewEnvelopeMessage(c.GetFullTopicForChannel(tt.channel), tt.channel, tt.gatewayID, tt.se)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			channel, gatewayID, ok := c.ParseTopic(m.Topic)
+			require.True(t, ok)
+			require.Equal(t, tt.channel, channel)
+			require.Equal(t, tt.gatewayID, gatewayID)
+			se := &meshtastic.ServiceEnvelope{}
+			require.NoError(t, proto.Unmarshal(m.Payload, se))
+			require.True(t, proto.Equal(tt.se, se))
+		})
+	}
+}
+
+func TestClient_Disconnect(t *testing.T) {
+	// Disconnecting a client which never connected does nothing.
+	NewClient("tcp://localhost:1883", "", "", "msh").Disconnect(0)
+
+	paho := &recordingClient{subscribed: map[string]byte{}}
+	c := NewClient("tcp://localhost:1883", "", "", "msh")
+	c.client = paho
+	c.Handle("LongFast", func(Message) {})
+	c.HandleJSON("LongFast", func(JSONMessage) {})
+	c.HandleAll(func(string, Message) {})
+	require.Len(t, paho.subscribed, 3)
+
+	c.Disconnect(250)
+	require.Empty(t, paho.subscribed)
+	require.True(t, paho.disconnected)
+}
+
+func TestClient_Unsubscribe(t *testing.T) {
+	paho := &recordingClient{subscribed: map[string]byte{}}
+	c := NewClient("tcp://localhost:1883", "", "", "msh")
+	c.client = paho
+	c.Handle("LongFast", func(Message) {})
+	c.HandleJSON("LongFast", func(JSONMessage) {})
+	c.Handle("MediumFast", func(Message) {})
+	require.Len(t, paho.subscribed, 3)
+
+	c.Unsubscribe("LongFast")
+	require.Equal(t, map[string]byte{"msh/2/e/MediumFast/+": QoSAtMostOnce}, paho.subscribed)
+	require.NotContains(t, c.channelHandlers, "LongFast")
+	require.NotContains(t, c.jsonHandlers, "LongFast")
+	require.Contains(t, c.channelHandlers, "MediumFast")
+
+	// Unsubscribing from a channel without handlers does nothing.
+	c.Unsubscribe("ShortFast")
+	require.Len(t, paho.subscribed, 1)
+
+	// Only the remaining subscription is left to remove on disconnect.
+	c.Disconnect(250)
+	require.Empty(t, paho.subscribed)
+}
+
+func TestClient_handleBrokerMessage_Errors(t *testing.T) {
+	c := NewClient("tcp://localhost:1883", "", "", "msh")
+	c.client = &recordingClient{subscribed: map[string]byte{}}
+	handled := make(chan string, 3)
+	c.HandleE("LongFast", func(m Message) error {
+		handled <- "error"
+		return errors.New("malformed payload")
+	})
+	c.Handle("LongFast", func(m Message) {
+		handled <- "panic"
+		panic("handler bug")
+	})
+	c.Handle("LongFast", func(m Message) {
+		handled <- "ok"
+	})
+
+	// Neither the error nor the panic stop the other handlers, or crash the test.
+	c.handleBrokerMessage(nil, fakeMessage{topic: "msh/2/e/LongFast/!abcd1234"})
+	var got []string
+	for range 3 {
+		select {
+		case h := <-handled:
+			got = append(got, h)
+		case <-time.After(time.Second):
+			t.Fatalf("handlers called: %v", got)
+		}
+	}
+	require.ElementsMatch(t, []string{"error", "panic", "ok"}, got)
+}
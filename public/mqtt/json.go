@@ -0,0 +1,244 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/charmbracelet/log"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/rabarar/meshtastic"
+	"google.golang.org/protobuf/proto"
+)
+
+// MQTTJSONTopic is the topic segment radios with JSON enabled publish decoded packets under, alongside the protobuf
+// ServiceEnvelopes published under MQTTProtoTopic.
+const MQTTJSONTopic = "/2/json/"
+
+// Values of JSONMessage.Type, matching those used by the firmware.
+const (
+	JSONTypeText      = "text"
+	JSONTypeNodeInfo  = "nodeinfo"
+	JSONTypePosition  = "position"
+	JSONTypeTelemetry = "telemetry"
+)
+
+// ErrUnsupportedJSONPortnum is returned when converting a packet whose portnum has no JSON representation.
+var ErrUnsupportedJSONPortnum = errors.New("portnum has no JSON representation")
+
+// JSONMessage is a decoded packet in the JSON format published by the firmware. The shape of Payload depends on Type,
+// see DecodePayload.
+type JSONMessage struct {
+	ID      uint32 `json:"id"`
+	Channel uint32 `json:"channel"`
+	From    uint32 `json:"from"`
+	To      uint32 `json:"to"`
+	// Sender is the ID of the gateway which published the message, e.g. !abcd1234.
+	Sender    string `json:"sender"`
+	Type      string `json:"type"`
+	Timestamp uint32 `json:"timestamp"`
+	HopStart  uint32 `json:"hop_start,omitempty"`
+	HopsAway  uint32 `json:"hops_away,omitempty"`
+	RSSI      int32  `json:"rssi,omitempty"`
+	// SNR is in dB.
+	SNR     float32         `json:"snr,omitempty"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// JSONTextPayload is the payload of a JSONMessage with type text.
+type JSONTextPayload struct {
+	Text string `json:"text"`
+}
+
+// JSONNodeInfoPayload is the payload of a JSONMessage with type nodeinfo.
+type JSONNodeInfoPayload struct {
+	ID        string `json:"id"`
+	LongName  string `json:"longname"`
+	ShortName string `json:"shortname"`
+	// Hardware is a meshtastic.HardwareModel.
+	Hardware uint32 `json:"hardware"`
+	// Role is a meshtastic.Config_DeviceConfig_Role.
+	Role uint32 `json:"role"`
+}
+
+// JSONPositionPayload is the payload of a JSONMessage with type position.
+type JSONPositionPayload struct {
+	LatitudeI     int32  `json:"latitude_i"`
+	LongitudeI    int32  `json:"longitude_i"`
+	Altitude      int32  `json:"altitude,omitempty"`
+	Time          uint32 `json:"time"`
+	PrecisionBits uint32 `json:"precision_bits,omitempty"`
+}
+
+// JSONTelemetryPayload is the payload of a JSONMessage with type telemetry. Only device metrics are supported.
+type JSONTelemetryPayload struct {
+	BatteryLevel       uint32  `json:"battery_level,omitempty"`
+	Voltage            float32 `json:"voltage,omitempty"`
+	ChannelUtilization float32 `json:"channel_utilization,omitempty"`
+	AirUtilTx          float32 `json:"air_util_tx,omitempty"`
+	UptimeSeconds      uint32  `json:"uptime_seconds,omitempty"`
+}
+
+// DecodePayload decodes the payload into the JSON payload struct matching Type, e.g. *JSONTextPayload for text
+// messages. Payloads of other types are decoded into a map[string]any.
+func (m *JSONMessage) DecodePayload() (any, error) {
+	var out any
+	switch m.Type {
+	case JSONTypeText:
+		out = &JSONTextPayload{}
+	case JSONTypeNodeInfo:
+		out = &JSONNodeInfoPayload{}
+	case JSONTypePosition:
+		out = &JSONPositionPayload{}
+	case JSONTypeTelemetry:
+		out = &JSONTelemetryPayload{}
+	default:
+		out = &map[string]any{}
+	}
+	if err := json.Unmarshal(m.Payload, out); err != nil {
+		return nil, fmt.Errorf("unmarshalling %s payload: %w", m.Type, err)
+	}
+	if generic, ok := out.(*map[string]any); ok {
+		return *generic, nil
+	}
+	return out, nil
+}
+
+// NewJSONMessage converts a decoded packet into a JSONMessage published by sender, the ID of the gateway node. It
+// returns ErrUnsupportedJSONPortnum for packets of portnums without a JSON representation.
+func NewJSONMessage(packet *meshtastic.MeshPacket, sender string) (*JSONMessage, error) {
+	data := packet.GetDecoded()
+	if data == nil {
+		return nil, errors.New("packet is not decoded")
+	}
+	msg := &JSONMessage{
+		ID:        packet.Id,
+		Channel:   packet.Channel,
+		From:      packet.From,
+		To:        packet.To,
+		Sender:    sender,
+		Timestamp: packet.RxTime,
+		HopStart:  packet.HopStart,
+		RSSI:      packet.RxRssi,
+		SNR:       packet.RxSnr,
+	}
+	if msg.Timestamp == 0 {
+		msg.Timestamp = uint32(time.Now().Unix())
+	}
+	if packet.HopStart >= packet.HopLimit {
+		msg.HopsAway = packet.HopStart - packet.HopLimit
+	}
+
+	var payload any
+	switch data.Portnum {
+	case meshtastic.PortNum_TEXT_MESSAGE_APP:
+		msg.Type = JSONTypeText
+		payload = JSONTextPayload{Text: string(data.Payload)}
+	case meshtastic.PortNum_NODEINFO_APP:
+		user := &meshtastic.User{}
+		if err := proto.Unmarshal(data.Payload, user); err != nil {
+			return nil, fmt.Errorf("unmarshalling user: %w", err)
+		}
+		msg.Type = JSONTypeNodeInfo
+		payload = JSONNodeInfoPayload{
+			ID:        user.Id,
+			LongName:  user.LongName,
+			ShortName: user.ShortName,
+			Hardware:  uint32(user.HwModel),
+			Role:      uint32(user.Role),
+		}
+	case meshtastic.PortNum_POSITION_APP:
+		position := &meshtastic.Position{}
+		if err := proto.Unmarshal(data.Payload, position); err != nil {
+			return nil, fmt.Errorf("unmarshalling position: %w", err)
+		}
+		msg.Type = JSONTypePosition
+		payload = JSONPositionPayload{
+			LatitudeI:     position.GetLatitudeI(),
+			LongitudeI:    position.GetLongitudeI(),
+			Altitude:      position.GetAltitude(),
+			Time:          position.Time,
+			PrecisionBits: position.PrecisionBits,
+		}
+	case meshtastic.PortNum_TELEMETRY_APP:
+		telemetry := &meshtastic.Telemetry{}
+		if err := proto.Unmarshal(data.Payload, telemetry); err != nil {
+			return nil, fmt.Errorf("unmarshalling telemetry: %w", err)
+		}
+		metrics := telemetry.GetDeviceMetrics()
+		if metrics == nil {
+			return nil, fmt.Errorf("%w: %s without device metrics", ErrUnsupportedJSONPortnum, data.Portnum)
+		}
+		msg.Type = JSONTypeTelemetry
+		payload = JSONTelemetryPayload{
+			BatteryLevel:       metrics.GetBatteryLevel(),
+			Voltage:            metrics.GetVoltage(),
+			ChannelUtilization: metrics.GetChannelUtilization(),
+			AirUtilTx:          metrics.GetAirUtilTx(),
+			UptimeSeconds:      metrics.GetUptimeSeconds(),
+		}
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedJSONPortnum, data.Portnum)
+	}
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("marshalling payload: %w", err)
+	}
+	msg.Payload = b
+	return msg, nil
+}
+
+// JSONHandlerFunc handles a JSONMessage received on a channel.
+type JSONHandlerFunc func(message JSONMessage)
+
+// GetFullJSONTopicForChannel returns the topic JSON messages for the channel are published under.
+func (c *Client) GetFullJSONTopicForChannel(channel string) string {
+	return c.topicRoot + MQTTJSONTopic + channel
+}
+
+// HandleJSON registers a handler for JSON messages on the specified channel. Messages which cannot be parsed are
+// logged and dropped.
+func (c *Client) HandleJSON(channel string, h JSONHandlerFunc) {
+	c.Lock()
+	defer c.Unlock()
+	if c.jsonHandlers == nil {
+		c.jsonHandlers = make(map[string][]JSONHandlerFunc)
+	}
+	c.jsonHandlers[channel] = append(c.jsonHandlers[channel], h)
+	c.subscribe(c.GetFullJSONTopicForChannel(channel)+"/+", 0, c.handleBrokerJSONMessage)
+}
+
+// PublishJSON publishes a JSON message on the channel, under the topic of the gateway in msg.Sender.
+func (c *Client) PublishJSON(channel string, msg *JSONMessage) error {
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("marshalling json message: %w", err)
+	}
+	return c.Publish(&Message{
+		Topic:   c.GetFullJSONTopicForChannel(channel) + "/" + msg.Sender,
+		Payload: b,
+	})
+}
+
+func (c *Client) handleBrokerJSONMessage(_ mqtt.Client, message mqtt.Message) {
+	topic := message.Topic()
+	channel, _, ok := c.ParseTopic(topic)
+	if !ok {
+		log.Warn("ignoring json message with unexpected topic", "topic", topic)
+		return
+	}
+
+	msg := JSONMessage{}
+	if err := json.Unmarshal(message.Payload(), &msg); err != nil {
+		log.Warn("failed to unmarshal json message", "topic", topic, "err", err)
+		return
+	}
+	c.RLock()
+	defer c.RUnlock()
+	for _, h := range c.jsonHandlers[channel] {
+		go runHandler(topic, func() {
+			h(msg)
+		})
+	}
+}
@@ -0,0 +1,181 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/rabarar/meshtastic"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+func mustMarshal(t *testing.T, m proto.Message) []byte {
+	t.Helper()
+	b, err := proto.Marshal(m)
+	require.NoError(t, err)
+	return b
+}
+
+func TestNewJSONMessage(t *testing.T) {
+	packet := func(portnum meshtastic.PortNum, payload []byte) *meshtastic.MeshPacket {
+		return &meshtastic.MeshPacket{
+			Id:       42,
+			From:     0xdeadbeef,
+			To:       0xffffffff,
+			Channel:  1,
+			RxTime:   1700000000,
+			HopStart: 3,
+			HopLimit: 2,
+			RxSnr:    6.25,
+			RxRssi:   -90,
+			PayloadVariant: &meshtastic.MeshPacket_Decoded{Decoded: &meshtastic.Data{
+				Portnum: portnum,
+				Payload: payload,
+			}},
+		}
+	}
+	const header = `"id":42,"channel":1,"from":3735928559,"to":4294967295,"sender":"!abcd1234","timestamp":1700000000,` +
+		`"hop_start":3,"hops_away":1,"rssi":-90,"snr":6.25`
+	tests := []struct {
+		name    string
+		packet  *meshtastic.MeshPacket
+		want    string
+		wantErr error
+	}{
+		{
+			name:   "text",
+			packet: packet(meshtastic.PortNum_TEXT_MESSAGE_APP, []byte("hello")),
+			want:   `{` + header + `,"type":"text","payload":{"text":"hello"}}`,
+		},
+		{
+			name: "nodeinfo",
+			packet: packet(meshtastic.PortNum_NODEINFO_APP, mustMarshal(t, &meshtastic.User{
+				Id:        "!deadbeef",
+				LongName:  "Remote",
+				ShortName: "REM",
+				HwModel:   meshtastic.HardwareModel_TBEAM,
+				Role:      meshtastic.Config_DeviceConfig_ROUTER,
+			})),
+			want: `{` + header + `,"type":"nodeinfo","payload":{"id":"!deadbeef","longname":"Remote","shortname":"REM",` +
+				`"hardware":4,"role":2}}`,
+		},
+		{
+			name: "position",
+			packet: packet(meshtastic.PortNum_POSITION_APP, mustMarshal(t, &meshtastic.Position{
+				LatitudeI:  proto.Int32(515014760),
+				LongitudeI: proto.Int32(-1406340),
+				Altitude:   proto.Int32(2),
+				Time:       1700000000,
+			})),
+			want: `{` + header + `,"type":"position","payload":{"latitude_i":515014760,"longitude_i":-1406340,` +
+				`"altitude":2,"time":1700000000}}`,
+		},
+		{
+			name: "telemetry",
+			packet: packet(meshtastic.PortNum_TELEMETRY_APP, mustMarshal(t, &meshtastic.Telemetry{
+				Variant: &meshtastic.Telemetry_DeviceMetrics{DeviceMetrics: &meshtastic.DeviceMetrics{
+					BatteryLevel:  proto.Uint32(87),
+					Voltage:       proto.Float32(3.5),
+					UptimeSeconds: proto.Uint32(60),
+				}},
+			})),
+			want: `{` + header + `,"type":"telemetry","payload":{"battery_level":87,"voltage":3.5,"uptime_seconds":60}}`,
+		},
+		{
+			name:    "unsupported portnum",
+			packet:  packet(meshtastic.PortNum_ROUTING_APP, nil),
+			wantErr: ErrUnsupportedJSONPortnum,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, err := NewJSONMessage(tt.packet, "!abcd1234")
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			got, err := json.Marshal(msg)
+			require.NoError(t, err)
+			require.JSONEq(t, tt.want, string(got))
+		})
+	}
+}
+
+func TestJSONMessage_DecodePayload(t *testing.T) {
+	// A message as published by the firmware.
+	raw := `{"channel":0,"from":2130636288,"hop_start":3,"hops_away":0,"id":1234,"payload":{"hardware":9,` +
+		`"id":"!7efeee00","longname":"Meshtastic ee00","role":0,"shortname":"ee00"},"rssi":-40,"sender":"!7efeee00",` +
+		`"snr":10.5,"timestamp":1700000000,"to":4294967295,"type":"nodeinfo"}`
+	msg := JSONMessage{}
+	require.NoError(t, json.Unmarshal([]byte(raw), &msg))
+	require.Equal(t, uint32(2130636288), msg.From)
+	require.Equal(t, "!7efeee00", msg.Sender)
+
+	payload, err := msg.DecodePayload()
+	require.NoError(t, err)
+	require.Equal(t, &JSONNodeInfoPayload{
+		ID:        "!7efeee00",
+		LongName:  "Meshtastic ee00",
+		ShortName: "ee00",
+		Hardware:  9,
+	}, payload)
+
+	msg.Type = "neighborinfo"
+	msg.Payload = json.RawMessage(`{"node_id":1}`)
+	payload, err = msg.DecodePayload()
+	require.NoError(t, err)
+	require.Equal(t, map[string]any{"node_id": float64(1)}, payload)
+}
+
+// fakeMessage is a paho message received from the broker.
+type fakeMessage struct {
+	mqtt.Message
+	topic   string
+	payload []byte
+}
+
+func (m fakeMessage) Topic() string {
+	return m.topic
+}
+
+func (m fakeMessage) Payload() []byte {
+	return m.payload
+}
+
+func (m fakeMessage) Retained() bool {
+	return false
+}
+
+func TestClient_handleBrokerJSONMessage(t *testing.T) {
+	c := NewClient("tcp://localhost:1883", "", "", "msh/EU_868")
+	received := make(chan JSONMessage, 1)
+	c.jsonHandlers = map[string][]JSONHandlerFunc{
+		"LongFast": {func(m JSONMessage) { received <- m }},
+	}
+
+	c.handleBrokerJSONMessage(nil, fakeMessage{
+		topic:   "msh/EU_868/2/json/LongFast/!abcd1234",
+		payload: []byte(`{"id":42,"type":"text","sender":"!abcd1234","payload":{"text":"hello"}}`),
+	})
+	select {
+	case msg := <-received:
+		require.Equal(t, uint32(42), msg.ID)
+		payload, err := msg.DecodePayload()
+		require.NoError(t, err)
+		require.Equal(t, &JSONTextPayload{Text: "hello"}, payload)
+	case <-time.After(time.Second):
+		t.Fatal("handler not called")
+	}
+
+	// Messages for other channels and invalid JSON are not delivered.
+	c.handleBrokerJSONMessage(nil, fakeMessage{topic: "msh/EU_868/2/json/Other/!abcd1234", payload: []byte(`{}`)})
+	c.handleBrokerJSONMessage(nil, fakeMessage{topic: "msh/EU_868/2/json/LongFast/!abcd1234", payload: []byte(`{`)})
+	select {
+	case msg := <-received:
+		t.Fatalf("unexpected message %v", msg)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
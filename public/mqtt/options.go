@@ -0,0 +1,95 @@
+package mqtt
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/rabarar/meshtastic"
+)
+
+const (
+	// DefaultServerAddress is the broker used by the firmware when no MQTT address is configured.
+	DefaultServerAddress = "mqtt.meshtastic.org"
+	// DefaultUsername and DefaultPassword are the credentials used by the firmware for the default broker.
+	DefaultUsername = "meshdev"
+	DefaultPassword = "large4cats"
+	// DefaultTopicRoot is the topic root used by the firmware when none is configured.
+	DefaultTopicRoot = "msh"
+
+	defaultPort    = "1883"
+	defaultTLSPort = "8883"
+)
+
+// Options holds the settings needed to connect a Client to a broker.
+type Options struct {
+	// URL of the broker, e.g. tcp://mqtt.meshtastic.org:1883
+	URL       string
+	Username  string
+	Password  string
+	TopicRoot string
+	// EncryptionEnabled is true if the radio publishes packets still encrypted with the channel key.
+	EncryptionEnabled bool
+	// JSONEnabled is true if the radio also publishes packets as JSON.
+	JSONEnabled bool
+	// TLSConfig is used when URL has an ssl:// scheme. See WithTLSConfig.
+	TLSConfig *tls.Config
+}
+
+// NewClientFromOptions creates a Client from Options.
+func NewClientFromOptions(opts Options) *Client {
+	var clientOpts []ClientOption
+	if opts.TLSConfig != nil {
+		clientOpts = append(clientOpts, WithTLSConfig(opts.TLSConfig))
+	}
+	return NewClient(opts.URL, opts.Username, opts.Password, opts.TopicRoot, clientOpts...)
+}
+
+// ModuleConfigToMQTTOptions builds Options from a radio's MQTT module config, so that a tool can connect to the same
+// broker as the radio. Defaults are filled in the same way the firmware does: an empty address means the default
+// server, which also supplies the default credentials if none are set.
+func ModuleConfigToMQTTOptions(cfg *meshtastic.ModuleConfig_MQTTConfig) (Options, error) {
+	if cfg == nil {
+		return Options{}, errors.New("no MQTT module config")
+	}
+	opts := Options{
+		Username:          cfg.Username,
+		Password:          cfg.Password,
+		TopicRoot:         cfg.Root,
+		EncryptionEnabled: cfg.EncryptionEnabled,
+		JSONEnabled:       cfg.JsonEnabled,
+	}
+
+	address := cfg.Address
+	if address == "" || address == DefaultServerAddress {
+		address = DefaultServerAddress
+		if opts.Username == "" {
+			opts.Username = DefaultUsername
+		}
+		if opts.Password == "" {
+			opts.Password = DefaultPassword
+		}
+	}
+	if opts.TopicRoot == "" {
+		opts.TopicRoot = DefaultTopicRoot
+	}
+
+	scheme, port := "tcp", defaultPort
+	if cfg.TlsEnabled {
+		scheme, port = "ssl", defaultTLSPort
+	}
+	host := address
+	if h, p, err := net.SplitHostPort(address); err == nil {
+		host, port = h, p
+	}
+	if _, err := strconv.ParseUint(port, 10, 16); err != nil || host == "" || strings.ContainsAny(host, "/ ") {
+		return Options{}, fmt.Errorf("invalid MQTT server address %q", cfg.Address)
+	}
+	u := url.URL{Scheme: scheme, Host: net.JoinHostPort(host, port)}
+	opts.URL = u.String()
+	return opts, nil
+}
@@ -0,0 +1,71 @@
+package mqtt
+
+import (
+	"testing"
+
+	"github.com/rabarar/meshtastic"
+	"github.com/stretchr/testify/require"
+)
+
+func TestModuleConfigToMQTTOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     *meshtastic.ModuleConfig_MQTTConfig
+		want    Options
+		wantErr bool
+	}{
+		{
+			name: "default server",
+			cfg:  &meshtastic.ModuleConfig_MQTTConfig{EncryptionEnabled: true},
+			want: Options{
+				URL:               "tcp://mqtt.meshtastic.org:1883",
+				Username:          DefaultUsername,
+				Password:          DefaultPassword,
+				TopicRoot:         DefaultTopicRoot,
+				EncryptionEnabled: true,
+			},
+		},
+		{
+			name: "custom server with port",
+			cfg: &meshtastic.ModuleConfig_MQTTConfig{
+				Address:  "broker.example.com:1884",
+				Username: "user",
+				Root:     "msh/EU_868",
+			},
+			want: Options{
+				URL:       "tcp://broker.example.com:1884",
+				Username:  "user",
+				TopicRoot: "msh/EU_868",
+			},
+		},
+		{
+			name: "tls",
+			cfg:  &meshtastic.ModuleConfig_MQTTConfig{Address: "broker.example.com", TlsEnabled: true, JsonEnabled: true},
+			want: Options{
+				URL:         "ssl://broker.example.com:8883",
+				TopicRoot:   DefaultTopicRoot,
+				JSONEnabled: true,
+			},
+		},
+		{
+			name:    "invalid address",
+			cfg:     &meshtastic.ModuleConfig_MQTTConfig{Address: "tcp://broker.example.com"},
+			wantErr: true,
+		},
+		{
+			name:    "nil",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ModuleConfigToMQTTOptions(tt.cfg)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -38,10 +38,17 @@ func createNonce(packetID uint32, fromNode uint32) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
-// XOR encrypts or decrypts text with the specified key. It requires the packetID and sending node ID for the AES IV
+// XOR encrypts or decrypts text with the specified key. It requires the packetID and sending node ID for the AES IV.
+// The key is first passed through ExpandKey, as by the firmware, which only uses AES-128 and AES-256 in CTR mode: a
+// 16 byte key selects AES-128, and any other PSK up to 32 bytes is padded to 32 bytes for AES-256. An empty key is
+// an unencrypted channel, for which text is returned unchanged.
 func XOR(text []byte, key []byte, packetID, fromNode uint32) ([]byte, error) {
-	if len(key) != 16 && len(key) != 24 && len(key) != 32 {
-		return nil, fmt.Errorf("key length must be 16, 24, or 32 bytes")
+	key = ExpandKey(key)
+	if len(key) == 0 {
+		return bytes.Clone(text), nil
+	}
+	if len(key) != 16 && len(key) != 32 {
+		return nil, fmt.Errorf("key length must be at most 32 bytes, got %d", len(key))
 	}
 
 	block, err := aes.NewCipher(key)
@@ -70,3 +77,16 @@ func XOR(text []byte, key []byte, packetID, fromNode uint32) ([]byte, error) {
 
 	return plaintext, nil
 }
+
+// Encrypt encrypts a plaintext payload, typically a marshalled Data protobuf, for transmission from fromNode in the
+// packet with the given ID. The nonce is constructed in the same way as the firmware, so the result can be decrypted
+// by other nodes holding the key. As with Decrypt, the key is passed through ExpandKey, so a PSK may be given in its
+// short form. Payloads longer than MaxPayloadLen, which would not fit in a LoRa packet, are rejected with
+// ErrPayloadTooLarge.
+func Encrypt(plaintext []byte, key []byte, packetID uint32, fromNode uint32) ([]byte, error) {
+	if len(plaintext) > MaxPayloadLen {
+		return nil, fmt.Errorf("%w: %d > %d", ErrPayloadTooLarge, len(plaintext), MaxPayloadLen)
+	}
+	// AES-CTR is symmetric, so encryption is the same operation as decryption.
+	return XOR(plaintext, ExpandKey(key), packetID, fromNode)
+}
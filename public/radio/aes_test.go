@@ -0,0 +1,161 @@
+package radio
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/rabarar/meshtastic"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_createNonce(t *testing.T) {
+	nonce, err := createNonce(0x01020304, 0xaabbccdd)
+	require.NoError(t, err)
+	// [64-bit packet ID][32-bit from node][32-bit block counter], all little endian, as built by the firmware.
+	require.Equal(t, []byte{
+		0x04, 0x03, 0x02, 0x01, 0x00, 0x00, 0x00, 0x00,
+		0xdd, 0xcc, 0xbb, 0xaa,
+		0x00, 0x00, 0x00, 0x00,
+	}, nonce)
+}
+
+func TestEncrypt(t *testing.T) {
+	plaintext := []byte("hello mesh")
+	ciphertext, err := Encrypt(plaintext, DefaultKey, 42, 0x1234)
+	require.NoError(t, err)
+	require.NotEqual(t, plaintext, ciphertext)
+
+	decrypted, err := XOR(ciphertext, DefaultKey, 42, 0x1234)
+	require.NoError(t, err)
+	require.Equal(t, plaintext, decrypted)
+
+	// The 1-byte shorthand for the default key encrypts as the full key does.
+	short, err := Encrypt(plaintext, []byte{0x01}, 42, 0x1234)
+	require.NoError(t, err)
+	require.Equal(t, ciphertext, short)
+}
+
+func TestEncrypt_KeyLengths(t *testing.T) {
+	plaintext := []byte("a payload that spans more than one sixteen byte AES block")
+	tests := []struct {
+		name string
+		key  []byte
+	}{
+		{name: "1-byte PSK", key: []byte{0x01}},
+		{name: "short PSK", key: []byte{0xaa, 0xbb, 0xcc}},
+		{name: "AES-128", key: DefaultKey},
+		{name: "24-byte PSK", key: bytes.Repeat([]byte{0x5a}, 24)},
+		{name: "AES-256", key: []byte{
+			0x6b, 0x81, 0x1e, 0x9f, 0x42, 0xd7, 0x3a, 0x0c, 0x95, 0xe2, 0x17, 0x68, 0xbf, 0x24, 0xc1, 0x7e,
+			0x03, 0xaa, 0x59, 0xf6, 0x8d, 0x30, 0xe4, 0x1b, 0x72, 0xcf, 0x06, 0x9b, 0x48, 0xb5, 0x2d, 0xe0,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ciphertext, err := Encrypt(plaintext, tt.key, 0x0badf00d, 0xdeadbeef)
+			require.NoError(t, err)
+			require.NotEqual(t, plaintext, ciphertext)
+
+			// Encrypt and Decrypt expand the key in the same way, so they round-trip for any PSK.
+			decrypted, err := Decrypt(&meshtastic.MeshPacket{
+				Id:             0x0badf00d,
+				From:           0xdeadbeef,
+				PayloadVariant: &meshtastic.MeshPacket_Encrypted{Encrypted: ciphertext},
+			}, tt.key)
+			require.NoError(t, err)
+			require.Equal(t, plaintext, decrypted)
+		})
+	}
+
+	// Keys of different lengths must produce different ciphertexts, so a 32 byte key isn't being truncated.
+	aes256 := tests[len(tests)-1].key
+	short, err := Encrypt(plaintext, aes256[:16], 1, 1)
+	require.NoError(t, err)
+	long, err := Encrypt(plaintext, aes256, 1, 1)
+	require.NoError(t, err)
+	require.NotEqual(t, short, long)
+}
+
+// TestXOR_KnownAnswer decrypts a text message sent on a channel with a 32 byte PSK. The ciphertext is independent of
+// this package, having been produced by OpenSSL's aes-256-ctr with the key and the nonce the firmware builds from the
+// packet ID and sender.
+func TestXOR_KnownAnswer(t *testing.T) {
+	key, err := ParseKey("a4Een0LXOgyV4hdovyTBfgOqWfaNMOQbcs8Gm0i1LeA=")
+	require.NoError(t, err)
+	packet := &meshtastic.MeshPacket{
+		Id:   0x5a3c9e01,
+		From: 0xdeadbeef,
+		PayloadVariant: &meshtastic.MeshPacket_Encrypted{
+			Encrypted: mustDecodeHex(t, "6a821e978c1f21fb55a368b62e7c"),
+		},
+	}
+	// A Data protobuf with portnum TEXT_MESSAGE_APP and payload "hello mesh".
+	plaintext := mustDecodeHex(t, "0801120a68656c6c6f206d657368")
+
+	decrypted, err := Decrypt(packet, key)
+	require.NoError(t, err)
+	require.Equal(t, plaintext, decrypted)
+	data, err := TryDecode(packet, key)
+	require.NoError(t, err)
+	require.Equal(t, meshtastic.PortNum_TEXT_MESSAGE_APP, data.GetPortnum())
+	require.Equal(t, "hello mesh", string(data.GetPayload()))
+
+	encrypted, err := Encrypt(plaintext, key, packet.Id, packet.From)
+	require.NoError(t, err)
+	require.Equal(t, packet.GetEncrypted(), encrypted)
+}
+
+func TestEncrypt_PayloadLen(t *testing.T) {
+	tests := []struct {
+		name    string
+		len     int
+		wantErr bool
+	}{
+		{name: "max", len: MaxPayloadLen},
+		{name: "one over max", len: MaxPayloadLen + 1, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Encrypt(make([]byte, tt.len), DefaultKey, 1, 2)
+			if tt.wantErr {
+				require.ErrorIs(t, err, ErrPayloadTooLarge)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestXOR_Keys(t *testing.T) {
+	text := []byte("hello mesh")
+	psk24 := bytes.Repeat([]byte{0x5a}, 24)
+	tests := []struct {
+		name string
+		key  []byte
+		// same is the key XOR must behave identically with, as the firmware would use it.
+		same    []byte
+		wantErr bool
+	}{
+		{name: "1-byte PSK", key: []byte{0x01}, same: DefaultKey},
+		{name: "24-byte PSK padded to AES-256", key: psk24, same: append(bytes.Clone(psk24), make([]byte, 8)...)},
+		{name: "too long", key: make([]byte, 33), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := XOR(text, tt.key, 1, 2)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			want, err := XOR(text, tt.same, 1, 2)
+			require.NoError(t, err)
+			require.Equal(t, want, got)
+		})
+	}
+
+	// An unencrypted channel passes the text through unchanged.
+	got, err := XOR(text, nil, 1, 2)
+	require.NoError(t, err)
+	require.Equal(t, text, got)
+}
@@ -0,0 +1,52 @@
+package radio
+
+import (
+	"github.com/rabarar/meshtastic"
+	"google.golang.org/protobuf/proto"
+)
+
+// weakKeys are the keys tried by BruteForceKey.
+var weakKeys = GenerateByteSlices()
+
+// BruteForceKey attempts to decrypt a packet with each of the weak keys from GenerateByteSlices, returning the first
+// key which yields a valid Data protobuf. A decrypt is only considered valid if the Data has a known portnum and its
+// payload decodes, which rules out almost all false positives.
+//
+// The channel hash in packet.Channel depends on the channel name as well as the key, so it cannot rule keys out on its
+// own. Instead, keys which produce the hash with one of the modem preset channel names are tried first, as these are
+// the channels most likely to be using a weak key.
+func BruteForceKey(packet *meshtastic.MeshPacket) ([]byte, *meshtastic.Data, bool) {
+	if packet.GetEncrypted() == nil {
+		return nil, nil, false
+	}
+	presetHashes := map[uint8]bool{}
+	for _, name := range presetChannelNames {
+		presetHashes[xorHash([]byte(name))] = true
+	}
+	// The hash of the channel name that the packet's hash implies for a key.
+	likely := func(key []byte) bool {
+		return presetHashes[uint8(packet.GetChannel())^xorHash(key)]
+	}
+
+	for _, pass := range []bool{true, false} {
+		for _, key := range weakKeys {
+			if likely(key) != pass {
+				continue
+			}
+			// XOR pads 24 byte keys to 32 bytes rather than using AES-192, as the firmware does.
+			plaintext, err := XOR(packet.GetEncrypted(), key, packet.GetId(), packet.GetFrom())
+			if err != nil {
+				continue
+			}
+			data := &meshtastic.Data{}
+			if err := proto.Unmarshal(plaintext, data); err != nil {
+				continue
+			}
+			if _, err := DecodeData(data); err != nil {
+				continue
+			}
+			return key, data, true
+		}
+	}
+	return nil, nil, false
+}
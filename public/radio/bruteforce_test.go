@@ -0,0 +1,64 @@
+package radio
+
+import (
+	"testing"
+
+	"github.com/rabarar/meshtastic"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+func weakKeyTestPacket(t testing.TB, channel string, key []byte) (*meshtastic.MeshPacket, *meshtastic.Data) {
+	t.Helper()
+	data := &meshtastic.Data{
+		Portnum: meshtastic.PortNum_TEXT_MESSAGE_APP,
+		Payload: []byte("weak keys are weak"),
+	}
+	plaintext, err := proto.Marshal(data)
+	require.NoError(t, err)
+	encrypted, err := Encrypt(plaintext, key, 0x1234abcd, 0xdeadbeef)
+	require.NoError(t, err)
+	return &meshtastic.MeshPacket{
+		Id:             0x1234abcd,
+		From:           0xdeadbeef,
+		Channel:        uint32(ChannelNumber(channel, key)),
+		PayloadVariant: &meshtastic.MeshPacket_Encrypted{Encrypted: encrypted},
+	}, data
+}
+
+func TestBruteForceKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		channel string
+		key     []byte
+	}{
+		{name: "preset channel", channel: "LongFast", key: weakKeys[42]},
+		{name: "private channel", channel: "Secret", key: weakKeys[256+200]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			packet, data := weakKeyTestPacket(t, tt.channel, tt.key)
+			key, decoded, ok := BruteForceKey(packet)
+			require.True(t, ok)
+			require.Equal(t, tt.key, key)
+			require.True(t, proto.Equal(data, decoded))
+		})
+	}
+}
+
+func TestBruteForceKey_StrongKey(t *testing.T) {
+	packet, _ := weakKeyTestPacket(t, "LongFast", DefaultKey)
+	_, _, ok := BruteForceKey(packet)
+	require.False(t, ok)
+}
+
+func BenchmarkBruteForceKey(b *testing.B) {
+	// The last key is tried last when the channel name is not a preset, which is the worst case.
+	packet, _ := weakKeyTestPacket(b, "Secret", weakKeys[len(weakKeys)-1])
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, ok := BruteForceKey(packet); !ok {
+			b.Fatal("key not found")
+		}
+	}
+}
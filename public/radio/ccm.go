@@ -0,0 +1,101 @@
+package radio
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+)
+
+// AES-CCM (RFC 3610) parameters used by the firmware for PKI encrypted packets: a 13 byte nonce, leaving 2 bytes for
+// the message length, and an 8 byte authentication tag.
+const (
+	ccmNonceLen = 13
+	ccmTagLen   = 8
+	// ccmLengthLen is the number of bytes used to encode the message length, L in RFC 3610.
+	ccmLengthLen = aes.BlockSize - 1 - ccmNonceLen
+)
+
+var errCCMAuth = errors.New("ccm: message authentication failed")
+
+// ccmSeal encrypts and authenticates plaintext along with the additional data aad, returning the ciphertext followed
+// by the tag.
+func ccmSeal(block cipher.Block, nonce, plaintext, aad []byte) []byte {
+	tag := ccmMAC(block, nonce, plaintext, aad)
+	out := make([]byte, len(plaintext)+ccmTagLen)
+	ccmCTR(block, nonce, out, plaintext)
+	ccmEncryptTag(block, nonce, out[len(plaintext):], tag)
+	return out
+}
+
+// ccmOpen verifies and decrypts ciphertext, which is followed by its tag, along with the additional data aad.
+func ccmOpen(block cipher.Block, nonce, ciphertext, aad []byte) ([]byte, error) {
+	if len(ciphertext) < ccmTagLen {
+		return nil, errCCMAuth
+	}
+	ciphertext, tag := ciphertext[:len(ciphertext)-ccmTagLen], ciphertext[len(ciphertext)-ccmTagLen:]
+	plaintext := make([]byte, len(ciphertext))
+	ccmCTR(block, nonce, plaintext, ciphertext)
+	expected := make([]byte, ccmTagLen)
+	ccmEncryptTag(block, nonce, expected, ccmMAC(block, nonce, plaintext, aad))
+	if subtle.ConstantTimeCompare(expected, tag) != 1 {
+		return nil, errCCMAuth
+	}
+	return plaintext, nil
+}
+
+// ccmMAC computes the CBC-MAC of the message, before it is encrypted.
+func ccmMAC(block cipher.Block, nonce, plaintext, aad []byte) []byte {
+	b0 := make([]byte, aes.BlockSize)
+	b0[0] = byte((ccmTagLen-2)/2<<3 | (ccmLengthLen - 1))
+	if len(aad) > 0 {
+		b0[0] |= 0x40
+	}
+	copy(b0[1:], nonce)
+	binary.BigEndian.PutUint16(b0[aes.BlockSize-ccmLengthLen:], uint16(len(plaintext)))
+
+	mac := make([]byte, aes.BlockSize)
+	block.Encrypt(mac, b0)
+	if len(aad) > 0 {
+		// Additional data shorter than 0xff00 bytes is prefixed with its length as 2 bytes.
+		mac = cbcMAC(block, mac, binary.BigEndian.AppendUint16(nil, uint16(len(aad))), aad)
+	}
+	return cbcMAC(block, mac, plaintext)[:ccmTagLen]
+}
+
+// cbcMAC continues a CBC-MAC over the concatenation of parts, zero padded to a whole number of blocks.
+func cbcMAC(block cipher.Block, mac []byte, parts ...[]byte) []byte {
+	var data []byte
+	for _, part := range parts {
+		data = append(data, part...)
+	}
+	for i := 0; i < len(data); i += aes.BlockSize {
+		for j, b := range data[i:min(i+aes.BlockSize, len(data))] {
+			mac[j] ^= b
+		}
+		block.Encrypt(mac, mac)
+	}
+	return mac
+}
+
+// ccmCTR encrypts or decrypts src into dst with the counter blocks following the one used for the tag.
+func ccmCTR(block cipher.Block, nonce, dst, src []byte) {
+	cipher.NewCTR(block, ccmCounter(nonce, 1)).XORKeyStream(dst[:len(src)], src)
+}
+
+// ccmEncryptTag encrypts the tag into dst with the first counter block.
+func ccmEncryptTag(block cipher.Block, nonce, dst, tag []byte) {
+	s0 := ccmCounter(nonce, 0)
+	block.Encrypt(s0, s0)
+	subtle.XORBytes(dst, tag, s0[:ccmTagLen])
+}
+
+// ccmCounter returns the counter block with the given index.
+func ccmCounter(nonce []byte, i uint16) []byte {
+	ctr := make([]byte, aes.BlockSize)
+	ctr[0] = ccmLengthLen - 1
+	copy(ctr[1:], nonce)
+	binary.BigEndian.PutUint16(ctr[aes.BlockSize-ccmLengthLen:], i)
+	return ctr
+}
@@ -0,0 +1,36 @@
+package radio
+
+import (
+	"crypto/aes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func mustDecodeHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	require.NoError(t, err)
+	return b
+}
+
+// TestCCM checks the implementation against packet vector #1 of RFC 3610.
+func TestCCM(t *testing.T) {
+	block, err := aes.NewCipher(mustDecodeHex(t, "c0c1c2c3c4c5c6c7c8c9cacbcccdcecf"))
+	require.NoError(t, err)
+	nonce := mustDecodeHex(t, "00000003020100a0a1a2a3a4a5")
+	aad := mustDecodeHex(t, "0001020304050607")
+	plaintext := mustDecodeHex(t, "08090a0b0c0d0e0f101112131415161718191a1b1c1d1e")
+	want := mustDecodeHex(t, "588c979a61c663d2f066d0c2c0f989806d5f6b61dac38417e8d12cfdf926e0")
+
+	sealed := ccmSeal(block, nonce, plaintext, aad)
+	require.Equal(t, want, sealed)
+	opened, err := ccmOpen(block, nonce, sealed, aad)
+	require.NoError(t, err)
+	require.Equal(t, plaintext, opened)
+
+	sealed[0] ^= 1
+	_, err = ccmOpen(block, nonce, sealed, aad)
+	require.ErrorIs(t, err, errCCMAuth)
+}
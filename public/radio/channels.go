@@ -0,0 +1,162 @@
+package radio
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/rabarar/meshtastic"
+)
+
+// presetDisplayNames are the names the firmware gives each modem preset, which are used for channels left unnamed.
+var presetDisplayNames = map[meshtastic.Config_LoRaConfig_ModemPreset]string{
+	meshtastic.Config_LoRaConfig_SHORT_TURBO:    "ShortTurbo",
+	meshtastic.Config_LoRaConfig_SHORT_FAST:     "ShortFast",
+	meshtastic.Config_LoRaConfig_SHORT_SLOW:     "ShortSlow",
+	meshtastic.Config_LoRaConfig_MEDIUM_FAST:    "MediumFast",
+	meshtastic.Config_LoRaConfig_MEDIUM_SLOW:    "MediumSlow",
+	meshtastic.Config_LoRaConfig_LONG_FAST:      "LongFast",
+	meshtastic.Config_LoRaConfig_LONG_MODERATE:  "LongMod",
+	meshtastic.Config_LoRaConfig_LONG_SLOW:      "LongSlow",
+	meshtastic.Config_LoRaConfig_VERY_LONG_SLOW: "VLongSlow",
+}
+
+// PresetChannelName returns the name the firmware uses for an unnamed channel on a radio with the given LoRa config.
+// This is the display name of the modem preset, such as "LongFast", or "Custom" if the radio uses custom modem
+// settings rather than a preset. As in lora.ModulationForConfig, a config without custom settings, including a nil
+// one, uses its preset, and unknown presets fall back to LONG_FAST.
+func PresetChannelName(cfg *meshtastic.Config_LoRaConfig) string {
+	if !cfg.GetUsePreset() && cfg.GetSpreadFactor() != 0 && cfg.GetBandwidth() != 0 && cfg.GetCodingRate() != 0 {
+		return "Custom"
+	}
+	if name, ok := presetDisplayNames[cfg.GetModemPreset()]; ok {
+		return name
+	}
+	return presetDisplayNames[meshtastic.Config_LoRaConfig_LONG_FAST]
+}
+
+// Channel is a channel configured on a radio, along with its role.
+type Channel struct {
+	// Index is the position of the channel in the radio's channel list.
+	Index int
+	// Name is the channel's name. Unnamed channels are given the name of the radio's modem preset, see
+	// PresetChannelName.
+	Name string
+	// PSK is the expanded key for the channel. It is empty if the channel is unencrypted.
+	PSK  []byte
+	Role meshtastic.Channel_Role
+}
+
+// IsPrimary reports whether the channel is the radio's primary channel.
+func (c Channel) IsPrimary() bool {
+	return c.Role == meshtastic.Channel_PRIMARY
+}
+
+// Channels is the set of channels configured on a radio. Exactly one channel is primary, which is the channel the
+// radio uses for its own traffic such as NodeInfo and position broadcasts.
+type Channels struct {
+	channels []Channel
+}
+
+// NewChannels creates Channels from a ChannelSet, as found in a channel URL. The first channel in the set is the
+// primary channel and the remainder are secondary channels. Unnamed channels are named after the set's LoRa config.
+func NewChannels(set *meshtastic.ChannelSet) (*Channels, error) {
+	settings := set.GetSettings()
+	if len(settings) == 0 {
+		return nil, errors.New("channel set contains no channels")
+	}
+	c := &Channels{}
+	for i, s := range settings {
+		role := meshtastic.Channel_SECONDARY
+		if i == 0 {
+			role = meshtastic.Channel_PRIMARY
+		}
+		c.channels = append(c.channels, newChannel(i, s, role, set.GetLoraConfig()))
+	}
+	return c, nil
+}
+
+// NewChannelsFromDevice creates Channels from the channel list reported by a radio, which includes the role of each
+// channel. Disabled channels are skipped. Unnamed channels are named after the radio's LoRa config, which may be nil
+// if it is not known.
+func NewChannelsFromDevice(channels []*meshtastic.Channel, lora *meshtastic.Config_LoRaConfig) (*Channels, error) {
+	c := &Channels{}
+	primaries := 0
+	for _, ch := range channels {
+		if ch.GetRole() == meshtastic.Channel_DISABLED {
+			continue
+		}
+		if ch.GetRole() == meshtastic.Channel_PRIMARY {
+			primaries++
+		}
+		c.channels = append(c.channels, newChannel(int(ch.GetIndex()), ch.GetSettings(), ch.GetRole(), lora))
+	}
+	if primaries != 1 {
+		return nil, errors.New("channel list must contain exactly one primary channel")
+	}
+	return c, nil
+}
+
+func newChannel(index int, settings *meshtastic.ChannelSettings, role meshtastic.Channel_Role, lora *meshtastic.Config_LoRaConfig) Channel {
+	psk := ExpandKey(bytes.Clone(settings.GetPsk()))
+	name := settings.GetName()
+	if name == "" {
+		name = PresetChannelName(lora)
+	}
+	return Channel{
+		Index: index,
+		Name:  name,
+		PSK:   psk,
+		Role:  role,
+	}
+}
+
+// Primary returns the primary channel.
+func (c *Channels) Primary() Channel {
+	for _, ch := range c.channels {
+		if ch.IsPrimary() {
+			return ch
+		}
+	}
+	// Both constructors guarantee a primary channel.
+	panic("radio: Channels has no primary channel")
+}
+
+// PrimaryPSK returns the expanded key for the primary channel.
+func (c *Channels) PrimaryPSK() []byte {
+	return c.Primary().PSK
+}
+
+// ByName returns the channel with the given name.
+func (c *Channels) ByName(name string) (Channel, bool) {
+	for _, ch := range c.channels {
+		if ch.Name == name {
+			return ch, true
+		}
+	}
+	return Channel{}, false
+}
+
+// ByIndex returns the channel with the given index, as used by MeshPacket.Channel for packets sent by a client.
+func (c *Channels) ByIndex(index int) (Channel, bool) {
+	for _, ch := range c.channels {
+		if ch.Index == index {
+			return ch, true
+		}
+	}
+	return Channel{}, false
+}
+
+// All returns all channels, ordered by index.
+func (c *Channels) All() []Channel {
+	return append([]Channel(nil), c.channels...)
+}
+
+// Keyring returns a keyring containing the default channel keys along with the key of each channel, for decoding
+// packets on any of them.
+func (c *Channels) Keyring() *Something {
+	keys := make(map[string][]byte, len(c.channels))
+	for _, ch := range c.channels {
+		keys[ch.Name] = ch.PSK
+	}
+	return NewThing(keys)
+}
@@ -0,0 +1,129 @@
+package radio
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/rabarar/meshtastic"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewChannels(t *testing.T) {
+	secret := []byte("0123456789abcdef")
+	channels, err := NewChannels(&meshtastic.ChannelSet{
+		Settings: []*meshtastic.ChannelSettings{
+			{Name: "LongFast", Psk: []byte{1}},
+			{Name: "Private", Psk: secret},
+		},
+	})
+	require.NoError(t, err)
+
+	primary := channels.Primary()
+	require.Equal(t, "LongFast", primary.Name)
+	require.True(t, primary.IsPrimary())
+	require.Equal(t, DefaultKey, channels.PrimaryPSK())
+
+	private, ok := channels.ByName("Private")
+	require.True(t, ok)
+	require.Equal(t, 1, private.Index)
+	require.Equal(t, meshtastic.Channel_SECONDARY, private.Role)
+	require.Equal(t, secret, private.PSK)
+
+	_, ok = channels.ByName("Missing")
+	require.False(t, ok)
+
+	byIndex, ok := channels.ByIndex(1)
+	require.True(t, ok)
+	require.Equal(t, private, byIndex)
+	_, ok = channels.ByIndex(2)
+	require.False(t, ok)
+
+	key, ok := channels.Keyring().Key("Private")
+	require.True(t, ok)
+	require.Equal(t, secret, key)
+}
+
+func TestNewChannels_ExpandsPSK(t *testing.T) {
+	psk24 := bytes.Repeat([]byte{0x5a}, 24)
+	tests := []struct {
+		name string
+		psk  []byte
+		want []byte
+	}{
+		{name: "1-byte", psk: []byte{1}, want: DefaultKey},
+		{name: "short", psk: []byte{0xaa, 0xbb}, want: append([]byte{0xaa, 0xbb}, make([]byte, 14)...)},
+		{name: "24 bytes", psk: psk24, want: append(bytes.Clone(psk24), make([]byte, 8)...)},
+		{name: "unencrypted", psk: []byte{}, want: []byte{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			channels, err := NewChannels(&meshtastic.ChannelSet{
+				Settings: []*meshtastic.ChannelSettings{{Name: "Test", Psk: tt.psk}},
+			})
+			require.NoError(t, err)
+			require.Equal(t, tt.want, channels.PrimaryPSK())
+		})
+	}
+}
+
+func TestNewChannels_Empty(t *testing.T) {
+	_, err := NewChannels(&meshtastic.ChannelSet{})
+	require.Error(t, err)
+}
+
+func TestNewChannels_Unnamed(t *testing.T) {
+	tests := []struct {
+		name string
+		lora *meshtastic.Config_LoRaConfig
+		want string
+	}{
+		{name: "no LoRa config", want: "LongFast"},
+		{
+			name: "preset",
+			lora: &meshtastic.Config_LoRaConfig{UsePreset: true, ModemPreset: meshtastic.Config_LoRaConfig_MEDIUM_SLOW},
+			want: "MediumSlow",
+		},
+		{
+			name: "custom",
+			lora: &meshtastic.Config_LoRaConfig{SpreadFactor: 10, Bandwidth: 250, CodingRate: 5},
+			want: "Custom",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			channels, err := NewChannels(&meshtastic.ChannelSet{
+				Settings:   []*meshtastic.ChannelSettings{{Psk: []byte{1}}, {Name: "Private", Psk: []byte{2}}},
+				LoraConfig: tt.lora,
+			})
+			require.NoError(t, err)
+			require.Equal(t, tt.want, channels.Primary().Name)
+			_, ok := channels.ByName(tt.want)
+			require.True(t, ok)
+			// Named channels keep their name.
+			_, ok = channels.ByName("Private")
+			require.True(t, ok)
+		})
+	}
+
+	channels, err := NewChannelsFromDevice([]*meshtastic.Channel{
+		{Index: 0, Role: meshtastic.Channel_PRIMARY, Settings: &meshtastic.ChannelSettings{Psk: []byte{1}}},
+	}, &meshtastic.Config_LoRaConfig{UsePreset: true, ModemPreset: meshtastic.Config_LoRaConfig_SHORT_FAST})
+	require.NoError(t, err)
+	require.Equal(t, "ShortFast", channels.Primary().Name)
+}
+
+func TestNewChannelsFromDevice(t *testing.T) {
+	channels, err := NewChannelsFromDevice([]*meshtastic.Channel{
+		{Index: 0, Role: meshtastic.Channel_SECONDARY, Settings: &meshtastic.ChannelSettings{Name: "Second"}},
+		{Index: 1, Role: meshtastic.Channel_PRIMARY, Settings: &meshtastic.ChannelSettings{Name: "First"}},
+		{Index: 2, Role: meshtastic.Channel_DISABLED},
+	}, nil)
+	require.NoError(t, err)
+	require.Equal(t, "First", channels.Primary().Name)
+	require.Len(t, channels.All(), 2)
+
+	_, err = NewChannelsFromDevice([]*meshtastic.Channel{
+		{Index: 0, Role: meshtastic.Channel_SECONDARY},
+	}, nil)
+	require.Error(t, err)
+}
@@ -0,0 +1,137 @@
+package radio
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/rabarar/meshtastic"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// payloadType describes the payload carried on a portnum.
+type payloadType struct {
+	portnum meshtastic.PortNum
+	// newMessage returns an empty message of the payload's type.
+	newMessage func() proto.Message
+	// text is true if the payload is plain UTF-8 text rather than a marshalled protobuf. These payloads are
+	// represented as a *wrapperspb.StringValue.
+	text bool
+}
+
+// payloadTypes is the registry of payload types by portnum. Where several portnums share a type, the first is used
+// by PortNumFor.
+var payloadTypes = []payloadType{
+	{portnum: meshtastic.PortNum_TEXT_MESSAGE_APP, newMessage: newStringValue, text: true},
+	{portnum: meshtastic.PortNum_DETECTION_SENSOR_APP, newMessage: newStringValue, text: true},
+	{portnum: meshtastic.PortNum_ALERT_APP, newMessage: newStringValue, text: true},
+	{portnum: meshtastic.PortNum_RANGE_TEST_APP, newMessage: newStringValue, text: true},
+	{portnum: meshtastic.PortNum_REMOTE_HARDWARE_APP, newMessage: func() proto.Message { return &meshtastic.HardwareMessage{} }},
+	{portnum: meshtastic.PortNum_POSITION_APP, newMessage: func() proto.Message { return &meshtastic.Position{} }},
+	{portnum: meshtastic.PortNum_NODEINFO_APP, newMessage: func() proto.Message { return &meshtastic.User{} }},
+	{portnum: meshtastic.PortNum_ROUTING_APP, newMessage: func() proto.Message { return &meshtastic.Routing{} }},
+	{portnum: meshtastic.PortNum_ADMIN_APP, newMessage: func() proto.Message { return &meshtastic.AdminMessage{} }},
+	{portnum: meshtastic.PortNum_WAYPOINT_APP, newMessage: func() proto.Message { return &meshtastic.Waypoint{} }},
+	{portnum: meshtastic.PortNum_PAXCOUNTER_APP, newMessage: func() proto.Message { return &meshtastic.Paxcount{} }},
+	{portnum: meshtastic.PortNum_STORE_FORWARD_APP, newMessage: func() proto.Message { return &meshtastic.StoreAndForward{} }},
+	{portnum: meshtastic.PortNum_TELEMETRY_APP, newMessage: func() proto.Message { return &meshtastic.Telemetry{} }},
+	{portnum: meshtastic.PortNum_TRACEROUTE_APP, newMessage: func() proto.Message { return &meshtastic.RouteDiscovery{} }},
+	{portnum: meshtastic.PortNum_NEIGHBORINFO_APP, newMessage: func() proto.Message { return &meshtastic.NeighborInfo{} }},
+	{portnum: meshtastic.PortNum_MAP_REPORT_APP, newMessage: func() proto.Message { return &meshtastic.MapReport{} }},
+}
+
+func newStringValue() proto.Message {
+	return &wrapperspb.StringValue{}
+}
+
+func lookupPayloadType(portnum meshtastic.PortNum) (payloadType, bool) {
+	for _, t := range payloadTypes {
+		if t.portnum == portnum {
+			return t, true
+		}
+	}
+	return payloadType{}, false
+}
+
+// PayloadTypeFor returns an empty message of the type carried on portnum, as returned by DecodeData. Text payloads
+// are represented as a *wrapperspb.StringValue.
+func PayloadTypeFor(portnum meshtastic.PortNum) (proto.Message, bool) {
+	t, ok := lookupPayloadType(portnum)
+	if !ok {
+		return nil, false
+	}
+	return t.newMessage(), true
+}
+
+// PortNumFor returns the portnum used to send msg. A *wrapperspb.StringValue is sent as a TEXT_MESSAGE_APP.
+func PortNumFor(msg proto.Message) (meshtastic.PortNum, bool) {
+	name := msg.ProtoReflect().Descriptor().FullName()
+	for _, t := range payloadTypes {
+		if t.newMessage().ProtoReflect().Descriptor().FullName() == name {
+			return t.portnum, true
+		}
+	}
+	return 0, false
+}
+
+// EncodePayload marshals msg as the payload for its portnum, see PortNumFor.
+func EncodePayload(msg proto.Message) (meshtastic.PortNum, []byte, error) {
+	portnum, ok := PortNumFor(msg)
+	if !ok {
+		return 0, nil, fmt.Errorf("%w: %s", ErrUnkownPayloadType, msg.ProtoReflect().Descriptor().FullName())
+	}
+	if s, ok := msg.(*wrapperspb.StringValue); ok {
+		return portnum, []byte(s.GetValue()), nil
+	}
+	payload, err := proto.Marshal(msg)
+	if err != nil {
+		return 0, nil, fmt.Errorf("marshalling %s payload: %w", portnum, err)
+	}
+	return portnum, payload, nil
+}
+
+// DecodeData unmarshals the payload of a Data protobuf into the concrete message type for its portnum.
+// Payloads which are plain UTF-8 text, such as TEXT_MESSAGE_APP, are returned as a *wrapperspb.StringValue.
+// ErrUnkownPayloadType is returned for portnums which are not understood.
+func DecodeData(data *meshtastic.Data) (proto.Message, error) {
+	t, ok := lookupPayloadType(data.GetPortnum())
+	if !ok {
+		return nil, ErrUnkownPayloadType
+	}
+	if t.text {
+		return wrapperspb.String(string(data.GetPayload())), nil
+	}
+	msg := t.newMessage()
+	if err := proto.Unmarshal(data.GetPayload(), msg); err != nil {
+		return nil, fmt.Errorf("unmarshalling %s payload: %w", data.GetPortnum(), err)
+	}
+	if position, ok := msg.(*meshtastic.Position); ok {
+		normalizePosition(position)
+	}
+	return msg, nil
+}
+
+// normalizePosition fills in the Time and Timestamp fields of a Position from each other. Older firmware only sets
+// Time, while newer firmware sets Timestamp to the time of the GPS fix and usually omits Time.
+func normalizePosition(position *meshtastic.Position) {
+	if position.Timestamp == 0 {
+		position.Timestamp = position.Time
+	}
+	if position.Time == 0 {
+		position.Time = position.Timestamp
+	}
+}
+
+// PositionTime returns the authoritative time of a Position. This is the time of the GPS fix if present, otherwise
+// the time the position was sent. The zero time is returned if neither is set.
+func PositionTime(position *meshtastic.Position) time.Time {
+	switch {
+	case position.GetTimestamp() != 0:
+		return time.Unix(int64(position.GetTimestamp()), 0).
+			Add(time.Duration(position.GetTimestampMillisAdjust()) * time.Millisecond)
+	case position.GetTime() != 0:
+		return time.Unix(int64(position.GetTime()), 0)
+	default:
+		return time.Time{}
+	}
+}
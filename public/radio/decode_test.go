@@ -0,0 +1,87 @@
+package radio
+
+import (
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/rabarar/meshtastic"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestDecodeData_Position(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload string
+	}{
+		{
+			// Older firmware sends lat/lon/alt and the time the position was sent.
+			name:    "time only",
+			payload: "0d6880b21e157c8aeaff18022500f15365",
+		},
+		{
+			// Newer firmware sends the timestamp of the GPS fix along with the location source and precision.
+			name:    "timestamp with location source",
+			payload: "0d6880b21e157c8aeaff180228023d00f15365b80120",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload, err := hex.DecodeString(tt.payload)
+			require.NoError(t, err)
+			msg, err := DecodeData(&meshtastic.Data{
+				Portnum: meshtastic.PortNum_POSITION_APP,
+				Payload: payload,
+			})
+			require.NoError(t, err)
+			position, ok := msg.(*meshtastic.Position)
+			require.True(t, ok)
+			require.Equal(t, int32(515014760), position.GetLatitudeI())
+			require.Equal(t, int32(-1406340), position.GetLongitudeI())
+			require.Equal(t, int32(2), position.GetAltitude())
+			require.Equal(t, uint32(1700000000), position.Time)
+			require.Equal(t, uint32(1700000000), position.Timestamp)
+			require.Equal(t, time.Unix(1700000000, 0), PositionTime(position))
+		})
+	}
+}
+
+func TestDecodeData_UnknownPortnum(t *testing.T) {
+	_, err := DecodeData(&meshtastic.Data{Portnum: meshtastic.PortNum_PRIVATE_APP})
+	require.ErrorIs(t, err, ErrUnkownPayloadType)
+}
+
+func TestPayloadRegistry_RoundTrip(t *testing.T) {
+	for _, pt := range payloadTypes {
+		msg, ok := PayloadTypeFor(pt.portnum)
+		require.True(t, ok, pt.portnum)
+		portnum, ok := PortNumFor(msg)
+		require.True(t, ok, pt.portnum)
+		if pt.text {
+			// All text payloads are sent as text messages.
+			require.Equal(t, meshtastic.PortNum_TEXT_MESSAGE_APP, portnum)
+			continue
+		}
+		require.Equal(t, pt.portnum, portnum)
+	}
+}
+
+func TestEncodePayload(t *testing.T) {
+	position := &meshtastic.Position{LatitudeI: proto.Int32(515000000), Time: 1700000000}
+	portnum, payload, err := EncodePayload(position)
+	require.NoError(t, err)
+	require.Equal(t, meshtastic.PortNum_POSITION_APP, portnum)
+	decoded, err := DecodeData(&meshtastic.Data{Portnum: portnum, Payload: payload})
+	require.NoError(t, err)
+	require.Equal(t, position.GetLatitudeI(), decoded.(*meshtastic.Position).GetLatitudeI())
+
+	portnum, payload, err = EncodePayload(wrapperspb.String("hello"))
+	require.NoError(t, err)
+	require.Equal(t, meshtastic.PortNum_TEXT_MESSAGE_APP, portnum)
+	require.Equal(t, []byte("hello"), payload)
+
+	_, _, err = EncodePayload(&meshtastic.MeshPacket{})
+	require.ErrorIs(t, err, ErrUnkownPayloadType)
+}
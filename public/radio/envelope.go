@@ -0,0 +1,36 @@
+package radio
+
+import (
+	"fmt"
+
+	"github.com/rabarar/meshtastic"
+	"google.golang.org/protobuf/proto"
+)
+
+// DecodeEnvelope unmarshals a ServiceEnvelope published to MQTT and decodes its packet, decrypting it with the key in
+// keys for the envelope's channel ID. Keys are passed through ExpandKey, so a PSK may be given in its short form.
+//
+// Busy MQTT servers carry traffic for many channels, so ErrNoKey is returned for encrypted packets on channels keys
+// has no entry for. The channel and packet are returned along with ErrNoKey and ErrDecrypt, so that callers may still
+// relay or log the packet.
+func DecodeEnvelope(
+	payload []byte, keys map[string][]byte,
+) (channel string, data *meshtastic.Data, packet *meshtastic.MeshPacket, err error) {
+	envelope := &meshtastic.ServiceEnvelope{}
+	if err := proto.Unmarshal(payload, envelope); err != nil {
+		return "", nil, nil, fmt.Errorf("unmarshalling service envelope: %w", err)
+	}
+	channel, packet = envelope.ChannelId, envelope.Packet
+	if packet == nil {
+		return channel, nil, nil, fmt.Errorf("service envelope contains no packet")
+	}
+	key, ok := keys[channel]
+	if _, encrypted := packet.PayloadVariant.(*meshtastic.MeshPacket_Encrypted); encrypted && !ok {
+		return channel, nil, packet, fmt.Errorf("%w %q", ErrNoKey, channel)
+	}
+	data, err = TryDecode(packet, key)
+	if err != nil {
+		return channel, nil, packet, err
+	}
+	return channel, data, packet, nil
+}
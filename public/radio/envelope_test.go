@@ -0,0 +1,89 @@
+package radio
+
+import (
+	"testing"
+
+	"github.com/rabarar/meshtastic"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestDecodeEnvelope(t *testing.T) {
+	data := &meshtastic.Data{Portnum: meshtastic.PortNum_TEXT_MESSAGE_APP, Payload: []byte("hi")}
+	plaintext, err := proto.Marshal(data)
+	require.NoError(t, err)
+	encrypted, err := Encrypt(plaintext, DefaultKey, 7, 0x1234)
+	require.NoError(t, err)
+	envelope := func(channel string, packet *meshtastic.MeshPacket) []byte {
+		b, err := proto.Marshal(&meshtastic.ServiceEnvelope{ChannelId: channel, GatewayId: "!00001234", Packet: packet})
+		require.NoError(t, err)
+		return b
+	}
+	encryptedPacket := &meshtastic.MeshPacket{
+		Id:             7,
+		From:           0x1234,
+		PayloadVariant: &meshtastic.MeshPacket_Encrypted{Encrypted: encrypted},
+	}
+	keys := map[string][]byte{"LongFast": {0x01}, "Other": []byte("0123456789abcdef")}
+
+	tests := []struct {
+		name        string
+		payload     []byte
+		wantChannel string
+		wantData    *meshtastic.Data
+		wantPacket  bool
+		wantErr     error
+	}{
+		{
+			name:        "encrypted",
+			payload:     envelope("LongFast", encryptedPacket),
+			wantChannel: "LongFast",
+			wantData:    data,
+			wantPacket:  true,
+		},
+		{
+			name: "decoded",
+			payload: envelope("Unknown", &meshtastic.MeshPacket{
+				Id:             7,
+				PayloadVariant: &meshtastic.MeshPacket_Decoded{Decoded: data},
+			}),
+			wantChannel: "Unknown",
+			wantData:    data,
+			wantPacket:  true,
+		},
+		{
+			name:        "no key",
+			payload:     envelope("Unknown", encryptedPacket),
+			wantChannel: "Unknown",
+			wantPacket:  true,
+			wantErr:     ErrNoKey,
+		},
+		{
+			name:        "wrong key",
+			payload:     envelope("Other", encryptedPacket),
+			wantChannel: "Other",
+			wantPacket:  true,
+			wantErr:     ErrDecrypt,
+		},
+		{
+			name:    "not an envelope",
+			payload: []byte{0xff},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			channel, gotData, packet, err := DecodeEnvelope(tt.payload, keys)
+			require.Equal(t, tt.wantChannel, channel)
+			require.Equal(t, tt.wantPacket, packet != nil)
+			if tt.wantData == nil {
+				require.Error(t, err)
+				if tt.wantErr != nil {
+					require.ErrorIs(t, err, tt.wantErr)
+				}
+				return
+			}
+			require.NoError(t, err)
+			require.True(t, proto.Equal(tt.wantData, gotData))
+		})
+	}
+}
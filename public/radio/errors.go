@@ -4,3 +4,12 @@ import "errors"
 
 var ErrUnkownPayloadType = errors.New("unknown payload type")
 var ErrDecrypt = errors.New("unable to decrypt payload")
+
+// ErrNoKey is returned by DecodeEnvelope for an encrypted packet on a channel it was not given a key for.
+var ErrNoKey = errors.New("no key for channel")
+
+// ErrPayloadTooLarge is returned when encrypting or decrypting a payload longer than MaxPayloadLen.
+var ErrPayloadTooLarge = errors.New("payload too large")
+
+// ErrNoPublicKey is returned when decoding a PKI encrypted packet without our private key or the sender's public key.
+var ErrNoPublicKey = errors.New("no public key for sender")
@@ -0,0 +1,106 @@
+package radio
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/rabarar/meshtastic"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// PKIChannel is the channel ID PKI encrypted packets are published under on MQTT, as they are not encrypted with a
+	// channel key.
+	PKIChannel = "PKI"
+	// PKIOverhead is the number of bytes PKI encryption adds to a payload: an 8 byte authentication tag followed by a
+	// 4 byte random nonce.
+	PKIOverhead = ccmTagLen + 4
+)
+
+// GenerateKeyPair generates a Curve25519 private key for PKI encryption, along with its public key. Radios running
+// firmware 2.5 or later publish their public key in User.PublicKey.
+func GenerateKeyPair() (privateKey, publicKey []byte, err error) {
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	return key.Bytes(), key.PublicKey().Bytes(), nil
+}
+
+// EncryptPKI encrypts a plaintext payload, typically a marshalled Data protobuf, as a direct message from fromNode to
+// the node with the given public key, as done by firmware 2.5 and later. The packet sending it should have
+// PkiEncrypted set.
+func EncryptPKI(plaintext, privateKey, publicKey []byte, packetID, fromNode uint32) ([]byte, error) {
+	if len(plaintext)+PKIOverhead > MaxPayloadLen {
+		return nil, fmt.Errorf("%w: %d > %d", ErrPayloadTooLarge, len(plaintext)+PKIOverhead, MaxPayloadLen)
+	}
+	block, err := pkiCipher(privateKey, publicKey)
+	if err != nil {
+		return nil, err
+	}
+	var extraNonce [4]byte
+	if _, err := rand.Read(extraNonce[:]); err != nil {
+		return nil, fmt.Errorf("generating nonce: %w", err)
+	}
+	nonce := pkiNonce(packetID, fromNode, extraNonce[:])
+	return append(ccmSeal(block, nonce, plaintext, nil), extraNonce[:]...), nil
+}
+
+// DecryptPKI decrypts a PKI encrypted direct message, using our private key and the public key of the sending node.
+// ErrDecrypt is returned if the packet was not encrypted for us by the holder of publicKey.
+func DecryptPKI(packet *meshtastic.MeshPacket, privateKey, publicKey []byte) (*meshtastic.Data, error) {
+	encrypted := packet.GetEncrypted()
+	if len(encrypted) > MaxPayloadLen {
+		return nil, fmt.Errorf("%w: %d > %d", ErrPayloadTooLarge, len(encrypted), MaxPayloadLen)
+	}
+	if len(encrypted) < PKIOverhead {
+		return nil, ErrDecrypt
+	}
+	block, err := pkiCipher(privateKey, publicKey)
+	if err != nil {
+		return nil, err
+	}
+	sealed, extraNonce := encrypted[:len(encrypted)-4], encrypted[len(encrypted)-4:]
+	plaintext, err := ccmOpen(block, pkiNonce(packet.Id, packet.From, extraNonce), sealed, nil)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	data := &meshtastic.Data{}
+	if err := proto.Unmarshal(plaintext, data); err != nil {
+		return nil, ErrDecrypt
+	}
+	return data, nil
+}
+
+// pkiCipher returns the AES-256 cipher keyed with the SHA-256 hash of the shared secret of the two keys.
+func pkiCipher(privateKey, publicKey []byte) (cipher.Block, error) {
+	private, err := ecdh.X25519().NewPrivateKey(privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("parsing private key: %w", err)
+	}
+	public, err := ecdh.X25519().NewPublicKey(publicKey)
+	if err != nil {
+		return nil, fmt.Errorf("parsing public key: %w", err)
+	}
+	shared, err := private.ECDH(public)
+	if err != nil {
+		return nil, fmt.Errorf("computing shared secret: %w", err)
+	}
+	key := sha256.Sum256(shared)
+	return aes.NewCipher(key[:])
+}
+
+// pkiNonce builds the nonce in the same way as the firmware: the packet ID as 8 bytes with the upper 4 replaced by
+// the random extra nonce, followed by the sending node, all little endian.
+func pkiNonce(packetID, fromNode uint32, extraNonce []byte) []byte {
+	nonce := make([]byte, ccmNonceLen)
+	binary.LittleEndian.PutUint32(nonce, packetID)
+	copy(nonce[4:8], extraNonce)
+	binary.LittleEndian.PutUint32(nonce[8:], fromNode)
+	return nonce
+}
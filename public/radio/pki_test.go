@@ -0,0 +1,110 @@
+package radio
+
+import (
+	"testing"
+
+	"github.com/rabarar/meshtastic"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+func pkiTestPacket(t *testing.T, senderPrivate, recipientPublic []byte, data *meshtastic.Data) *meshtastic.MeshPacket {
+	t.Helper()
+	plaintext, err := proto.Marshal(data)
+	require.NoError(t, err)
+	encrypted, err := EncryptPKI(plaintext, senderPrivate, recipientPublic, 99, 0x1234)
+	require.NoError(t, err)
+	require.Len(t, encrypted, len(plaintext)+PKIOverhead)
+	return &meshtastic.MeshPacket{
+		Id:             99,
+		From:           0x1234,
+		To:             0x5678,
+		PkiEncrypted:   true,
+		PayloadVariant: &meshtastic.MeshPacket_Encrypted{Encrypted: encrypted},
+	}
+}
+
+func TestDecryptPKI(t *testing.T) {
+	senderPrivate, senderPublic, err := GenerateKeyPair()
+	require.NoError(t, err)
+	recipientPrivate, recipientPublic, err := GenerateKeyPair()
+	require.NoError(t, err)
+	_, otherPublic, err := GenerateKeyPair()
+	require.NoError(t, err)
+	data := &meshtastic.Data{Portnum: meshtastic.PortNum_TEXT_MESSAGE_APP, Payload: []byte("hello")}
+
+	tests := []struct {
+		name       string
+		privateKey []byte
+		publicKey  []byte
+		modify     func(packet *meshtastic.MeshPacket)
+		wantErr    error
+	}{
+		{name: "recipient", privateKey: recipientPrivate, publicKey: senderPublic},
+		// The shared secret is the same from either side.
+		{name: "sender", privateKey: senderPrivate, publicKey: recipientPublic},
+		{name: "wrong sender", privateKey: recipientPrivate, publicKey: otherPublic, wantErr: ErrDecrypt},
+		{
+			name:       "wrong packet ID",
+			privateKey: recipientPrivate,
+			publicKey:  senderPublic,
+			modify:     func(packet *meshtastic.MeshPacket) { packet.Id++ },
+			wantErr:    ErrDecrypt,
+		},
+		{
+			name:       "tampered",
+			privateKey: recipientPrivate,
+			publicKey:  senderPublic,
+			modify:     func(packet *meshtastic.MeshPacket) { packet.GetEncrypted()[0] ^= 1 },
+			wantErr:    ErrDecrypt,
+		},
+		{
+			name:       "truncated",
+			privateKey: recipientPrivate,
+			publicKey:  senderPublic,
+			modify: func(packet *meshtastic.MeshPacket) {
+				packet.PayloadVariant = &meshtastic.MeshPacket_Encrypted{Encrypted: packet.GetEncrypted()[:PKIOverhead-1]}
+			},
+			wantErr: ErrDecrypt,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			packet := pkiTestPacket(t, senderPrivate, recipientPublic, data)
+			if tt.modify != nil {
+				tt.modify(packet)
+			}
+			decoded, err := DecryptPKI(packet, tt.privateKey, tt.publicKey)
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.True(t, proto.Equal(data, decoded))
+		})
+	}
+}
+
+func TestSomething_Decode_PKI(t *testing.T) {
+	senderPrivate, senderPublic, err := GenerateKeyPair()
+	require.NoError(t, err)
+	recipientPrivate, recipientPublic, err := GenerateKeyPair()
+	require.NoError(t, err)
+	data := &meshtastic.Data{Portnum: meshtastic.PortNum_TEXT_MESSAGE_APP, Payload: []byte("hello")}
+	packet := pkiTestPacket(t, senderPrivate, recipientPublic, data)
+
+	keys := NewThing(nil)
+	_, _, err = keys.Decode(packet)
+	require.ErrorIs(t, err, ErrNoPublicKey)
+
+	keys.SetPrivateKey(recipientPrivate)
+	keys.AddUser(0x1234, &meshtastic.User{Id: "!00001234"})
+	_, _, err = keys.Decode(packet)
+	require.ErrorIs(t, err, ErrNoPublicKey)
+
+	keys.AddUser(0x1234, &meshtastic.User{Id: "!00001234", PublicKey: senderPublic})
+	channel, decoded, err := keys.Decode(packet)
+	require.NoError(t, err)
+	require.Equal(t, PKIChannel, channel)
+	require.True(t, proto.Equal(data, decoded))
+}
@@ -1,9 +1,10 @@
 package radio
 
 import (
+	"bytes"
 	"encoding/base64"
-	"encoding/hex"
 	"fmt"
+	"strings"
 
 	"github.com/charmbracelet/log"
 	"github.com/rabarar/meshtastic"
@@ -18,17 +19,102 @@ const (
 	ComModeSerialDebug
 )
 
+// MaxPayloadLen is the maximum length of the encrypted payload of a MeshPacket, which is a marshalled Data protobuf.
+// It is the maximum LoRa packet length of 255 bytes less the 16 byte packet header.
+const MaxPayloadLen = 237
+
 // DefaultKey encryption key, commonly referenced as AQ==
 // as base64: 1PG7OiApB1nwvP+rz05pAQ==
 var DefaultKey = []byte{0xd4, 0xf1, 0xbb, 0x3a, 0x20, 0x29, 0x07, 0x59, 0xf0, 0xbc, 0xff, 0xab, 0xcf, 0x4e, 0x69, 0x01}
 
-// ParseKey converts the most common representation of a channel encryption key (URL encoded base64) to a byte slice
+// ParseKey converts the base64 representation of a channel encryption key to a byte slice. Both the standard and
+// URL-safe alphabets are accepted, with or without padding. Unlike NormalizeAndParsePSK, the key is returned as
+// decoded, without expansion or length checks.
 func ParseKey(key string) ([]byte, error) {
-	return base64.URLEncoding.DecodeString(key)
+	decoded, err := decodeBase64Key(key)
+	if err != nil {
+		return nil, fmt.Errorf("key %q is not valid base64: %w", key, err)
+	}
+	return decoded, nil
+}
+
+// KeyToString returns the URL-safe base64 form of a channel key, as used in Meshtastic channel URLs. It round-trips
+// with ParseKey.
+func KeyToString(key []byte) string {
+	return base64.URLEncoding.EncodeToString(key)
+}
+
+// KeyToStdString returns the standard base64 form of a channel key, as shown by the Meshtastic apps and CLI.
+func KeyToStdString(key []byte) string {
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+// decodeBase64Key decodes base64 in either the standard or URL-safe alphabet, with or without padding.
+func decodeBase64Key(s string) ([]byte, error) {
+	// Drop any padding and map the URL-safe alphabet onto the standard one so that either form decodes.
+	normalized := strings.TrimRight(strings.TrimSpace(s), "=")
+	normalized = strings.NewReplacer("-", "+", "_", "/").Replace(normalized)
+	return base64.RawStdEncoding.DecodeString(normalized)
+}
+
+// NormalizeAndParsePSK parses a PSK as typically pasted by a user. Both the standard and URL-safe base64 alphabets are
+// accepted, with or without padding. A single byte PSK such as the "AQ==" shorthand is expanded to the full key in the
+// same way as the firmware, and a PSK of "AA==" or "" indicates no encryption and returns an empty key.
+//
+// The decoded PSK must be 0, 1, 16, 24 or 32 bytes long.
+func NormalizeAndParsePSK(s string) ([]byte, error) {
+	psk, err := decodeBase64Key(s)
+	if err != nil {
+		return nil, fmt.Errorf("PSK %q is not valid base64: %w", s, err)
+	}
+	switch len(psk) {
+	case 0:
+		return []byte{}, nil
+	case 1:
+		return expandPSK(psk[0]), nil
+	case 16, 24, 32:
+		return psk, nil
+	default:
+		return nil, fmt.Errorf("PSK %q decodes to %d bytes, it must be 0, 1, 16, 24 or 32 bytes long", s, len(psk))
+	}
+}
+
+// ExpandKey derives the AES key used by the firmware from a channel PSK as configured:
+//   - an empty PSK means no encryption and is returned as is
+//   - a single byte PSK is an index into the default keys, see the "AQ==" shorthand in NormalizeAndParsePSK
+//   - a PSK shorter than 16 bytes is zero padded to 16 bytes, and one between 16 and 32 bytes is zero padded to 32
+//
+// 16 and 32 byte keys are returned unchanged. The firmware only uses AES-128 and AES-256, so a 24 byte PSK is padded
+// to 32 bytes for AES-256 rather than being used for AES-192 as XOR would with the unexpanded key.
+func ExpandKey(psk []byte) []byte {
+	switch {
+	case len(psk) == 0:
+		return psk
+	case len(psk) == 1:
+		return expandPSK(psk[0])
+	case len(psk) < 16:
+		return append(bytes.Clone(psk), make([]byte, 16-len(psk))...)
+	case len(psk) > 16 && len(psk) < 32:
+		return append(bytes.Clone(psk), make([]byte, 32-len(psk))...)
+	default:
+		return psk
+	}
+}
+
+// expandPSK expands a single byte PSK index to a full key. An index of 0 means no encryption, 1 is DefaultKey and
+// higher values are added to the last byte of DefaultKey.
+func expandPSK(index byte) []byte {
+	if index == 0 {
+		return []byte{}
+	}
+	key := bytes.Clone(DefaultKey)
+	key[len(key)-1] += index - 1
+	return key
 }
 
 // GenerateByteSlices creates a bunch of weak keys for use when interfacing on MQTT.
-// This creates 128, 192, and 256 bit AES keys with only a single byte specified
+// This creates 16, 24 and 32 byte keys with only a single byte specified. As in the firmware, 24 byte keys are padded
+// to 32 bytes for AES-256 when used, see ExpandKey.
 func GenerateByteSlices() [][]byte {
 	// There are 256 possible values for a single byte
 	// We create 1536 slices: 512 with 16 bytes, 512 with 24 bytes, and 512 with 32 bytes
@@ -69,66 +155,60 @@ func xorHash(p []byte) uint8 {
 	return code
 }
 
-// ChannelHash returns the hash for a given channel by XORing the channel name and PSK.
+// ChannelHash returns the hash for a given channel by XORing the channel name and PSK, as a uint32 for direct use as
+// MeshPacket.Channel. It is the same as ChannelNumber, and never returns an error.
+//
+// Deprecated: Use ChannelNumber.
 func ChannelHash(channelName string, channelKey []byte) (uint32, error) {
-	if len(channelKey) == 0 {
-		return 0, fmt.Errorf("channel key cannot be empty")
-	}
-
-	h := xorHash([]byte(channelName))
-	h ^= xorHash(channelKey)
-
-	return uint32(h), nil
+	return uint32(ChannelNumber(channelName, channelKey)), nil
 }
 
-// TryDecode attempts to decrypt a packet with the specified key, or return the already decrypted data if present.
-func TryDecode(packet *meshtastic.MeshPacket, key []byte) (*meshtastic.Data, error) {
+// ChannelNumber returns the channel hash the firmware stamps into MeshPacket.Channel for packets on the named channel.
+// key is the channel PSK as configured and is passed through ExpandKey before hashing, so an empty key for an
+// unencrypted channel hashes as the name alone. The firmware truncates the hash to a single byte.
+func ChannelNumber(name string, key []byte) uint8 {
+	return xorHash([]byte(name)) ^ xorHash(ExpandKey(key))
+}
 
+// Decrypt returns the plaintext of a packet's encrypted payload, decrypted with the specified key, which is
+// normally a marshalled Data protobuf. It is not checked to be one, so payloads which fail to parse as Data can still
+// be examined. The key is passed through ExpandKey, and encrypted payloads longer than MaxPayloadLen are rejected with
+// ErrPayloadTooLarge. For a packet which has already been decrypted, its Data is marshalled and returned.
+func Decrypt(packet *meshtastic.MeshPacket, key []byte) ([]byte, error) {
 	switch packet.GetPayloadVariant().(type) {
 	case *meshtastic.MeshPacket_Decoded:
-		//fmt.Println("decoded")
-		return packet.GetDecoded(), nil
+		return proto.Marshal(packet.GetDecoded())
 	case *meshtastic.MeshPacket_Encrypted:
-		decrypted, err := XOR(packet.GetEncrypted(), key, packet.Id, packet.From)
+		if n := len(packet.GetEncrypted()); n > MaxPayloadLen {
+			return nil, fmt.Errorf("%w: %d > %d", ErrPayloadTooLarge, n, MaxPayloadLen)
+		}
+		decrypted, err := XOR(packet.GetEncrypted(), ExpandKey(key), packet.Id, packet.From)
 		if err != nil {
-			log.Warnf("Failed decrypting packet: %s", err)
+			log.Debugf("Failed decrypting packet: %s", err)
 			return nil, ErrDecrypt
 		}
-		log.Warnf("PLAINTEXT: [%s]", hex.EncodeToString(decrypted))
-
-		useOriginal := true
-		if useOriginal {
-			var meshPacket meshtastic.Data
-			err = proto.Unmarshal(decrypted, &meshPacket)
-			if err != nil {
-				log.Warnf("Failed to unmarshal Meshtastic Data packet: %s", err)
-				return nil, ErrDecrypt
-			}
-			return &meshPacket, nil
-		} else {
-
-			var dataPacket meshtastic.Data
-			err = proto.Unmarshal(decrypted, &dataPacket)
-			if err != nil {
-				log.Warnf("Failed to unmarshal Meshtastic Data packet: %s", err)
-				return nil, ErrDecrypt
-			}
-
-			switch dataPacket.Portnum {
-			case meshtastic.PortNum_TEXT_MESSAGE_APP:
-				txt := dataPacket.Payload
-				fmt.Println("Got Text:", string(txt))
-			case meshtastic.PortNum_TELEMETRY_APP:
-				var telemetry meshtastic.Telemetry
-				proto.Unmarshal(dataPacket.Payload, &telemetry)
-				fmt.Printf("Got Telemetry:")
-			default:
-				fmt.Println("Unknown portnum:", dataPacket.Portnum)
-			}
-
-			return &dataPacket, nil
-		}
+		return decrypted, nil
 	default:
 		return nil, ErrUnkownPayloadType
 	}
 }
+
+// TryDecode attempts to decrypt a packet with the specified key, or return the already decrypted data if present.
+// The key is passed through ExpandKey, so a PSK may be given in its short form. Encrypted payloads longer than
+// MaxPayloadLen are rejected with ErrPayloadTooLarge, as no radio could have sent them. See Decrypt for the plaintext
+// before it is parsed.
+func TryDecode(packet *meshtastic.MeshPacket, key []byte) (*meshtastic.Data, error) {
+	if decoded := packet.GetDecoded(); decoded != nil {
+		return decoded, nil
+	}
+	decrypted, err := Decrypt(packet, key)
+	if err != nil {
+		return nil, err
+	}
+	var data meshtastic.Data
+	if err := proto.Unmarshal(decrypted, &data); err != nil {
+		log.Debugf("Failed to unmarshal Meshtastic Data packet: %s", err)
+		return nil, ErrDecrypt
+	}
+	return &data, nil
+}
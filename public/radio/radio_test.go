@@ -0,0 +1,246 @@
+package radio
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/rabarar/meshtastic"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestNormalizeAndParsePSK(t *testing.T) {
+	tests := []struct {
+		name    string
+		psk     string
+		want    []byte
+		wantErr bool
+	}{
+		{name: "empty", psk: "", want: []byte{}},
+		{name: "no encryption", psk: "AA==", want: []byte{}},
+		{name: "default shorthand", psk: "AQ==", want: DefaultKey},
+		{name: "default shorthand unpadded", psk: "AQ", want: DefaultKey},
+		{name: "standard alphabet", psk: "1PG7OiApB1nwvP+rz05pAQ==", want: DefaultKey},
+		{name: "url alphabet unpadded", psk: "1PG7OiApB1nwvP-rz05pAQ", want: DefaultKey},
+		{name: "bad length", psk: "AQI=", wantErr: true},
+		{name: "not base64", psk: "!!!!", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NormalizeAndParsePSK(tt.psk)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestChannelNumber(t *testing.T) {
+	tests := []struct {
+		name string
+		key  []byte
+		want uint8
+	}{
+		{name: "LongFast", key: []byte{0x01}, want: 8},
+		{name: "LongFast", key: DefaultKey, want: 8},
+		{name: "MediumFast", key: []byte{0x01}, want: 31},
+		{name: "MediumSlow", key: []byte{0x01}, want: 24},
+		{name: "ShortFast", key: []byte{0x01}, want: 112},
+		{name: "LongSlow", key: []byte{0x01}, want: 15},
+		// An unencrypted channel hashes as the name alone.
+		{name: "LongFast", key: []byte{}, want: 10},
+		{name: "LongFast", key: []byte{0x00}, want: 10},
+	}
+	for _, tt := range tests {
+		got := ChannelNumber(tt.name, tt.key)
+		require.Equal(t, tt.want, got, "%s %x", tt.name, tt.key)
+		// ChannelHash agrees for every PSK, including short and empty ones.
+		hash, err := ChannelHash(tt.name, tt.key)
+		require.NoError(t, err)
+		require.Equal(t, uint32(tt.want), hash)
+	}
+}
+
+func TestExpandKey(t *testing.T) {
+	full := []byte("0123456789abcdef")
+	tests := []struct {
+		name string
+		psk  []byte
+		want []byte
+	}{
+		{name: "empty", psk: []byte{}, want: []byte{}},
+		{name: "no encryption index", psk: []byte{0x00}, want: []byte{}},
+		{name: "default key index", psk: []byte{0x01}, want: DefaultKey},
+		{name: "second key index", psk: []byte{0x02}, want: append(bytes.Clone(DefaultKey[:15]), 0x02)},
+		{name: "16 bytes", psk: full, want: full},
+		{name: "short key padded to 16", psk: []byte{0xaa, 0xbb}, want: append([]byte{0xaa, 0xbb}, make([]byte, 14)...)},
+		{name: "24 bytes padded to 32", psk: bytes.Repeat([]byte{0xcc}, 24), want: append(bytes.Repeat([]byte{0xcc}, 24), make([]byte, 8)...)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, ExpandKey(tt.psk))
+		})
+	}
+}
+
+func TestTryDecode(t *testing.T) {
+	data := &meshtastic.Data{Portnum: meshtastic.PortNum_TEXT_MESSAGE_APP, Payload: []byte("hi")}
+	plaintext, err := proto.Marshal(data)
+	require.NoError(t, err)
+	encrypted, err := Encrypt(plaintext, DefaultKey, 7, 0x1234)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		packet  *meshtastic.MeshPacket
+		key     []byte
+		wantErr error
+	}{
+		{
+			name:   "encrypted",
+			packet: &meshtastic.MeshPacket{Id: 7, From: 0x1234, PayloadVariant: &meshtastic.MeshPacket_Encrypted{Encrypted: encrypted}},
+			key:    DefaultKey,
+		},
+		{
+			name:   "already decoded",
+			packet: &meshtastic.MeshPacket{PayloadVariant: &meshtastic.MeshPacket_Decoded{Decoded: data}},
+		},
+		{
+			name:    "wrong key",
+			packet:  &meshtastic.MeshPacket{Id: 7, From: 0x1234, PayloadVariant: &meshtastic.MeshPacket_Encrypted{Encrypted: encrypted}},
+			key:     []byte("0123456789abcdef"),
+			wantErr: ErrDecrypt,
+		},
+		{name: "no payload", packet: &meshtastic.MeshPacket{}, wantErr: ErrUnkownPayloadType},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decoded, err := TryDecode(tt.packet, tt.key)
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.True(t, proto.Equal(data, decoded))
+		})
+	}
+}
+
+func TestTryDecode_ShortPSK(t *testing.T) {
+	data := &meshtastic.Data{Portnum: meshtastic.PortNum_TEXT_MESSAGE_APP, Payload: []byte("hi")}
+	plaintext, err := proto.Marshal(data)
+	require.NoError(t, err)
+	encrypted, err := Encrypt(plaintext, DefaultKey, 7, 0x1234)
+	require.NoError(t, err)
+
+	decoded, err := TryDecode(&meshtastic.MeshPacket{
+		Id:             7,
+		From:           0x1234,
+		PayloadVariant: &meshtastic.MeshPacket_Encrypted{Encrypted: encrypted},
+	}, []byte{0x01})
+	require.NoError(t, err)
+	require.True(t, proto.Equal(data, decoded))
+}
+
+func TestTryDecode_PayloadLen(t *testing.T) {
+	// A text message which marshals to exactly MaxPayloadLen bytes.
+	data := &meshtastic.Data{Portnum: meshtastic.PortNum_TEXT_MESSAGE_APP, Payload: bytes.Repeat([]byte("a"), 232)}
+	plaintext, err := proto.Marshal(data)
+	require.NoError(t, err)
+	require.Len(t, plaintext, MaxPayloadLen)
+	encrypted, err := Encrypt(plaintext, DefaultKey, 7, 0x1234)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name      string
+		encrypted []byte
+		wantErr   error
+	}{
+		{name: "max", encrypted: encrypted},
+		{name: "one over max", encrypted: append(encrypted, 0), wantErr: ErrPayloadTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decoded, err := TryDecode(&meshtastic.MeshPacket{
+				Id:             7,
+				From:           0x1234,
+				PayloadVariant: &meshtastic.MeshPacket_Encrypted{Encrypted: tt.encrypted},
+			}, DefaultKey)
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.True(t, proto.Equal(data, decoded))
+		})
+	}
+}
+
+func TestDecrypt(t *testing.T) {
+	// Plaintext which is not a valid Data protobuf can still be decrypted.
+	plaintext := []byte{0xff, 0xff, 0xff}
+	encrypted, err := Encrypt(plaintext, DefaultKey, 7, 0x1234)
+	require.NoError(t, err)
+	packet := &meshtastic.MeshPacket{
+		Id:             7,
+		From:           0x1234,
+		PayloadVariant: &meshtastic.MeshPacket_Encrypted{Encrypted: encrypted},
+	}
+
+	decrypted, err := Decrypt(packet, DefaultKey)
+	require.NoError(t, err)
+	require.Equal(t, plaintext, decrypted)
+	_, err = TryDecode(packet, DefaultKey)
+	require.ErrorIs(t, err, ErrDecrypt)
+
+	data := &meshtastic.Data{Portnum: meshtastic.PortNum_TEXT_MESSAGE_APP, Payload: []byte("hi")}
+	decrypted, err = Decrypt(&meshtastic.MeshPacket{PayloadVariant: &meshtastic.MeshPacket_Decoded{Decoded: data}}, nil)
+	require.NoError(t, err)
+	want, err := proto.Marshal(data)
+	require.NoError(t, err)
+	require.Equal(t, want, decrypted)
+}
+
+func TestParseKey(t *testing.T) {
+	for _, key := range []string{
+		"1PG7OiApB1nwvP+rz05pAQ==",
+		"1PG7OiApB1nwvP+rz05pAQ",
+		"1PG7OiApB1nwvP-rz05pAQ==",
+		"1PG7OiApB1nwvP-rz05pAQ",
+	} {
+		t.Run(key, func(t *testing.T) {
+			parsed, err := ParseKey(key)
+			require.NoError(t, err)
+			require.Equal(t, DefaultKey, parsed)
+		})
+	}
+
+	// Short keys are returned as decoded.
+	parsed, err := ParseKey("AQ==")
+	require.NoError(t, err)
+	require.Equal(t, []byte{0x01}, parsed)
+
+	_, err = ParseKey("not base64!")
+	require.Error(t, err)
+}
+
+func TestKeyToString(t *testing.T) {
+	require.Equal(t, "1PG7OiApB1nwvP-rz05pAQ==", KeyToString(DefaultKey))
+	require.Equal(t, "1PG7OiApB1nwvP+rz05pAQ==", KeyToStdString(DefaultKey))
+
+	// The default key is the expansion of the AQ== shorthand.
+	expanded, err := NormalizeAndParsePSK("AQ==")
+	require.NoError(t, err)
+	require.Equal(t, KeyToStdString(expanded), KeyToStdString(DefaultKey))
+
+	for _, key := range [][]byte{DefaultKey, {0x01}, bytes.Repeat([]byte{0xfb}, 32)} {
+		for _, s := range []string{KeyToString(key), KeyToStdString(key)} {
+			parsed, err := ParseKey(s)
+			require.NoError(t, err)
+			require.Equal(t, key, parsed)
+		}
+	}
+}
@@ -1,20 +1,174 @@
 package radio
 
 import (
+	"bytes"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+
 	"github.com/rabarar/meshtastic"
 )
 
-// Something is something created to track keys for packet decrypting
+// presetChannelNames are the channel names the firmware uses for the standard modem presets when a channel is left
+// unnamed. These channels use the default PSK unless the user has changed it.
+var presetChannelNames = []string{
+	"ShortTurbo",
+	"ShortFast",
+	"ShortSlow",
+	"MediumFast",
+	"MediumSlow",
+	"LongTurbo",
+	"LongFast",
+	"LongModerate",
+	"LongMod",
+	"LongSlow",
+	"VeryLongSlow",
+	"VLongSlow",
+}
+
+// DefaultChannelKeys returns a map of the standard modem preset channel names to the expanded default key.
+// A new map is returned on each call, so callers are free to modify it.
+func DefaultChannelKeys() map[string][]byte {
+	keys := make(map[string][]byte, len(presetChannelNames))
+	for _, name := range presetChannelNames {
+		keys[name] = bytes.Clone(DefaultKey)
+	}
+	return keys
+}
+
+// Something is something created to track keys for packet decrypting. Along with channel keys, it holds our private
+// key and the public keys of other nodes for decrypting PKI encrypted direct messages. It is safe for concurrent use.
 type Something struct {
-	keys map[string][]byte
+	mu         sync.RWMutex
+	keys       map[string][]byte
+	privateKey []byte
+	publicKeys map[uint32][]byte
+}
+
+// NewThing creates a keyring populated with DefaultChannelKeys. Any entries in overrides replace or extend the
+// defaults, which allows the key for a preset channel to be changed or private channels to be added.
+func NewThing(overrides map[string][]byte) *Something {
+	keys := DefaultChannelKeys()
+	for name, key := range overrides {
+		keys[name] = key
+	}
+	return &Something{keys: keys, publicKeys: map[uint32][]byte{}}
+}
+
+// IsPresetChannel reports whether name is one of the standard modem preset channel names.
+func IsPresetChannel(name string) bool {
+	return slices.Contains(presetChannelNames, name)
+}
+
+// AddChannel registers the key used for the named channel, replacing any existing key.
+//
+// If key is nil and name is a standard preset channel (e.g. "LongFast"), DefaultKey is assigned. This shorthand only
+// applies to channels using the default PSK; a preset channel that has been configured with a custom PSK must be
+// added with that key explicitly.
+func (s *Something) AddChannel(name string, key []byte) error {
+	if key == nil {
+		if !IsPresetChannel(name) {
+			return fmt.Errorf("no key provided for non-preset channel %q", name)
+		}
+		key = bytes.Clone(DefaultKey)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[name] = key
+	return nil
+}
+
+// Key returns the key registered for the named channel.
+func (s *Something) Key(name string) ([]byte, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	key, ok := s.keys[name]
+	return key, ok
+}
+
+// SetPrivateKey sets our private key, used to decrypt PKI encrypted direct messages sent to us. This is the key in the
+// radio's SecurityConfig, or one created with GenerateKeyPair.
+func (s *Something) SetPrivateKey(key []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.privateKey = key
+}
+
+// AddPublicKey registers the public key of the node with the given number, replacing any existing key. Nodes publish
+// their public key in User.PublicKey, see AddUser.
+func (s *Something) AddPublicKey(num uint32, key []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.publicKeys[num] = key
+}
+
+// AddUser registers the public key carried by a User received from the node with the given number. Users without a
+// public key, from firmware before 2.5, are ignored.
+func (s *Something) AddUser(num uint32, user *meshtastic.User) {
+	if len(user.GetPublicKey()) == 0 {
+		return
+	}
+	s.AddPublicKey(num, user.GetPublicKey())
+}
+
+// PublicKey returns the public key registered for the node with the given number.
+func (s *Something) PublicKey(num uint32) ([]byte, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	key, ok := s.publicKeys[num]
+	return key, ok
+}
+
+// Decode decrypts a packet using whichever registered key matches, returning the name of that channel along with the
+// decoded Data. Keys are tried in channel name order, skipping those whose channel hash (see ChannelNumber) differs
+// from packet.Channel. A key is only accepted if the decrypted payload unmarshals as Data. ErrDecrypt is returned if
+// no key matches. Packets which have already been decoded are returned as is, with an empty channel name.
+//
+// Packets with PkiEncrypted set are decrypted with our private key and the sender's public key instead, returning
+// PKIChannel as the channel name. ErrNoPublicKey is returned if either key is missing.
+func (s *Something) Decode(packet *meshtastic.MeshPacket) (string, *meshtastic.Data, error) {
+	if decoded := packet.GetDecoded(); decoded != nil {
+		return "", decoded, nil
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if packet.GetPkiEncrypted() {
+		publicKey, ok := s.publicKeys[packet.GetFrom()]
+		if !ok || s.privateKey == nil {
+			return "", nil, ErrNoPublicKey
+		}
+		data, err := DecryptPKI(packet, s.privateKey, publicKey)
+		if err != nil {
+			return "", nil, err
+		}
+		return PKIChannel, data, nil
+	}
+	names := make([]string, 0, len(s.keys))
+	for name := range s.keys {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		key := s.keys[name]
+		if uint32(ChannelNumber(name, key)) != packet.GetChannel() {
+			continue
+		}
+		data, err := TryDecode(packet, key)
+		if err == nil {
+			return name, data, nil
+		}
+	}
+	return "", nil, ErrDecrypt
 }
 
-func NewThing() *Something {
-	return &Something{keys: map[string][]byte{
-		"LongFast":  DefaultKey,
-		"LongSlow":  DefaultKey,
-		"VLongSlow": DefaultKey,
-	}}
+// TryDecodeAny attempts to decode a packet with each of the registered keys in turn, returning the name of the
+// channel whose key succeeded along with the decoded Data. As the preset channels share the default key, only keys
+// whose channel hash matches the packet are tried, so that the name reported is that of the channel it was sent on.
+//
+// Deprecated: Use Decode.
+func (s *Something) TryDecodeAny(packet *meshtastic.MeshPacket) (string, *meshtastic.Data, error) {
+	return s.Decode(packet)
 }
 
 // TryDecode decode a payload to a Data protobuf
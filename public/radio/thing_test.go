@@ -0,0 +1,163 @@
+package radio
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/rabarar/meshtastic"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+func encryptedTestPacket(t *testing.T, channel string, key []byte, data *meshtastic.Data) *meshtastic.MeshPacket {
+	t.Helper()
+	plaintext, err := proto.Marshal(data)
+	require.NoError(t, err)
+	encrypted, err := Encrypt(plaintext, key, 99, 0x1234)
+	require.NoError(t, err)
+	return &meshtastic.MeshPacket{
+		Id:             99,
+		From:           0x1234,
+		Channel:        uint32(ChannelNumber(channel, key)),
+		PayloadVariant: &meshtastic.MeshPacket_Encrypted{Encrypted: encrypted},
+	}
+}
+
+func TestSomething_Decode(t *testing.T) {
+	privateKey := []byte("0123456789abcdef")
+	keys := NewThing(map[string][]byte{"Private": privateKey})
+	data := &meshtastic.Data{Portnum: meshtastic.PortNum_TEXT_MESSAGE_APP, Payload: []byte("hello")}
+
+	tests := []struct {
+		name    string
+		channel string
+		key     []byte
+	}{
+		{name: "default channel", channel: "LongFast", key: DefaultKey},
+		{name: "private channel", channel: "Private", key: privateKey},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			channel, decoded, err := keys.Decode(encryptedTestPacket(t, tt.channel, tt.key, data))
+			require.NoError(t, err)
+			require.Equal(t, tt.channel, channel)
+			require.True(t, proto.Equal(data, decoded))
+		})
+	}
+}
+
+func TestSomething_Decode_HashMismatch(t *testing.T) {
+	keys := NewThing(nil)
+	packet := encryptedTestPacket(t, "LongFast", DefaultKey, &meshtastic.Data{Portnum: meshtastic.PortNum_TEXT_MESSAGE_APP})
+	// A channel hash that none of the registered channels have means no key is tried.
+	packet.Channel = 0xff
+	_, _, err := keys.Decode(packet)
+	require.ErrorIs(t, err, ErrDecrypt)
+}
+
+func TestNewThing(t *testing.T) {
+	customKey := []byte("0123456789abcdef")
+	keys := NewThing(map[string][]byte{"LongFast": customKey, "Private": {2}})
+
+	tests := []struct {
+		name    string
+		channel string
+		wantKey []byte
+		wantOK  bool
+	}{
+		{name: "default preset", channel: "MediumFast", wantKey: DefaultKey, wantOK: true},
+		{name: "overridden preset", channel: "LongFast", wantKey: customKey, wantOK: true},
+		{name: "added channel", channel: "Private", wantKey: []byte{2}, wantOK: true},
+		{name: "unknown channel", channel: "Unknown"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, ok := keys.keys[tt.channel]
+			require.Equal(t, tt.wantOK, ok)
+			require.Equal(t, tt.wantKey, key)
+		})
+	}
+
+	// The defaults are copied, so the keyring cannot change DefaultKey.
+	key := keys.keys["ShortFast"]
+	key[0] ^= 0xff
+	require.NotEqual(t, key, DefaultKey)
+}
+
+func TestSomething_AddChannel(t *testing.T) {
+	customKey := []byte("0123456789abcdef")
+	tests := []struct {
+		name    string
+		channel string
+		key     []byte
+		wantKey []byte
+		wantErr bool
+	}{
+		{name: "preset without key", channel: "LongFast", wantKey: DefaultKey},
+		{name: "preset with custom key", channel: "LongFast", key: customKey, wantKey: customKey},
+		{name: "private channel", channel: "Private", key: customKey, wantKey: customKey},
+		{name: "private channel without key", channel: "Private", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys := NewThing(map[string][]byte{"LongFast": []byte("fedcba9876543210")})
+			err := keys.AddChannel(tt.channel, tt.key)
+			if tt.wantErr {
+				require.Error(t, err)
+				_, ok := keys.keys[tt.channel]
+				require.False(t, ok)
+				return
+			}
+			require.NoError(t, err)
+			key, ok := keys.keys[tt.channel]
+			require.True(t, ok)
+			require.Equal(t, tt.wantKey, key)
+		})
+	}
+}
+
+func TestSomething_TryDecodeAny_AddedChannel(t *testing.T) {
+	privateKey := []byte("0123456789abcdef")
+	keys := NewThing(nil)
+	packet := encryptedTestPacket(t, "Private", privateKey, &meshtastic.Data{
+		Portnum: meshtastic.PortNum_TEXT_MESSAGE_APP,
+		Payload: []byte("hello"),
+	})
+
+	// None of the preset channels have the key the packet was sent with.
+	_, _, err := keys.TryDecodeAny(packet)
+	require.ErrorIs(t, err, ErrDecrypt)
+
+	require.NoError(t, keys.AddChannel("Private", privateKey))
+	name, decoded, err := keys.TryDecodeAny(packet)
+	require.NoError(t, err)
+	require.Equal(t, "Private", name)
+	require.Equal(t, "hello", string(decoded.Payload))
+}
+
+func TestSomething_TryDecodeAny(t *testing.T) {
+	keys := NewThing(map[string][]byte{"Private": []byte("0123456789abcdef")})
+	data := &meshtastic.Data{Portnum: meshtastic.PortNum_TEXT_MESSAGE_APP, Payload: []byte("hello")}
+
+	// Every preset channel shares the default key, so the channel hash is all that tells them apart.
+	for _, channel := range append(slices.Clone(presetChannelNames), "Private") {
+		t.Run(channel, func(t *testing.T) {
+			key, ok := keys.Key(channel)
+			require.True(t, ok)
+			name, decoded, err := keys.TryDecodeAny(encryptedTestPacket(t, channel, key, data))
+			require.NoError(t, err)
+			require.Equal(t, channel, name)
+			require.True(t, proto.Equal(data, decoded))
+		})
+	}
+}
+
+func TestSomething_Decode_Decoded(t *testing.T) {
+	data := &meshtastic.Data{Portnum: meshtastic.PortNum_TEXT_MESSAGE_APP, Payload: []byte("hello")}
+	channel, decoded, err := NewThing(nil).Decode(&meshtastic.MeshPacket{
+		PayloadVariant: &meshtastic.MeshPacket_Decoded{Decoded: data},
+	})
+	require.NoError(t, err)
+	require.Empty(t, channel)
+	require.Same(t, data, decoded)
+}
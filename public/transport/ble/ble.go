@@ -0,0 +1,201 @@
+//go:build linux || windows || (darwin && cgo)
+
+package ble
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+
+	"tinygo.org/x/bluetooth"
+)
+
+var (
+	serviceUUID   = mustParseUUID(ServiceUUID)
+	toRadioUUID   = mustParseUUID(ToRadioUUID)
+	fromRadioUUID = mustParseUUID(FromRadioUUID)
+	fromNumUUID   = mustParseUUID(FromNumUUID)
+
+	enableOnce sync.Once
+	enableErr  error
+)
+
+func mustParseUUID(s string) bluetooth.UUID {
+	uuid, err := bluetooth.ParseUUID(s)
+	if err != nil {
+		panic(err)
+	}
+	return uuid
+}
+
+// Device is a radio found by Scan.
+type Device struct {
+	// Address identifies the device to Connect. On macOS this is a UUID assigned by the OS rather than a MAC address.
+	Address string
+	Name    string
+	RSSI    int16
+
+	address bluetooth.Address
+}
+
+func adapter() (*bluetooth.Adapter, error) {
+	enableOnce.Do(func() {
+		enableErr = bluetooth.DefaultAdapter.Enable()
+	})
+	if enableErr != nil {
+		return nil, fmt.Errorf("enabling bluetooth adapter: %w", enableErr)
+	}
+	return bluetooth.DefaultAdapter, nil
+}
+
+// Scan returns the radios advertising the Meshtastic service which are found before ctx is done.
+func Scan(ctx context.Context) ([]Device, error) {
+	return scan(ctx, nil)
+}
+
+// scan scans until ctx is done, or until stop returns true for a device found.
+func scan(ctx context.Context, stop func(Device) bool) ([]Device, error) {
+	a, err := adapter()
+	if err != nil {
+		return nil, err
+	}
+	var (
+		mu      sync.Mutex
+		devices []Device
+		seen    = map[string]bool{}
+	)
+	go func() {
+		<-ctx.Done()
+		_ = a.StopScan()
+	}()
+	err = a.Scan(func(a *bluetooth.Adapter, result bluetooth.ScanResult) {
+		if !result.HasServiceUUID(serviceUUID) {
+			return
+		}
+		d := Device{
+			Address: result.Address.String(),
+			Name:    result.LocalName(),
+			RSSI:    result.RSSI,
+			address: result.Address,
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if seen[d.Address] {
+			return
+		}
+		seen[d.Address] = true
+		devices = append(devices, d)
+		if stop != nil && stop(d) {
+			_ = a.StopScan()
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("scanning: %w", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	return devices, nil
+}
+
+// Connect scans for the radio with the given address and connects to it. The returned Conn can be wrapped with
+// transport.NewClientStreamConn.
+func Connect(ctx context.Context, address string) (*Conn, error) {
+	devices, err := scan(ctx, func(d Device) bool {
+		return d.Address == address
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range devices {
+		if d.Address == address {
+			return ConnectDevice(d)
+		}
+	}
+	if ctx.Err() != nil {
+		return nil, fmt.Errorf("radio %s not found: %w", address, ctx.Err())
+	}
+	return nil, fmt.Errorf("radio %s not found", address)
+}
+
+// ConnectDevice connects to a radio found by Scan.
+func ConnectDevice(d Device) (*Conn, error) {
+	a, err := adapter()
+	if err != nil {
+		return nil, err
+	}
+	device, err := a.Connect(d.address, bluetooth.ConnectionParams{})
+	if err != nil {
+		return nil, fmt.Errorf("connecting to %s: %w", d.Address, err)
+	}
+	chars, err := discover(device)
+	if err != nil {
+		_ = device.Disconnect()
+		return nil, err
+	}
+	conn := newConn(chars)
+	if err := chars.fromNum.EnableNotifications(func([]byte) {
+		conn.notify()
+	}); err != nil {
+		slog.Warn("unable to enable FromNum notifications, falling back to polling", "err", err)
+	}
+	return conn, nil
+}
+
+func discover(device bluetooth.Device) (*gattCharacteristics, error) {
+	services, err := device.DiscoverServices([]bluetooth.UUID{serviceUUID})
+	if err != nil {
+		return nil, fmt.Errorf("discovering services: %w", err)
+	}
+	if len(services) == 0 {
+		return nil, errors.New("radio does not offer the meshtastic service")
+	}
+	found, err := services[0].DiscoverCharacteristics([]bluetooth.UUID{toRadioUUID, fromRadioUUID, fromNumUUID})
+	if err != nil {
+		return nil, fmt.Errorf("discovering characteristics: %w", err)
+	}
+	chars := &gattCharacteristics{device: device}
+	for i := range found {
+		switch found[i].UUID() {
+		case toRadioUUID:
+			chars.toRadio = found[i]
+		case fromRadioUUID:
+			chars.fromRadio = found[i]
+		case fromNumUUID:
+			chars.fromNum = found[i]
+		}
+	}
+	if chars.toRadio.UUID() != toRadioUUID || chars.fromRadio.UUID() != fromRadioUUID || chars.fromNum.UUID() != fromNumUUID {
+		return nil, errors.New("radio is missing meshtastic characteristics")
+	}
+	return chars, nil
+}
+
+// gattCharacteristics implements characteristics for a connected BLE device.
+type gattCharacteristics struct {
+	device    bluetooth.Device
+	toRadio   bluetooth.DeviceCharacteristic
+	fromRadio bluetooth.DeviceCharacteristic
+	fromNum   bluetooth.DeviceCharacteristic
+}
+
+func (g *gattCharacteristics) readFromRadio() ([]byte, error) {
+	buf := make([]byte, 512)
+	n, err := g.fromRadio.Read(buf)
+	if err != nil {
+		return nil, fmt.Errorf("reading FromRadio: %w", err)
+	}
+	return buf[:n], nil
+}
+
+func (g *gattCharacteristics) writeToRadio(data []byte) error {
+	if _, err := g.toRadio.WriteWithoutResponse(data); err != nil {
+		return fmt.Errorf("writing ToRadio: %w", err)
+	}
+	return nil
+}
+
+func (g *gattCharacteristics) disconnect() error {
+	return g.device.Disconnect()
+}
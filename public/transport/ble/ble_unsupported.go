@@ -0,0 +1,27 @@
+//go:build !(linux || windows || (darwin && cgo))
+
+package ble
+
+import "context"
+
+// Device is a radio found by Scan.
+type Device struct {
+	Address string
+	Name    string
+	RSSI    int16
+}
+
+// Scan returns ErrUnsupported on this platform.
+func Scan(ctx context.Context) ([]Device, error) {
+	return nil, ErrUnsupported
+}
+
+// Connect returns ErrUnsupported on this platform.
+func Connect(ctx context.Context, address string) (*Conn, error) {
+	return nil, ErrUnsupported
+}
+
+// ConnectDevice returns ErrUnsupported on this platform.
+func ConnectDevice(d Device) (*Conn, error) {
+	return nil, ErrUnsupported
+}
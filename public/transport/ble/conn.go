@@ -0,0 +1,139 @@
+// Package ble connects to radios over Bluetooth LE.
+//
+// Radios offer the client API over BLE as three GATT characteristics rather than a byte stream: protobufs are written
+// to ToRadio and read from FromRadio one at a time, and FromNum notifies when FromRadio has data. Conn adapts these to
+// the stream protocol so that it can be used with transport.NewClientStreamConn like a serial or TCP connection.
+package ble
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/rabarar/meshtool-go/public/transport"
+)
+
+const (
+	// ServiceUUID is the UUID of the GATT service radios advertise.
+	ServiceUUID = "6ba1b218-15a8-461f-9fa8-5dcae273eafd"
+	// ToRadioUUID is the characteristic ToRadio protobufs are written to.
+	ToRadioUUID = "f75c76d2-129e-4dad-a1dd-7866124401e7"
+	// FromRadioUUID is the characteristic FromRadio protobufs are read from.
+	FromRadioUUID = "2c55e69e-4993-11ed-b878-0242ac120002"
+	// FromNumUUID is the characteristic which notifies when FromRadio has data to be read.
+	FromNumUUID = "ed9da18c-a800-4f66-a670-aa7547e34453"
+
+	// pollInterval is how often FromRadio is read when there is no notification from FromNum, in case one was missed.
+	pollInterval = time.Second
+	headerLen    = 4
+)
+
+// ErrUnsupported is returned on platforms without BLE support. macOS support requires cgo.
+var ErrUnsupported = errors.New("ble is not supported on this platform")
+
+// characteristics is the GATT interface of a connected radio.
+type characteristics interface {
+	// readFromRadio reads the next FromRadio protobuf, returning an empty slice if there are none.
+	readFromRadio() ([]byte, error)
+	// writeToRadio writes a ToRadio protobuf.
+	writeToRadio(data []byte) error
+	disconnect() error
+}
+
+// Conn is an io.ReadWriteCloser which speaks the stream protocol over a BLE connection to a radio.
+type Conn struct {
+	chars characteristics
+	// fromNum receives a value each time the radio notifies FromNum.
+	fromNum chan struct{}
+	closed  chan struct{}
+	once    sync.Once
+
+	readMu  sync.Mutex
+	readBuf []byte
+
+	writeMu  sync.Mutex
+	writeBuf []byte
+}
+
+func newConn(chars characteristics) *Conn {
+	return &Conn{
+		chars:   chars,
+		fromNum: make(chan struct{}, 1),
+		closed:  make(chan struct{}),
+	}
+}
+
+// notify wakes any pending Read, called when FromNum changes.
+func (c *Conn) notify() {
+	select {
+	case c.fromNum <- struct{}{}:
+	default:
+	}
+}
+
+// Read reads framed FromRadio messages, blocking until the radio has one available.
+func (c *Conn) Read(p []byte) (int, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+	for len(c.readBuf) == 0 {
+		data, err := c.chars.readFromRadio()
+		if err != nil {
+			return 0, err
+		}
+		if len(data) > 0 {
+			c.readBuf = binary.BigEndian.AppendUint16([]byte{transport.Start1, transport.Start2}, uint16(len(data)))
+			c.readBuf = append(c.readBuf, data...)
+			break
+		}
+		select {
+		case <-c.closed:
+			return 0, io.EOF
+		case <-c.fromNum:
+		case <-time.After(pollInterval):
+		}
+	}
+	n := copy(p, c.readBuf)
+	c.readBuf = c.readBuf[n:]
+	return n, nil
+}
+
+// Write accepts the stream protocol, writing each complete message to ToRadio. Bytes outside of a message, such as
+// the wake sequence sent by transport.NewClientStreamConn, are discarded. A message which fails to be written is
+// dropped rather than being sent again by the next Write.
+func (c *Conn) Write(p []byte) (int, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.writeBuf = append(c.writeBuf, p...)
+	for {
+		// Discard anything before the start of a message.
+		start := 0
+		for start < len(c.writeBuf)-1 && !(c.writeBuf[start] == transport.Start1 && c.writeBuf[start+1] == transport.Start2) {
+			start++
+		}
+		c.writeBuf = c.writeBuf[start:]
+		if len(c.writeBuf) < headerLen || c.writeBuf[0] != transport.Start1 {
+			return len(p), nil
+		}
+		length := int(binary.BigEndian.Uint16(c.writeBuf[2:headerLen]))
+		if len(c.writeBuf) < headerLen+length {
+			return len(p), nil
+		}
+		msg := c.writeBuf[headerLen : headerLen+length]
+		c.writeBuf = c.writeBuf[headerLen+length:]
+		if err := c.chars.writeToRadio(msg); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// Close disconnects from the radio.
+func (c *Conn) Close() error {
+	var err error
+	c.once.Do(func() {
+		close(c.closed)
+		err = c.chars.disconnect()
+	})
+	return err
+}
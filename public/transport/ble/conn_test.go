@@ -0,0 +1,103 @@
+package ble
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/rabarar/meshtastic"
+	"github.com/rabarar/meshtool-go/public/transport"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+// fakeRadio implements characteristics with in-memory queues.
+type fakeRadio struct {
+	mu        sync.Mutex
+	fromRadio [][]byte
+	toRadio   [][]byte
+	// writeErr is returned by the next writeToRadio, if set.
+	writeErr error
+}
+
+func (f *fakeRadio) readFromRadio() ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.fromRadio) == 0 {
+		return []byte{}, nil
+	}
+	data := f.fromRadio[0]
+	f.fromRadio = f.fromRadio[1:]
+	return data, nil
+}
+
+func (f *fakeRadio) writeToRadio(data []byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.writeErr; err != nil {
+		f.writeErr = nil
+		return err
+	}
+	f.toRadio = append(f.toRadio, append([]byte(nil), data...))
+	return nil
+}
+
+func (f *fakeRadio) disconnect() error {
+	return nil
+}
+
+func TestConn_Write(t *testing.T) {
+	radio := &fakeRadio{}
+	conn := newConn(radio)
+	// NewClientStreamConn sends the wake sequence, which must not reach the radio.
+	sc, err := transport.NewClientStreamConn(conn)
+	require.NoError(t, err)
+
+	sent := &meshtastic.ToRadio{PayloadVariant: &meshtastic.ToRadio_WantConfigId{WantConfigId: 42}}
+	require.NoError(t, sc.Write(sent))
+
+	require.Len(t, radio.toRadio, 1)
+	received := &meshtastic.ToRadio{}
+	require.NoError(t, proto.Unmarshal(radio.toRadio[0], received))
+	require.True(t, proto.Equal(sent, received))
+}
+
+func TestConn_Write_Error(t *testing.T) {
+	writeErr := errors.New("write failed")
+	radio := &fakeRadio{writeErr: writeErr}
+	sc := transport.NewRadioStreamConn(newConn(radio))
+
+	failed := &meshtastic.ToRadio{PayloadVariant: &meshtastic.ToRadio_WantConfigId{WantConfigId: 1}}
+	require.ErrorIs(t, sc.Write(failed), writeErr)
+	sent := &meshtastic.ToRadio{PayloadVariant: &meshtastic.ToRadio_WantConfigId{WantConfigId: 2}}
+	require.NoError(t, sc.Write(sent))
+
+	// The message which failed is not sent again along with the next one.
+	require.Len(t, radio.toRadio, 1)
+	received := &meshtastic.ToRadio{}
+	require.NoError(t, proto.Unmarshal(radio.toRadio[0], received))
+	require.True(t, proto.Equal(sent, received))
+}
+
+func TestConn_Read(t *testing.T) {
+	radio := &fakeRadio{}
+	conn := newConn(radio)
+	sc := transport.NewRadioStreamConn(conn)
+
+	sent := &meshtastic.FromRadio{Id: 7, PayloadVariant: &meshtastic.FromRadio_ConfigCompleteId{ConfigCompleteId: 42}}
+	done := make(chan error, 1)
+	received := &meshtastic.FromRadio{}
+	go func() {
+		done <- sc.Read(received)
+	}()
+
+	data, err := proto.Marshal(sent)
+	require.NoError(t, err)
+	radio.mu.Lock()
+	radio.fromRadio = append(radio.fromRadio, data)
+	radio.mu.Unlock()
+	conn.notify()
+
+	require.NoError(t, <-done)
+	require.True(t, proto.Equal(sent, received))
+}
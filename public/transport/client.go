@@ -4,173 +4,189 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"log/slog"
 	"math/rand"
+	"net"
+	"os"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/rabarar/meshtastic"
+	"github.com/rabarar/meshtool-go/public/meshtool"
+	"github.com/rabarar/meshtool-go/public/radio"
 
 	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
 )
 
 var (
 	ErrTimeout = errors.New("timeout connecting to radio")
+	// ErrStreamClosed is returned by Connect when the connection to the radio is closed before config is complete,
+	// such as when a serial port disappears.
+	ErrStreamClosed = errors.New("stream closed")
 )
 
-type HandlerFunc func(message proto.Message)
-
-type Client struct {
-	sc       *StreamConn
-	handlers *HandlerRegistry
-	log      *slog.Logger
+// maxPendingMessages is the maximum number of messages held while waiting for the radio to complete sending its
+// config.
+const maxPendingMessages = 256
+
+// DefaultWantConfigInterval is the default time Connect waits for the radio to respond before requesting its config
+// again.
+const DefaultWantConfigInterval = 2 * time.Second
+
+const (
+	// maxConsecutiveReadErrors is the number of errors in a row reading from the radio after which the client stops
+	// reading, rather than retrying forever on a malformed stream.
+	maxConsecutiveReadErrors = 5
+	// initialReadErrorBackoff is the time waited after the first read error, which doubles after each consecutive
+	// error up to maxReadErrorBackoff.
+	initialReadErrorBackoff = 10 * time.Millisecond
+	maxReadErrorBackoff     = time.Second
+)
 
-	State State
-}
+type HandlerFunc func(message proto.Message)
 
-type State struct {
-	sync.RWMutex
-	complete       bool
-	configID       uint32
-	nodeInfo       *meshtastic.MyNodeInfo
-	deviceMetadata *meshtastic.DeviceMetadata
-	nodes          []*meshtastic.NodeInfo
-	channels       []*meshtastic.Channel
-	configs        []*meshtastic.Config
-	modules        []*meshtastic.ModuleConfig
-}
+// DecodedHandlerFunc is called with a received MeshPacket and its payload decoded to the concrete message type for
+// the packet's portnum. See radio.DecodeData for the types used.
+type DecodedHandlerFunc func(packet *meshtastic.MeshPacket, msg proto.Message)
 
-func (s *State) Complete() bool {
-	s.RLock()
-	defer s.RUnlock()
-	return s.complete
-}
+// DecodedPacketHandlerFunc is called with a received MeshPacket which has been decoded into a meshtool.DecodedPacket.
+type DecodedPacketHandlerFunc func(packet *meshtool.DecodedPacket)
 
-func (s *State) ConfigID() uint32 {
-	s.RLock()
-	defer s.RUnlock()
-	return s.configID
-}
+// ClientOption configures optional behaviour of a Client.
+type ClientOption func(*Client)
 
-func (s *State) NodeInfo() *meshtastic.MyNodeInfo {
-	s.RLock()
-	defer s.RUnlock()
-	return s.nodeInfo
+// Logger is the logging interface used by Client. It is satisfied by *slog.Logger. A charmbracelet/log Logger can be
+// used by wrapping it with slog.New, as it implements slog.Handler.
+type Logger interface {
+	Debug(msg string, keyvals ...any)
+	Info(msg string, keyvals ...any)
+	Warn(msg string, keyvals ...any)
+	Error(msg string, keyvals ...any)
 }
 
-func (s *State) DeviceMetadata() *meshtastic.DeviceMetadata {
-	s.RLock()
-	defer s.RUnlock()
-	return proto.Clone(s.deviceMetadata).(*meshtastic.DeviceMetadata)
-}
+var _ Logger = (*slog.Logger)(nil)
 
-func (s *State) Nodes() []*meshtastic.NodeInfo {
-	s.RLock()
-	defer s.RUnlock()
-	var nodeInfos []*meshtastic.NodeInfo
-	for _, n := range s.nodes {
-		nodeInfos = append(nodeInfos, proto.Clone(n).(*meshtastic.NodeInfo))
+// WithLogger sets the logger used by the client. By default, slog.Default is used with the "client" group.
+func WithLogger(logger Logger) ClientOption {
+	return func(c *Client) {
+		c.log = logger
 	}
-	return nodeInfos
 }
 
-func (s *State) Channels() []*meshtastic.Channel {
-	s.RLock()
-	defer s.RUnlock()
-	var channels []*meshtastic.Channel
-	for _, n := range s.channels {
-		channels = append(channels, proto.Clone(n).(*meshtastic.Channel))
+// WithKeyring sets the keyring used to decrypt packets which the radio passes on still encrypted. The radio's private
+// key and the public keys of the nodes it hears from are added to it, so that PKI encrypted direct messages can be
+// decrypted too.
+func WithKeyring(keys *radio.Something) ClientOption {
+	return func(c *Client) {
+		c.keys = keys
 	}
-	return channels
 }
 
-func (s *State) Configs() []*meshtastic.Config {
-	s.RLock()
-	defer s.RUnlock()
-	var configs []*meshtastic.Config
-	for _, n := range s.configs {
-		configs = append(configs, proto.Clone(n).(*meshtastic.Config))
+// WithHandlerRegistry sets the registry the client dispatches received messages to, in place of the one it would
+// otherwise create. This allows one registry to be shared with other sources of messages, such as MQTT. The
+// errorOnNoHandler argument to NewClient is ignored, as the registry was created with its own.
+func WithHandlerRegistry(handlers *HandlerRegistry) ClientOption {
+	return func(c *Client) {
+		c.handlers = handlers
 	}
-	return configs
 }
 
-func (s *State) Modules() []*meshtastic.ModuleConfig {
-	s.RLock()
-	defer s.RUnlock()
-	var configs []*meshtastic.ModuleConfig
-	for _, n := range s.modules {
-		configs = append(configs, proto.Clone(n).(*meshtastic.ModuleConfig))
+// WithWantConfigInterval sets how long Connect waits for the radio to respond before requesting its config again.
+func WithWantConfigInterval(interval time.Duration) ClientOption {
+	return func(c *Client) {
+		c.wantConfigInterval = interval
 	}
-	return configs
-}
-
-func (s *State) SetComplete(complete bool) {
-	s.Lock()
-	defer s.Unlock()
-	s.complete = complete
 }
 
-func (s *State) SetConfigID(configID uint32) {
-	s.Lock()
-	defer s.Unlock()
-	s.configID = configID
-}
-
-func (s *State) SetNodeInfo(nodeInfo *meshtastic.MyNodeInfo) {
-	s.Lock()
-	defer s.Unlock()
-	s.nodeInfo = nodeInfo
-}
-
-func (s *State) SetDeviceMetadata(deviceMetadata *meshtastic.DeviceMetadata) {
-	s.Lock()
-	defer s.Unlock()
-	s.deviceMetadata = deviceMetadata
-}
-
-func (s *State) AddNode(node *meshtastic.NodeInfo) {
-	s.Lock()
-	defer s.Unlock()
-	s.nodes = append(s.nodes, node)
+// WithHandleBeforeConfigComplete causes messages which are not part of the radio's config, such as packets, to be
+// passed to handlers as soon as they are received. By default, messages received while the radio is still sending its
+// config are held and passed to handlers once config is complete.
+func WithHandleBeforeConfigComplete() ClientOption {
+	return func(c *Client) {
+		c.handleBeforeConfigComplete = true
+	}
 }
 
-func (s *State) AddChannel(channel *meshtastic.Channel) {
-	s.Lock()
-	defer s.Unlock()
-	s.channels = append(s.channels, channel)
+// WithHandleConfig causes the messages making up the radio's config, such as MyNodeInfo, NodeInfo, Channel and Config,
+// to be passed to handlers as they stream in during the WantConfig exchange. By default, they are only recorded in
+// State.
+func WithHandleConfig() ClientOption {
+	return func(c *Client) {
+		c.handleConfig = true
+	}
 }
 
-func (s *State) AddConfig(config *meshtastic.Config) {
-	s.Lock()
-	defer s.Unlock()
-	s.configs = append(s.configs, config)
-}
+type Client struct {
+	sc       Transport
+	handlers *HandlerRegistry
+	log      Logger
+	keys     *radio.Something
+	stats    clientStats
+
+	handleBeforeConfigComplete bool
+	handleConfig               bool
+	nextID                     PacketIDAllocator
+	wantConfigInterval         time.Duration
+
+	subscribersMu sync.Mutex
+	subscribers   map[protoreflect.FullName]map[*subscriber]struct{}
+
+	queueMu      sync.Mutex
+	queueUpdated chan struct{}
+
+	xmodemMu      sync.Mutex
+	xmodemPackets chan *meshtastic.XModem
+	xmodemTimeout time.Duration
+
+	// readDone is closed when the goroutine started by Connect stops reading from the radio.
+	readDone chan struct{}
+	// done is closed when the connection to the radio is closed, by Disconnect or because reading from it failed.
+	done chan struct{}
+	// errMu guards err, the error which caused reading from the radio to stop.
+	errMu          sync.Mutex
+	err            error
+	closed         atomic.Bool
+	disconnecting  atomic.Bool
+	closeOnce      sync.Once
+	disconnectOnce sync.Once
+	disconnectErr  error
 
-func (s *State) AddModule(module *meshtastic.ModuleConfig) {
-	s.Lock()
-	defer s.Unlock()
-	s.modules = append(s.modules, module)
+	State State
 }
 
-func NewClient(sc *StreamConn, errorOnNoHandler bool) *Client {
-	return &Client{
-		// TODO: allow consumer to specify logger
+// NewClient creates a client which talks to a radio over the given Transport, usually a *StreamConn. Messages with no
+// handler registered cause an error to be logged if errorOnNoHandler is true, unless WithHandlerRegistry is used.
+func NewClient(sc Transport, errorOnNoHandler bool, opts ...ClientOption) *Client {
+	c := &Client{
 		log:      slog.Default().WithGroup("client"),
 		sc:       sc,
 		handlers: NewHandlerRegistry(errorOnNoHandler),
+		nextID:   newRandomPacketIDAllocator(),
+		done:     make(chan struct{}),
+
+		wantConfigInterval: DefaultWantConfigInterval,
+		xmodemTimeout:      xmodemReplyTimeout,
 	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
 }
 
 // You have to send this first to get the radio into protobuf mode and have it accept and send packets via serial
-func (c *Client) sendGetConfig() error {
+func (c *Client) sendGetConfig(ctx context.Context) error {
 	r := rand.Uint32()
-	c.State.configID = r
+	c.State.SetConfigID(r)
 	msg := &meshtastic.ToRadio{
 		PayloadVariant: &meshtastic.ToRadio_WantConfigId{
 			WantConfigId: r,
 		},
 	}
 	c.log.Debug("sending want config", "id", r)
-	if err := c.sc.Write(msg); err != nil {
+	if err := c.writeContext(ctx, msg); err != nil {
 		return fmt.Errorf("writing want config command: %w", err)
 	}
 	c.log.Debug("sent want config")
@@ -181,56 +197,259 @@ func (c *Client) Handle(kind proto.Message, handler MessageHandler) {
 	c.handlers.RegisterHandler(kind, handler)
 }
 
+// Handlers returns the registry the client dispatches received messages to.
+func (c *Client) Handlers() *HandlerRegistry {
+	return c.handlers
+}
+
+// HandleDefault registers a handler which is called for each message received from the radio which has no handler
+// registered for its type with Handle. See HandlerRegistry.RegisterDefault.
+func (c *Client) HandleDefault(handler MessageHandler) {
+	c.handlers.RegisterDefault(handler)
+}
+
+// HandleDecoded registers a handler which is called for each received MeshPacket with its payload decoded.
+// Encrypted packets are decrypted using the keyring provided by WithKeyring, and are dropped if there is no keyring or
+// none of its keys match. Packets with a payload that cannot be decoded are also dropped.
+func (c *Client) HandleDecoded(handler DecodedHandlerFunc) {
+	c.handleDecodedPacket(func(packet *meshtool.DecodedPacket) {
+		handler(packet.Packet, packet.Payload)
+	})
+}
+
+// HandlePort registers a handler which is called for each received MeshPacket with the given portnum. Packets are
+// decrypted and decoded in the same way as HandleDecoded.
+func (c *Client) HandlePort(portnum meshtastic.PortNum, handler DecodedPacketHandlerFunc) {
+	c.handleDecodedPacket(func(packet *meshtool.DecodedPacket) {
+		if packet.Portnum == portnum {
+			handler(packet)
+		}
+	})
+}
+
+func (c *Client) handleDecodedPacket(handler DecodedPacketHandlerFunc) {
+	c.Handle(new(meshtastic.MeshPacket), func(msg proto.Message) {
+		packet := msg.(*meshtastic.MeshPacket)
+		decoded, err := meshtool.NewDecodedPacket(packet, c.keys)
+		if err != nil {
+			c.log.Debug("unable to decode packet", "id", packet.Id, "from", packet.From, "err", err)
+			return
+		}
+		handler(decoded)
+	})
+}
+
 func (c *Client) SendToRadio(msg *meshtastic.ToRadio) error {
+	return c.write(msg)
+}
+
+// SendPacket sends a MeshPacket to the radio, returning its ID so that replies can be correlated with it. If the
+// packet does not already have an ID, one is assigned using the client's PacketIDAllocator.
+func (c *Client) SendPacket(packet *meshtastic.MeshPacket) (uint32, error) {
+	if packet.Id == 0 {
+		id, err := c.allocatePacketID()
+		if err != nil {
+			return 0, fmt.Errorf("sending packet: %w", err)
+		}
+		packet.Id = id
+	}
+	err := c.write(&meshtastic.ToRadio{
+		PayloadVariant: &meshtastic.ToRadio_Packet{
+			Packet: packet,
+		},
+	})
+	if err != nil {
+		return 0, fmt.Errorf("sending packet: %w", err)
+	}
+	return packet.Id, nil
+}
+
+// SendText sends a text message from the connected node to another node, or to meshtool.BroadcastNodeID, on the
+// channel with the given index. The ID of the sent packet is returned so that the routing ACK can be correlated with
+// it.
+func (c *Client) SendText(to meshtool.NodeID, channel uint8, text string) (uint32, error) {
+	packet := NewTextPacket(c.myNodeID(), to, text).GetPacket()
+	packet.Channel = uint32(channel)
+	return c.SendPacket(packet)
+}
+
+func (c *Client) handleMessage(msg proto.Message) {
+	if xmodem, ok := msg.(*meshtastic.XModem); ok {
+		c.notifyXModem(xmodem)
+	}
+	subscribed := c.notifySubscribers(msg)
+	// A message which has been passed to a subscriber has been handled, even if no handlers are registered for it.
+	if err := c.handlers.HandleMessage(msg); err != nil && !subscribed {
+		c.log.Error("error handling message", "err", err)
+	}
+}
+
+// updateNodeDB keeps the nodes held in State up to date with packets received from the mesh.
+func (c *Client) updateNodeDB(packet *meshtastic.MeshPacket) {
+	decoded, err := meshtool.NewDecodedPacket(packet, c.keys)
+	if err != nil {
+		return
+	}
+	switch payload := decoded.Payload.(type) {
+	case *meshtastic.User:
+		c.State.UpdateNode(decoded.From, payload)
+		if c.keys != nil {
+			c.keys.AddUser(decoded.From, payload)
+		}
+	case *meshtastic.Position, *meshtastic.Telemetry:
+		c.State.UpdateNode(decoded.From, payload)
+	}
+}
+
+// myNodeID returns the ID of the node the client is connected to, or zero if it is not yet known.
+func (c *Client) myNodeID() meshtool.NodeID {
+	return meshtool.NodeID(c.State.NodeInfo().GetMyNodeNum())
+}
+
+// Stats returns a snapshot of the connection level metrics of the client.
+func (c *Client) Stats() Stats {
+	stats := c.stats.snapshot()
+	if counter, ok := c.sc.(byteCounter); ok {
+		stats.BytesRead = counter.BytesRead()
+		stats.BytesWritten = counter.BytesWritten()
+	}
+	return stats
+}
+
+func (c *Client) write(msg *meshtastic.ToRadio) error {
+	return c.writeContext(context.Background(), msg)
+}
+
+// writeContext writes msg to the radio, giving up if ctx is done first when the Transport supports it, as *StreamConn
+// does.
+func (c *Client) writeContext(ctx context.Context, msg *meshtastic.ToRadio) error {
+	if w, ok := c.sc.(interface {
+		WriteContext(context.Context, proto.Message) error
+	}); ok {
+		return w.WriteContext(ctx, msg)
+	}
 	return c.sc.Write(msg)
 }
 
+// Connect requests the radio's config and starts reading messages from it, returning once the config has been
+// received. If ctx is done before then, the StreamConn is closed and ErrTimeout is returned. Once Connect has
+// returned, ctx no longer has any effect, and messages are read until Disconnect is called or reading fails, after
+// which Done is closed and Err returns the reason.
+//
+// The radio can miss the request for its config, such as when it has just been connected over USB, so it is resent
+// with a new config ID every DefaultWantConfigInterval, or the interval set with WithWantConfigInterval, until the
+// radio responds.
+//
+// Errors reading from the radio are retried with a backoff, up to maxConsecutiveReadErrors times in a row. If the
+// radio closes the connection or reading is abandoned before config is complete, Connect returns an error, which wraps
+// ErrStreamClosed in the former case. After config is complete, such failures close the connection, are logged and are
+// returned by Err.
 func (c *Client) Connect(ctx context.Context) error {
-	if err := c.sendGetConfig(); err != nil {
+	c.stats.recordConnectStarted()
+	requestedAt := time.Now().UnixNano()
+	if err := c.sendGetConfig(ctx); err != nil {
+		if ctx.Err() != nil {
+			if closeErr := c.closeConn(); closeErr != nil {
+				c.log.Debug("closing connection after timeout", "err", closeErr)
+			}
+			return ErrTimeout
+		}
 		return fmt.Errorf("requesting config: %w", err)
 	}
 	cfgComplete := make(chan struct{})
+	// readFailed receives the error which caused reading from the radio to stop, other than the client closing the
+	// connection. It is buffered so that the read goroutine does not block once Connect has returned.
+	readFailed := make(chan error, 1)
+	c.readDone = make(chan struct{})
 	go func() {
+		defer close(c.readDone)
+		// pending holds messages which arrive while the radio is still sending its config, to be handled once config
+		// is complete.
+		var pending []proto.Message
+		// readErrors is the number of consecutive errors reading from the radio.
+		readErrors := 0
 		for {
 			msg := &meshtastic.FromRadio{}
 			err := c.sc.Read(msg)
 			if err != nil {
-				c.log.Error("error reading from radio", "err", err)
+				if c.closed.Load() || c.disconnecting.Load() {
+					c.log.Debug("stopped reading from radio", "err", err)
+					return
+				}
+				if isClosedErr(err) {
+					c.log.Warn("connection to radio closed", "err", err)
+					c.stopReading(fmt.Errorf("%w: %w", ErrStreamClosed, err))
+					readFailed <- c.Err()
+					return
+				}
+				c.stats.readErrors.Add(1)
+				readErrors++
+				c.log.Error("error reading from radio", "err", err, "consecutive", readErrors)
+				if readErrors >= maxConsecutiveReadErrors {
+					c.stopReading(fmt.Errorf("giving up after %d consecutive read errors: %w", readErrors, err))
+					readFailed <- c.Err()
+					return
+				}
+				select {
+				case <-c.done:
+					return
+				case <-time.After(readErrorBackoff(readErrors)):
+				}
 				continue
 			}
+			readErrors = 0
+			c.stats.recordRead(msg)
 			c.log.Debug("received message from radio", "msg", msg)
 			var variant proto.Message
+			isConfig := false
 			switch msg.GetPayloadVariant().(type) {
 			// These pbufs all get sent upon initial connection to the node
 			case *meshtastic.FromRadio_MyInfo:
 				c.State.SetNodeInfo(msg.GetMyInfo())
 				variant = c.State.nodeInfo
+				isConfig = true
 			case *meshtastic.FromRadio_Metadata:
 				c.State.SetDeviceMetadata(msg.GetMetadata())
 				variant = c.State.deviceMetadata
+				isConfig = true
 			case *meshtastic.FromRadio_NodeInfo:
 				node := msg.GetNodeInfo()
 				c.State.AddNode(node)
+				if c.keys != nil {
+					c.keys.AddUser(node.GetNum(), node.GetUser())
+				}
 				variant = node
+				isConfig = true
 			case *meshtastic.FromRadio_Channel:
 				channel := msg.GetChannel()
 				c.State.AddChannel(channel)
 				variant = channel
+				isConfig = true
 			case *meshtastic.FromRadio_Config:
 				cfg := msg.GetConfig()
 				c.State.AddConfig(cfg)
+				if privateKey := cfg.GetSecurity().GetPrivateKey(); c.keys != nil && len(privateKey) > 0 {
+					c.keys.SetPrivateKey(privateKey)
+				}
 				variant = cfg
+				isConfig = true
 			case *meshtastic.FromRadio_ModuleConfig:
 				cfg := msg.GetModuleConfig()
 				c.State.AddModule(cfg)
 				variant = cfg
+				isConfig = true
 			case *meshtastic.FromRadio_ConfigCompleteId:
 				// logged here because it's not an actual proto.Message that we can call handlers on
 				c.log.Debug("config complete")
 				if !c.State.Complete() {
+					c.stats.recordConfigComplete()
 					close(cfgComplete)
 				}
 				c.State.SetComplete(true)
+				for _, m := range pending {
+					c.handleMessage(m)
+				}
+				pending = nil
 				continue
 				// below are packets not part of initial connection
 
@@ -240,6 +459,7 @@ func (c *Client) Connect(ctx context.Context) error {
 				variant = msg.GetMqttClientProxyMessage()
 			case *meshtastic.FromRadio_QueueStatus:
 				variant = msg.GetQueueStatus()
+				c.setQueueStatus(msg.GetQueueStatus())
 			case *meshtastic.FromRadio_Rebooted:
 				// true if radio just rebooted
 				// logged here because it's not an actual proto.Message that we can call handlers on
@@ -250,26 +470,134 @@ func (c *Client) Connect(ctx context.Context) error {
 				variant = msg.GetXmodemPacket()
 			case *meshtastic.FromRadio_Packet:
 				variant = msg.GetPacket()
+				c.updateNodeDB(msg.GetPacket())
 			default:
 				c.log.Warn("unhandled protobuf from radio")
+				continue
 			}
 
 			if !c.State.Complete() {
-				continue
-			}
-			err = c.handlers.HandleMessage(variant)
-			if err != nil {
-				c.log.Error("error handling message", "err", err)
+				if isConfig {
+					if c.handleConfig {
+						c.handleMessage(variant)
+					}
+					continue
+				}
+				if !c.handleBeforeConfigComplete {
+					if len(pending) >= maxPendingMessages {
+						c.log.Warn("dropping message received before config complete", "msg", variant)
+						continue
+					}
+					pending = append(pending, variant)
+					continue
+				}
 			}
+			c.handleMessage(variant)
 		}
 	}()
 
+	retry := time.NewTicker(c.wantConfigInterval)
+	defer retry.Stop()
 	for {
 		select {
 		case <-ctx.Done():
+			// Closing the connection unblocks the read goroutine so that it can exit.
+			if err := c.closeConn(); err != nil {
+				c.log.Debug("closing connection after timeout", "err", err)
+			}
 			return ErrTimeout
+		case err := <-readFailed:
+			return err
 		case <-cfgComplete:
 			return nil
+		case <-retry.C:
+			// A new request restarts the config being sent, so only resend if the radio has not yet responded.
+			if c.stats.lastMessage.Load() >= requestedAt {
+				continue
+			}
+			c.log.Debug("no response from radio, resending want config")
+			requestedAt = time.Now().UnixNano()
+			if err := c.sendGetConfig(ctx); err != nil {
+				c.log.Warn("error resending want config", "err", err)
+			}
 		}
 	}
 }
+
+// readErrorBackoff returns how long to wait before reading from the radio again after the given number of consecutive
+// read errors.
+func readErrorBackoff(readErrors int) time.Duration {
+	return min(initialReadErrorBackoff<<(readErrors-1), maxReadErrorBackoff)
+}
+
+// Disconnect tells the radio that the client is disconnecting, stops reading from it and closes the StreamConn. It is
+// safe to call more than once, and after Connect has failed.
+func (c *Client) Disconnect() error {
+	c.disconnectOnce.Do(func() {
+		// The radio may close the connection in response to being told, which is not a failure to report.
+		c.disconnecting.Store(true)
+		if !c.closed.Load() {
+			err := c.write(&meshtastic.ToRadio{
+				PayloadVariant: &meshtastic.ToRadio_Disconnect{Disconnect: true},
+			})
+			if err != nil {
+				c.log.Debug("error sending disconnect", "err", err)
+			}
+		}
+		if err := c.closeConn(); err != nil {
+			c.disconnectErr = fmt.Errorf("closing connection: %w", err)
+		}
+		if c.readDone != nil {
+			<-c.readDone
+		}
+	})
+	return c.disconnectErr
+}
+
+// Done returns a channel which is closed once the connection to the radio has been closed, either by Disconnect or
+// because reading from the radio failed. Err then returns the reason reading failed.
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err returns the error which caused the client to stop reading from the radio, such as the radio closing the
+// connection, or nil if it is still reading or was stopped by Disconnect.
+func (c *Client) Err() error {
+	c.errMu.Lock()
+	defer c.errMu.Unlock()
+	return c.err
+}
+
+// stopReading records the error which caused reading from the radio to stop and closes the connection, so that Done
+// is closed and the client reports the failure rather than appearing healthy.
+func (c *Client) stopReading(err error) {
+	c.errMu.Lock()
+	c.err = err
+	c.errMu.Unlock()
+	if closeErr := c.closeConn(); closeErr != nil {
+		c.log.Debug("closing connection after read failure", "err", closeErr)
+	}
+	if c.State.Complete() {
+		c.log.Error("stopped reading from radio", "err", err)
+	}
+}
+
+// closeConn closes the StreamConn. Only the first call closes it, with later calls returning nil.
+func (c *Client) closeConn() (err error) {
+	c.closeOnce.Do(func() {
+		c.closed.Store(true)
+		err = c.sc.Close()
+		close(c.done)
+	})
+	return err
+}
+
+// isClosedErr reports whether err was returned from reading a connection which has been closed, after which no
+// further messages can be read.
+func isClosedErr(err error) bool {
+	return errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, io.ErrClosedPipe) ||
+		errors.Is(err, net.ErrClosed) ||
+		errors.Is(err, os.ErrClosed)
+}
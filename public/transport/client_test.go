@@ -0,0 +1,722 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/rabarar/meshtastic"
+	"github.com/rabarar/meshtool-go/public/meshtool"
+	"github.com/rabarar/meshtool-go/public/radio"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestNewClient_WithLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(log.New(&buf))
+	c := NewClient(nil, true, WithLogger(logger))
+
+	// No handler is registered, so handling a message logs an error.
+	c.handleMessage(&meshtastic.QueueStatus{})
+	require.Contains(t, buf.String(), "error handling message")
+}
+
+// hangUpConn is a net.Conn which, after writing a disconnect request, waits for a read to fail before returning. This
+// ensures the client sees the radio hang up in response before Disconnect has closed the connection itself.
+type hangUpConn struct {
+	net.Conn
+	readFailed chan struct{}
+	once       sync.Once
+}
+
+func (c *hangUpConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if err != nil {
+		c.once.Do(func() {
+			close(c.readFailed)
+		})
+	}
+	return n, err
+}
+
+func (c *hangUpConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	msg := &meshtastic.ToRadio{}
+	if proto.Unmarshal(p, msg) == nil && msg.GetDisconnect() {
+		select {
+		case <-c.readFailed:
+		case <-time.After(time.Second):
+		}
+	}
+	return n, err
+}
+
+func TestClient_Disconnect_RadioHangsUp(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	clientEnd, radioEnd := net.Pipe()
+	t.Cleanup(func() {
+		radioEnd.Close()
+	})
+	go func() {
+		sc := NewRadioStreamConn(radioEnd)
+		for {
+			msg := &meshtastic.ToRadio{}
+			if err := sc.Read(msg); err != nil {
+				return
+			}
+			if id := msg.GetWantConfigId(); id != 0 {
+				_ = sc.Write(&meshtastic.FromRadio{
+					PayloadVariant: &meshtastic.FromRadio_ConfigCompleteId{ConfigCompleteId: id},
+				})
+			}
+			// Radios close the connection once the client says it is disconnecting.
+			if msg.GetDisconnect() {
+				radioEnd.Close()
+				return
+			}
+		}
+	}()
+	conn := &hangUpConn{Conn: clientEnd, readFailed: make(chan struct{})}
+	c := NewClient(NewRadioStreamConn(conn), false)
+	require.NoError(t, c.Connect(ctx))
+
+	require.NoError(t, c.Disconnect())
+	<-c.Done()
+	require.NoError(t, c.Err())
+}
+
+// encryptedPacket returns a MeshPacket carrying data encrypted with key, as received from the named channel.
+func encryptedPacket(t *testing.T, channel string, key []byte, data *meshtastic.Data) *meshtastic.MeshPacket {
+	t.Helper()
+	plaintext, err := proto.Marshal(data)
+	require.NoError(t, err)
+	encrypted, err := radio.XOR(plaintext, key, 99, 0xdeadbeef)
+	require.NoError(t, err)
+	return &meshtastic.MeshPacket{
+		Id:             99,
+		From:           0xdeadbeef,
+		Channel:        uint32(radio.ChannelNumber(channel, key)),
+		PayloadVariant: &meshtastic.MeshPacket_Encrypted{Encrypted: encrypted},
+	}
+}
+
+func TestClient_HandleDecoded(t *testing.T) {
+	text := &meshtastic.Data{Portnum: meshtastic.PortNum_TEXT_MESSAGE_APP, Payload: []byte("hello")}
+	tests := []struct {
+		name   string
+		opts   []ClientOption
+		packet *meshtastic.MeshPacket
+		want   proto.Message
+	}{
+		{
+			name:   "decoded",
+			packet: &meshtastic.MeshPacket{Id: 1, PayloadVariant: &meshtastic.MeshPacket_Decoded{Decoded: text}},
+			want:   wrapperspb.String("hello"),
+		},
+		{
+			name:   "encrypted",
+			opts:   []ClientOption{WithKeyring(radio.NewThing(nil))},
+			packet: encryptedPacket(t, "LongFast", radio.DefaultKey, text),
+			want:   wrapperspb.String("hello"),
+		},
+		{
+			name:   "encrypted without keyring",
+			packet: encryptedPacket(t, "LongFast", radio.DefaultKey, text),
+		},
+		{
+			name:   "encrypted with unknown key",
+			opts:   []ClientOption{WithKeyring(radio.NewThing(nil))},
+			packet: encryptedPacket(t, "LongFast", []byte("0123456789abcdef"), text),
+		},
+		{
+			name: "unknown portnum",
+			packet: &meshtastic.MeshPacket{Id: 1, PayloadVariant: &meshtastic.MeshPacket_Decoded{Decoded: &meshtastic.Data{
+				Portnum: meshtastic.PortNum_PRIVATE_APP,
+			}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewClient(nil, false, tt.opts...)
+			type decoded struct {
+				packet *meshtastic.MeshPacket
+				msg    proto.Message
+			}
+			received := make(chan decoded, 1)
+			c.HandleDecoded(func(packet *meshtastic.MeshPacket, msg proto.Message) {
+				received <- decoded{packet: packet, msg: msg}
+			})
+			require.NoError(t, c.handlers.HandleMessage(tt.packet))
+
+			select {
+			case got := <-received:
+				require.NotNil(t, tt.want, "undecodable packet was handled")
+				require.Same(t, tt.packet, got.packet)
+				require.True(t, proto.Equal(tt.want, got.msg))
+			case <-time.After(50 * time.Millisecond):
+				require.Nil(t, tt.want, "packet not handled")
+			}
+		})
+	}
+}
+
+func TestClient_HandlePort(t *testing.T) {
+	c := NewClient(nil, false, WithKeyring(radio.NewThing(nil)))
+	received := make(chan *meshtool.DecodedPacket, 2)
+	c.HandlePort(meshtastic.PortNum_TEXT_MESSAGE_APP, func(packet *meshtool.DecodedPacket) {
+		received <- packet
+	})
+
+	require.NoError(t, c.handlers.HandleMessage(&meshtastic.MeshPacket{Id: 1, PayloadVariant: &meshtastic.MeshPacket_Decoded{Decoded: &meshtastic.Data{
+		Portnum: meshtastic.PortNum_POSITION_APP,
+	}}}))
+	require.NoError(t, c.handlers.HandleMessage(encryptedPacket(t, "LongFast", radio.DefaultKey, &meshtastic.Data{
+		Portnum: meshtastic.PortNum_TEXT_MESSAGE_APP,
+		Payload: []byte("hello"),
+	})))
+
+	select {
+	case packet := <-received:
+		require.Equal(t, uint32(99), packet.ID)
+		require.Equal(t, "LongFast", packet.Channel)
+		require.Equal(t, meshtastic.PortNum_TEXT_MESSAGE_APP, packet.Portnum)
+		require.True(t, proto.Equal(wrapperspb.String("hello"), packet.Payload))
+	case <-time.After(time.Second):
+		t.Fatal("text message not handled")
+	}
+	select {
+	case packet := <-received:
+		t.Fatalf("handled packet on %s", packet.Portnum)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestClient_Connect_PendingMessages(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []ClientOption
+		// wantBeforeComplete is the IDs of the packets handled before the radio completes sending its config.
+		wantBeforeComplete []uint32
+	}{
+		{
+			name: "held until config complete",
+		},
+		{
+			name:               "WithHandleBeforeConfigComplete",
+			opts:               []ClientOption{WithHandleBeforeConfigComplete()},
+			wantBeforeComplete: []uint32{1, 2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientEnd, radioEnd := net.Pipe()
+			t.Cleanup(func() {
+				radioEnd.Close()
+			})
+			// The radio sends packets amongst its config, and only completes config once released.
+			release := make(chan struct{})
+			go func() {
+				sc := NewRadioStreamConn(radioEnd)
+				msg := &meshtastic.ToRadio{}
+				if err := sc.Read(msg); err != nil {
+					return
+				}
+				go io.Copy(io.Discard, radioEnd)
+				for _, m := range []*meshtastic.FromRadio{
+					{PayloadVariant: &meshtastic.FromRadio_Packet{Packet: &meshtastic.MeshPacket{Id: 1}}},
+					{PayloadVariant: &meshtastic.FromRadio_Channel{Channel: &meshtastic.Channel{Index: 0}}},
+					{PayloadVariant: &meshtastic.FromRadio_Packet{Packet: &meshtastic.MeshPacket{Id: 2}}},
+				} {
+					_ = sc.Write(m)
+				}
+				<-release
+				_ = sc.Write(&meshtastic.FromRadio{
+					PayloadVariant: &meshtastic.FromRadio_ConfigCompleteId{ConfigCompleteId: msg.GetWantConfigId()},
+				})
+				_ = sc.Write(&meshtastic.FromRadio{
+					PayloadVariant: &meshtastic.FromRadio_Packet{Packet: &meshtastic.MeshPacket{Id: 3}},
+				})
+			}()
+
+			c := NewClient(NewRadioStreamConn(clientEnd), false, tt.opts...)
+			t.Cleanup(func() {
+				_ = c.Disconnect()
+			})
+			packets, cancelPackets := c.SubscribePackets()
+			defer cancelPackets()
+			connectErr := make(chan error, 1)
+			go func() {
+				ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+				defer cancel()
+				connectErr <- c.Connect(ctx)
+			}()
+
+			receive := func(n int) []uint32 {
+				var ids []uint32
+				timeout := time.After(100 * time.Millisecond)
+				for len(ids) < n {
+					select {
+					case packet := <-packets:
+						ids = append(ids, packet.Id)
+					case <-timeout:
+						return ids
+					}
+				}
+				return ids
+			}
+			require.Equal(t, tt.wantBeforeComplete, receive(2))
+			require.False(t, c.State.Complete())
+
+			close(release)
+			require.NoError(t, <-connectErr)
+			want := []uint32{3}
+			if tt.wantBeforeComplete == nil {
+				// Held packets are handled in the order they were received, before any received after config.
+				want = []uint32{1, 2, 3}
+			}
+			require.Equal(t, want, receive(len(want)))
+			require.Len(t, c.State.Channels(), 1)
+		})
+	}
+}
+
+func TestClient_Connect_CancelStopsReading(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	clientEnd, radioEnd := net.Pipe()
+	// The radio never responds, so config is never completed.
+	go io.Copy(io.Discard, radioEnd)
+	c := NewClient(NewRadioStreamConn(clientEnd), false)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, c.Connect(ctx), ErrTimeout)
+
+	// require.Eventually runs its condition in another goroutine, so poll directly.
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	require.LessOrEqual(t, runtime.NumGoroutine(), before)
+}
+
+func TestClient_Connect_RadioNotReading(t *testing.T) {
+	clientEnd, radioEnd := net.Pipe()
+	defer radioEnd.Close()
+	// The radio never reads, so the request for its config can't be written.
+	c := NewClient(NewRadioStreamConn(clientEnd), false)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, c.Connect(ctx), ErrTimeout)
+	require.NoError(t, c.Disconnect())
+}
+
+func TestClient_SendText(t *testing.T) {
+	conn := &bufferConn{}
+	c := NewClient(NewRadioStreamConn(conn), false)
+
+	id, err := c.SendText(meshtool.BroadcastNodeID, 2, "hello mesh")
+	require.NoError(t, err)
+	require.NotZero(t, id)
+
+	received := &meshtastic.ToRadio{}
+	require.NoError(t, NewRadioStreamConn(conn).Read(received))
+	packet := received.GetPacket()
+	require.Equal(t, id, packet.GetId())
+	require.Equal(t, uint32(meshtool.BroadcastNodeID), packet.GetTo())
+	require.Equal(t, uint32(2), packet.GetChannel())
+	require.Equal(t, meshtastic.PortNum_TEXT_MESSAGE_APP, packet.GetDecoded().GetPortnum())
+	require.Equal(t, "hello mesh", string(packet.GetDecoded().GetPayload()))
+}
+
+func TestClient_SendWaypoint(t *testing.T) {
+	latitude, longitude := int32(515014760), int32(-1406340)
+	tests := []struct {
+		name     string
+		waypoint *meshtastic.Waypoint
+		wantErr  bool
+	}{
+		{
+			name: "new",
+			waypoint: &meshtastic.Waypoint{
+				LatitudeI:  &latitude,
+				LongitudeI: &longitude,
+				Name:       "Camp",
+				Expire:     1900000000,
+				LockedTo:   0x1234,
+			},
+		},
+		{
+			name:     "update",
+			waypoint: &meshtastic.Waypoint{Id: 42, LatitudeI: &latitude, LongitudeI: &longitude, Name: "Camp"},
+		},
+		{name: "no position", waypoint: &meshtastic.Waypoint{Name: "Nowhere"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := &bufferConn{}
+			c := NewClient(NewRadioStreamConn(conn), false)
+
+			id, err := c.SendWaypoint(tt.waypoint, meshtool.BroadcastNodeID)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			received := &meshtastic.ToRadio{}
+			require.NoError(t, NewRadioStreamConn(conn).Read(received))
+			packet := received.GetPacket()
+			require.Equal(t, id, packet.GetId())
+			require.Equal(t, uint32(meshtool.BroadcastNodeID), packet.GetTo())
+			require.Equal(t, meshtastic.PortNum_WAYPOINT_APP, packet.GetDecoded().GetPortnum())
+			got := &meshtastic.Waypoint{}
+			require.NoError(t, proto.Unmarshal(packet.GetDecoded().GetPayload(), got))
+			require.NotZero(t, got.Id)
+			if tt.waypoint.Id != 0 {
+				require.Equal(t, tt.waypoint.Id, got.Id)
+			}
+			got.Id = tt.waypoint.Id
+			require.True(t, proto.Equal(tt.waypoint, got))
+		})
+	}
+}
+
+// startFakeRadio connects a Client to a fake radio which completes config immediately, returning the client and a
+// channel receiving each message the client sends after config.
+func startFakeRadio(t *testing.T) (*Client, <-chan *meshtastic.ToRadio) {
+	clientEnd, radioEnd := net.Pipe()
+	t.Cleanup(func() {
+		radioEnd.Close()
+	})
+	received := make(chan *meshtastic.ToRadio, 16)
+	go func() {
+		defer close(received)
+		sc := NewRadioStreamConn(radioEnd)
+		for {
+			msg := &meshtastic.ToRadio{}
+			if err := sc.Read(msg); err != nil {
+				return
+			}
+			if id := msg.GetWantConfigId(); id != 0 {
+				_ = sc.Write(&meshtastic.FromRadio{
+					PayloadVariant: &meshtastic.FromRadio_ConfigCompleteId{ConfigCompleteId: id},
+				})
+				continue
+			}
+			received <- msg
+		}
+	}()
+
+	c := NewClient(NewRadioStreamConn(clientEnd), false)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(t, c.Connect(ctx))
+	return c, received
+}
+
+func TestClient_Connect_HandleConfig(t *testing.T) {
+	tests := []struct {
+		name         string
+		opts         []ClientOption
+		wantChannels int
+	}{
+		{
+			name: "default",
+		},
+		{
+			name:         "WithHandleConfig",
+			opts:         []ClientOption{WithHandleConfig()},
+			wantChannels: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientEnd, radioEnd := net.Pipe()
+			t.Cleanup(func() {
+				radioEnd.Close()
+			})
+			go func() {
+				sc := NewRadioStreamConn(radioEnd)
+				for {
+					msg := &meshtastic.ToRadio{}
+					if err := sc.Read(msg); err != nil {
+						return
+					}
+					id := msg.GetWantConfigId()
+					if id == 0 {
+						continue
+					}
+					for i := range 2 {
+						_ = sc.Write(&meshtastic.FromRadio{
+							PayloadVariant: &meshtastic.FromRadio_Channel{Channel: &meshtastic.Channel{Index: int32(i)}},
+						})
+					}
+					_ = sc.Write(&meshtastic.FromRadio{
+						PayloadVariant: &meshtastic.FromRadio_ConfigCompleteId{ConfigCompleteId: id},
+					})
+				}
+			}()
+
+			c := NewClient(NewRadioStreamConn(clientEnd), false, tt.opts...)
+			channels := make(chan *meshtastic.Channel, 2)
+			c.Handle(&meshtastic.Channel{}, func(msg proto.Message) {
+				channels <- msg.(*meshtastic.Channel)
+			})
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			require.NoError(t, c.Connect(ctx))
+			require.Len(t, c.State.Channels(), 2)
+
+			// Handlers are called in their own goroutines, so wait briefly for any which are still running.
+			var handled int
+			timeout := time.After(100 * time.Millisecond)
+		wait:
+			for handled < 2 {
+				select {
+				case <-channels:
+					handled++
+				case <-timeout:
+					break wait
+				}
+			}
+			require.Equal(t, tt.wantChannels, handled)
+		})
+	}
+}
+
+func TestClient_Disconnect(t *testing.T) {
+	before := runtime.NumGoroutine()
+	c, received := startFakeRadio(t)
+
+	require.NoError(t, c.Disconnect())
+	msg := <-received
+	require.True(t, msg.GetDisconnect())
+	// The radio sees the connection close once the client has disconnected.
+	_, ok := <-received
+	require.False(t, ok)
+
+	require.NoError(t, c.Disconnect())
+	_, err := c.SendText(1, 0, "hello")
+	require.Error(t, err)
+
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	require.LessOrEqual(t, runtime.NumGoroutine(), before)
+}
+
+func TestClient_Disconnect_AfterFailedConnect(t *testing.T) {
+	clientEnd, radioEnd := net.Pipe()
+	go io.Copy(io.Discard, radioEnd)
+	c := NewClient(NewRadioStreamConn(clientEnd), false)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, c.Connect(ctx), ErrTimeout)
+	require.NoError(t, c.Disconnect())
+}
+
+// garbageConn is an io.ReadWriteCloser which discards writes and reads an endless stream of correctly framed messages
+// which are not valid protobufs.
+type garbageConn struct {
+	offset int
+}
+
+func (g *garbageConn) Read(p []byte) (int, error) {
+	frame := []byte{Start1, Start2, 0x00, 0x02, 0xff, 0xff}
+	for i := range p {
+		p[i] = frame[g.offset%len(frame)]
+		g.offset++
+	}
+	return len(p), nil
+}
+
+func (*garbageConn) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func (*garbageConn) Close() error {
+	return nil
+}
+
+func TestClient_Connect_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		conn    func() io.ReadWriteCloser
+		wantErr error
+	}{
+		{
+			name: "radio closes connection",
+			conn: func() io.ReadWriteCloser {
+				clientEnd, radioEnd := net.Pipe()
+				go func() {
+					// Read the want config request, then hang up.
+					_, _ = NewRadioStreamConn(radioEnd).ReadBytes()
+					radioEnd.Close()
+				}()
+				return clientEnd
+			},
+			wantErr: ErrStreamClosed,
+		},
+		{
+			name: "malformed stream",
+			conn: func() io.ReadWriteCloser {
+				return &garbageConn{}
+			},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			c := NewClient(NewRadioStreamConn(tc.conn()), false)
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			err := c.Connect(ctx)
+			require.Error(t, err)
+			require.NotErrorIs(t, err, ErrTimeout)
+			if tc.wantErr != nil {
+				require.ErrorIs(t, err, tc.wantErr)
+			}
+		})
+	}
+}
+
+// connectScriptedRadio connects a Client to a fake radio which completes config immediately and then only reads,
+// returning the client and the radio's end of the connection for the test to write further messages to.
+func connectScriptedRadio(t *testing.T, ctx context.Context) (*Client, net.Conn) {
+	t.Helper()
+	clientEnd, radioEnd := net.Pipe()
+	t.Cleanup(func() {
+		radioEnd.Close()
+	})
+	configured := make(chan struct{})
+	go func() {
+		sc := NewRadioStreamConn(radioEnd)
+		for {
+			msg := &meshtastic.ToRadio{}
+			if err := sc.Read(msg); err != nil {
+				return
+			}
+			if id := msg.GetWantConfigId(); id != 0 {
+				_ = sc.Write(&meshtastic.FromRadio{
+					PayloadVariant: &meshtastic.FromRadio_ConfigCompleteId{ConfigCompleteId: id},
+				})
+				close(configured)
+				return
+			}
+		}
+	}()
+
+	c := NewClient(NewRadioStreamConn(clientEnd), false)
+	t.Cleanup(func() {
+		_ = c.Disconnect()
+	})
+	require.NoError(t, c.Connect(ctx))
+	<-configured
+	// Discard anything else the client sends, such as the disconnect request.
+	go io.Copy(io.Discard, radioEnd)
+	return c, radioEnd
+}
+
+// malformedFrame is a correctly framed message which is not a valid FromRadio protobuf, so reading it fails.
+var malformedFrame = []byte{Start1, Start2, 0x00, 0x02, 0xff, 0xff}
+
+func TestClient_Connect_ReadsAfterDeadline(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	c, radioEnd := connectScriptedRadio(t, ctx)
+	packets := make(chan *meshtastic.MeshPacket, 1)
+	c.Handle(&meshtastic.MeshPacket{}, func(msg proto.Message) {
+		packets <- msg.(*meshtastic.MeshPacket)
+	})
+	<-ctx.Done()
+
+	// A read error after the deadline used to stop the client reading for good. The writes block until the client
+	// reads them, so they are made in the background.
+	go func() {
+		if _, err := radioEnd.Write(malformedFrame); err != nil {
+			return
+		}
+		_ = NewRadioStreamConn(radioEnd).Write(&meshtastic.FromRadio{
+			PayloadVariant: &meshtastic.FromRadio_Packet{Packet: &meshtastic.MeshPacket{Id: 1}},
+		})
+	}()
+	select {
+	case packet := <-packets:
+		require.Equal(t, uint32(1), packet.Id)
+	case <-time.After(time.Second):
+		t.Fatal("packet not received after the Connect deadline")
+	}
+	require.NoError(t, c.Err())
+}
+
+func TestClient_Err(t *testing.T) {
+	tests := []struct {
+		name    string
+		fail    func(t *testing.T, radioEnd net.Conn)
+		wantErr error
+	}{
+		{
+			name: "radio closes connection",
+			fail: func(t *testing.T, radioEnd net.Conn) {
+				require.NoError(t, radioEnd.Close())
+			},
+			wantErr: ErrStreamClosed,
+		},
+		{
+			name: "malformed stream",
+			fail: func(t *testing.T, radioEnd net.Conn) {
+				for range maxConsecutiveReadErrors {
+					_, err := radioEnd.Write(malformedFrame)
+					require.NoError(t, err)
+				}
+			},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			c, radioEnd := connectScriptedRadio(t, ctx)
+			require.NoError(t, c.Err())
+
+			tc.fail(t, radioEnd)
+			select {
+			case <-c.Done():
+			case <-time.After(5 * time.Second):
+				t.Fatal("client still appears healthy after reading failed")
+			}
+			require.Error(t, c.Err())
+			if tc.wantErr != nil {
+				require.ErrorIs(t, c.Err(), tc.wantErr)
+			}
+			_, err := c.SendText(1, 0, "hello")
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestClient_Done_Disconnect(t *testing.T) {
+	c, _ := startFakeRadio(t)
+	select {
+	case <-c.Done():
+		t.Fatal("Done closed while connected")
+	default:
+	}
+	require.NoError(t, c.Disconnect())
+	<-c.Done()
+	require.NoError(t, c.Err())
+}
@@ -0,0 +1,124 @@
+package transport
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// FrameScanner reads the protobuf messages framed by the meshtastic stream protocol from an io.Reader, such as a
+// captured log of a serial connection, without needing a connection to a radio. Its API follows bufio.Scanner:
+//
+//	scanner := transport.NewFrameScanner(f)
+//	for scanner.Scan() {
+//		msg := &meshtastic.FromRadio{}
+//		if err := scanner.Decode(msg); err != nil {
+//			continue
+//		}
+//		// ...
+//	}
+//	if err := scanner.Err(); err != nil {
+//		// ...
+//	}
+//
+// Bytes which are not part of a frame, such as noise or the radio's debug log output, are skipped until the start of
+// the next frame. Frames with a length greater than PacketMTU are assumed to be corrupt and are skipped.
+type FrameScanner struct {
+	r *bufio.Reader
+	// DebugWriter is an optional writer which receives the bytes read which are not part of a frame.
+	DebugWriter io.Writer
+
+	frame []byte
+	err   error
+}
+
+// NewFrameScanner creates a FrameScanner reading from r.
+func NewFrameScanner(r io.Reader) *FrameScanner {
+	return &FrameScanner{r: bufio.NewReader(r)}
+}
+
+// Scan advances to the next frame, which is then available from Frame and Decode. It returns false once the end of
+// the input is reached or an error occurs.
+func (s *FrameScanner) Scan() bool {
+	if s.err != nil {
+		return false
+	}
+	s.frame, s.err = readFrame(s.r, s.DebugWriter)
+	return s.err == nil
+}
+
+// Frame returns the payload of the most recent frame read by Scan, without its header.
+func (s *FrameScanner) Frame() []byte {
+	return s.frame
+}
+
+// Decode unmarshals the most recent frame read by Scan into out.
+func (s *FrameScanner) Decode(out proto.Message) error {
+	return proto.Unmarshal(s.frame, out)
+}
+
+// Err returns the first error encountered by Scan, other than the input ending between frames. A frame which is cut
+// off by the end of the input is reported as io.ErrUnexpectedEOF.
+func (s *FrameScanner) Err() error {
+	if errors.Is(s.err, io.EOF) {
+		return nil
+	}
+	return s.err
+}
+
+// readFrame reads the payload of the next frame from r, resynchronising on Start1 and Start2 after any bytes which
+// are not part of a frame. Skipped bytes are written to debug if it is not nil. io.EOF is returned only if r ends
+// between frames.
+func readFrame(r io.Reader, debug io.Writer) ([]byte, error) {
+	var header [4]byte
+	// sawStart1 is true when the previous byte read was Start1, so the next is expected to be Start2.
+	sawStart1 := false
+	for {
+		if _, err := io.ReadFull(r, header[:1]); err != nil {
+			if sawStart1 && errors.Is(err, io.EOF) {
+				return nil, io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+
+		switch {
+		case header[0] == Start1:
+			sawStart1 = true
+			continue
+		case !sawStart1 || header[0] != Start2:
+			sawStart1 = false
+			if debug != nil {
+				debug.Write(header[:1])
+			}
+			continue
+		}
+		sawStart1 = false
+
+		// The next two bytes should be the length of the protobuf message.
+		if _, err := io.ReadFull(r, header[2:]); err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		length := int(binary.BigEndian.Uint16(header[2:]))
+		if length > PacketMTU {
+			// packet corrupt, start over
+			continue
+		}
+
+		data := make([]byte, length)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		return data, nil
+	}
+}
+
+// unexpectedEOF converts io.EOF to io.ErrUnexpectedEOF, for when the input ends part way through a frame.
+func unexpectedEOF(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,100 @@
+package transport
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/rabarar/meshtastic"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestFrameScanner(t *testing.T) {
+	messages := []*meshtastic.FromRadio{
+		{Id: 1, PayloadVariant: &meshtastic.FromRadio_ConfigCompleteId{ConfigCompleteId: 42}},
+		{Id: 2, PayloadVariant: &meshtastic.FromRadio_Rebooted{Rebooted: true}},
+		{Id: 3},
+	}
+	frames := make([][]byte, len(messages))
+	for i, msg := range messages {
+		conn := &bufferConn{}
+		require.NoError(t, NewRadioStreamConn(conn).Write(msg))
+		frames[i] = conn.Bytes()
+	}
+
+	tests := []struct {
+		name      string
+		input     [][]byte
+		wantIDs   []uint32
+		wantDebug string
+		wantErr   error
+	}{
+		{
+			name:    "frames",
+			input:   frames,
+			wantIDs: []uint32{1, 2, 3},
+		},
+		{
+			name: "noise between frames",
+			input: [][]byte{
+				[]byte("INFO boot\n"), frames[0],
+				[]byte("DEBUG\n"), frames[1],
+			},
+			wantIDs:   []uint32{1, 2},
+			wantDebug: "INFO boot\nDEBUG\n",
+		},
+		{
+			name: "resync after repeated Start1",
+			input: [][]byte{
+				{Start1, Start1}, frames[0],
+			},
+			wantIDs: []uint32{1},
+		},
+		{
+			name: "Start1 not followed by Start2",
+			input: [][]byte{
+				{Start1, 'x'}, frames[0],
+			},
+			wantIDs:   []uint32{1},
+			wantDebug: "x",
+		},
+		{
+			name: "length over MTU",
+			input: [][]byte{
+				{Start1, Start2, 0xff, 0xff}, frames[0],
+			},
+			wantIDs: []uint32{1},
+		},
+		{
+			name: "truncated frame",
+			input: [][]byte{
+				frames[0], frames[1][:len(frames[1])-1],
+			},
+			wantIDs: []uint32{1},
+			wantErr: io.ErrUnexpectedEOF,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var debug bytes.Buffer
+			scanner := NewFrameScanner(bytes.NewReader(bytes.Join(tc.input, nil)))
+			scanner.DebugWriter = &debug
+
+			var ids []uint32
+			for scanner.Scan() {
+				msg := &meshtastic.FromRadio{}
+				require.NoError(t, scanner.Decode(msg))
+				require.True(t, proto.Equal(messages[msg.Id-1], msg))
+				ids = append(ids, msg.Id)
+			}
+			require.Equal(t, tc.wantIDs, ids)
+			require.Equal(t, tc.wantDebug, debug.String())
+			if tc.wantErr != nil {
+				require.ErrorIs(t, scanner.Err(), tc.wantErr)
+			} else {
+				require.NoError(t, scanner.Err())
+			}
+		})
+	}
+}
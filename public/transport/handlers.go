@@ -10,11 +10,16 @@ import (
 // MessageHandler defines the function signature for a handler that processes a protobuf message.
 type MessageHandler func(msg proto.Message)
 
-// HandlerRegistry holds registered handlers for protobuf messages.
+// HandlerRegistry holds registered handlers for protobuf messages, dispatching each message passed to HandleMessage to
+// the handlers for its type. A Client dispatches the messages it receives from the radio to its registry, and a
+// registry can be used standalone or shared between several sources of messages, see WithHandlerRegistry. It is safe
+// for concurrent use.
 type HandlerRegistry struct {
 	errorOnNoHandlers bool
 	mu                sync.RWMutex
 	handlers          map[string][]MessageHandler
+	// defaultHandlers are invoked for messages with no handlers registered for their type.
+	defaultHandlers []MessageHandler
 }
 
 // NewHandlerRegistry creates a new instance of HandlerRegistry. Set errorOnNoHandler to true if you want HandleMessage to return
@@ -39,7 +44,18 @@ func (r *HandlerRegistry) RegisterHandler(msg proto.Message, handler MessageHand
 	r.handlers[name] = append(r.handlers[name], handler)
 }
 
-// HandleMessage invokes all registered handlers for the provided protobuf message, in the order they were registered.
+// RegisterDefault registers a handler which is invoked for any message with no handlers registered for its type, such
+// as to log or forward every otherwise unhandled message in one place. While a default handler is registered,
+// HandleMessage no longer returns an error for unhandled messages.
+func (r *HandlerRegistry) RegisterDefault(handler MessageHandler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.defaultHandlers = append(r.defaultHandlers, handler)
+}
+
+// HandleMessage invokes all registered handlers for the provided protobuf message. Each handler is run in its own
+// goroutine, so handlers may run concurrently and in any order, and HandleMessage does not wait for them to return.
+// If none are registered for its type, the default handlers are invoked instead, see RegisterDefault.
 func (r *HandlerRegistry) HandleMessage(msg proto.Message) error {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
@@ -55,6 +71,10 @@ func (r *HandlerRegistry) HandleMessage(msg proto.Message) error {
 			go handler(msg)
 		}
 
+	} else if len(r.defaultHandlers) > 0 {
+		for _, handler := range r.defaultHandlers {
+			go handler(msg)
+		}
 	} else if r.errorOnNoHandlers {
 		return fmt.Errorf("no handlers registered for message: %s", msgName)
 	}
@@ -0,0 +1,77 @@
+package transport
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rabarar/meshtastic"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestHandlerRegistry_RegisterDefault(t *testing.T) {
+	tests := []struct {
+		name        string
+		msg         proto.Message
+		wantHandler string
+	}{
+		{name: "registered type", msg: &meshtastic.QueueStatus{}, wantHandler: "QueueStatus"},
+		{name: "unregistered type", msg: &meshtastic.LogRecord{}, wantHandler: "default"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Handlers run in their own goroutines, so report which was called over a channel.
+			called := make(chan string, 2)
+			r := NewHandlerRegistry(true)
+			r.RegisterHandler(&meshtastic.QueueStatus{}, func(proto.Message) {
+				called <- "QueueStatus"
+			})
+			r.RegisterDefault(func(msg proto.Message) {
+				called <- "default"
+			})
+
+			require.NoError(t, r.HandleMessage(tt.msg))
+			select {
+			case got := <-called:
+				require.Equal(t, tt.wantHandler, got)
+			case <-time.After(time.Second):
+				t.Fatal("no handler called")
+			}
+			select {
+			case got := <-called:
+				t.Fatalf("handler %q also called", got)
+			case <-time.After(50 * time.Millisecond):
+			}
+		})
+	}
+}
+
+func TestHandlerRegistry_ErrorOnNoHandler(t *testing.T) {
+	r := NewHandlerRegistry(true)
+	require.Error(t, r.HandleMessage(&meshtastic.LogRecord{}))
+	require.NoError(t, NewHandlerRegistry(false).HandleMessage(&meshtastic.LogRecord{}))
+}
+
+func TestClient_WithHandlerRegistry(t *testing.T) {
+	handlers := NewHandlerRegistry(false)
+	called := make(chan proto.Message, 2)
+	handlers.RegisterHandler(&meshtastic.QueueStatus{}, func(msg proto.Message) {
+		called <- msg
+	})
+	c := NewClient(nil, true, WithHandlerRegistry(handlers))
+	require.Same(t, handlers, c.Handlers())
+
+	// Both the client and another source of messages dispatch to the shared registry.
+	c.handleMessage(&meshtastic.QueueStatus{Free: 1})
+	require.NoError(t, handlers.HandleMessage(&meshtastic.QueueStatus{Free: 2}))
+	var free []uint32
+	for range 2 {
+		select {
+		case msg := <-called:
+			free = append(free, msg.(*meshtastic.QueueStatus).GetFree())
+		case <-time.After(time.Second):
+			t.Fatal("handler not called")
+		}
+	}
+	require.ElementsMatch(t, []uint32{1, 2}, free)
+}
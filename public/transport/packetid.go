@@ -0,0 +1,57 @@
+package transport
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+)
+
+// maxPacketIDAttempts is the number of times a PacketIDAllocator is asked for a non-zero ID before giving up.
+const maxPacketIDAttempts = 16
+
+// ErrNoPacketID is returned when sending a packet if the Client's PacketIDAllocator keeps returning zero.
+var ErrNoPacketID = errors.New("packet ID allocator returned no non-zero ID")
+
+// PacketIDAllocator returns IDs for packets sent by a Client. Implementations must be safe for concurrent use.
+// The Client skips any zero IDs returned, as the firmware treats a packet ID of zero as unset, and fails to send with
+// ErrNoPacketID if several in a row are zero.
+type PacketIDAllocator func() uint32
+
+// WithPacketIDAllocator sets the allocator used to assign IDs to outgoing packets. By default IDs count up from a
+// random starting point.
+func WithPacketIDAllocator(allocator PacketIDAllocator) ClientOption {
+	return func(c *Client) {
+		c.nextID = allocator
+	}
+}
+
+// NewSequentialPacketIDAllocator returns a PacketIDAllocator which counts up from start. It is mainly useful for tests
+// which need predictable packet IDs.
+func NewSequentialPacketIDAllocator(start uint32) PacketIDAllocator {
+	var mu sync.Mutex
+	next := start
+	return func() uint32 {
+		mu.Lock()
+		defer mu.Unlock()
+		id := next
+		next++
+		return id
+	}
+}
+
+// newRandomPacketIDAllocator returns a PacketIDAllocator which counts up from a random starting point, much like the
+// firmware does. Counting rather than picking each ID at random guarantees that IDs are not reused until the counter
+// wraps around.
+func newRandomPacketIDAllocator() PacketIDAllocator {
+	return NewSequentialPacketIDAllocator(rand.Uint32())
+}
+
+// allocatePacketID returns a new, non-zero, packet ID.
+func (c *Client) allocatePacketID() (uint32, error) {
+	for range maxPacketIDAttempts {
+		if id := c.nextID(); id != 0 {
+			return id, nil
+		}
+	}
+	return 0, ErrNoPacketID
+}
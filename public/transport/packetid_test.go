@@ -0,0 +1,52 @@
+package transport
+
+import (
+	"testing"
+
+	"github.com/rabarar/meshtastic"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewSequentialPacketIDAllocator(t *testing.T) {
+	next := NewSequentialPacketIDAllocator(41)
+	require.Equal(t, uint32(41), next())
+	require.Equal(t, uint32(42), next())
+}
+
+func TestClient_SendPacket_AllocatesID(t *testing.T) {
+	conn := &bufferConn{}
+	// The counter wraps to zero, which must be skipped.
+	c := NewClient(NewRadioStreamConn(conn), false, WithPacketIDAllocator(NewSequentialPacketIDAllocator(^uint32(0))))
+
+	first, err := c.SendPacket(NewTextPacket(1, 2, "hello").GetPacket())
+	require.NoError(t, err)
+	require.Equal(t, ^uint32(0), first)
+	second, err := c.SendPacket(NewTextPacket(1, 2, "world").GetPacket())
+	require.NoError(t, err)
+	require.Equal(t, uint32(1), second)
+	// IDs already set on a packet are kept.
+	third, err := c.SendPacket(&meshtastic.MeshPacket{Id: 42})
+	require.NoError(t, err)
+	require.Equal(t, uint32(42), third)
+
+	rc := NewRadioStreamConn(conn)
+	for _, want := range []uint32{first, second, third} {
+		received := &meshtastic.ToRadio{}
+		require.NoError(t, rc.Read(received))
+		require.Equal(t, want, received.GetPacket().GetId())
+	}
+}
+
+func TestClient_SendPacket_ZeroAllocator(t *testing.T) {
+	conn := &bufferConn{}
+	calls := 0
+	c := NewClient(NewRadioStreamConn(conn), false, WithPacketIDAllocator(func() uint32 {
+		calls++
+		return 0
+	}))
+
+	_, err := c.SendPacket(NewTextPacket(1, 2, "hello").GetPacket())
+	require.ErrorIs(t, err, ErrNoPacketID)
+	require.Equal(t, maxPacketIDAttempts, calls)
+	require.Zero(t, conn.Len(), "packet sent without an ID")
+}
@@ -0,0 +1,60 @@
+package transport
+
+import (
+	"github.com/rabarar/meshtastic"
+	"github.com/rabarar/meshtool-go/public/meshtool"
+	"github.com/rabarar/meshtool-go/public/radio"
+	"google.golang.org/protobuf/proto"
+)
+
+// NewDataPacket wraps a payload for the given portnum in a ToRadio packet from one node to another.
+func NewDataPacket(from, to meshtool.NodeID, portnum meshtastic.PortNum, payload []byte) *meshtastic.ToRadio {
+	return &meshtastic.ToRadio{
+		PayloadVariant: &meshtastic.ToRadio_Packet{
+			Packet: &meshtastic.MeshPacket{
+				From: from.Uint32(),
+				To:   to.Uint32(),
+				PayloadVariant: &meshtastic.MeshPacket_Decoded{
+					Decoded: &meshtastic.Data{
+						Portnum: portnum,
+						Payload: payload,
+					},
+				},
+			},
+		},
+	}
+}
+
+// NewTextPacket creates a ToRadio packet containing a text message.
+func NewTextPacket(from, to meshtool.NodeID, text string) *meshtastic.ToRadio {
+	return NewDataPacket(from, to, meshtastic.PortNum_TEXT_MESSAGE_APP, []byte(text))
+}
+
+// NewPayloadPacket creates a ToRadio packet containing msg, sent on the portnum for its type. See radio.PortNumFor.
+func NewPayloadPacket(from, to meshtool.NodeID, msg proto.Message) (*meshtastic.ToRadio, error) {
+	portnum, payload, err := radio.EncodePayload(msg)
+	if err != nil {
+		return nil, err
+	}
+	return NewDataPacket(from, to, portnum, payload), nil
+}
+
+// NewPositionPacket creates a ToRadio packet containing a Position.
+func NewPositionPacket(from, to meshtool.NodeID, position *meshtastic.Position) (*meshtastic.ToRadio, error) {
+	return NewPayloadPacket(from, to, position)
+}
+
+// NewNodeInfoPacket creates a ToRadio packet containing a User, which is how NodeInfo is exchanged over the mesh.
+func NewNodeInfoPacket(from, to meshtool.NodeID, user *meshtastic.User) (*meshtastic.ToRadio, error) {
+	return NewPayloadPacket(from, to, user)
+}
+
+// NewTelemetryPacket creates a ToRadio packet containing Telemetry.
+func NewTelemetryPacket(from, to meshtool.NodeID, telemetry *meshtastic.Telemetry) (*meshtastic.ToRadio, error) {
+	return NewPayloadPacket(from, to, telemetry)
+}
+
+// NewWaypointPacket creates a ToRadio packet containing a Waypoint.
+func NewWaypointPacket(from, to meshtool.NodeID, waypoint *meshtastic.Waypoint) (*meshtastic.ToRadio, error) {
+	return NewPayloadPacket(from, to, waypoint)
+}
@@ -0,0 +1,80 @@
+package transport
+
+import (
+	"testing"
+
+	"github.com/rabarar/meshtastic"
+	"github.com/rabarar/meshtool-go/public/meshtool"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestNewTextPacket(t *testing.T) {
+	want := &meshtastic.ToRadio{
+		PayloadVariant: &meshtastic.ToRadio_Packet{
+			Packet: &meshtastic.MeshPacket{
+				From: 0x1234,
+				To:   meshtool.BroadcastNodeID.Uint32(),
+				PayloadVariant: &meshtastic.MeshPacket_Decoded{
+					Decoded: &meshtastic.Data{
+						Portnum: meshtastic.PortNum_TEXT_MESSAGE_APP,
+						Payload: []byte("hello"),
+					},
+				},
+			},
+		},
+	}
+	got := NewTextPacket(0x1234, meshtool.BroadcastNodeID, "hello")
+	require.True(t, proto.Equal(want, got))
+}
+
+func TestNewProtoPackets(t *testing.T) {
+	latitude := int32(515014760)
+	position := &meshtastic.Position{LatitudeI: &latitude}
+	user := &meshtastic.User{Id: "!00001234", LongName: "Test"}
+	telemetry := &meshtastic.Telemetry{
+		Variant: &meshtastic.Telemetry_DeviceMetrics{DeviceMetrics: &meshtastic.DeviceMetrics{}},
+	}
+	waypoint := &meshtastic.Waypoint{Id: 42, LatitudeI: &latitude, Name: "Camp"}
+
+	tests := []struct {
+		name    string
+		build   func() (*meshtastic.ToRadio, error)
+		portnum meshtastic.PortNum
+		payload proto.Message
+	}{
+		{
+			name:    "position",
+			build:   func() (*meshtastic.ToRadio, error) { return NewPositionPacket(1, 2, position) },
+			portnum: meshtastic.PortNum_POSITION_APP,
+			payload: position,
+		},
+		{
+			name:    "nodeinfo",
+			build:   func() (*meshtastic.ToRadio, error) { return NewNodeInfoPacket(1, 2, user) },
+			portnum: meshtastic.PortNum_NODEINFO_APP,
+			payload: user,
+		},
+		{
+			name:    "telemetry",
+			build:   func() (*meshtastic.ToRadio, error) { return NewTelemetryPacket(1, 2, telemetry) },
+			portnum: meshtastic.PortNum_TELEMETRY_APP,
+			payload: telemetry,
+		},
+		{
+			name:    "waypoint",
+			build:   func() (*meshtastic.ToRadio, error) { return NewWaypointPacket(1, 2, waypoint) },
+			portnum: meshtastic.PortNum_WAYPOINT_APP,
+			payload: waypoint,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload, err := proto.Marshal(tt.payload)
+			require.NoError(t, err)
+			got, err := tt.build()
+			require.NoError(t, err)
+			require.True(t, proto.Equal(NewDataPacket(1, 2, tt.portnum, payload), got))
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package transport
+
+import (
+	"context"
+
+	"github.com/rabarar/meshtastic"
+)
+
+// QueueFree returns the number of free slots in the radio's outgoing packet queue, so that callers sending many
+// packets can pace themselves. ok is false if the radio has not yet reported the status of its queue.
+func (c *Client) QueueFree() (free uint32, ok bool) {
+	return c.State.QueueFree()
+}
+
+// SendToRadioBlocking waits until the radio has a free slot in its outgoing packet queue before sending msg, so that
+// packets are not dropped when many are sent at once. The radio reports the status of its queue after each packet it
+// is sent; until it first does so, msg is sent immediately. An error is returned if ctx is done before a slot is free.
+func (c *Client) SendToRadioBlocking(ctx context.Context, msg *meshtastic.ToRadio) error {
+	for {
+		// The channel is fetched before checking the queue so that an update in between is not missed.
+		updated := c.queueStatusUpdated()
+		if c.State.reserveQueueSlot() {
+			return c.write(msg)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-updated:
+		}
+	}
+}
+
+// setQueueStatus records a QueueStatus received from the radio and wakes any senders waiting for a free slot.
+func (c *Client) setQueueStatus(queueStatus *meshtastic.QueueStatus) {
+	c.State.SetQueueStatus(queueStatus)
+	c.queueMu.Lock()
+	defer c.queueMu.Unlock()
+	if c.queueUpdated != nil {
+		close(c.queueUpdated)
+		c.queueUpdated = nil
+	}
+}
+
+// queueStatusUpdated returns a channel which is closed when the radio next reports the status of its queue.
+func (c *Client) queueStatusUpdated() <-chan struct{} {
+	c.queueMu.Lock()
+	defer c.queueMu.Unlock()
+	if c.queueUpdated == nil {
+		c.queueUpdated = make(chan struct{})
+	}
+	return c.queueUpdated
+}
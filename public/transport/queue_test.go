@@ -0,0 +1,49 @@
+package transport
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/rabarar/meshtastic"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient_SendToRadioBlocking(t *testing.T) {
+	c := NewClient(NewRadioStreamConn(&bufferConn{}), false)
+	msg := NewTextPacket(1, 2, "hello")
+
+	// The queue status is unknown until the radio reports it, so sending does not block.
+	_, ok := c.QueueFree()
+	require.False(t, ok)
+	require.NoError(t, c.SendToRadioBlocking(context.Background(), msg))
+
+	c.setQueueStatus(&meshtastic.QueueStatus{Free: 1, Maxlen: 16})
+	require.NoError(t, c.SendToRadioBlocking(context.Background(), msg))
+	free, ok := c.QueueFree()
+	require.True(t, ok)
+	require.Zero(t, free)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, c.SendToRadioBlocking(ctx, msg), context.DeadlineExceeded)
+
+	sent := make(chan error)
+	go func() {
+		sent <- c.SendToRadioBlocking(context.Background(), msg)
+	}()
+	select {
+	case <-sent:
+		t.Fatal("sent while the queue was full")
+	case <-time.After(20 * time.Millisecond):
+	}
+	c.setQueueStatus(&meshtastic.QueueStatus{Free: 4, Maxlen: 16})
+	select {
+	case err := <-sent:
+		require.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("not sent after the queue freed up")
+	}
+	// The slot taken by the send is deducted until the radio next reports its queue.
+	require.Equal(t, uint32(3), c.State.QueueStatus().GetFree())
+}
@@ -0,0 +1,199 @@
+package serial
+
+import (
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+const (
+	// DefaultReconnectInitialBackoff is the delay before the first attempt to reopen a port which has failed.
+	DefaultReconnectInitialBackoff = 500 * time.Millisecond
+	// DefaultReconnectMaxBackoff is the longest delay between attempts to reopen a port.
+	DefaultReconnectMaxBackoff = 30 * time.Second
+)
+
+// ReconnectingPort is a serial port which, when reading or writing fails, for example because the radio has been
+// unplugged, reopens the same port path with exponential backoff and transparently resumes. Reads and writes block
+// while the port is being reopened. This is intended for unattended gateways.
+//
+// A radio which has been reconnected does not know about the client, so callers should use OnReconnect to re-run the
+// WantConfig handshake, for example by sending a ToRadio with a new WantConfigId.
+type ReconnectingPort struct {
+	path string
+	opts Options
+	// OnReconnect, if set, is called in its own goroutine each time the port has been reopened.
+	OnReconnect func()
+
+	// open opens the port, it is replaced in tests.
+	open           func(path string, opts Options) (io.ReadWriteCloser, error)
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+
+	mu     sync.Mutex
+	port   io.ReadWriteCloser
+	closed chan struct{}
+	// reopened is closed when a reconnect in progress completes, so that concurrent readers and writers wait for it.
+	reopened chan struct{}
+}
+
+var _ io.ReadWriteCloser = (*ReconnectingPort)(nil)
+
+// ConnectReconnecting opens the serial port with the given options, returning a port which reopens itself after I/O
+// errors. Opening the port the first time is not retried, so that a wrong path is reported immediately.
+func ConnectReconnecting(path string, opts Options) (*ReconnectingPort, error) {
+	return newReconnectingPort(path, opts, func(path string, opts Options) (io.ReadWriteCloser, error) {
+		return ConnectWithOptions(path, opts)
+	})
+}
+
+func newReconnectingPort(
+	path string, opts Options, open func(string, Options) (io.ReadWriteCloser, error),
+) (*ReconnectingPort, error) {
+	port, err := open(path, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &ReconnectingPort{
+		path:           path,
+		opts:           opts,
+		open:           open,
+		initialBackoff: DefaultReconnectInitialBackoff,
+		maxBackoff:     DefaultReconnectMaxBackoff,
+		port:           port,
+		closed:         make(chan struct{}),
+	}, nil
+}
+
+// Read reads from the port, reopening it and retrying if the read fails.
+func (p *ReconnectingPort) Read(b []byte) (int, error) {
+	for {
+		port, err := p.current()
+		if err != nil {
+			return 0, err
+		}
+		n, err := port.Read(b)
+		if err == nil || n > 0 {
+			return n, nil
+		}
+		if err := p.reconnect(port, err); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// Write writes to the port, reopening it and retrying if the write fails. A write which fails part way through is
+// retried from the start, as the radio discards partial frames.
+func (p *ReconnectingPort) Write(b []byte) (int, error) {
+	for {
+		port, err := p.current()
+		if err != nil {
+			return 0, err
+		}
+		n, err := port.Write(b)
+		if err == nil {
+			return n, nil
+		}
+		if err := p.reconnect(port, err); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// Close closes the port, stopping any reconnection in progress. Blocked and subsequent reads and writes return
+// os.ErrClosed.
+func (p *ReconnectingPort) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	select {
+	case <-p.closed:
+		return nil
+	default:
+	}
+	close(p.closed)
+	if p.port == nil {
+		return nil
+	}
+	return p.port.Close()
+}
+
+// current returns the open port, waiting for a reconnect in progress to complete.
+func (p *ReconnectingPort) current() (io.ReadWriteCloser, error) {
+	for {
+		p.mu.Lock()
+		port, reopened := p.port, p.reopened
+		p.mu.Unlock()
+		select {
+		case <-p.closed:
+			return nil, os.ErrClosed
+		default:
+		}
+		if port != nil {
+			return port, nil
+		}
+		select {
+		case <-p.closed:
+			return nil, os.ErrClosed
+		case <-reopened:
+		}
+	}
+}
+
+// reconnect reopens the port after failed returned cause. If another reader or writer has already started
+// reconnecting, it returns so that the caller waits for that attempt instead.
+func (p *ReconnectingPort) reconnect(failed io.ReadWriteCloser, cause error) error {
+	p.mu.Lock()
+	select {
+	case <-p.closed:
+		p.mu.Unlock()
+		return os.ErrClosed
+	default:
+	}
+	if p.port != failed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.port = nil
+	p.reopened = make(chan struct{})
+	reopened := p.reopened
+	p.mu.Unlock()
+	defer close(reopened)
+
+	log.Warn("serial port failed, reconnecting", "port", p.path, "err", cause)
+	if err := failed.Close(); err != nil {
+		log.Debug("failed to close serial port", "port", p.path, "err", err)
+	}
+	backoff := p.initialBackoff
+	for attempt := 1; ; attempt++ {
+		select {
+		case <-p.closed:
+			return os.ErrClosed
+		case <-time.After(backoff):
+		}
+		port, err := p.open(p.path, p.opts)
+		if err != nil {
+			log.Debug("failed to reopen serial port", "port", p.path, "attempt", attempt, "err", err)
+			backoff = min(backoff*2, p.maxBackoff)
+			continue
+		}
+
+		p.mu.Lock()
+		select {
+		case <-p.closed:
+			p.mu.Unlock()
+			_ = port.Close()
+			return os.ErrClosed
+		default:
+		}
+		p.port = port
+		p.mu.Unlock()
+		log.Info("reconnected serial port", "port", p.path, "attempts", attempt)
+		if p.OnReconnect != nil {
+			go p.OnReconnect()
+		}
+		return nil
+	}
+}
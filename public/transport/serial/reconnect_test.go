@@ -0,0 +1,138 @@
+package serial
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeDevice opens ports which are pipes to the test. Opening fails while it is unplugged.
+type fakeDevice struct {
+	mu        sync.Mutex
+	unplugged bool
+	opens     int
+	radio     net.Conn
+}
+
+func (d *fakeDevice) open(_ string, _ Options) (io.ReadWriteCloser, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.unplugged {
+		return nil, errors.New("no such device")
+	}
+	d.opens++
+	port, radio := net.Pipe()
+	d.radio = radio
+	return port, nil
+}
+
+func (d *fakeDevice) setUnplugged(unplugged bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.unplugged = unplugged
+	if unplugged {
+		d.radio.Close()
+	}
+}
+
+func (d *fakeDevice) radioEnd() net.Conn {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.radio
+}
+
+func newTestPort(t *testing.T, device *fakeDevice) *ReconnectingPort {
+	t.Helper()
+	p, err := newReconnectingPort("/dev/ttyUSB0", Options{}, device.open)
+	require.NoError(t, err)
+	p.initialBackoff = time.Millisecond
+	p.maxBackoff = 5 * time.Millisecond
+	t.Cleanup(func() {
+		p.Close()
+	})
+	return p
+}
+
+func TestReconnectingPort_Reconnects(t *testing.T) {
+	device := &fakeDevice{}
+	p := newTestPort(t, device)
+	reconnected := make(chan struct{}, 1)
+	p.OnReconnect = func() {
+		reconnected <- struct{}{}
+	}
+
+	go device.radioEnd().Write([]byte("before"))
+	b := make([]byte, 16)
+	n, err := p.Read(b)
+	require.NoError(t, err)
+	require.Equal(t, "before", string(b[:n]))
+
+	// Unplug the radio, leaving it unplugged for a few attempts to reopen it.
+	device.setUnplugged(true)
+	read := make(chan string)
+	go func() {
+		n, err := p.Read(b)
+		if err != nil {
+			read <- err.Error()
+			return
+		}
+		read <- string(b[:n])
+	}()
+	time.Sleep(20 * time.Millisecond)
+	device.setUnplugged(false)
+
+	select {
+	case <-reconnected:
+	case <-time.After(time.Second):
+		t.Fatal("OnReconnect not called")
+	}
+	go device.radioEnd().Write([]byte("after"))
+	select {
+	case got := <-read:
+		require.Equal(t, "after", got)
+	case <-time.After(time.Second):
+		t.Fatal("read did not resume")
+	}
+	require.Equal(t, 2, device.opens)
+
+	// Writes go to the reopened port.
+	go func() {
+		_, _ = p.Write([]byte("hello"))
+	}()
+	n, err = device.radioEnd().Read(b)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(b[:n]))
+}
+
+func TestReconnectingPort_CloseWhileReconnecting(t *testing.T) {
+	device := &fakeDevice{}
+	p := newTestPort(t, device)
+
+	device.setUnplugged(true)
+	done := make(chan error)
+	go func() {
+		_, err := p.Read(make([]byte, 16))
+		done <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	require.NoError(t, p.Close())
+	select {
+	case err := <-done:
+		require.ErrorIs(t, err, os.ErrClosed)
+	case <-time.After(time.Second):
+		t.Fatal("read did not return after Close")
+	}
+	_, err := p.Write([]byte("hello"))
+	require.ErrorIs(t, err, os.ErrClosed)
+}
+
+func TestConnectReconnecting_OpenFails(t *testing.T) {
+	_, err := newReconnectingPort("/dev/ttyUSB0", Options{}, (&fakeDevice{unplugged: true}).open)
+	require.Error(t, err)
+}
@@ -1,20 +1,76 @@
 package serial
 
 import (
+	"io"
+
+	"github.com/rabarar/meshtool-go/public/transport"
 	"go.bug.st/serial"
 )
 
 const (
-	DefaultPortSpeed = 115200 //921600
+	// DefaultPortSpeed is the baud rate used when none is configured. Some radios and USB bridges instead want
+	// 921600, see Options.
+	DefaultPortSpeed = 115200
+	// DefaultDataBits is the number of data bits used when none is configured.
+	DefaultDataBits = 8
 )
 
-func Connect(port string) (serial.Port, error) {
+// Options configures the serial port opened by ConnectWithOptions. Zero values use the defaults, which are 115200
+// baud, 8 data bits, no parity and one stop bit.
+type Options struct {
+	BaudRate int
+	DataBits int
+	Parity   serial.Parity
+	StopBits serial.StopBits
+}
+
+// mode returns the serial mode for the options, with defaults filled in.
+func (o Options) mode() *serial.Mode {
 	mode := &serial.Mode{
-		BaudRate: DefaultPortSpeed,
+		BaudRate: o.BaudRate,
+		DataBits: o.DataBits,
+		Parity:   o.Parity,
+		StopBits: o.StopBits,
+	}
+	if mode.BaudRate == 0 {
+		mode.BaudRate = DefaultPortSpeed
+	}
+	if mode.DataBits == 0 {
+		mode.DataBits = DefaultDataBits
 	}
-	p, err := serial.Open(port, mode)
+	return mode
+}
+
+// Connect opens the serial port with the default options.
+func Connect(port string) (serial.Port, error) {
+	return ConnectWithOptions(port, Options{})
+}
+
+// ConnectWithOptions opens the serial port with the given options.
+func ConnectWithOptions(port string, opts Options) (serial.Port, error) {
+	p, err := serial.Open(port, opts.mode())
 	if err != nil {
 		return nil, err
 	}
 	return p, nil
 }
+
+// ConnectTransport opens the serial port with the given options and wakes the radio, returning a transport.Transport
+// which can be passed to transport.NewClient.
+func ConnectTransport(port string, opts Options) (*transport.StreamConn, error) {
+	p, err := ConnectWithOptions(port, opts)
+	if err != nil {
+		return nil, err
+	}
+	return newTransport(p)
+}
+
+// newTransport wraps an open port in a StreamConn, closing the port if the radio cannot be woken.
+func newTransport(port io.ReadWriteCloser) (*transport.StreamConn, error) {
+	sc, err := transport.NewClientStreamConn(port)
+	if err != nil {
+		_ = port.Close()
+		return nil, err
+	}
+	return sc, nil
+}
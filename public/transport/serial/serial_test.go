@@ -0,0 +1,69 @@
+package serial
+
+import (
+	"io"
+	"net"
+	"testing"
+
+	"github.com/rabarar/meshtastic"
+	"github.com/rabarar/meshtool-go/public/transport"
+	"github.com/stretchr/testify/require"
+	"go.bug.st/serial"
+)
+
+func TestOptions_mode(t *testing.T) {
+	tests := []struct {
+		name string
+		opts Options
+		want *serial.Mode
+	}{
+		{
+			name: "defaults",
+			want: &serial.Mode{BaudRate: 115200, DataBits: 8},
+		},
+		{
+			name: "custom",
+			opts: Options{BaudRate: 921600, DataBits: 7, Parity: serial.EvenParity, StopBits: serial.TwoStopBits},
+			want: &serial.Mode{BaudRate: 921600, DataBits: 7, Parity: serial.EvenParity, StopBits: serial.TwoStopBits},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, tt.opts.mode())
+		})
+	}
+}
+
+func TestNewTransport(t *testing.T) {
+	port, radio := net.Pipe()
+	defer radio.Close()
+	woken := make(chan error, 1)
+	go func() {
+		// Discard the wake message, then send a message to the client.
+		_, err := io.ReadFull(radio, make([]byte, 32))
+		if err == nil {
+			err = transport.NewRadioStreamConn(radio).Write(&meshtastic.FromRadio{Id: 1})
+		}
+		woken <- err
+	}()
+
+	tr, err := newTransport(port)
+	require.NoError(t, err)
+	var _ transport.Transport = tr
+	msg := &meshtastic.FromRadio{}
+	require.NoError(t, tr.Read(msg))
+	require.Equal(t, uint32(1), msg.Id)
+	require.NoError(t, <-woken)
+	require.NoError(t, tr.Close())
+}
+
+func TestNewTransport_WakeFails(t *testing.T) {
+	port, radio := net.Pipe()
+	radio.Close()
+
+	_, err := newTransport(port)
+	require.Error(t, err)
+	// The port is closed when the radio can't be woken.
+	_, err = port.Write([]byte{0})
+	require.ErrorIs(t, err, io.ErrClosedPipe)
+}
@@ -2,46 +2,91 @@ package serial
 
 import (
 	"fmt"
-	"github.com/charmbracelet/log"
+	"strings"
+
 	"go.bug.st/serial/enumerator"
 )
 
 type usbDevice struct {
 	VID string
 	PID string
+	// Chip describes the device or USB bridge.
+	Chip string
 }
 
 var knownDevices = []usbDevice{
-	// rak4631_19003
-	{VID: "239A", PID: "8029"},
-	// CP210x UART Bridge
+	{VID: "239A", PID: "8029", Chip: "RAK4631 (nRF52840)"},
 	// Commonly found on Heltec and other devices.
-	{VID: "10C4", PID: "EA60"},
+	{VID: "10C4", PID: "EA60", Chip: "CP210x UART Bridge"},
+	// Commonly found on T-Beam, T-Lora and other LILYGO devices.
+	{VID: "1A86", PID: "7523", Chip: "CH340 UART Bridge"},
+	{VID: "1A86", PID: "55D4", Chip: "CH9102 UART Bridge"},
+	// ESP32-S3 and ESP32-C3 boards without a UART bridge, such as the Heltec V3 and T-Deck.
+	{VID: "303A", PID: "1001", Chip: "ESP32 USB JTAG/serial"},
+	// nRF52840 boards running the Adafruit bootloader, such as the T-Echo.
+	{VID: "239A", PID: "0029", Chip: "nRF52840"},
+	{VID: "0403", PID: "6001", Chip: "FTDI FT232 UART Bridge"},
+}
+
+// PortInfo describes a serial port which is likely to be a Meshtastic device.
+type PortInfo struct {
+	// Name is the name of the port to pass to Connect, e.g. /dev/ttyUSB0 or COM3.
+	Name         string
+	VID          string
+	PID          string
+	SerialNumber string
+	// Product is the OS-dependent product description of the port, which may be empty.
+	Product string
+	// Chip describes the known device or USB bridge the port was matched by.
+	Chip string
 }
 
-func GetPorts() []string {
+// DetectMeshtastic lists the USB serial ports whose VID and PID match chips commonly used by Meshtastic devices. Some
+// of these are generic USB bridges, so a port returned may not be a Meshtastic device, and the metadata returned is
+// intended to let the user pick between them.
+func DetectMeshtastic() ([]PortInfo, error) {
 	ports, err := enumerator.GetDetailedPortsList()
 	if err != nil {
-		log.Fatal(err)
-	}
-	var foundDevices []string
-	if len(ports) == 0 {
-		fmt.Println("No serial ports found!")
-		return nil
+		return nil, fmt.Errorf("listing serial ports: %w", err)
 	}
+	return filterKnownDevices(ports), nil
+}
+
+func filterKnownDevices(ports []*enumerator.PortDetails) []PortInfo {
+	var found []PortInfo
 	for _, port := range ports {
-		// fmt.Printf("Found port: %s %s\n", port.PID, port.VID)
-		if port.IsUSB {
-			for _, device := range knownDevices {
-				if device.VID != port.VID {
-					continue
-				}
-				if device.PID != port.PID {
-					continue
-				}
-				foundDevices = append(foundDevices, port.Name)
+		if !port.IsUSB {
+			continue
+		}
+		for _, device := range knownDevices {
+			// The case of the hex IDs varies by OS.
+			if !strings.EqualFold(device.VID, port.VID) || !strings.EqualFold(device.PID, port.PID) {
+				continue
 			}
+			found = append(found, PortInfo{
+				Name:         port.Name,
+				VID:          port.VID,
+				PID:          port.PID,
+				SerialNumber: port.SerialNumber,
+				Product:      port.Product,
+				Chip:         device.Chip,
+			})
+			break
 		}
 	}
-	return foundDevices
+	return found
+}
+
+// GetPorts returns the names of ports which are likely to be Meshtastic devices, which is empty if none are found. See
+// DetectMeshtastic.
+func GetPorts() ([]string, error) {
+	ports, err := DetectMeshtastic()
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(ports))
+	for _, port := range ports {
+		names = append(names, port.Name)
+	}
+	return names, nil
 }
@@ -0,0 +1,33 @@
+package serial
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.bug.st/serial/enumerator"
+)
+
+func TestFilterKnownDevices(t *testing.T) {
+	ports := []*enumerator.PortDetails{
+		{Name: "/dev/ttyS0"},
+		{Name: "/dev/ttyUSB0", IsUSB: true, VID: "10c4", PID: "ea60", SerialNumber: "0001", Product: "CP2102 USB to UART"},
+		{Name: "/dev/ttyACM0", IsUSB: true, VID: "2341", PID: "0043", Product: "Arduino Uno"},
+		{Name: "/dev/ttyACM1", IsUSB: true, VID: "303A", PID: "1001"},
+	}
+	require.Equal(t, []PortInfo{
+		{
+			Name:         "/dev/ttyUSB0",
+			VID:          "10c4",
+			PID:          "ea60",
+			SerialNumber: "0001",
+			Product:      "CP2102 USB to UART",
+			Chip:         "CP210x UART Bridge",
+		},
+		{
+			Name: "/dev/ttyACM1",
+			VID:  "303A",
+			PID:  "1001",
+			Chip: "ESP32 USB JTAG/serial",
+		},
+	}, filterKnownDevices(ports))
+}
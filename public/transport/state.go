@@ -0,0 +1,274 @@
+package transport
+
+import (
+	"sync"
+
+	"github.com/rabarar/meshtastic"
+	"github.com/rabarar/meshtool-go/public/meshtool"
+	"google.golang.org/protobuf/proto"
+)
+
+type State struct {
+	sync.RWMutex
+	complete       bool
+	configID       uint32
+	nodeInfo       *meshtastic.MyNodeInfo
+	deviceMetadata *meshtastic.DeviceMetadata
+	nodes          []*meshtastic.NodeInfo
+	channels       []*meshtastic.Channel
+	configs        []*meshtastic.Config
+	modules        []*meshtastic.ModuleConfig
+	queueStatus    *meshtastic.QueueStatus
+}
+
+func (s *State) Complete() bool {
+	s.RLock()
+	defer s.RUnlock()
+	return s.complete
+}
+
+func (s *State) ConfigID() uint32 {
+	s.RLock()
+	defer s.RUnlock()
+	return s.configID
+}
+
+func (s *State) NodeInfo() *meshtastic.MyNodeInfo {
+	s.RLock()
+	defer s.RUnlock()
+	return cloneMessage(s.nodeInfo)
+}
+
+// DeviceMetadata returns the metadata reported by the radio, or nil if the radio has not yet sent it.
+func (s *State) DeviceMetadata() *meshtastic.DeviceMetadata {
+	s.RLock()
+	defer s.RUnlock()
+	return cloneMessage(s.deviceMetadata)
+}
+
+func (s *State) Nodes() []*meshtastic.NodeInfo {
+	s.RLock()
+	defer s.RUnlock()
+	var nodeInfos []*meshtastic.NodeInfo
+	for _, n := range s.nodes {
+		nodeInfos = append(nodeInfos, cloneMessage(n))
+	}
+	return nodeInfos
+}
+
+// Node returns a copy of the NodeInfo of the node with the given number, and false if the node is not known. Unlike
+// Nodes, only the requested node is copied.
+func (s *State) Node(num uint32) (*meshtastic.NodeInfo, bool) {
+	s.RLock()
+	defer s.RUnlock()
+	for _, n := range s.nodes {
+		if n.GetNum() == num {
+			return cloneMessage(n), true
+		}
+	}
+	return nil, false
+}
+
+// NodeCount returns the number of known nodes.
+func (s *State) NodeCount() int {
+	s.RLock()
+	defer s.RUnlock()
+	return len(s.nodes)
+}
+
+func (s *State) Channels() []*meshtastic.Channel {
+	s.RLock()
+	defer s.RUnlock()
+	var channels []*meshtastic.Channel
+	for _, n := range s.channels {
+		channels = append(channels, cloneMessage(n))
+	}
+	return channels
+}
+
+func (s *State) Configs() []*meshtastic.Config {
+	s.RLock()
+	defer s.RUnlock()
+	var configs []*meshtastic.Config
+	for _, n := range s.configs {
+		configs = append(configs, cloneMessage(n))
+	}
+	return configs
+}
+
+func (s *State) Modules() []*meshtastic.ModuleConfig {
+	s.RLock()
+	defer s.RUnlock()
+	var configs []*meshtastic.ModuleConfig
+	for _, n := range s.modules {
+		configs = append(configs, cloneMessage(n))
+	}
+	return configs
+}
+
+// ChannelByIndex returns a copy of the channel in the given slot of the radio's channel table, and false if the radio
+// has not reported it.
+func (s *State) ChannelByIndex(index uint8) (*meshtastic.Channel, bool) {
+	s.RLock()
+	defer s.RUnlock()
+	for i := len(s.channels) - 1; i >= 0; i-- {
+		if ch := s.channels[i]; ch != nil && ch.Index == int32(index) {
+			return cloneMessage(ch), true
+		}
+	}
+	return nil, false
+}
+
+// DeviceConfig returns the device config reported by the radio, or nil if it has not been received.
+func (s *State) DeviceConfig() *meshtastic.Config_DeviceConfig {
+	s.RLock()
+	defer s.RUnlock()
+	return latestConfig(s.configs, (*meshtastic.Config).GetDevice)
+}
+
+// LoRaConfig returns the LoRa config reported by the radio, or nil if it has not been received.
+func (s *State) LoRaConfig() *meshtastic.Config_LoRaConfig {
+	s.RLock()
+	defer s.RUnlock()
+	return latestConfig(s.configs, (*meshtastic.Config).GetLora)
+}
+
+// MQTTModuleConfig returns the MQTT module config reported by the radio, or nil if it has not been received.
+func (s *State) MQTTModuleConfig() *meshtastic.ModuleConfig_MQTTConfig {
+	s.RLock()
+	defer s.RUnlock()
+	return latestConfig(s.modules, (*meshtastic.ModuleConfig).GetMqtt)
+}
+
+// QueueStatus returns the most recent status of the radio's outgoing packet queue, or nil if the radio has not yet
+// reported it.
+func (s *State) QueueStatus() *meshtastic.QueueStatus {
+	s.RLock()
+	defer s.RUnlock()
+	return cloneMessage(s.queueStatus)
+}
+
+// QueueFree returns the number of free slots in the radio's outgoing packet queue. ok is false if the radio has not
+// yet reported the status of its queue.
+func (s *State) QueueFree() (free uint32, ok bool) {
+	s.RLock()
+	defer s.RUnlock()
+	if s.queueStatus == nil {
+		return 0, false
+	}
+	return s.queueStatus.Free, true
+}
+
+func (s *State) SetComplete(complete bool) {
+	s.Lock()
+	defer s.Unlock()
+	s.complete = complete
+}
+
+func (s *State) SetConfigID(configID uint32) {
+	s.Lock()
+	defer s.Unlock()
+	s.configID = configID
+}
+
+func (s *State) SetNodeInfo(nodeInfo *meshtastic.MyNodeInfo) {
+	s.Lock()
+	defer s.Unlock()
+	s.nodeInfo = nodeInfo
+}
+
+func (s *State) SetDeviceMetadata(deviceMetadata *meshtastic.DeviceMetadata) {
+	s.Lock()
+	defer s.Unlock()
+	s.deviceMetadata = deviceMetadata
+}
+
+func (s *State) SetQueueStatus(queueStatus *meshtastic.QueueStatus) {
+	s.Lock()
+	defer s.Unlock()
+	s.queueStatus = queueStatus
+}
+
+// reserveQueueSlot takes a free slot in the radio's outgoing packet queue, returning false if there are none. The
+// slot is taken from the last reported status, which is replaced when the radio next reports the status of its queue.
+// If the status is not yet known, a slot is always available.
+func (s *State) reserveQueueSlot() bool {
+	s.Lock()
+	defer s.Unlock()
+	if s.queueStatus == nil {
+		return true
+	}
+	if s.queueStatus.Free == 0 {
+		return false
+	}
+	s.queueStatus = cloneMessage(s.queueStatus)
+	s.queueStatus.Free--
+	return true
+}
+
+func (s *State) AddNode(node *meshtastic.NodeInfo) {
+	s.Lock()
+	defer s.Unlock()
+	s.nodes = append(s.nodes, node)
+}
+
+// UpdateNode merges a payload received from the node with the given number into its NodeInfo, adding the node if it
+// is not yet known. See meshtool.MergeNodeInfo.
+func (s *State) UpdateNode(num uint32, update proto.Message) {
+	s.Lock()
+	defer s.Unlock()
+	for i, n := range s.nodes {
+		if n.GetNum() == num {
+			s.nodes[i] = meshtool.MergeNodeInfo(n, update)
+			return
+		}
+	}
+	node := meshtool.MergeNodeInfo(nil, update)
+	node.Num = num
+	s.nodes = append(s.nodes, node)
+}
+
+func (s *State) AddChannel(channel *meshtastic.Channel) {
+	s.Lock()
+	defer s.Unlock()
+	s.channels = append(s.channels, channel)
+}
+
+func (s *State) AddConfig(config *meshtastic.Config) {
+	s.Lock()
+	defer s.Unlock()
+	s.configs = append(s.configs, config)
+}
+
+func (s *State) AddModule(module *meshtastic.ModuleConfig) {
+	s.Lock()
+	defer s.Unlock()
+	s.modules = append(s.modules, module)
+}
+
+// latestConfig returns a copy of the payload most recently received in configs which get returns non-nil for, as the
+// radio sends its config again each time it is requested. The caller must hold the State's lock.
+func latestConfig[C any, T interface {
+	proto.Message
+	comparable
+}](configs []C, get func(C) T) T {
+	var zero T
+	for i := len(configs) - 1; i >= 0; i-- {
+		if c := get(configs[i]); c != zero {
+			return cloneMessage(c)
+		}
+	}
+	return zero
+}
+
+// cloneMessage returns a deep copy of m, or nil if m is nil.
+func cloneMessage[T interface {
+	proto.Message
+	comparable
+}](m T) T {
+	var zero T
+	if m == zero {
+		return zero
+	}
+	return proto.Clone(m).(T)
+}
@@ -0,0 +1,144 @@
+package transport
+
+import (
+	"testing"
+
+	"github.com/rabarar/meshtastic"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+// TestState_Empty ensures that every accessor is safe to call before the radio has sent any state.
+func TestState_Empty(t *testing.T) {
+	s := &State{}
+	require.NotPanics(t, func() {
+		require.False(t, s.Complete())
+		require.Zero(t, s.ConfigID())
+		require.Nil(t, s.NodeInfo())
+		require.Nil(t, s.DeviceMetadata())
+		require.Empty(t, s.Nodes())
+		_, ok := s.Node(1)
+		require.False(t, ok)
+		require.Zero(t, s.NodeCount())
+		_, ok = s.ChannelByIndex(0)
+		require.False(t, ok)
+		require.Nil(t, s.DeviceConfig())
+		require.Nil(t, s.LoRaConfig())
+		require.Nil(t, s.MQTTModuleConfig())
+		require.Empty(t, s.Channels())
+		require.Empty(t, s.Configs())
+		require.Empty(t, s.Modules())
+	})
+}
+
+func TestState_DeviceMetadata(t *testing.T) {
+	s := &State{}
+	// The radio has not yet sent its metadata.
+	require.NotPanics(t, func() {
+		require.Nil(t, s.DeviceMetadata())
+	})
+	require.Nil(t, NewClient(nil, false).State.DeviceMetadata())
+
+	metadata := &meshtastic.DeviceMetadata{FirmwareVersion: "2.5.0", HasBluetooth: true}
+	s.SetDeviceMetadata(metadata)
+	require.True(t, proto.Equal(metadata, s.DeviceMetadata()))
+}
+
+func TestState_NilEntries(t *testing.T) {
+	s := &State{}
+	s.SetNodeInfo(nil)
+	s.SetDeviceMetadata(nil)
+	s.AddNode(nil)
+	s.AddChannel(nil)
+	s.AddConfig(nil)
+	s.AddModule(nil)
+	require.NotPanics(t, func() {
+		require.Nil(t, s.NodeInfo())
+		require.Nil(t, s.DeviceMetadata())
+		require.Equal(t, []*meshtastic.NodeInfo{nil}, s.Nodes())
+		require.Equal(t, []*meshtastic.Channel{nil}, s.Channels())
+		require.Equal(t, []*meshtastic.Config{nil}, s.Configs())
+		require.Equal(t, []*meshtastic.ModuleConfig{nil}, s.Modules())
+		require.Nil(t, s.DeviceConfig())
+		require.Nil(t, s.MQTTModuleConfig())
+		_, ok := s.ChannelByIndex(0)
+		require.False(t, ok)
+	})
+}
+
+func TestState_ClonesValues(t *testing.T) {
+	s := &State{}
+	metadata := &meshtastic.DeviceMetadata{FirmwareVersion: "2.5.0"}
+	s.SetDeviceMetadata(metadata)
+
+	got := s.DeviceMetadata()
+	require.Equal(t, "2.5.0", got.FirmwareVersion)
+	got.FirmwareVersion = "changed"
+	require.Equal(t, "2.5.0", s.DeviceMetadata().FirmwareVersion)
+}
+
+func TestState_UpdateNode(t *testing.T) {
+	s := &State{}
+	s.AddNode(&meshtastic.NodeInfo{Num: 1, User: &meshtastic.User{LongName: "One"}})
+
+	s.UpdateNode(1, &meshtastic.Position{LatitudeI: proto.Int32(10)})
+	s.UpdateNode(2, &meshtastic.User{LongName: "Two"})
+
+	nodes := s.Nodes()
+	require.Len(t, nodes, 2)
+	require.Equal(t, "One", nodes[0].GetUser().GetLongName())
+	require.Equal(t, int32(10), nodes[0].GetPosition().GetLatitudeI())
+	require.Equal(t, uint32(2), nodes[1].Num)
+	require.Equal(t, "Two", nodes[1].GetUser().GetLongName())
+}
+
+func TestState_Node(t *testing.T) {
+	s := &State{}
+	s.AddNode(&meshtastic.NodeInfo{Num: 1, User: &meshtastic.User{LongName: "One"}})
+	s.AddNode(&meshtastic.NodeInfo{Num: 2, User: &meshtastic.User{LongName: "Two"}})
+	require.Equal(t, 2, s.NodeCount())
+
+	node, ok := s.Node(2)
+	require.True(t, ok)
+	require.Equal(t, "Two", node.GetUser().GetLongName())
+	node.User.LongName = "changed"
+	node, _ = s.Node(2)
+	require.Equal(t, "Two", node.GetUser().GetLongName())
+
+	_, ok = s.Node(3)
+	require.False(t, ok)
+}
+
+func TestState_TypedConfig(t *testing.T) {
+	s := &State{}
+	s.AddChannel(&meshtastic.Channel{Index: 0, Settings: &meshtastic.ChannelSettings{Name: "Primary"}})
+	s.AddChannel(&meshtastic.Channel{Index: 1, Settings: &meshtastic.ChannelSettings{Name: "Secondary"}})
+	s.AddConfig(&meshtastic.Config{PayloadVariant: &meshtastic.Config_Device{
+		Device: &meshtastic.Config_DeviceConfig{Role: meshtastic.Config_DeviceConfig_ROUTER},
+	}})
+	s.AddConfig(&meshtastic.Config{PayloadVariant: &meshtastic.Config_Lora{
+		Lora: &meshtastic.Config_LoRaConfig{HopLimit: 3},
+	}})
+	s.AddModule(&meshtastic.ModuleConfig{PayloadVariant: &meshtastic.ModuleConfig_Mqtt{
+		Mqtt: &meshtastic.ModuleConfig_MQTTConfig{Enabled: true},
+	}})
+
+	ch, ok := s.ChannelByIndex(1)
+	require.True(t, ok)
+	require.Equal(t, "Secondary", ch.GetSettings().GetName())
+	_, ok = s.ChannelByIndex(2)
+	require.False(t, ok)
+	require.Equal(t, meshtastic.Config_DeviceConfig_ROUTER, s.DeviceConfig().GetRole())
+	require.Equal(t, uint32(3), s.LoRaConfig().GetHopLimit())
+	require.True(t, s.MQTTModuleConfig().GetEnabled())
+
+	// The radio sends its config again when it is requested again, the latest values are returned.
+	s.AddConfig(&meshtastic.Config{PayloadVariant: &meshtastic.Config_Lora{
+		Lora: &meshtastic.Config_LoRaConfig{HopLimit: 5},
+	}})
+	require.Equal(t, uint32(5), s.LoRaConfig().GetHopLimit())
+
+	// Returned values are copies.
+	s.LoRaConfig().HopLimit = 7
+	require.Equal(t, uint32(5), s.LoRaConfig().GetHopLimit())
+}
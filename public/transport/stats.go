@@ -0,0 +1,91 @@
+package transport
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rabarar/meshtastic"
+)
+
+// byteCounter is implemented by Transports which count the bytes they read and write, such as *StreamConn.
+type byteCounter interface {
+	BytesRead() uint64
+	BytesWritten() uint64
+}
+
+var _ byteCounter = (*StreamConn)(nil)
+
+// Stats is a snapshot of the connection level metrics of a Client.
+type Stats struct {
+	// MessagesReceived counts the FromRadio messages received, keyed by the name of the payload variant
+	// (e.g. "packet", "node_info").
+	MessagesReceived map[string]uint64
+	// BytesRead is the number of bytes read from the radio, as counted by the Transport, including the stream protocol
+	// headers. It is zero for Transports which don't count bytes, see StreamConn.BytesRead.
+	BytesRead uint64
+	// BytesWritten is the number of bytes written to the radio, as counted by the Transport, including the stream
+	// protocol headers. It is zero for Transports which don't count bytes, see StreamConn.BytesWritten.
+	BytesWritten uint64
+	// ReadErrors is the number of failed reads from the radio.
+	ReadErrors uint64
+	// LastMessage is the time the last message was received from the radio.
+	LastMessage time.Time
+	// ConfigCompleteAfter is how long the radio took to complete sending its config after Connect was called.
+	// It is zero until config is complete.
+	ConfigCompleteAfter time.Duration
+}
+
+// clientStats holds the metrics of a Client. Counters are updated atomically so they can be read at any time without
+// blocking the read loop.
+type clientStats struct {
+	readErrors          atomic.Uint64
+	lastMessage         atomic.Int64
+	connectStarted      atomic.Int64
+	configCompleteAfter atomic.Int64
+
+	mu               sync.Mutex
+	messagesReceived map[string]uint64
+}
+
+func (s *clientStats) recordRead(msg *meshtastic.FromRadio) {
+	s.lastMessage.Store(time.Now().UnixNano())
+
+	variant := "unknown"
+	m := msg.ProtoReflect()
+	if fd := m.WhichOneof(m.Descriptor().Oneofs().ByName("payload_variant")); fd != nil {
+		variant = string(fd.Name())
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.messagesReceived == nil {
+		s.messagesReceived = map[string]uint64{}
+	}
+	s.messagesReceived[variant]++
+}
+
+func (s *clientStats) recordConnectStarted() {
+	s.connectStarted.Store(time.Now().UnixNano())
+	s.configCompleteAfter.Store(0)
+}
+
+func (s *clientStats) recordConfigComplete() {
+	s.configCompleteAfter.Store(time.Now().UnixNano() - s.connectStarted.Load())
+}
+
+func (s *clientStats) snapshot() Stats {
+	stats := Stats{
+		ReadErrors:          s.readErrors.Load(),
+		ConfigCompleteAfter: time.Duration(s.configCompleteAfter.Load()),
+		MessagesReceived:    map[string]uint64{},
+	}
+	if lastMessage := s.lastMessage.Load(); lastMessage != 0 {
+		stats.LastMessage = time.Unix(0, lastMessage)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for variant, count := range s.messagesReceived {
+		stats.MessagesReceived[variant] = count
+	}
+	return stats
+}
@@ -0,0 +1,63 @@
+package transport
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/rabarar/meshtastic"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestClient_Stats(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	start := time.Now()
+	c, radioEnd := connectScriptedRadio(t, ctx)
+
+	stats := c.Stats()
+	wantConfig := &meshtastic.ToRadio{
+		PayloadVariant: &meshtastic.ToRadio_WantConfigId{WantConfigId: c.State.ConfigID()},
+	}
+	configComplete := &meshtastic.FromRadio{
+		PayloadVariant: &meshtastic.FromRadio_ConfigCompleteId{ConfigCompleteId: c.State.ConfigID()},
+	}
+	require.Equal(t, map[string]uint64{"config_complete_id": 1}, stats.MessagesReceived)
+	// Each message is preceded by a 4 byte header.
+	require.Equal(t, uint64(proto.Size(wantConfig)+4), stats.BytesWritten)
+	require.Equal(t, uint64(proto.Size(configComplete)+4), stats.BytesRead)
+	require.Zero(t, stats.ReadErrors)
+	require.Positive(t, stats.ConfigCompleteAfter)
+	require.LessOrEqual(t, stats.ConfigCompleteAfter, time.Since(start))
+	require.False(t, stats.LastMessage.Before(start))
+
+	// The snapshot is a copy, unaffected by later messages.
+	stats.MessagesReceived["packet"] = 100
+	packet := &meshtastic.FromRadio{
+		PayloadVariant: &meshtastic.FromRadio_Packet{Packet: &meshtastic.MeshPacket{Id: 1}},
+	}
+	go func() {
+		if _, err := radioEnd.Write(malformedFrame); err != nil {
+			return
+		}
+		_ = NewRadioStreamConn(radioEnd).Write(packet)
+	}()
+	require.Eventually(t, func() bool {
+		return c.Stats().MessagesReceived["packet"] == 1
+	}, time.Second, 10*time.Millisecond)
+
+	stats = c.Stats()
+	require.Equal(t, map[string]uint64{"config_complete_id": 1, "packet": 1}, stats.MessagesReceived)
+	require.Equal(t, uint64(1), stats.ReadErrors)
+	// The bytes of the message which failed to be read are counted too.
+	require.Equal(t, uint64(proto.Size(configComplete)+len(malformedFrame)+proto.Size(packet)+2*4), stats.BytesRead)
+}
+
+func TestClient_Stats_Empty(t *testing.T) {
+	stats := NewClient(nil, false).Stats()
+	require.Empty(t, stats.MessagesReceived)
+	require.NotNil(t, stats.MessagesReceived)
+	require.True(t, stats.LastMessage.IsZero())
+	require.Zero(t, stats.ConfigCompleteAfter)
+}
@@ -0,0 +1,71 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rabarar/meshtastic"
+	"github.com/rabarar/meshtool-go/public/meshtool"
+	"google.golang.org/protobuf/proto"
+)
+
+// ErrStoreForwardUnavailable is returned when a store and forward server is unable to service a request.
+var ErrStoreForwardUnavailable = errors.New("store and forward server unavailable")
+
+// RequestStoreForwardHistory asks store and forward servers on the mesh to replay the messages they have stored from
+// within the given window, which the firmware handles with minute resolution. It blocks until the number of messages
+// announced by the server have been received, or ctx is done. The returned packets are the STORE_FORWARD_APP packets
+// sent by the server, each containing one replayed message.
+//
+// The request is broadcast, so this is best suited to meshes with a single store and forward server.
+func (c *Client) RequestStoreForwardHistory(ctx context.Context, window time.Duration) ([]*meshtastic.MeshPacket, error) {
+	request, err := proto.Marshal(&meshtastic.StoreAndForward{
+		Rr: meshtastic.StoreAndForward_CLIENT_HISTORY,
+		Variant: &meshtastic.StoreAndForward_History_{
+			History: &meshtastic.StoreAndForward_History{
+				Window: uint32(window.Minutes()),
+			},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("marshalling history request: %w", err)
+	}
+
+	// Start watching before sending the request so that no responses are missed.
+	packets, stop := c.SubscribePackets()
+	defer stop()
+
+	packet := NewDataPacket(c.myNodeID(), meshtool.BroadcastNodeID, meshtastic.PortNum_STORE_FORWARD_APP, request).GetPacket()
+	packet.GetDecoded().WantResponse = true
+	if _, err := c.SendPacket(packet); err != nil {
+		return nil, fmt.Errorf("sending history request: %w", err)
+	}
+
+	var history []*meshtastic.MeshPacket
+	// expected is the number of messages the server has said it will send, or -1 if it is yet to say.
+	expected := -1
+	for expected < 0 || len(history) < expected {
+		var packet *meshtastic.MeshPacket
+		select {
+		case <-ctx.Done():
+			return history, ctx.Err()
+		case packet = <-packets:
+		}
+		decoded, err := meshtool.NewDecodedPacket(packet, c.keys)
+		if err != nil || decoded.Portnum != meshtastic.PortNum_STORE_FORWARD_APP {
+			continue
+		}
+		sf := decoded.Payload.(*meshtastic.StoreAndForward)
+		switch sf.Rr {
+		case meshtastic.StoreAndForward_ROUTER_HISTORY:
+			expected = int(sf.GetHistory().GetHistoryMessages())
+		case meshtastic.StoreAndForward_ROUTER_TEXT_DIRECT, meshtastic.StoreAndForward_ROUTER_TEXT_BROADCAST:
+			history = append(history, packet)
+		case meshtastic.StoreAndForward_ROUTER_ERROR, meshtastic.StoreAndForward_ROUTER_BUSY:
+			return history, fmt.Errorf("%w: %s", ErrStoreForwardUnavailable, sf.Rr)
+		}
+	}
+	return history, nil
+}
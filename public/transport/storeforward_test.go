@@ -0,0 +1,153 @@
+package transport
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/rabarar/meshtastic"
+	"github.com/rabarar/meshtool-go/public/meshtool"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+// storeForwardServer connects a Client to a fake radio which completes config immediately and answers each store and
+// forward history request with the given responses.
+func storeForwardServer(t *testing.T, responses ...*meshtastic.StoreAndForward) (*Client, <-chan *meshtastic.MeshPacket) {
+	t.Helper()
+	clientEnd, radioEnd := net.Pipe()
+	t.Cleanup(func() {
+		radioEnd.Close()
+	})
+	requests := make(chan *meshtastic.MeshPacket, 1)
+	go func() {
+		sc := NewRadioStreamConn(radioEnd)
+		for {
+			msg := &meshtastic.ToRadio{}
+			if err := sc.Read(msg); err != nil {
+				return
+			}
+			if id := msg.GetWantConfigId(); id != 0 {
+				_ = sc.Write(&meshtastic.FromRadio{
+					PayloadVariant: &meshtastic.FromRadio_ConfigCompleteId{ConfigCompleteId: id},
+				})
+				continue
+			}
+			if msg.GetPacket().GetDecoded().GetPortnum() != meshtastic.PortNum_STORE_FORWARD_APP {
+				continue
+			}
+			requests <- msg.GetPacket()
+			// Unrelated traffic is ignored.
+			_ = sc.Write(&meshtastic.FromRadio{PayloadVariant: &meshtastic.FromRadio_Packet{Packet: &meshtastic.MeshPacket{
+				Id: 1000,
+				PayloadVariant: &meshtastic.MeshPacket_Decoded{Decoded: &meshtastic.Data{
+					Portnum: meshtastic.PortNum_TEXT_MESSAGE_APP,
+					Payload: []byte("unrelated"),
+				}},
+			}}})
+			for i, response := range responses {
+				payload, err := proto.Marshal(response)
+				if err != nil {
+					return
+				}
+				_ = sc.Write(&meshtastic.FromRadio{PayloadVariant: &meshtastic.FromRadio_Packet{Packet: &meshtastic.MeshPacket{
+					Id:   uint32(i + 1),
+					From: 0xdeadbeef,
+					To:   msg.GetPacket().GetFrom(),
+					PayloadVariant: &meshtastic.MeshPacket_Decoded{Decoded: &meshtastic.Data{
+						Portnum: meshtastic.PortNum_STORE_FORWARD_APP,
+						Payload: payload,
+					}},
+				}}})
+			}
+		}
+	}()
+
+	c := NewClient(NewRadioStreamConn(clientEnd), false)
+	t.Cleanup(func() {
+		_ = c.Disconnect()
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(t, c.Connect(ctx))
+	return c, requests
+}
+
+func storeForwardText(rr meshtastic.StoreAndForward_RequestResponse, text string) *meshtastic.StoreAndForward {
+	return &meshtastic.StoreAndForward{Rr: rr, Variant: &meshtastic.StoreAndForward_Text{Text: []byte(text)}}
+}
+
+func TestClient_RequestStoreForwardHistory(t *testing.T) {
+	history := &meshtastic.StoreAndForward{
+		Rr:      meshtastic.StoreAndForward_ROUTER_HISTORY,
+		Variant: &meshtastic.StoreAndForward_History_{History: &meshtastic.StoreAndForward_History{HistoryMessages: 2}},
+	}
+	tests := []struct {
+		name      string
+		responses []*meshtastic.StoreAndForward
+		timeout   time.Duration
+		wantIDs   []uint32
+		wantErr   error
+	}{
+		{
+			name: "history",
+			responses: []*meshtastic.StoreAndForward{
+				history,
+				storeForwardText(meshtastic.StoreAndForward_ROUTER_TEXT_BROADCAST, "one"),
+				storeForwardText(meshtastic.StoreAndForward_ROUTER_TEXT_DIRECT, "two"),
+			},
+			wantIDs: []uint32{2, 3},
+		},
+		{
+			name:      "no messages",
+			responses: []*meshtastic.StoreAndForward{{Rr: meshtastic.StoreAndForward_ROUTER_HISTORY}},
+		},
+		{
+			name:      "busy",
+			responses: []*meshtastic.StoreAndForward{{Rr: meshtastic.StoreAndForward_ROUTER_BUSY}},
+			wantErr:   ErrStoreForwardUnavailable,
+		},
+		{
+			name: "incomplete",
+			responses: []*meshtastic.StoreAndForward{
+				history,
+				storeForwardText(meshtastic.StoreAndForward_ROUTER_TEXT_BROADCAST, "one"),
+			},
+			timeout: 100 * time.Millisecond,
+			wantIDs: []uint32{2},
+			wantErr: context.DeadlineExceeded,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, requests := storeForwardServer(t, tt.responses...)
+			timeout := tt.timeout
+			if timeout == 0 {
+				timeout = time.Second
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+
+			packets, err := c.RequestStoreForwardHistory(ctx, 90*time.Minute)
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+			} else {
+				require.NoError(t, err)
+			}
+			var ids []uint32
+			for _, packet := range packets {
+				ids = append(ids, packet.Id)
+			}
+			require.Equal(t, tt.wantIDs, ids)
+
+			request := <-requests
+			require.Equal(t, meshtool.BroadcastNodeID.Uint32(), request.To)
+			require.True(t, request.GetDecoded().WantResponse)
+			sf := &meshtastic.StoreAndForward{}
+			require.NoError(t, proto.Unmarshal(request.GetDecoded().Payload, sf))
+			require.Equal(t, meshtastic.StoreAndForward_CLIENT_HISTORY, sf.Rr)
+			require.Equal(t, uint32(90), sf.GetHistory().GetWindow())
+		})
+	}
+}
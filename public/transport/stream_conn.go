@@ -2,11 +2,14 @@ package transport
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"fmt"
 	"google.golang.org/protobuf/proto"
+	"hash/crc32"
 	"io"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -21,6 +24,8 @@ const (
 	Start2 = 0xc3
 	// PacketMTU is the maximum size of the protobuf message which can be sent within the header.
 	PacketMTU = 512
+	// checksumLen is the length of the CRC-32 appended to each message when StreamConn.Checksum is enabled.
+	checksumLen = 4
 )
 
 // StreamConn implements the meshtastic client API stream protocol.
@@ -30,9 +35,24 @@ type StreamConn struct {
 	conn io.ReadWriteCloser
 	// DebugWriter is an optional writer that is used when a non-protobuf message is sent over the connection.
 	DebugWriter io.Writer
+	// DebugOutput is an optional function called with each line of text read which is not part of the stream protocol,
+	// without its line ending. Radios write their log as text when serial debug output is enabled, and do so after
+	// booting until a client puts them into protobuf mode.
+	DebugOutput func(line string)
+	// Checksum enables an application level integrity check which is not part of the meshtastic stream protocol.
+	// When enabled, a CRC-32 is appended to each message written and verified on each message read, with corrupt
+	// messages being discarded. Both ends of the connection must enable Checksum, so this is only suitable for
+	// connections between this library's client and emulated radio. The checksum counts towards PacketMTU, so messages
+	// written are limited to PacketMTU-4 bytes.
+	Checksum bool
 
-	readMu  sync.Mutex
-	writeMu sync.Mutex
+	readMu sync.Mutex
+	// debugLines splits the bytes skipped between frames into lines for DebugOutput. It is guarded by readMu.
+	debugLines *lineWriter
+	writeMu    sync.Mutex
+
+	bytesRead    atomic.Uint64
+	bytesWritten atomic.Uint64
 }
 
 // NewClientStreamConn creates a new StreamConn with the provided io.ReadWriteCloser.
@@ -56,6 +76,18 @@ func (c *StreamConn) Close() (err error) {
 	return c.conn.Close()
 }
 
+// BytesRead returns the number of bytes read from the connection. This includes the stream protocol headers and
+// checksums, along with any bytes skipped between messages such as debug output or corrupt messages.
+func (c *StreamConn) BytesRead() uint64 {
+	return c.bytesRead.Load()
+}
+
+// BytesWritten returns the number of bytes written to the connection, including the stream protocol headers and
+// checksums, and the wake message sent by NewClientStreamConn.
+func (c *StreamConn) BytesWritten() uint64 {
+	return c.bytesWritten.Load()
+}
+
 // Read reads a protobuf message from the connection.
 func (c *StreamConn) Read(out proto.Message) error {
 	data, err := c.ReadBytes()
@@ -65,59 +97,86 @@ func (c *StreamConn) Read(out proto.Message) error {
 	return proto.Unmarshal(data, out)
 }
 
+// ReadContext is like Read, but returns ctx.Err() if ctx is done before a message has been read. Interrupting a read
+// in progress requires the underlying connection to support read deadlines, as net.Conn does, otherwise ctx is only
+// checked before reading. The remainder of a message interrupted part way through is skipped by the next read.
+func (c *StreamConn) ReadContext(ctx context.Context, out proto.Message) error {
+	var setDeadline func(time.Time) error
+	if d, ok := c.conn.(interface{ SetReadDeadline(time.Time) error }); ok {
+		setDeadline = d.SetReadDeadline
+	}
+	return withContext(ctx, setDeadline, func() error {
+		return c.Read(out)
+	})
+}
+
 // ReadBytes reads a byte message from the connection.
 // Prefer using Read if you have a protobuf message.
 func (c *StreamConn) ReadBytes() ([]byte, error) {
 	c.readMu.Lock()
 	defer c.readMu.Unlock()
-	buf := make([]byte, 4)
 	for {
-		// Read the first byte, looking for Start1.
-		_, err := io.ReadFull(c.conn, buf[:1])
+		data, err := readFrame(countingReader{r: c.conn, n: &c.bytesRead}, c.debugWriter())
 		if err != nil {
 			return nil, err
 		}
 
-		// Check for Start1.
-		if buf[0] != Start1 {
-			if c.DebugWriter != nil {
-				c.DebugWriter.Write(buf[0:1])
+		if c.Checksum {
+			if len(data) < checksumLen {
+				// packet corrupt, start over
+				continue
+			}
+			payload, sum := data[:len(data)-checksumLen], data[len(data)-checksumLen:]
+			if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(sum) {
+				// packet corrupt, start over
+				continue
 			}
-			continue
+			data = payload
 		}
 
-		// Read the second byte, looking for Start2.
-		_, err = io.ReadFull(c.conn, buf[1:2])
-		if err != nil {
-			return nil, err
-		}
+		return data, nil
+	}
+}
 
-		// Check for Start2.
-		if buf[1] != Start2 {
-			continue
-		}
+// debugWriter returns the writer receiving the bytes skipped between frames, or nil if there is none. c.readMu must be
+// held.
+func (c *StreamConn) debugWriter() io.Writer {
+	if c.DebugOutput == nil {
+		return c.DebugWriter
+	}
+	if c.debugLines == nil {
+		c.debugLines = &lineWriter{}
+	}
+	c.debugLines.fn = c.DebugOutput
+	if c.DebugWriter == nil {
+		return c.debugLines
+	}
+	return io.MultiWriter(c.DebugWriter, c.debugLines)
+}
 
-		// The next two bytes should be the length of the protobuf message.
-		_, err = io.ReadFull(c.conn, buf[2:])
-		if err != nil {
-			return nil, err
-		}
+// maxDebugLineLen is the length at which a line of debug output without a line ending is passed on regardless, so
+// that binary noise does not build up.
+const maxDebugLineLen = 1024
 
-		length := int(binary.BigEndian.Uint16(buf[2:]))
-		if length > PacketMTU {
-			//packet corrupt, start over
-			continue
-		}
-		data := make([]byte, length)
+// lineWriter calls fn with each line written to it, without its line ending. A partial line is held until it is
+// completed by a later write.
+type lineWriter struct {
+	fn  func(line string)
+	buf []byte
+}
 
-		// Read the protobuf data.
-		_, err = io.ReadFull(c.conn, data)
-		if err != nil {
-			return nil, err
+func (w *lineWriter) Write(p []byte) (int, error) {
+	for _, b := range p {
+		if b != '\n' {
+			w.buf = append(w.buf, b)
+			if len(w.buf) < maxDebugLineLen {
+				continue
+			}
 		}
-
-		return data, nil
+		w.fn(string(bytes.TrimSuffix(w.buf, []byte{'\r'})))
+		w.buf = w.buf[:0]
 	}
+	return len(p), nil
 }
 
 // writeStreamHeader writes the stream protocol header to the provided writer.
@@ -151,20 +210,67 @@ func (c *StreamConn) Write(in proto.Message) error {
 	return nil
 }
 
+// WriteContext is like Write, but returns ctx.Err() if ctx is done before the message has been written. Interrupting a
+// write in progress requires the underlying connection to support write deadlines, as net.Conn does, otherwise ctx is
+// only checked before writing. The radio discards a message interrupted part way through.
+func (c *StreamConn) WriteContext(ctx context.Context, in proto.Message) error {
+	var setDeadline func(time.Time) error
+	if d, ok := c.conn.(interface{ SetWriteDeadline(time.Time) error }); ok {
+		setDeadline = d.SetWriteDeadline
+	}
+	return withContext(ctx, setDeadline, func() error {
+		return c.Write(in)
+	})
+}
+
+// withContext calls f, interrupting it by setting a deadline in the past with setDeadline if ctx is done first. If
+// setDeadline is nil, ctx is only checked before calling f.
+func withContext(ctx context.Context, setDeadline func(time.Time) error, f func() error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if setDeadline == nil || ctx.Done() == nil {
+		return f()
+	}
+	interrupted := make(chan struct{})
+	stop := context.AfterFunc(ctx, func() {
+		defer close(interrupted)
+		_ = setDeadline(time.Unix(1, 0))
+	})
+	err := f()
+	if !stop() {
+		// Wait for the deadline to have been set before clearing it, so that later calls aren't interrupted.
+		<-interrupted
+		_ = setDeadline(time.Time{})
+		if err != nil {
+			return ctx.Err()
+		}
+	}
+	return err
+}
+
 // WriteBytes writes a byte slice to the connection.
 // Prefer using Write if you have a protobuf message.
 func (c *StreamConn) WriteBytes(data []byte) error {
-	if len(data) > PacketMTU {
-		return fmt.Errorf("data length exceeds MTU: %d > %d", len(data), PacketMTU)
+	mtu := PacketMTU
+	if c.Checksum {
+		mtu -= checksumLen
+	}
+	if len(data) > mtu {
+		return fmt.Errorf("data length exceeds MTU: %d > %d", len(data), mtu)
+	}
+	if c.Checksum {
+		data = binary.BigEndian.AppendUint32(bytes.Clone(data), crc32.ChecksumIEEE(data))
 	}
 	c.writeMu.Lock()
 	defer c.writeMu.Unlock()
 
-	if err := writeStreamHeader(c.conn, uint16(len(data))); err != nil {
+	w := countingWriter{w: c.conn, n: &c.bytesWritten}
+	if err := writeStreamHeader(w, uint16(len(data))); err != nil {
 		return fmt.Errorf("writing stream header: %w", err)
 	}
 
-	if _, err := c.conn.Write(data); err != nil {
+	if _, err := w.Write(data); err != nil {
 		return fmt.Errorf("writing proto message: %w", err)
 	}
 	return nil
@@ -176,7 +282,7 @@ func (c *StreamConn) WriteBytes(data []byte) error {
 // TODO: Rather than just sending this on start, do we need to also send this after a long period of inactivity?
 func (c *StreamConn) writeWake() error {
 	// Send 32 bytes of Start2 to wake the radio if sleeping.
-	_, err := c.conn.Write(
+	_, err := countingWriter{w: c.conn, n: &c.bytesWritten}.Write(
 		bytes.Repeat([]byte{Start2}, 32),
 	)
 	if err != nil {
@@ -185,3 +291,27 @@ func (c *StreamConn) writeWake() error {
 	time.Sleep(WaitAfterWake)
 	return nil
 }
+
+// countingReader adds the number of bytes read from r to n.
+type countingReader struct {
+	r io.Reader
+	n *atomic.Uint64
+}
+
+func (r countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n.Add(uint64(n))
+	return n, err
+}
+
+// countingWriter adds the number of bytes written to w to n.
+type countingWriter struct {
+	w io.Writer
+	n *atomic.Uint64
+}
+
+func (w countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n.Add(uint64(n))
+	return n, err
+}
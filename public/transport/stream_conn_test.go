@@ -2,8 +2,10 @@ package transport
 
 import (
 	"bytes"
+	"context"
 	"net"
 	"testing"
+	"time"
 
 	"github.com/rabarar/meshtastic"
 	"github.com/stretchr/testify/require"
@@ -68,3 +70,172 @@ func Test_writeStreamHeader(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, []byte{Start1, Start2, 0x01, 0x01}, out.Bytes())
 }
+
+// bufferConn is an io.ReadWriteCloser backed by a bytes.Buffer.
+type bufferConn struct {
+	bytes.Buffer
+}
+
+func (b *bufferConn) Close() error {
+	return nil
+}
+
+func TestStreamConn_Checksum(t *testing.T) {
+	conn := &bufferConn{}
+	sc := NewRadioStreamConn(conn)
+	sc.Checksum = true
+
+	corrupt := &meshtastic.FromRadio{Id: 1}
+	require.NoError(t, sc.Write(corrupt))
+	// Flip a bit in the payload of the first message, after the 4 byte header.
+	conn.Bytes()[5] ^= 0x01
+
+	valid := &meshtastic.FromRadio{Id: 2}
+	require.NoError(t, sc.Write(valid))
+
+	received := &meshtastic.FromRadio{}
+	require.NoError(t, sc.Read(received))
+	require.True(t, proto.Equal(valid, received))
+}
+
+func TestStreamConn_ChecksumRoundTrip(t *testing.T) {
+	conn := &bufferConn{}
+	sc := NewRadioStreamConn(conn)
+	sc.Checksum = true
+
+	sent := &meshtastic.FromRadio{Id: 123, PayloadVariant: &meshtastic.FromRadio_ConfigCompleteId{ConfigCompleteId: 456}}
+	require.NoError(t, sc.Write(sent))
+	received := &meshtastic.FromRadio{}
+	require.NoError(t, sc.Read(received))
+	require.True(t, proto.Equal(sent, received))
+}
+
+func TestStreamConn_WriteBytes_MTU(t *testing.T) {
+	tests := []struct {
+		name     string
+		checksum bool
+		size     int
+		wantErr  bool
+	}{
+		{name: "at MTU", size: PacketMTU},
+		{name: "over MTU", size: PacketMTU + 1, wantErr: true},
+		// The checksum is part of the frame, so leaves 508 bytes for the message.
+		{name: "checksum at MTU", checksum: true, size: PacketMTU - checksumLen},
+		{name: "checksum 509 bytes", checksum: true, size: 509, wantErr: true},
+		{name: "checksum 512 bytes", checksum: true, size: 512, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := &bufferConn{}
+			sc := NewRadioStreamConn(conn)
+			sc.Checksum = tt.checksum
+			data := bytes.Repeat([]byte{0x5a}, tt.size)
+
+			err := sc.WriteBytes(data)
+			if tt.wantErr {
+				require.Error(t, err)
+				require.Zero(t, conn.Len())
+				return
+			}
+			require.NoError(t, err)
+			read, err := sc.ReadBytes()
+			require.NoError(t, err)
+			require.Equal(t, data, read)
+		})
+	}
+}
+
+func TestStreamConn_ByteCounts(t *testing.T) {
+	conn := &bufferConn{}
+	client, err := NewClientStreamConn(conn)
+	require.NoError(t, err)
+	client.Checksum = true
+	wake := conn.Len()
+	require.Equal(t, uint64(wake), client.BytesWritten())
+
+	msg := &meshtastic.ToRadio{PayloadVariant: &meshtastic.ToRadio_WantConfigId{WantConfigId: 42}}
+	require.NoError(t, client.Write(msg))
+	// The header and checksum are counted along with the message.
+	written := uint64(conn.Len())
+	require.Equal(t, uint64(wake+4+proto.Size(msg)+checksumLen), written)
+	require.Equal(t, written, client.BytesWritten())
+
+	// The radio reads and counts the wake message, which is skipped, along with the message.
+	radio := NewRadioStreamConn(conn)
+	radio.Checksum = true
+	require.NoError(t, radio.Read(&meshtastic.ToRadio{}))
+	require.Equal(t, written, radio.BytesRead())
+	require.Zero(t, radio.BytesWritten())
+}
+
+func TestStreamConn_ReadContext(t *testing.T) {
+	clientNetConn, radioNetConn := net.Pipe()
+	defer clientNetConn.Close()
+	defer radioNetConn.Close()
+	client := NewRadioStreamConn(clientNetConn)
+	radio := NewRadioStreamConn(radioNetConn)
+
+	// The radio sends nothing, so the read is interrupted.
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, client.ReadContext(ctx, &meshtastic.FromRadio{}), context.DeadlineExceeded)
+
+	// The deadline is cleared, so later reads succeed.
+	go radio.Write(&meshtastic.FromRadio{Id: 1})
+	received := &meshtastic.FromRadio{}
+	require.NoError(t, client.ReadContext(context.Background(), received))
+	require.Equal(t, uint32(1), received.Id)
+
+	// A context which is already done is checked before reading.
+	cancel()
+	require.ErrorIs(t, client.ReadContext(ctx, received), context.DeadlineExceeded)
+}
+
+func TestStreamConn_WriteContext(t *testing.T) {
+	clientNetConn, radioNetConn := net.Pipe()
+	defer clientNetConn.Close()
+	defer radioNetConn.Close()
+	client := NewRadioStreamConn(clientNetConn)
+	radio := NewRadioStreamConn(radioNetConn)
+
+	// The radio isn't reading, so the write is interrupted.
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, client.WriteContext(ctx, &meshtastic.ToRadio{}), context.DeadlineExceeded)
+
+	received := make(chan *meshtastic.ToRadio, 1)
+	go func() {
+		msg := &meshtastic.ToRadio{}
+		if err := radio.Read(msg); err == nil {
+			received <- msg
+		}
+	}()
+	sent := &meshtastic.ToRadio{PayloadVariant: &meshtastic.ToRadio_WantConfigId{WantConfigId: 1}}
+	require.NoError(t, client.WriteContext(context.Background(), sent))
+	require.True(t, proto.Equal(sent, <-received))
+}
+
+func TestStreamConn_DebugOutput(t *testing.T) {
+	conn := &bufferConn{}
+	radio := NewRadioStreamConn(conn)
+	// A radio which has just booted writes its log as text before the client puts it into protobuf mode.
+	conn.Write([]byte("INFO  | ??:??:?? 0 Booted\r\nDEBUG | ??:??:?? 1 Start"))
+	require.NoError(t, radio.Write(&meshtastic.FromRadio{Id: 1}))
+	conn.Write([]byte("ing\n"))
+	require.NoError(t, radio.Write(&meshtastic.FromRadio{Id: 2}))
+
+	client := NewRadioStreamConn(conn)
+	var lines []string
+	var raw bytes.Buffer
+	client.DebugOutput = func(line string) {
+		lines = append(lines, line)
+	}
+	client.DebugWriter = &raw
+	for _, want := range []uint32{1, 2} {
+		msg := &meshtastic.FromRadio{}
+		require.NoError(t, client.Read(msg))
+		require.Equal(t, want, msg.Id)
+	}
+	require.Equal(t, []string{"INFO  | ??:??:?? 0 Booted", "DEBUG | ??:??:?? 1 Starting"}, lines)
+	require.Equal(t, "INFO  | ??:??:?? 0 Booted\r\nDEBUG | ??:??:?? 1 Starting\n", raw.String())
+}
@@ -0,0 +1,82 @@
+package transport
+
+import (
+	"github.com/rabarar/meshtastic"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// subscriptionBuffer is the number of messages buffered for each subscription. Messages received while a
+// subscription's buffer is full are dropped for that subscription.
+const subscriptionBuffer = 64
+
+// subscriber receives messages of a single type for a subscription created by Subscribe.
+type subscriber struct {
+	// send passes msg to the subscription without blocking, returning false if its buffer is full.
+	send  func(msg proto.Message) bool
+	close func()
+}
+
+// Subscribe returns a channel which receives each message of type T received from the radio, such as
+// *meshtastic.MeshPacket or *meshtastic.QueueStatus, along with a function to cancel the subscription. Cancelling
+// closes the channel, so it can be ranged over. Messages are delivered in the order they are received, alongside any
+// handlers registered with Handle.
+//
+// Subscribe is a function rather than a method on Client as Go does not allow methods to have type parameters.
+func Subscribe[T proto.Message](c *Client) (<-chan T, func()) {
+	var zero T
+	name := zero.ProtoReflect().Descriptor().FullName()
+	ch := make(chan T, subscriptionBuffer)
+	sub := &subscriber{
+		send: func(msg proto.Message) bool {
+			select {
+			case ch <- msg.(T):
+				return true
+			default:
+				return false
+			}
+		},
+		close: func() {
+			close(ch)
+		},
+	}
+
+	c.subscribersMu.Lock()
+	if c.subscribers == nil {
+		c.subscribers = map[protoreflect.FullName]map[*subscriber]struct{}{}
+	}
+	if c.subscribers[name] == nil {
+		c.subscribers[name] = map[*subscriber]struct{}{}
+	}
+	c.subscribers[name][sub] = struct{}{}
+	c.subscribersMu.Unlock()
+
+	return ch, func() {
+		c.subscribersMu.Lock()
+		defer c.subscribersMu.Unlock()
+		if _, ok := c.subscribers[name][sub]; !ok {
+			return
+		}
+		delete(c.subscribers[name], sub)
+		sub.close()
+	}
+}
+
+// SubscribePackets returns a channel which receives each MeshPacket received from the radio, along with a function to
+// cancel the subscription. See Subscribe.
+func (c *Client) SubscribePackets() (<-chan *meshtastic.MeshPacket, func()) {
+	return Subscribe[*meshtastic.MeshPacket](c)
+}
+
+// notifySubscribers passes msg to each subscription for its type, returning whether there were any.
+func (c *Client) notifySubscribers(msg proto.Message) bool {
+	c.subscribersMu.Lock()
+	defer c.subscribersMu.Unlock()
+	subs := c.subscribers[msg.ProtoReflect().Descriptor().FullName()]
+	for sub := range subs {
+		if !sub.send(msg) {
+			c.log.Warn("subscriber is not keeping up, dropping message", "type", proto.MessageName(msg))
+		}
+	}
+	return len(subs) > 0
+}
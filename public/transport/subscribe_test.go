@@ -0,0 +1,39 @@
+package transport
+
+import (
+	"bytes"
+	"log/slog"
+	"testing"
+
+	"github.com/rabarar/meshtastic"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubscribe(t *testing.T) {
+	var logs bytes.Buffer
+	c := NewClient(nil, true, WithLogger(slog.New(slog.NewTextHandler(&logs, nil))))
+
+	packets, cancelPackets := c.SubscribePackets()
+	statuses, cancelStatuses := Subscribe[*meshtastic.QueueStatus](c)
+	defer cancelStatuses()
+
+	for id := uint32(1); id <= 3; id++ {
+		c.handleMessage(&meshtastic.MeshPacket{Id: id})
+	}
+	c.handleMessage(&meshtastic.QueueStatus{Free: 5})
+	// Messages passed to a subscriber are not reported as unhandled.
+	require.Empty(t, logs.String())
+
+	cancelPackets()
+	cancelPackets()
+	var ids []uint32
+	for packet := range packets {
+		ids = append(ids, packet.Id)
+	}
+	require.Equal(t, []uint32{1, 2, 3}, ids)
+	require.Equal(t, uint32(5), (<-statuses).Free)
+
+	// Once cancelled, the subscription no longer receives messages.
+	c.handleMessage(&meshtastic.MeshPacket{Id: 4})
+	require.Contains(t, logs.String(), "error handling message")
+}
@@ -0,0 +1,33 @@
+// Package tcp connects to radios which offer the stream API over TCP, such as those with WiFi or ethernet.
+package tcp
+
+import (
+	"net"
+	"time"
+)
+
+const (
+	// DefaultPort is the port radios listen on for the stream API.
+	DefaultPort = "4403"
+	// DefaultDialTimeout is the dial timeout used by Connect.
+	DefaultDialTimeout = 10 * time.Second
+)
+
+// Connect dials a radio at addr, which is a host or host:port. If no port is given, DefaultPort is used. The returned
+// connection can be wrapped with transport.NewClientStreamConn.
+func Connect(addr string) (net.Conn, error) {
+	return ConnectTimeout(addr, DefaultDialTimeout)
+}
+
+// ConnectTimeout is like Connect but with the given dial timeout.
+func ConnectTimeout(addr string, timeout time.Duration) (net.Conn, error) {
+	return net.DialTimeout("tcp", withDefaultPort(addr), timeout)
+}
+
+// withDefaultPort returns addr with DefaultPort added if it has no port.
+func withDefaultPort(addr string) string {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return net.JoinHostPort(addr, DefaultPort)
+	}
+	return addr
+}
@@ -0,0 +1,90 @@
+package tcp
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/rabarar/meshtastic"
+	"github.com/rabarar/meshtool-go/public/emulated"
+	"github.com/rabarar/meshtool-go/public/meshtool"
+	"github.com/rabarar/meshtool-go/public/radio"
+	"github.com/rabarar/meshtool-go/public/transport"
+	"github.com/stretchr/testify/require"
+)
+
+// freeAddr returns a local address which is not in use.
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := l.Addr().String()
+	require.NoError(t, l.Close())
+	return addr
+}
+
+func TestConnect(t *testing.T) {
+	addr := freeAddr(t)
+	r, err := emulated.NewRadio(emulated.Config{
+		Bus:    emulated.NewBus("msh"),
+		NodeID: meshtool.NodeID(0x1234),
+		Channels: &meshtastic.ChannelSet{
+			Settings: []*meshtastic.ChannelSettings{{Name: "LongFast", Psk: radio.DefaultKey}},
+		},
+		TCPListenAddr: addr,
+	})
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- r.Run(ctx)
+	}()
+
+	var conn net.Conn
+	require.Eventually(t, func() bool {
+		conn, err = Connect(addr)
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+	defer conn.Close()
+
+	sc, err := transport.NewClientStreamConn(conn)
+	require.NoError(t, err)
+	client := transport.NewClient(sc, false)
+	require.NoError(t, client.Connect(ctx))
+	require.Equal(t, uint32(0x1234), client.State.NodeInfo().GetMyNodeNum())
+
+	// Run returns once cancelled, closing the listener.
+	cancel()
+	select {
+	case err := <-runErr:
+		require.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("Run did not return after being cancelled")
+	}
+	_, err = Connect(addr)
+	require.Error(t, err)
+}
+
+func TestConnectTimeout_Unreachable(t *testing.T) {
+	// Nothing listens on this reserved address, so the dial must time out rather than hang.
+	_, err := ConnectTimeout("192.0.2.1", 10*time.Millisecond)
+	require.Error(t, err)
+}
+
+func TestWithDefaultPort(t *testing.T) {
+	tests := []struct {
+		addr string
+		want string
+	}{
+		{addr: "192.0.2.1", want: "192.0.2.1:4403"},
+		{addr: "meshtastic.local", want: "meshtastic.local:4403"},
+		{addr: "::1", want: "[::1]:4403"},
+		{addr: "192.0.2.1:1234", want: "192.0.2.1:1234"},
+		{addr: "[::1]:1234", want: "[::1]:1234"},
+	}
+	for _, tt := range tests {
+		require.Equal(t, tt.want, withDefaultPort(tt.addr), tt.addr)
+	}
+}
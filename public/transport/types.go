@@ -1,12 +1,17 @@
 package transport
 
-// Transport defines methods required for communicating with a radio via serial, ble, or tcp
-// Probably need to reevaluate this to just use the ToRadio and FromRadio protobufs
-type Transport interface {
-	Connect() error
-	SendPacket(data []byte) error
-	RequestConfig() error
+import "google.golang.org/protobuf/proto"
 
-	//	Listen(ch chan)
+// Transport carries protobufs between a Client and a radio: ToRadio messages are written to it and FromRadio messages
+// are read from it. *StreamConn implements Transport for serial, TCP and BLE connections: serial.ConnectTransport
+// returns one directly, and the connections returned by tcp.Connect and ble.Connect can be wrapped with
+// NewClientStreamConn.
+type Transport interface {
+	// Read reads the next message from the radio into out, blocking until one is available.
+	Read(out proto.Message) error
+	// Write writes a message to the radio.
+	Write(in proto.Message) error
 	Close() error
 }
+
+var _ Transport = (*StreamConn)(nil)
@@ -0,0 +1,42 @@
+package transport
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+
+	"github.com/rabarar/meshtastic"
+	"github.com/rabarar/meshtool-go/public/meshtool"
+	"google.golang.org/protobuf/proto"
+)
+
+// SendWaypoint sends a Waypoint from the connected node to another node, or to meshtool.BroadcastNodeID, on the
+// primary channel, dropping a pin on the map in the apps. The ID of the sent packet is returned.
+//
+// The waypoint must have a position. If it has no ID, a random one is assigned to a copy of the waypoint, as the apps
+// do; sending a waypoint with the ID of an existing one updates it. Expire is the time, in seconds since the Unix
+// epoch, after which receivers remove the waypoint, with zero meaning it never expires. A waypoint with LockedTo set
+// may only be updated by that node; lock a waypoint to the connected node with State.NodeInfo().GetMyNodeNum().
+func (c *Client) SendWaypoint(w *meshtastic.Waypoint, dest meshtool.NodeID) (uint32, error) {
+	if w.LatitudeI == nil || w.LongitudeI == nil {
+		return 0, errors.New("waypoint has no position")
+	}
+	if w.Id == 0 {
+		w = proto.Clone(w).(*meshtastic.Waypoint)
+		w.Id = newWaypointID()
+	}
+	msg, err := NewWaypointPacket(c.myNodeID(), dest, w)
+	if err != nil {
+		return 0, fmt.Errorf("encoding waypoint: %w", err)
+	}
+	return c.SendPacket(msg.GetPacket())
+}
+
+// newWaypointID returns a random non-zero waypoint ID.
+func newWaypointID() uint32 {
+	for {
+		if id := rand.Uint32(); id != 0 {
+			return id
+		}
+	}
+}
@@ -0,0 +1,283 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/rabarar/meshtastic"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// XModemBlockSize is the maximum number of bytes of a file sent in each XModem packet.
+	XModemBlockSize = 128
+	// xmodemMaxRetransmits is the number of times a packet is retried after being rejected before a transfer is
+	// cancelled. It matches the limit used by the firmware.
+	xmodemMaxRetransmits = 25
+	// xmodemReplyTimeout is how long to wait for the radio to reply to an XModem packet before sending it again.
+	xmodemReplyTimeout = 3 * time.Second
+)
+
+var (
+	// ErrXModemRejected is returned when the radio refuses to start a transfer, such as when the file does not exist.
+	ErrXModemRejected = errors.New("xmodem transfer rejected by radio")
+	// ErrXModemCancelled is returned when a transfer is cancelled by the radio, or after too many retransmits.
+	ErrXModemCancelled = errors.New("xmodem transfer cancelled")
+	// ErrXModemBusy is returned when an XModem transfer is started while another is in progress on the same Client.
+	ErrXModemBusy = errors.New("xmodem transfer already in progress")
+
+	// errXModemNoReply is returned when the radio does not reply to an XModem packet within the client's timeout.
+	errXModemNoReply = fmt.Errorf("no reply from radio: %w", ErrXModemCancelled)
+)
+
+// XModemSend uploads the contents of r to the file named filename on the radio's filesystem.
+//
+// The transfer uses the XModem protocol embedded in ToRadio and FromRadio messages: the filename is sent in a SOH
+// packet with sequence number zero, followed by the file in numbered blocks of XModemBlockSize bytes each protected by
+// a CRC-16, and finally an EOT. Blocks which the radio NAKs or does not reply to are retransmitted. Connect must have
+// been called. If the connection to the radio is closed during the transfer, the reason is returned, or
+// ErrStreamClosed after Disconnect.
+func (c *Client) XModemSend(ctx context.Context, filename string, r io.Reader) error {
+	packets, done, err := c.startXModem()
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	reply, err := c.xmodemExchange(ctx, packets, &meshtastic.XModem{
+		Control: meshtastic.XModem_SOH,
+		Buffer:  []byte(filename),
+	})
+	if err != nil {
+		return err
+	}
+	if reply.Control != meshtastic.XModem_ACK {
+		return fmt.Errorf("uploading %q: %w", filename, ErrXModemRejected)
+	}
+
+	buf := make([]byte, XModemBlockSize)
+	for seq := uint32(1); ; seq++ {
+		n, err := io.ReadFull(r, buf)
+		if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+			c.cancelXModem()
+			return fmt.Errorf("reading file: %w", err)
+		}
+		if n == 0 {
+			break
+		}
+		block := &meshtastic.XModem{
+			Control: meshtastic.XModem_SOH,
+			Seq:     seq,
+			Crc16:   uint32(crc16CCITT(buf[:n])),
+			Buffer:  append([]byte(nil), buf[:n]...),
+		}
+		if err := c.xmodemSendBlock(ctx, packets, block); err != nil {
+			return err
+		}
+		if n < XModemBlockSize {
+			break
+		}
+	}
+
+	return c.xmodemSendBlock(ctx, packets, &meshtastic.XModem{Control: meshtastic.XModem_EOT})
+}
+
+// XModemReceive downloads the file named filename from the radio's filesystem, writing its contents to w.
+//
+// The filename is sent in a STX packet with sequence number zero, after which the radio sends the file in numbered
+// blocks. Each block is ACKed if its CRC-16 is valid, or NAKed to have the radio retransmit it. The transfer is
+// complete when the radio sends an EOT. Connect must have been called. If the connection to the radio is closed during
+// the transfer, the reason is returned, or ErrStreamClosed after Disconnect.
+func (c *Client) XModemReceive(ctx context.Context, filename string, w io.Writer) error {
+	packets, done, err := c.startXModem()
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	reply, err := c.xmodemExchange(ctx, packets, &meshtastic.XModem{
+		Control: meshtastic.XModem_STX,
+		Buffer:  []byte(filename),
+	})
+	if err != nil {
+		return err
+	}
+
+	expected := uint32(1)
+	retransmits := 0
+	for {
+		switch reply.Control {
+		case meshtastic.XModem_EOT:
+			return nil
+		case meshtastic.XModem_CAN:
+			return ErrXModemCancelled
+		case meshtastic.XModem_NAK:
+			if expected == 1 {
+				return fmt.Errorf("downloading %q: %w", filename, ErrXModemRejected)
+			}
+			return fmt.Errorf("unexpected NAK from radio: %w", ErrXModemCancelled)
+		case meshtastic.XModem_SOH, meshtastic.XModem_STX:
+		default:
+			c.log.Debug("ignoring unexpected xmodem packet", "control", reply.Control)
+			reply, err = c.xmodemReceive(ctx, packets)
+			if errors.Is(err, errXModemNoReply) {
+				c.cancelXModem()
+			}
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		control := meshtastic.XModem_ACK
+		switch {
+		case reply.Seq == expected && uint32(crc16CCITT(reply.Buffer)) == reply.Crc16:
+			if _, err := w.Write(reply.Buffer); err != nil {
+				c.cancelXModem()
+				return fmt.Errorf("writing file: %w", err)
+			}
+			expected++
+			retransmits = 0
+		case reply.Seq == expected-1:
+			// Our ACK of the previous block was lost, so acknowledge it again without writing it twice.
+		default:
+			retransmits++
+			if retransmits > xmodemMaxRetransmits {
+				c.cancelXModem()
+				return ErrXModemCancelled
+			}
+			control = meshtastic.XModem_NAK
+		}
+		reply, err = c.xmodemExchange(ctx, packets, &meshtastic.XModem{Control: control})
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// xmodemSendBlock sends a block to the radio, retransmitting it until it is ACKed.
+func (c *Client) xmodemSendBlock(ctx context.Context, packets <-chan *meshtastic.XModem, block *meshtastic.XModem) error {
+	for retransmits := 0; ; retransmits++ {
+		reply, err := c.xmodemExchange(ctx, packets, block)
+		if err != nil {
+			return err
+		}
+		switch reply.Control {
+		case meshtastic.XModem_ACK:
+			return nil
+		case meshtastic.XModem_CAN:
+			return ErrXModemCancelled
+		}
+		if retransmits >= xmodemMaxRetransmits {
+			c.cancelXModem()
+			return ErrXModemCancelled
+		}
+		c.log.Debug("retransmitting xmodem packet", "seq", block.Seq, "reply", reply.Control)
+	}
+}
+
+// xmodemExchange sends an XModem packet to the radio and waits for its reply. The packet is sent again each time the
+// radio does not reply in time, and the transfer is cancelled once it has been retransmitted xmodemMaxRetransmits
+// times without a reply.
+func (c *Client) xmodemExchange(ctx context.Context, packets <-chan *meshtastic.XModem, packet *meshtastic.XModem) (*meshtastic.XModem, error) {
+	for retransmits := 0; ; retransmits++ {
+		err := c.write(&meshtastic.ToRadio{
+			PayloadVariant: &meshtastic.ToRadio_XmodemPacket{XmodemPacket: packet},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("sending xmodem packet: %w", err)
+		}
+		reply, err := c.xmodemReceive(ctx, packets)
+		if !errors.Is(err, errXModemNoReply) {
+			return reply, err
+		}
+		if retransmits >= xmodemMaxRetransmits {
+			c.cancelXModem()
+			return nil, err
+		}
+		c.log.Debug("no reply to xmodem packet, retransmitting", "seq", packet.Seq, "control", packet.Control)
+	}
+}
+
+// xmodemReceive waits for the next XModem packet from the radio, returning errXModemNoReply if none arrives within the
+// client's reply timeout.
+func (c *Client) xmodemReceive(ctx context.Context, packets <-chan *meshtastic.XModem) (*meshtastic.XModem, error) {
+	timer := time.NewTimer(c.xmodemTimeout)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		c.cancelXModem()
+		return nil, ctx.Err()
+	case <-c.done:
+		if err := c.Err(); err != nil {
+			return nil, err
+		}
+		return nil, ErrStreamClosed
+	case <-timer.C:
+		return nil, errXModemNoReply
+	case reply := <-packets:
+		return reply, nil
+	}
+}
+
+// cancelXModem tells the radio to abandon the current transfer.
+func (c *Client) cancelXModem() {
+	err := c.write(&meshtastic.ToRadio{
+		PayloadVariant: &meshtastic.ToRadio_XmodemPacket{
+			XmodemPacket: &meshtastic.XModem{Control: meshtastic.XModem_CAN},
+		},
+	})
+	if err != nil {
+		c.log.Warn("error cancelling xmodem transfer", "err", err)
+	}
+}
+
+// startXModem claims the client for an XModem transfer, returning a channel which receives the XModem packets sent by
+// the radio and a function to call once the transfer is finished.
+func (c *Client) startXModem() (<-chan *meshtastic.XModem, func(), error) {
+	c.xmodemMu.Lock()
+	defer c.xmodemMu.Unlock()
+	if c.xmodemPackets != nil {
+		return nil, nil, ErrXModemBusy
+	}
+	ch := make(chan *meshtastic.XModem, 1)
+	c.xmodemPackets = ch
+	return ch, func() {
+		c.xmodemMu.Lock()
+		c.xmodemPackets = nil
+		c.xmodemMu.Unlock()
+	}, nil
+}
+
+// notifyXModem passes an XModem packet received from the radio to the transfer in progress, if any.
+func (c *Client) notifyXModem(packet *meshtastic.XModem) {
+	c.xmodemMu.Lock()
+	defer c.xmodemMu.Unlock()
+	if c.xmodemPackets == nil {
+		return
+	}
+	select {
+	case c.xmodemPackets <- proto.Clone(packet).(*meshtastic.XModem):
+	default:
+		c.log.Warn("xmodem transfer is not keeping up, dropping packet", "seq", packet.Seq)
+	}
+}
+
+// crc16CCITT calculates the CRC-16/XMODEM checksum of data, as used by the firmware to protect XModem blocks.
+func crc16CCITT(data []byte) uint16 {
+	var crc uint16
+	for _, b := range data {
+		crc ^= uint16(b) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
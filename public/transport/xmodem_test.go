@@ -0,0 +1,237 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/rabarar/meshtastic"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCRC16CCITT(t *testing.T) {
+	require.Equal(t, uint16(0x31c3), crc16CCITT([]byte("123456789")))
+}
+
+// fakeXModemRadio implements the radio side of the XModem protocol with an in-memory filesystem. The first data block
+// of each transfer is corrupted, or rejected, to exercise retransmission.
+type fakeXModemRadio struct {
+	sc    *StreamConn
+	files map[string][]byte
+
+	// upload state
+	uploading string
+	received  []byte
+	expected  uint32
+	nakked    bool
+
+	// download state
+	sending   []byte
+	offset    int
+	last      *meshtastic.XModem
+	corrupted bool
+}
+
+func newFakeXModemRadio(t *testing.T, files map[string][]byte) *Client {
+	clientEnd, radioEnd := net.Pipe()
+	t.Cleanup(func() {
+		clientEnd.Close()
+		radioEnd.Close()
+	})
+	r := &fakeXModemRadio{sc: NewRadioStreamConn(radioEnd), files: files}
+	go r.run()
+
+	c := NewClient(NewRadioStreamConn(clientEnd), false)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(t, c.Connect(ctx))
+	return c
+}
+
+func (r *fakeXModemRadio) run() {
+	for {
+		msg := &meshtastic.ToRadio{}
+		if err := r.sc.Read(msg); err != nil {
+			return
+		}
+		if msg.GetWantConfigId() != 0 {
+			r.send(&meshtastic.FromRadio{
+				PayloadVariant: &meshtastic.FromRadio_ConfigCompleteId{ConfigCompleteId: msg.GetWantConfigId()},
+			})
+			continue
+		}
+		if packet := msg.GetXmodemPacket(); packet != nil {
+			r.handle(packet)
+		}
+	}
+}
+
+func (r *fakeXModemRadio) handle(packet *meshtastic.XModem) {
+	switch packet.Control {
+	case meshtastic.XModem_SOH:
+		if packet.Seq == 0 {
+			r.uploading, r.received, r.expected = string(packet.Buffer), nil, 1
+			r.reply(meshtastic.XModem_ACK)
+			return
+		}
+		if !r.nakked {
+			r.nakked = true
+			r.reply(meshtastic.XModem_NAK)
+			return
+		}
+		if packet.Seq != r.expected || uint32(crc16CCITT(packet.Buffer)) != packet.Crc16 {
+			r.reply(meshtastic.XModem_NAK)
+			return
+		}
+		r.received = append(r.received, packet.Buffer...)
+		r.expected++
+		r.reply(meshtastic.XModem_ACK)
+	case meshtastic.XModem_STX:
+		data, ok := r.files[string(packet.Buffer)]
+		if !ok {
+			r.reply(meshtastic.XModem_NAK)
+			return
+		}
+		r.sending, r.offset = data, 0
+		r.sendBlock(1)
+	case meshtastic.XModem_ACK:
+		r.offset += len(r.last.GetBuffer())
+		if r.offset >= len(r.sending) {
+			r.reply(meshtastic.XModem_EOT)
+			return
+		}
+		r.sendBlock(r.last.Seq + 1)
+	case meshtastic.XModem_NAK:
+		r.sendXModem(r.last)
+	case meshtastic.XModem_EOT:
+		r.files[r.uploading] = r.received
+		r.reply(meshtastic.XModem_ACK)
+	}
+}
+
+func (r *fakeXModemRadio) sendBlock(seq uint32) {
+	block := r.sending[r.offset:min(r.offset+XModemBlockSize, len(r.sending))]
+	r.last = &meshtastic.XModem{
+		Control: meshtastic.XModem_SOH,
+		Seq:     seq,
+		Crc16:   uint32(crc16CCITT(block)),
+		Buffer:  block,
+	}
+	if !r.corrupted {
+		r.corrupted = true
+		corrupt := &meshtastic.XModem{Control: r.last.Control, Seq: seq, Crc16: r.last.Crc16 + 1, Buffer: block}
+		r.sendXModem(corrupt)
+		return
+	}
+	r.sendXModem(r.last)
+}
+
+func (r *fakeXModemRadio) reply(control meshtastic.XModem_Control) {
+	r.sendXModem(&meshtastic.XModem{Control: control})
+}
+
+func (r *fakeXModemRadio) sendXModem(packet *meshtastic.XModem) {
+	r.send(&meshtastic.FromRadio{PayloadVariant: &meshtastic.FromRadio_XmodemPacket{XmodemPacket: packet}})
+}
+
+func (r *fakeXModemRadio) send(msg *meshtastic.FromRadio) {
+	_ = r.sc.Write(msg)
+}
+
+func TestClient_XModem(t *testing.T) {
+	for _, size := range []int{0, 100, XModemBlockSize, 300} {
+		data := bytes.Repeat([]byte("meshtastic"), size/10+1)[:size]
+		files := map[string][]byte{}
+		c := newFakeXModemRadio(t, files)
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		require.NoError(t, c.XModemSend(ctx, "/test.txt", bytes.NewReader(data)))
+		require.Equal(t, string(data), string(files["/test.txt"]), "size %d", size)
+
+		var got bytes.Buffer
+		require.NoError(t, c.XModemReceive(ctx, "/test.txt", &got))
+		require.Equal(t, string(data), got.String(), "size %d", size)
+	}
+}
+
+func TestClient_XModemReceive_Missing(t *testing.T) {
+	c := newFakeXModemRadio(t, map[string][]byte{})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.ErrorIs(t, c.XModemReceive(ctx, "/missing", &bytes.Buffer{}), ErrXModemRejected)
+}
+
+// connectXModemRadio connects a client to a radio which completes the config exchange and then passes each XModem
+// packet it receives to handle, along with the radio's end of the connection.
+func connectXModemRadio(t *testing.T, handle func(sc *StreamConn, packet *meshtastic.XModem)) *Client {
+	clientEnd, radioEnd := net.Pipe()
+	t.Cleanup(func() {
+		clientEnd.Close()
+		radioEnd.Close()
+	})
+	go func() {
+		sc := NewRadioStreamConn(radioEnd)
+		for {
+			msg := &meshtastic.ToRadio{}
+			if err := sc.Read(msg); err != nil {
+				return
+			}
+			if id := msg.GetWantConfigId(); id != 0 {
+				_ = sc.Write(&meshtastic.FromRadio{
+					PayloadVariant: &meshtastic.FromRadio_ConfigCompleteId{ConfigCompleteId: id},
+				})
+				continue
+			}
+			if packet := msg.GetXmodemPacket(); packet != nil {
+				handle(sc, packet)
+			}
+		}
+	}()
+
+	c := NewClient(NewRadioStreamConn(clientEnd), false)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(t, c.Connect(ctx))
+	return c
+}
+
+func TestClient_XModemSend_NoReply(t *testing.T) {
+	received := make(chan *meshtastic.XModem, xmodemMaxRetransmits+2)
+	c := connectXModemRadio(t, func(sc *StreamConn, packet *meshtastic.XModem) {
+		received <- packet
+	})
+	c.xmodemTimeout = time.Millisecond
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	require.ErrorIs(t, c.XModemSend(ctx, "/test.txt", bytes.NewReader([]byte("hello"))), ErrXModemCancelled)
+	// The filename is retransmitted each time the radio does not reply, until the transfer is cancelled.
+	for range xmodemMaxRetransmits + 1 {
+		packet := <-received
+		require.Equal(t, meshtastic.XModem_SOH, packet.Control)
+		require.Equal(t, "/test.txt", string(packet.Buffer))
+	}
+	require.Equal(t, meshtastic.XModem_CAN, (<-received).Control)
+}
+
+func TestClient_XModemSend_Closed(t *testing.T) {
+	c := connectXModemRadio(t, func(sc *StreamConn, packet *meshtastic.XModem) {
+		if packet.Seq == 0 {
+			_ = sc.Write(&meshtastic.FromRadio{
+				PayloadVariant: &meshtastic.FromRadio_XmodemPacket{XmodemPacket: &meshtastic.XModem{Control: meshtastic.XModem_ACK}},
+			})
+			return
+		}
+		// The radio goes away part way through the transfer.
+		_ = sc.Close()
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	data := bytes.Repeat([]byte("meshtastic"), 50)
+	require.ErrorIs(t, c.XModemSend(ctx, "/test.txt", bytes.NewReader(data)), ErrStreamClosed)
+	require.NoError(t, ctx.Err(), "transfer did not stop when the connection closed")
+}